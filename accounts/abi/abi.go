@@ -23,7 +23,6 @@ import (
 	"io"
 	"math/big"
 	"reflect"
-	"strings"
 
 	"github.com/megatilt/go-tilt/common"
 )
@@ -35,6 +34,7 @@ type ABI struct {
 	Constructor Method
 	Methods     map[string]Method
 	Events      map[string]Event
+	Errors      map[string]Error
 }
 
 // JSON returns a parsed ABI interface and error if it failed.
@@ -80,21 +80,20 @@ func (abi ABI) Pack(name string, args ...interface{}) ([]byte, error) {
 
 // toGoSliceType parses the input and casts it to the proper slice defined by the ABI
 // argument in T.
-func toGoSlice(i int, t Argument, output []byte) (interface{}, error) {
-	index := i * 32
+func toGoSlice(index int, t Type, output []byte) (interface{}, error) {
 	// The slice must, at very least be large enough for the index+32 which is exactly the size required
 	// for the [offset in output, size of offset].
 	if index+32 > len(output) {
 		return nil, fmt.Errorf("abi: cannot marshal in to go slice: insufficient size output %d require %d", len(output), index+32)
 	}
-	elem := t.Type.Elem
+	elem := t.Elem
 
 	// first we need to create a slice of the type
 	var refSlice reflect.Value
 	switch elem.T {
 	case IntTy, UintTy, BoolTy:
 		// create a new reference slice matching the element type
-		switch t.Type.Kind {
+		switch t.Kind {
 		case reflect.Bool:
 			refSlice = reflect.ValueOf([]bool(nil))
 		case reflect.Uint8:
@@ -129,7 +128,7 @@ func toGoSlice(i int, t Argument, output []byte) (interface{}, error) {
 	var slice []byte
 	var size int
 	var offset int
-	if t.Type.IsSlice {
+	if t.IsSlice {
 		// get the offset which determines the start of this array ...
 		offset = int(binary.BigEndian.Uint64(output[index+24 : index+32]))
 		if offset+32 > len(output) {
@@ -148,9 +147,9 @@ func toGoSlice(i int, t Argument, output []byte) (interface{}, error) {
 
 		// reslice to match the required size
 		slice = slice[:size*32]
-	} else if t.Type.IsArray {
+	} else if t.IsArray {
 		//get the number of elements in the array
-		size = t.Type.SliceSize
+		size = t.SliceSize
 
 		//check to make sure array size matches up
 		if index+32*size > len(output) {
@@ -168,7 +167,7 @@ func toGoSlice(i int, t Argument, output []byte) (interface{}, error) {
 		// set inter to the correct type (cast)
 		switch elem.T {
 		case IntTy, UintTy:
-			inter = readInteger(t.Type.Kind, returnOutput)
+			inter = readInteger(t.Kind, returnOutput)
 		case BoolTy:
 			inter = !allZero(returnOutput)
 		case AddressTy:
@@ -220,13 +219,16 @@ func allZero(b []byte) bool {
 
 // toGoType parses the input and casts it to the proper type defined by the ABI
 // argument in T.
-func toGoType(i int, t Argument, output []byte) (interface{}, error) {
+func toGoType(index int, t Type, output []byte) (interface{}, error) {
+	// tuples and arrays of them, or of dynamic values, are decoded component by component
+	if t.T == TupleTy || isCompositeArray(t) {
+		return toGoSequence(index, t, output)
+	}
 	// we need to treat slices differently
-	if (t.Type.IsSlice || t.Type.IsArray) && t.Type.T != BytesTy && t.Type.T != StringTy && t.Type.T != FixedBytesTy && t.Type.T != FunctionTy {
-		return toGoSlice(i, t, output)
+	if (t.IsSlice || t.IsArray) && t.T != BytesTy && t.T != StringTy && t.T != FixedBytesTy && t.T != FunctionTy {
+		return toGoSlice(index, t, output)
 	}
 
-	index := i * 32
 	if index+32 > len(output) {
 		return nil, fmt.Errorf("abi: cannot marshal in to go type: length insufficient %d require %d", len(output), index+32)
 	}
@@ -234,7 +236,7 @@ func toGoType(i int, t Argument, output []byte) (interface{}, error) {
 	// Parse the given index output and check whether we need to read
 	// a different offset and length based on the type (i.e. string, bytes)
 	var returnOutput []byte
-	switch t.Type.T {
+	switch t.T {
 	case StringTy, BytesTy: // variable arrays are written at the end of the return bytes
 		// parse offset from which we should start reading
 		offset := int(binary.BigEndian.Uint64(output[index+24 : index+32]))
@@ -254,9 +256,9 @@ func toGoType(i int, t Argument, output []byte) (interface{}, error) {
 	}
 
 	// convert the bytes to whatever is specified by the ABI.
-	switch t.Type.T {
+	switch t.T {
 	case IntTy, UintTy:
-		return readInteger(t.Type.Kind, returnOutput), nil
+		return readInteger(t.Kind, returnOutput), nil
 	case BoolTy:
 		return !allZero(returnOutput), nil
 	case AddressTy:
@@ -268,7 +270,83 @@ func toGoType(i int, t Argument, output []byte) (interface{}, error) {
 	case StringTy:
 		return string(returnOutput), nil
 	}
-	return nil, fmt.Errorf("abi: unknown type %v", t.Type.T)
+	return nil, fmt.Errorf("abi: unknown type %v", t.T)
+}
+
+// toGoSequence parses the input and unpacks the tuple, or array of tuples or of
+// composite values, whose head is at the given index in to a value of its
+// reflected Go type.
+func toGoSequence(index int, t Type, output []byte) (interface{}, error) {
+	if index+32 > len(output) {
+		return nil, fmt.Errorf("abi: cannot marshal in to go tuple: length insufficient %d require %d", len(output), index+32)
+	}
+	// dynamic tuples are written in the tail, static ones in place
+	data := output[index:]
+	if isDynamicType(t) {
+		offset := int(binary.BigEndian.Uint64(output[index+24 : index+32]))
+		if offset+32 > len(output) {
+			return nil, fmt.Errorf("abi: cannot marshal in to go tuple: offset %d would go over slice boundary (len=%d)", offset, len(output))
+		}
+		data = output[offset:]
+	}
+	value := reflect.New(reflectType(t)).Elem()
+
+	var types []Type
+	switch {
+	case t.IsSlice:
+		// slices are prefixed with their size in elements
+		size := int(binary.BigEndian.Uint64(data[24:32]))
+		if size > len(data)/32 {
+			return nil, fmt.Errorf("abi: cannot marshal in to go slice: insufficient size output %d require %d", len(data), 32+size*32)
+		}
+		data = data[32:]
+		for i := 0; i < size; i++ {
+			types = append(types, *t.Elem)
+		}
+		value.Set(reflect.MakeSlice(value.Type(), size, size))
+	case t.IsArray:
+		for i := 0; i < t.SliceSize; i++ {
+			types = append(types, *t.Elem)
+		}
+	default:
+		for _, elem := range t.TupleElems {
+			types = append(types, *elem)
+		}
+	}
+	values, err := readSequence(types, data)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		var field reflect.Value
+		if t.IsSlice || t.IsArray {
+			field = value.Index(i)
+		} else {
+			field = value.Field(i)
+		}
+		if err := assign(field, reflect.ValueOf(v)); err != nil {
+			return nil, err
+		}
+	}
+	return value.Interface(), nil
+}
+
+// readSequence unpacks a head/tail encoded sequence of values of the given types,
+// starting at the beginning of data.
+func readSequence(types []Type, data []byte) ([]interface{}, error) {
+	var (
+		values = make([]interface{}, len(types))
+		index  int
+	)
+	for i, t := range types {
+		value, err := toGoType(index, t, data)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+		index += headSize(t)
+	}
+	return values, nil
 }
 
 // these variable are used to determine certain types during type assertion for
@@ -280,13 +358,29 @@ var (
 	r_byte       = reflect.TypeOf(byte(0))
 )
 
-// Unpack output in v according to the abi specification
+// Unpack output in v according to the abi specification. The name may refer to
+// a method, whose outputs are unpacked from the call's return data, or to an
+// event, whose non-indexed inputs are unpacked from the log data.
 func (abi ABI) Unpack(v interface{}, name string, output []byte) error {
-	var method = abi.Methods[name]
+	if method, ok := abi.Methods[name]; ok {
+		return unpack(v, method.Outputs, output)
+	}
+	if event, ok := abi.Events[name]; ok {
+		return unpack(v, event.NonIndexed(), output)
+	}
+	return fmt.Errorf("abi: could not locate named method or event '%s'", name)
+}
 
+// unpack unmarshals the output in v according to the given arguments. A struct
+// receives the values into the fields matching the argument names, a slice of
+// interfaces receives them in order.
+func unpack(v interface{}, args []Argument, output []byte) error {
 	if len(output) == 0 {
 		return fmt.Errorf("abi: unmarshalling empty output")
 	}
+	if len(args) == 0 {
+		return fmt.Errorf("abi: no values to unmarshal output in to")
+	}
 
 	// make sure the passed value is a pointer
 	valueOf := reflect.ValueOf(v)
@@ -299,26 +393,44 @@ func (abi ABI) Unpack(v interface{}, name string, output []byte) error {
 		typ   = value.Type()
 	)
 
-	if len(method.Outputs) > 1 {
+	// the heads of the values follow each other, static arrays and tuples
+	// taking up more than a single word
+	offsets := make([]int, len(args))
+	for i := 1; i < len(args); i++ {
+		offsets[i] = offsets[i-1] + headSize(args[i-1].Type)
+	}
+
+	if len(args) > 1 || value.Kind() == reflect.Struct {
 		switch value.Kind() {
 		// struct will match named return values to the struct's field
 		// names
 		case reflect.Struct:
-			for i := 0; i < len(method.Outputs); i++ {
-				marshalledValue, err := toGoType(i, method.Outputs[i], output)
+			// a lone tuple may be unpacked straight in to the struct, unless
+			// the struct has a field for it
+			if len(args) == 1 && args[0].Type.T == TupleTy && !args[0].Type.IsSlice && !args[0].Type.IsArray {
+				if _, ok := fieldByABIName(typ, args[0].Name); !ok {
+					marshalledValue, err := toGoType(0, args[0].Type, output)
+					if err != nil {
+						return err
+					}
+					return set(value, reflect.ValueOf(marshalledValue), args[0])
+				}
+			}
+			for i := 0; i < len(args); i++ {
+				if args[i].Name == "" {
+					continue // anonymous values can't be matched to fields
+				}
+				// fields are matched by their abi tag or capitalised name
+				j, ok := fieldByABIName(typ, args[i].Name)
+				if !ok {
+					continue
+				}
+				marshalledValue, err := toGoType(offsets[i], args[i].Type, output)
 				if err != nil {
 					return err
 				}
-				reflectValue := reflect.ValueOf(marshalledValue)
-
-				for j := 0; j < typ.NumField(); j++ {
-					field := typ.Field(j)
-					// TODO read tags: `abi:"fieldName"`
-					if field.Name == strings.ToUpper(method.Outputs[i].Name[:1])+method.Outputs[i].Name[1:] {
-						if err := set(value.Field(j), reflectValue, method.Outputs[i]); err != nil {
-							return err
-						}
-					}
+				if err := set(value.Field(j), reflect.ValueOf(marshalledValue), args[i]); err != nil {
+					return err
 				}
 			}
 		case reflect.Slice:
@@ -328,17 +440,17 @@ func (abi ABI) Unpack(v interface{}, name string, output []byte) error {
 
 			// if the slice already contains values, set those instead of the interface slice itself.
 			if value.Len() > 0 {
-				if len(method.Outputs) > value.Len() {
-					return fmt.Errorf("abi: cannot marshal in to slices of unequal size (require: %v, got: %v)", len(method.Outputs), value.Len())
+				if len(args) > value.Len() {
+					return fmt.Errorf("abi: cannot marshal in to slices of unequal size (require: %v, got: %v)", len(args), value.Len())
 				}
 
-				for i := 0; i < len(method.Outputs); i++ {
-					marshalledValue, err := toGoType(i, method.Outputs[i], output)
+				for i := 0; i < len(args); i++ {
+					marshalledValue, err := toGoType(offsets[i], args[i].Type, output)
 					if err != nil {
 						return err
 					}
 					reflectValue := reflect.ValueOf(marshalledValue)
-					if err := set(value.Index(i).Elem(), reflectValue, method.Outputs[i]); err != nil {
+					if err := set(value.Index(i).Elem(), reflectValue, args[i]); err != nil {
 						return err
 					}
 				}
@@ -347,9 +459,9 @@ func (abi ABI) Unpack(v interface{}, name string, output []byte) error {
 
 			// create a new slice and start appending the unmarshalled
 			// values to the new interface slice.
-			z := reflect.MakeSlice(typ, 0, len(method.Outputs))
-			for i := 0; i < len(method.Outputs); i++ {
-				marshalledValue, err := toGoType(i, method.Outputs[i], output)
+			z := reflect.MakeSlice(typ, 0, len(args))
+			for i := 0; i < len(args); i++ {
+				marshalledValue, err := toGoType(offsets[i], args[i].Type, output)
 				if err != nil {
 					return err
 				}
@@ -361,11 +473,11 @@ func (abi ABI) Unpack(v interface{}, name string, output []byte) error {
 		}
 
 	} else {
-		marshalledValue, err := toGoType(0, method.Outputs[0], output)
+		marshalledValue, err := toGoType(0, args[0].Type, output)
 		if err != nil {
 			return err
 		}
-		if err := set(value, reflect.ValueOf(marshalledValue), method.Outputs[0]); err != nil {
+		if err := set(value, reflect.ValueOf(marshalledValue), args[0]); err != nil {
 			return err
 		}
 	}
@@ -390,6 +502,7 @@ func (abi *ABI) UnmarshalJSON(data []byte) error {
 
 	abi.Methods = make(map[string]Method)
 	abi.Events = make(map[string]Event)
+	abi.Errors = make(map[string]Error)
 	for _, field := range fields {
 		switch field.Type {
 		case "constructor":
@@ -410,6 +523,11 @@ func (abi *ABI) UnmarshalJSON(data []byte) error {
 				Anonymous: field.Anonymous,
 				Inputs:    field.Inputs,
 			}
+		case "error":
+			abi.Errors[field.Name] = Error{
+				Name:   field.Name,
+				Inputs: field.Inputs,
+			}
 		}
 	}
 
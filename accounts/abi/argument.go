@@ -19,6 +19,7 @@ package abi
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 // Argument holds the name of the argument and the corresponding type.
@@ -31,16 +32,22 @@ type Argument struct {
 
 func (a *Argument) UnmarshalJSON(data []byte) error {
 	var extarg struct {
-		Name    string
-		Type    string
-		Indexed bool
+		Name         string
+		Type         string
+		InternalType string
+		Indexed      bool
+		Components   []Argument
 	}
 	err := json.Unmarshal(data, &extarg)
 	if err != nil {
 		return fmt.Errorf("argument json err: %v", err)
 	}
 
-	a.Type, err = NewType(extarg.Type)
+	if strings.HasPrefix(extarg.Type, "tuple") {
+		a.Type, err = NewTupleType(extarg.Type, extarg.InternalType, extarg.Components)
+	} else {
+		a.Type, err = NewType(extarg.Type)
+	}
 	if err != nil {
 		return err
 	}
@@ -22,8 +22,10 @@ import (
 	"fmt"
 	"math/big"
 	"sync"
+	"time"
 
 	"github.com/megatilt/go-tilt"
+	"github.com/megatilt/go-tilt/accounts/abi"
 	"github.com/megatilt/go-tilt/accounts/abi/bind"
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/common/math"
@@ -35,12 +37,36 @@ import (
 	"github.com/megatilt/go-tilt/tiltdb"
 	"github.com/megatilt/go-tilt/event"
 	"github.com/megatilt/go-tilt/params"
+	"github.com/megatilt/go-tilt/rpc"
+	"github.com/megatilt/go-tilt/tilt/filters"
 )
 
-// This nil assignment ensures compile time that SimulatedBackend implements bind.ContractBackend.
-var _ bind.ContractBackend = (*SimulatedBackend)(nil)
+// These nil assignments ensure compile time that SimulatedBackend implements
+// bind.ContractBackend and the same client interfaces as tiltclient.Client.
+var (
+	_ bind.ContractBackend          = (*SimulatedBackend)(nil)
+	_ tiltnet.ChainReader           = (*SimulatedBackend)(nil)
+	_ tiltnet.TransactionReader     = (*SimulatedBackend)(nil)
+	_ tiltnet.ChainStateReader      = (*SimulatedBackend)(nil)
+	_ tiltnet.ChainSyncReader       = (*SimulatedBackend)(nil)
+	_ tiltnet.ContractCaller        = (*SimulatedBackend)(nil)
+	_ tiltnet.LogFilterer           = (*SimulatedBackend)(nil)
+	_ tiltnet.TransactionSender     = (*SimulatedBackend)(nil)
+	_ tiltnet.GasPricer             = (*SimulatedBackend)(nil)
+	_ tiltnet.PendingStateReader    = (*SimulatedBackend)(nil)
+	_ tiltnet.PendingContractCaller = (*SimulatedBackend)(nil)
+	_ tiltnet.GasEstimator          = (*SimulatedBackend)(nil)
+)
+
+// defaultGasLimit is the gas limit of the genesis block of simulated chains
+// created without an explicit one.
+const defaultGasLimit = 4712388
 
-var errBlockNumberUnsupported = errors.New("SimulatedBackend cannot access blocks other than the latest block")
+var (
+	errBlockDoesNotExist = errors.New("block does not exist in blockchain")
+	errPendingBlockDirty = errors.New("pending block has transactions, commit or roll them back first")
+	errExecutionFailed   = errors.New("execution failed")
+)
 
 // SimulatedBackend implements bind.ContractBackend, simulating a blockchain in
 // the background. Its main purpose is to allow easily testing contract bindings.
@@ -51,6 +77,10 @@ type SimulatedBackend struct {
 	mu           sync.Mutex
 	pendingBlock *types.Block   // Currently pending block that will be imported on request
 	pendingState *state.StateDB // Currently pending state that will be the active on on request
+	timeOffset   int64          // Seconds the pending block's timestamp is adjusted by
+
+	mux    *event.TypeMux       // Event multiplexer the blockchain posts its logs to
+	events *filters.EventSystem // Event system for filtering log events live
 
 	config *params.ChainConfig
 }
@@ -58,17 +88,32 @@ type SimulatedBackend struct {
 // NewSimulatedBackend creates a new binding backend using a simulated blockchain
 // for testing purposes.
 func NewSimulatedBackend(alloc core.GenesisAlloc) *SimulatedBackend {
+	return NewSimulatedBackendWithGasLimit(alloc, defaultGasLimit)
+}
+
+// NewSimulatedBackendWithGasLimit creates a new binding backend using a simulated
+// blockchain whose genesis block has the given gas limit. Subsequent blocks adjust
+// their limits from there according to the protocol rules.
+func NewSimulatedBackendWithGasLimit(alloc core.GenesisAlloc, gasLimit uint64) *SimulatedBackend {
 	database, _ := tiltdb.NewMemDatabase()
-	genesis := core.Genesis{Config: params.AllProtocolChanges, Alloc: alloc}
+	genesis := core.Genesis{Config: params.AllProtocolChanges, GasLimit: gasLimit, Alloc: alloc}
 	genesis.MustCommit(database)
-	blockchain, _ := core.NewBlockChain(database, genesis.Config, tilthash.NewFaker(), new(event.TypeMux), vm.Config{})
-	backend := &SimulatedBackend{database: database, blockchain: blockchain, config: genesis.Config}
-	backend.rollback()
+	mux := new(event.TypeMux)
+	blockchain, _ := core.NewBlockChain(database, genesis.Config, tilthash.NewFaker(), mux, vm.Config{})
+	backend := &SimulatedBackend{database: database, blockchain: blockchain, mux: mux, config: genesis.Config}
+	backend.events = filters.NewEventSystem(mux, &filterBackend{database, blockchain, mux}, false)
+	backend.generatePending(blockchain.CurrentBlock(), nil)
 	return backend
 }
 
+// Close terminates the underlying blockchain's update loop.
+func (b *SimulatedBackend) Close() error {
+	b.blockchain.Stop()
+	return nil
+}
+
 // Commit imports all the pending transactions as a single block and starts a
-// fresh new state.
+// fresh new state on top of it.
 func (b *SimulatedBackend) Commit() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -76,7 +121,8 @@ func (b *SimulatedBackend) Commit() {
 	if _, err := b.blockchain.InsertChain([]*types.Block{b.pendingBlock}); err != nil {
 		panic(err) // This cannot happen unless the simulator is wrong, fail in that case
 	}
-	b.rollback()
+	b.timeOffset = 0
+	b.generatePending(b.pendingBlock, nil)
 }
 
 // Rollback aborts all pending transactions, reverting to the last committed state.
@@ -88,20 +134,102 @@ func (b *SimulatedBackend) Rollback() {
 }
 
 func (b *SimulatedBackend) rollback() {
-	blocks, _ := core.GenerateChain(b.config, b.blockchain.CurrentBlock(), b.database, 1, func(int, *core.BlockGen) {})
+	b.timeOffset = 0
+	b.generatePending(b.blockchain.GetBlockByHash(b.pendingBlock.ParentHash()), nil)
+}
+
+// Fork creates a side-chain that can be used to simulate reorgs. The pending block
+// is moved on top of the given parent, which must be a known block, so that the
+// following commits extend the side chain. Once it outweighs the canonical chain,
+// the blockchain reorganises to it, emitting the removed and added logs.
+//
+// The pending block must be empty, fork before sending any transactions.
+func (b *SimulatedBackend) Fork(ctx context.Context, parent common.Hash) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.pendingBlock.Transactions()) != 0 {
+		return errPendingBlockDirty
+	}
+	block := b.blockchain.GetBlockByHash(parent)
+	if block == nil {
+		return errBlockDoesNotExist
+	}
+	b.generatePending(block, nil)
+	return nil
+}
+
+// AdjustTime shifts the timestamp of the pending block by the given duration,
+// which is useful to test time dependent contract logic. The adjustment lasts
+// until the pending block is committed or rolled back, and must keep the block
+// newer than its parent.
+func (b *SimulatedBackend) AdjustTime(adjustment time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	parent := b.blockchain.GetBlockByHash(b.pendingBlock.ParentHash())
+	offset := b.timeOffset + int64(adjustment/time.Second)
+	if b.pendingBlock.Time().Int64()-b.timeOffset+offset <= parent.Time().Int64() {
+		return fmt.Errorf("adjusted block time not after parent's")
+	}
+	b.timeOffset = offset
+	b.generatePending(parent, b.pendingBlock.Transactions())
+	return nil
+}
+
+// generatePending rebuilds the pending block and state on top of the given parent,
+// including the given transactions and applying the current time adjustment.
+func (b *SimulatedBackend) generatePending(parent *types.Block, txs types.Transactions) {
+	blocks, _ := core.GenerateChain(b.config, parent, b.database, 1, func(number int, block *core.BlockGen) {
+		if b.timeOffset != 0 {
+			block.OffsetTime(b.timeOffset)
+		}
+		for _, tx := range txs {
+			block.AddTx(tx)
+		}
+	})
 	b.pendingBlock = blocks[0]
 	b.pendingState, _ = state.New(b.pendingBlock.Root(), b.database)
 }
 
+// stateByBlockNumber retrieves the state of the canonical block with the given
+// number, or of the latest block if the number is nil.
+func (b *SimulatedBackend) stateByBlockNumber(blockNumber *big.Int) (*state.StateDB, error) {
+	if blockNumber == nil || blockNumber.Cmp(b.blockchain.CurrentBlock().Number()) == 0 {
+		return b.blockchain.State()
+	}
+	block, err := b.blockByNumber(blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return b.blockchain.StateAt(block.Root())
+}
+
+// blockByNumber retrieves the canonical block with the given number, or the latest
+// block if the number is nil.
+func (b *SimulatedBackend) blockByNumber(number *big.Int) (*types.Block, error) {
+	if number == nil || number.Cmp(b.blockchain.CurrentBlock().Number()) == 0 {
+		return b.blockchain.CurrentBlock(), nil
+	}
+	if number.Sign() < 0 {
+		return nil, errBlockDoesNotExist
+	}
+	block := b.blockchain.GetBlockByNumber(number.Uint64())
+	if block == nil {
+		return nil, errBlockDoesNotExist
+	}
+	return block, nil
+}
+
 // CodeAt returns the code associated with a certain account in the blockchain.
 func (b *SimulatedBackend) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if blockNumber != nil && blockNumber.Cmp(b.blockchain.CurrentBlock().Number()) != 0 {
-		return nil, errBlockNumberUnsupported
+	statedb, err := b.stateByBlockNumber(blockNumber)
+	if err != nil {
+		return nil, err
 	}
-	statedb, _ := b.blockchain.State()
 	return statedb.GetCode(contract), nil
 }
 
@@ -110,10 +238,10 @@ func (b *SimulatedBackend) BalanceAt(ctx context.Context, contract common.Addres
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if blockNumber != nil && blockNumber.Cmp(b.blockchain.CurrentBlock().Number()) != 0 {
-		return nil, errBlockNumberUnsupported
+	statedb, err := b.stateByBlockNumber(blockNumber)
+	if err != nil {
+		return nil, err
 	}
-	statedb, _ := b.blockchain.State()
 	return statedb.GetBalance(contract), nil
 }
 
@@ -122,10 +250,10 @@ func (b *SimulatedBackend) NonceAt(ctx context.Context, contract common.Address,
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if blockNumber != nil && blockNumber.Cmp(b.blockchain.CurrentBlock().Number()) != 0 {
-		return 0, errBlockNumberUnsupported
+	statedb, err := b.stateByBlockNumber(blockNumber)
+	if err != nil {
+		return 0, err
 	}
-	statedb, _ := b.blockchain.State()
 	return statedb.GetNonce(contract), nil
 }
 
@@ -134,17 +262,123 @@ func (b *SimulatedBackend) StorageAt(ctx context.Context, contract common.Addres
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if blockNumber != nil && blockNumber.Cmp(b.blockchain.CurrentBlock().Number()) != 0 {
-		return nil, errBlockNumberUnsupported
+	statedb, err := b.stateByBlockNumber(blockNumber)
+	if err != nil {
+		return nil, err
 	}
-	statedb, _ := b.blockchain.State()
 	val := statedb.GetState(contract, key)
 	return val[:], nil
 }
 
 // TransactionReceipt returns the receipt of a transaction.
 func (b *SimulatedBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
-	return core.GetReceipt(b.database, txHash), nil
+	receipt := core.GetReceipt(b.database, txHash)
+	if receipt == nil {
+		return nil, tiltnet.NotFound
+	}
+	return receipt, nil
+}
+
+// TransactionByHash checks the pending block for the transaction with the given
+// hash, falling back to the blockchain. The isPending return value indicates
+// whether the transaction has been committed yet.
+func (b *SimulatedBackend) TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if tx := b.pendingBlock.Transaction(txHash); tx != nil {
+		return tx, true, nil
+	}
+	if tx, _, _, _ := core.GetTransaction(b.database, txHash); tx != nil {
+		return tx, false, nil
+	}
+	return nil, false, tiltnet.NotFound
+}
+
+// BlockByHash retrieves a block from the blockchain by hash.
+func (b *SimulatedBackend) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if block := b.blockchain.GetBlockByHash(hash); block != nil {
+		return block, nil
+	}
+	return nil, tiltnet.NotFound
+}
+
+// BlockByNumber retrieves a canonical block by number, or the latest block if
+// the number is nil.
+func (b *SimulatedBackend) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	block, err := b.blockByNumber(number)
+	if err != nil {
+		return nil, tiltnet.NotFound
+	}
+	return block, nil
+}
+
+// HeaderByHash retrieves a block header from the blockchain by hash.
+func (b *SimulatedBackend) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if header := b.blockchain.GetHeaderByHash(hash); header != nil {
+		return header, nil
+	}
+	return nil, tiltnet.NotFound
+}
+
+// HeaderByNumber retrieves a canonical block header by number, or the latest one
+// if the number is nil.
+func (b *SimulatedBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	block, err := b.blockByNumber(number)
+	if err != nil {
+		return nil, tiltnet.NotFound
+	}
+	return block.Header(), nil
+}
+
+// TransactionCount returns the number of transactions in the given block.
+func (b *SimulatedBackend) TransactionCount(ctx context.Context, blockHash common.Hash) (uint, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if blockHash == b.pendingBlock.Hash() {
+		return uint(b.pendingBlock.Transactions().Len()), nil
+	}
+	block := b.blockchain.GetBlockByHash(blockHash)
+	if block == nil {
+		return 0, tiltnet.NotFound
+	}
+	return uint(block.Transactions().Len()), nil
+}
+
+// TransactionInBlock returns the transaction at the given index in the given block.
+func (b *SimulatedBackend) TransactionInBlock(ctx context.Context, blockHash common.Hash, index uint) (*types.Transaction, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	block := b.pendingBlock
+	if blockHash != block.Hash() {
+		if block = b.blockchain.GetBlockByHash(blockHash); block == nil {
+			return nil, tiltnet.NotFound
+		}
+	}
+	txs := block.Transactions()
+	if uint(len(txs)) <= index {
+		return nil, tiltnet.NotFound
+	}
+	return txs[index], nil
+}
+
+// SyncProgress always reports the simulated chain as synchronised.
+func (b *SimulatedBackend) SyncProgress(ctx context.Context) (*tiltnet.SyncProgress, error) {
+	return nil, nil
 }
 
 // PendingCodeAt returns the code associated with an account in the pending state.
@@ -155,20 +389,53 @@ func (b *SimulatedBackend) PendingCodeAt(ctx context.Context, contract common.Ad
 	return b.pendingState.GetCode(contract), nil
 }
 
+// PendingBalanceAt returns the dwan balance of an account in the pending state.
+func (b *SimulatedBackend) PendingBalanceAt(ctx context.Context, account common.Address) (*big.Int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.pendingState.GetBalance(account), nil
+}
+
+// PendingStorageAt returns the value of key in the storage of an account in the
+// pending state.
+func (b *SimulatedBackend) PendingStorageAt(ctx context.Context, account common.Address, key common.Hash) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	val := b.pendingState.GetState(account, key)
+	return val[:], nil
+}
+
+// PendingTransactionCount returns the number of transactions in the pending block.
+func (b *SimulatedBackend) PendingTransactionCount(ctx context.Context) (uint, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return uint(b.pendingBlock.Transactions().Len()), nil
+}
+
 // CallContract executes a contract call.
 func (b *SimulatedBackend) CallContract(ctx context.Context, call tiltnet.CallMsg, blockNumber *big.Int) ([]byte, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if blockNumber != nil && blockNumber.Cmp(b.blockchain.CurrentBlock().Number()) != 0 {
-		return nil, errBlockNumberUnsupported
+	block, err := b.blockByNumber(blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	state, err := b.blockchain.StateAt(block.Root())
+	if err != nil {
+		return nil, err
 	}
-	state, err := b.blockchain.State()
+	rval, _, failed, err := b.callContract(ctx, call, block, state)
 	if err != nil {
 		return nil, err
 	}
-	rval, _, err := b.callContract(ctx, call, b.blockchain.CurrentBlock(), state)
-	return rval, err
+	if failed {
+		return nil, callFailure(rval)
+	}
+	return rval, nil
 }
 
 // PendingCallContract executes a contract call on the pending state.
@@ -177,8 +444,14 @@ func (b *SimulatedBackend) PendingCallContract(ctx context.Context, call tiltnet
 	defer b.mu.Unlock()
 	defer b.pendingState.RevertToSnapshot(b.pendingState.Snapshot())
 
-	rval, _, err := b.callContract(ctx, call, b.pendingBlock, b.pendingState)
-	return rval, err
+	rval, _, failed, err := b.callContract(ctx, call, b.pendingBlock, b.pendingState)
+	if err != nil {
+		return nil, err
+	}
+	if failed {
+		return nil, callFailure(rval)
+	}
+	return rval, nil
 }
 
 // PendingNonceAt implements PendingStateReader.PendingNonceAt, retrieving
@@ -215,11 +488,11 @@ func (b *SimulatedBackend) EstimateGas(ctx context.Context, call tiltnet.CallMsg
 		call.Gas = new(big.Int).SetUint64(mid)
 
 		snapshot := b.pendingState.Snapshot()
-		_, gas, err := b.callContract(ctx, call, b.pendingBlock, b.pendingState)
+		_, gas, failed, err := b.callContract(ctx, call, b.pendingBlock, b.pendingState)
 		b.pendingState.RevertToSnapshot(snapshot)
 
-		// If the transaction became invalid or used all the gas (failed), raise the gas limit
-		if err != nil || gas.Cmp(call.Gas) == 0 {
+		// If the transaction became invalid, failed or used all the gas, raise the gas limit
+		if err != nil || failed || gas.Cmp(call.Gas) == 0 {
 			lo = mid
 			continue
 		}
@@ -230,8 +503,10 @@ func (b *SimulatedBackend) EstimateGas(ctx context.Context, call tiltnet.CallMsg
 }
 
 // callContract implemens common code between normal and pending contract calls.
-// state is modified during execution, make sure to copy it if necessary.
-func (b *SimulatedBackend) callContract(ctx context.Context, call tiltnet.CallMsg, block *types.Block, statedb *state.StateDB) ([]byte, *big.Int, error) {
+// state is modified during execution, make sure to copy it if necessary. The
+// returned flag reports whether the execution failed in the TiltVM, in which case
+// the returned data is the revert payload, if any.
+func (b *SimulatedBackend) callContract(ctx context.Context, call tiltnet.CallMsg, block *types.Block, statedb *state.StateDB) ([]byte, *big.Int, bool, error) {
 	// Ensure message is initialized properly.
 	if call.GasPrice == nil {
 		call.GasPrice = big.NewInt(1)
@@ -253,8 +528,22 @@ func (b *SimulatedBackend) callContract(ctx context.Context, call tiltnet.CallMs
 	// about the transaction and calling mechanisms.
 	vmenv := vm.NewTiltVM(tiltvmContext, statedb, b.config, vm.Config{})
 	gaspool := new(core.GasPool).AddGas(math.MaxBig256)
-	ret, gasUsed, _, err := core.NewStateTransition(vmenv, msg, gaspool).TransitionDb()
-	return ret, gasUsed, err
+	ret, gasUsed, _, failed, err := core.NewStateTransition(vmenv, msg, gaspool).TransitionDb()
+	return ret, gasUsed, failed, err
+}
+
+// callFailure converts the return data of a call failed in the TiltVM into the
+// error reported for it, the same way a node would: an *abi.RevertError holding
+// the decoded reason of Error(string) reverts or the raw payload of others, and
+// a plain failure if nothing was returned.
+func callFailure(data []byte) error {
+	if len(data) == 0 {
+		return errExecutionFailed
+	}
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return &abi.RevertError{Name: "Error", Reason: reason, Data: data}
+	}
+	return &abi.RevertError{Data: data}
 }
 
 // SendTransaction updates the pending block to include the given transaction.
@@ -272,17 +561,107 @@ func (b *SimulatedBackend) SendTransaction(ctx context.Context, tx *types.Transa
 		panic(fmt.Errorf("invalid transaction nonce: got %d, want %d", tx.Nonce(), nonce))
 	}
 
-	blocks, _ := core.GenerateChain(b.config, b.blockchain.CurrentBlock(), b.database, 1, func(number int, block *core.BlockGen) {
-		for _, tx := range b.pendingBlock.Transactions() {
-			block.AddTx(tx)
-		}
-		block.AddTx(tx)
-	})
-	b.pendingBlock = blocks[0]
-	b.pendingState, _ = state.New(b.pendingBlock.Root(), b.database)
+	parent := b.blockchain.GetBlockByHash(b.pendingBlock.ParentHash())
+	b.generatePending(parent, append(b.pendingBlock.Transactions(), tx))
 	return nil
 }
 
+// FilterLogs executes a log filter operation, blocking during execution and
+// returning all the results in one batch.
+func (b *SimulatedBackend) FilterLogs(ctx context.Context, query tiltnet.FilterQuery) ([]types.Log, error) {
+	// Initialize unset filter boundaried to run from genesis to chain head
+	from := int64(0)
+	if query.FromBlock != nil {
+		from = query.FromBlock.Int64()
+	}
+	to := int64(-1)
+	if query.ToBlock != nil {
+		to = query.ToBlock.Int64()
+	}
+	// Construct and execute the filter
+	filter := filters.New(&filterBackend{b.database, b.blockchain, b.mux}, false)
+	filter.SetBeginBlock(from)
+	filter.SetEndBlock(to)
+	filter.SetAddresses(query.Addresses)
+	filter.SetTopics(query.Topics)
+
+	logs, err := filter.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]types.Log, len(logs))
+	for i, log := range logs {
+		res[i] = *log
+	}
+	return res, nil
+}
+
+// SubscribeFilterLogs creates a background log filtering operation, returning a
+// subscription immediately, which can be used to stream the found events.
+func (b *SimulatedBackend) SubscribeFilterLogs(ctx context.Context, query tiltnet.FilterQuery, ch chan<- types.Log) (tiltnet.Subscription, error) {
+	// Subscribe to contract events
+	sink := make(chan []*types.Log)
+
+	sub, err := b.events.SubscribeLogs(filters.FilterCriteria{
+		FromBlock: query.FromBlock,
+		ToBlock:   query.ToBlock,
+		Addresses: query.Addresses,
+		Topics:    query.Topics,
+	}, sink)
+	if err != nil {
+		return nil, err
+	}
+	// Since we're getting logs in batches, we need to flatten them into a plain stream
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case logs := <-sink:
+				for _, log := range logs {
+					select {
+					case ch <- *log:
+					case err := <-sub.Err():
+						return err
+					case <-quit:
+						return nil
+					}
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+// SubscribeNewHead returns a subscription streaming the headers of the blocks
+// becoming the head of the chain.
+func (b *SimulatedBackend) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (tiltnet.Subscription, error) {
+	sink := make(chan *types.Header)
+	sub := b.events.SubscribeNewHeads(sink)
+
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case head := <-sink:
+				select {
+				case ch <- head:
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
 // callmsg implements core.Message to allow passing it as a transaction simulator.
 type callmsg struct {
 	tiltnet.CallMsg
@@ -296,3 +675,26 @@ func (m callmsg) GasPrice() *big.Int   { return m.CallMsg.GasPrice }
 func (m callmsg) Gas() *big.Int        { return m.CallMsg.Gas }
 func (m callmsg) Value() *big.Int      { return m.CallMsg.Value }
 func (m callmsg) Data() []byte         { return m.CallMsg.Data }
+
+// filterBackend implements filters.Backend to support filtering for logs without
+// taking bloom-bits acceleration structures into account.
+type filterBackend struct {
+	db  tiltdb.Database
+	bc  *core.BlockChain
+	mux *event.TypeMux
+}
+
+func (fb *filterBackend) ChainDb() tiltdb.Database { return fb.db }
+func (fb *filterBackend) EventMux() *event.TypeMux { return fb.mux }
+
+func (fb *filterBackend) HeaderByNumber(ctx context.Context, block rpc.BlockNumber) (*types.Header, error) {
+	// The simulated chain never reorganises, so its head is already safe
+	if block == rpc.LatestBlockNumber || block == rpc.SafeBlockNumber {
+		return fb.bc.CurrentBlock().Header(), nil
+	}
+	return fb.bc.GetHeaderByNumber(uint64(block.Int64())), nil
+}
+
+func (fb *filterBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	return core.GetBlockReceipts(fb.db, hash, core.GetBlockNumber(fb.db, hash)), nil
+}
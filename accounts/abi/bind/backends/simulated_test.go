@@ -0,0 +1,80 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package backends
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/megatilt/go-tilt"
+	"github.com/megatilt/go-tilt/accounts/abi"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core"
+	"github.com/megatilt/go-tilt/crypto"
+)
+
+// Tests that calls failing in the TiltVM are reported as errors instead of being
+// returned as empty results, both on the chain head and on the pending state.
+func TestCallContractFailure(t *testing.T) {
+	var (
+		failing = common.HexToAddress("0x0100000000000000000000000000000000000000")
+		working = common.HexToAddress("0x0200000000000000000000000000000000000000")
+	)
+	sim := NewSimulatedBackend(core.GenesisAlloc{
+		failing: {Code: []byte{0xfe}, Balance: new(big.Int)}, // INVALID
+		// PUSH1 42 PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+		working: {Code: []byte{0x60, 0x2a, 0x60, 0x00, 0x52, 0x60, 0x20, 0x60, 0x00, 0xf3}, Balance: new(big.Int)},
+	})
+	want := common.LeftPadBytes([]byte{0x2a}, 32)
+
+	if out, err := sim.CallContract(context.Background(), tiltnet.CallMsg{To: &working}, nil); err != nil || !bytes.Equal(out, want) {
+		t.Errorf("working call mismatch: have %x (%v), want %x", out, err, want)
+	}
+	if out, err := sim.PendingCallContract(context.Background(), tiltnet.CallMsg{To: &working}); err != nil || !bytes.Equal(out, want) {
+		t.Errorf("working pending call mismatch: have %x (%v), want %x", out, err, want)
+	}
+	if out, err := sim.CallContract(context.Background(), tiltnet.CallMsg{To: &failing}, nil); err != errExecutionFailed {
+		t.Errorf("failing call mismatch: have %x (%v), want %v", out, err, errExecutionFailed)
+	}
+	if out, err := sim.PendingCallContract(context.Background(), tiltnet.CallMsg{To: &failing}); err != errExecutionFailed {
+		t.Errorf("failing pending call mismatch: have %x (%v), want %v", out, err, errExecutionFailed)
+	}
+}
+
+// Tests that the return data of failed calls is converted into revert errors,
+// decoding the reasons of Error(string) payloads.
+func TestCallFailure(t *testing.T) {
+	reason := crypto.Keccak256([]byte("Error(string)"))[:4]
+	reason = append(reason, common.LeftPadBytes([]byte{0x20}, 32)...)
+	reason = append(reason, common.LeftPadBytes([]byte{0x04}, 32)...)
+	reason = append(reason, common.RightPadBytes([]byte("boom"), 32)...)
+
+	if err := callFailure(nil); err != errExecutionFailed {
+		t.Errorf("empty failure mismatch: have %v, want %v", err, errExecutionFailed)
+	}
+	err, ok := callFailure(reason).(*abi.RevertError)
+	if !ok || err.Name != "Error" || err.Reason != "boom" || !bytes.Equal(err.Data, reason) {
+		t.Errorf("reason failure mismatch: have %+v", err)
+	}
+	custom := []byte{0x01, 0x02, 0x03, 0x04}
+	err, ok = callFailure(custom).(*abi.RevertError)
+	if !ok || err.Name != "" || !bytes.Equal(err.Data, custom) {
+		t.Errorf("custom failure mismatch: have %+v", err)
+	}
+}
@@ -25,8 +25,10 @@ import (
 	"github.com/megatilt/go-tilt"
 	"github.com/megatilt/go-tilt/accounts/abi"
 	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
 	"github.com/megatilt/go-tilt/core/types"
 	"github.com/megatilt/go-tilt/crypto"
+	"github.com/megatilt/go-tilt/event"
 )
 
 // SignerFn is a signer function callback when a contract requires a method to
@@ -55,6 +57,23 @@ type TransactOpts struct {
 	Context context.Context // Network context to support cancellation and timeouts (nil = no timeout)
 }
 
+// FilterOpts is the collection of options to fine tune filtering for events
+// within a bound contract.
+type FilterOpts struct {
+	Start uint64  // Start of the queried range
+	End   *uint64 // End of the range (nil = latest)
+
+	Context context.Context // Network context to support cancellation and timeouts (nil = no timeout)
+}
+
+// WatchOpts is the collection of options to fine tune subscribing for events
+// within a bound contract.
+type WatchOpts struct {
+	Start *uint64 // Start of the queried range (nil = latest)
+
+	Context context.Context // Network context to support cancellation and timeouts (nil = no timeout)
+}
+
 // BoundContract is the base wrapper object that reflects a contract on the
 // Tiltnet network. It contains a collection of methods that are used by the
 // higher level contract bindings to operate.
@@ -63,16 +82,18 @@ type BoundContract struct {
 	abi        abi.ABI            // Reflect based ABI to access the correct Tiltnet methods
 	caller     ContractCaller     // Read interface to interact with the blockchain
 	transactor ContractTransactor // Write interface to interact with the blockchain
+	filterer   ContractFilterer   // Event filtering to interact with the blockchain
 }
 
 // NewBoundContract creates a low level contract interface through which calls
 // and transactions may be made through.
-func NewBoundContract(address common.Address, abi abi.ABI, caller ContractCaller, transactor ContractTransactor) *BoundContract {
+func NewBoundContract(address common.Address, abi abi.ABI, caller ContractCaller, transactor ContractTransactor, filterer ContractFilterer) *BoundContract {
 	return &BoundContract{
 		address:    address,
 		abi:        abi,
 		caller:     caller,
 		transactor: transactor,
+		filterer:   filterer,
 	}
 }
 
@@ -80,7 +101,7 @@ func NewBoundContract(address common.Address, abi abi.ABI, caller ContractCaller
 // deployment address with a Go wrapper.
 func DeployContract(opts *TransactOpts, abi abi.ABI, bytecode []byte, backend ContractBackend, params ...interface{}) (common.Address, *types.Transaction, *BoundContract, error) {
 	// Otherwise try to deploy the contract
-	c := NewBoundContract(common.Address{}, abi, backend, backend)
+	c := NewBoundContract(common.Address{}, abi, backend, backend, backend)
 
 	input, err := c.abi.Pack("", params...)
 	if err != nil {
@@ -140,11 +161,36 @@ func (c *BoundContract) Call(opts *CallOpts, result interface{}, method string,
 		}
 	}
 	if err != nil {
-		return err
+		return c.revertError(err)
 	}
 	return c.abi.Unpack(result, method, output)
 }
 
+// revertError decodes the revert payload carried by the error of a failed call
+// with the contract's ABI, resolving its custom errors into an *abi.RevertError.
+// Errors without a decodable payload are returned as is.
+func (c *BoundContract) revertError(err error) error {
+	carrier, ok := err.(interface {
+		ErrorData() interface{}
+	})
+	if !ok {
+		return err
+	}
+	hex, ok := carrier.ErrorData().(string)
+	if !ok {
+		return err
+	}
+	data, derr := hexutil.Decode(hex)
+	if derr != nil || len(data) == 0 {
+		return err
+	}
+	revert, uerr := c.abi.UnpackRevert(data)
+	if uerr != nil {
+		return err
+	}
+	return revert
+}
+
 // Transact invokes the (paid) contract method with params as input values.
 func (c *BoundContract) Transact(opts *TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
 	// Otherwise pack up the parameters and invoke the contract
@@ -225,6 +271,117 @@ func (c *BoundContract) transact(opts *TransactOpts, contract *common.Address, i
 	return signedTx, nil
 }
 
+// FilterLogs filters contract logs for past blocks, returning the logs of the
+// given event matching the given topic criteria.
+func (c *BoundContract) FilterLogs(opts *FilterOpts, name string, query ...[]interface{}) (chan types.Log, tiltnet.Subscription, error) {
+	// Don't crash on a lazy user
+	if opts == nil {
+		opts = new(FilterOpts)
+	}
+	if c.filterer == nil {
+		return nil, nil, ErrNoFilterer
+	}
+	// Append the event selector to the query parameters and construct the topic set
+	ev, ok := c.abi.Events[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("event '%s' not found", name)
+	}
+	topics, err := makeTopics(ev, query...)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Start the background filtering
+	logs := make(chan types.Log, 128)
+
+	config := tiltnet.FilterQuery{
+		Addresses: []common.Address{c.address},
+		Topics:    topics,
+		FromBlock: new(big.Int).SetUint64(opts.Start),
+	}
+	if opts.End != nil {
+		config.ToBlock = new(big.Int).SetUint64(*opts.End)
+	}
+	buff, err := c.filterer.FilterLogs(ensureContext(opts.Context), config)
+	if err != nil {
+		return nil, nil, err
+	}
+	sub := event.NewSubscription(func(quit <-chan struct{}) error {
+		for _, log := range buff {
+			select {
+			case logs <- log:
+			case <-quit:
+				return nil
+			}
+		}
+		return nil
+	})
+	return logs, sub, nil
+}
+
+// WatchLogs filters subscribes to contract logs for future blocks, returning a
+// subscription object that can be used to tear down the watcher.
+func (c *BoundContract) WatchLogs(opts *WatchOpts, name string, query ...[]interface{}) (chan types.Log, tiltnet.Subscription, error) {
+	// Don't crash on a lazy user
+	if opts == nil {
+		opts = new(WatchOpts)
+	}
+	if c.filterer == nil {
+		return nil, nil, ErrNoFilterer
+	}
+	// Append the event selector to the query parameters and construct the topic set
+	ev, ok := c.abi.Events[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("event '%s' not found", name)
+	}
+	topics, err := makeTopics(ev, query...)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Start the background filtering
+	logs := make(chan types.Log, 128)
+
+	config := tiltnet.FilterQuery{
+		Addresses: []common.Address{c.address},
+		Topics:    topics,
+	}
+	if opts.Start != nil {
+		config.FromBlock = new(big.Int).SetUint64(*opts.Start)
+	}
+	sub, err := c.filterer.SubscribeFilterLogs(ensureContext(opts.Context), config, logs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logs, sub, nil
+}
+
+// UnpackLog unpacks a retrieved log into the provided output structure, the
+// non-indexed fields from the log data and the indexed ones from its topics.
+func (c *BoundContract) UnpackLog(out interface{}, name string, log types.Log) error {
+	ev, ok := c.abi.Events[name]
+	if !ok {
+		return fmt.Errorf("event '%s' not found", name)
+	}
+	if len(log.Data) > 0 {
+		if err := c.abi.Unpack(out, name, log.Data); err != nil {
+			return err
+		}
+	}
+	var indexed []abi.Argument
+	for _, arg := range ev.Inputs {
+		if arg.Indexed {
+			indexed = append(indexed, arg)
+		}
+	}
+	topics := log.Topics
+	if !ev.Anonymous {
+		if len(topics) == 0 || topics[0] != ev.Id() {
+			return errEventSignatureMismatch
+		}
+		topics = topics[1:]
+	}
+	return parseTopics(out, indexed, topics)
+}
+
 func ensureContext(ctx context.Context) context.Context {
 	if ctx == nil {
 		return context.TODO()
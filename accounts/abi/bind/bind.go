@@ -24,6 +24,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 	"unicode"
@@ -47,7 +48,10 @@ const (
 // manually maintain hard coded strings that break on runtime.
 func Bind(types []string, abis []string, bytecodes []string, pkg string, lang Lang) (string, error) {
 	// Process each individual contract requested binding
-	contracts := make(map[string]*tmplContract)
+	var (
+		contracts = make(map[string]*tmplContract)
+		structs   = make(map[string]*tmplStruct)
+	)
 
 	for i := 0; i < len(types); i++ {
 		// Parse the actual ABI to generate the binding for
@@ -94,6 +98,21 @@ func Bind(types []string, abis []string, bytecodes []string, pkg string, lang La
 				transacts[original.Name] = &tmplMethod{Original: original, Normalized: normalized, Structured: structured(original)}
 			}
 		}
+		// Extract the events, normalizing their names and inputs the same way
+		events := make(map[string]*tmplEvent)
+		for _, original := range tiltvmABI.Events {
+			normalized := original
+			normalized.Name = methodNormalizer[lang](original.Name)
+
+			normalized.Inputs = make([]abi.Argument, len(original.Inputs))
+			copy(normalized.Inputs, original.Inputs)
+			for j, input := range normalized.Inputs {
+				if input.Name == "" {
+					normalized.Inputs[j].Name = fmt.Sprintf("arg%d", j)
+				}
+			}
+			events[original.Name] = &tmplEvent{Original: original, Normalized: normalized}
+		}
 		contracts[types[i]] = &tmplContract{
 			Type:        capitalise(types[i]),
 			InputABI:    strings.Replace(strippedABI, "\"", "\\\"", -1),
@@ -101,17 +120,28 @@ func Bind(types []string, abis []string, bytecodes []string, pkg string, lang La
 			Constructor: tiltvmABI.Constructor,
 			Calls:       calls,
 			Transacts:   transacts,
+			Events:      events,
+		}
+		// Assign the struct types of the tuples in the order they are declared in
+		if lang == LangGo {
+			bindStructs(tiltvmABI, structs)
 		}
 	}
 	// Generate the contract template data content and render it
 	data := &tmplData{
 		Package:   pkg,
 		Contracts: contracts,
+		Structs:   structs,
 	}
 	buffer := new(bytes.Buffer)
 
 	funcs := map[string]interface{}{
-		"bindtype":     bindType[lang],
+		"bindtype": func(kind abi.Type) string {
+			return bindType[lang](kind, structs)
+		},
+		"bindtopictype": func(kind abi.Type) string {
+			return bindTopicType[lang](kind, structs)
+		},
 		"namedtype":    namedType[lang],
 		"capitalise":   capitalise,
 		"decapitalise": decapitalise,
@@ -134,7 +164,7 @@ func Bind(types []string, abis []string, bytecodes []string, pkg string, lang La
 
 // bindType is a set of type binders that convert Solidity types to some supported
 // programming language.
-var bindType = map[Lang]func(kind abi.Type) string{
+var bindType = map[Lang]func(kind abi.Type, structs map[string]*tmplStruct) string{
 	LangGo:   bindTypeGo,
 	LangJava: bindTypeJava,
 }
@@ -142,10 +172,13 @@ var bindType = map[Lang]func(kind abi.Type) string{
 // bindTypeGo converts a Solidity type to a Go one. Since there is no clear mapping
 // from all Solidity types to Go ones (e.g. uint17), those that cannot be exactly
 // mapped will use an upscaled type (e.g. *big.Int).
-func bindTypeGo(kind abi.Type) string {
+func bindTypeGo(kind abi.Type, structs map[string]*tmplStruct) string {
 	stringKind := kind.String()
 
 	switch {
+	case kind.T == abi.TupleTy:
+		return bindStructTypeGo(kind, structs)
+
 	case strings.HasPrefix(stringKind, "address"):
 		parts := regexp.MustCompile(`address(\[[0-9]*\])?`).FindStringSubmatch(stringKind)
 		if len(parts) != 2 {
@@ -183,10 +216,37 @@ func bindTypeGo(kind abi.Type) string {
 	}
 }
 
+// bindTopicType is a set of type binders that convert Solidity types of indexed
+// event inputs to some supported programming language.
+var bindTopicType = map[Lang]func(kind abi.Type, structs map[string]*tmplStruct) string{
+	LangGo:   bindTopicTypeGo,
+	LangJava: bindTopicTypeJava,
+}
+
+// bindTopicTypeGo converts the type of an indexed event input to a Go one. As
+// dynamic types are only stored as their hash in the log topics, those can't be
+// recovered and bind to a hash instead.
+func bindTopicTypeGo(kind abi.Type, structs map[string]*tmplStruct) string {
+	if hashedTopic(kind) {
+		return "common.Hash"
+	}
+	return bindTypeGo(kind, structs)
+}
+
+// bindTopicTypeJava converts the type of an indexed event input to a Java one,
+// binding dynamic types to their hash as stored in the log topics.
+func bindTopicTypeJava(kind abi.Type, structs map[string]*tmplStruct) string {
+	if hashedTopic(kind) {
+		return "Hash"
+	}
+	return bindTypeJava(kind, structs)
+}
+
 // bindTypeJava converts a Solidity type to a Java one. Since there is no clear mapping
 // from all Solidity types to Java ones (e.g. uint17), those that cannot be exactly
-// mapped will use an upscaled type (e.g. BigDecimal).
-func bindTypeJava(kind abi.Type) string {
+// mapped will use an upscaled type (e.g. BigDecimal). Tuples are not supported
+// and are left as their solidity representation.
+func bindTypeJava(kind abi.Type, structs map[string]*tmplStruct) string {
 	stringKind := kind.String()
 
 	switch {
@@ -254,6 +314,90 @@ func bindTypeJava(kind abi.Type) string {
 	}
 }
 
+// bindStructs assigns a struct type to every tuple (and nested tuple) used in
+// the arguments of the contract, going through the constructor, methods and
+// events in alphabetical order so that unnamed structs are numbered stably.
+func bindStructs(contract abi.ABI, structs map[string]*tmplStruct) {
+	var args []abi.Argument
+	args = append(args, contract.Constructor.Inputs...)
+
+	methods := make([]string, 0, len(contract.Methods))
+	for name := range contract.Methods {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	for _, name := range methods {
+		args = append(args, contract.Methods[name].Inputs...)
+		args = append(args, contract.Methods[name].Outputs...)
+	}
+	events := make([]string, 0, len(contract.Events))
+	for name := range contract.Events {
+		events = append(events, name)
+	}
+	sort.Strings(events)
+	for _, name := range events {
+		for _, input := range contract.Events[name].Inputs {
+			// Indexed tuples are only available as their hash
+			if !input.Indexed {
+				args = append(args, input)
+			}
+		}
+	}
+	for _, arg := range args {
+		if arg.Type.T == abi.TupleTy {
+			bindStructTypeGo(arg.Type, structs)
+		}
+	}
+}
+
+// bindStructTypeGo converts a tuple, or an array of tuples, to the Go struct
+// type bound to it, generating the struct if it's the first time the tuple is
+// seen. Structs are named after the solidity struct the tuple was declared as
+// if known, or numbered otherwise.
+func bindStructTypeGo(kind abi.Type, structs map[string]*tmplStruct) string {
+	switch {
+	case kind.IsSlice:
+		return "[]" + bindStructTypeGo(*kind.Elem, structs)
+	case kind.IsArray:
+		return fmt.Sprintf("[%d]", kind.SliceSize) + bindStructTypeGo(*kind.Elem, structs)
+	}
+	id := kind.TupleRawName + kind.String()
+	if s, exist := structs[id]; exist {
+		return s.Name
+	}
+	// Bind the components first, nested tuples declaring their own structs
+	var fields []*tmplField
+	names, _ := abi.TupleFieldNames(kind.TupleRawNames) // validated when parsing the abi
+	for i, elem := range kind.TupleElems {
+		fields = append(fields, &tmplField{Type: bindTypeGo(*elem, structs), Name: names[i], SolKind: *elem})
+	}
+	// Unnamed structs are numbered, differently shaped ones of the same name suffixed
+	name := "Struct"
+	if kind.TupleRawName != "" {
+		name = capitalise(kind.TupleRawName)
+	}
+	if kind.TupleRawName == "" || structTaken(structs, name) {
+		base := name
+		for i := 0; ; i++ {
+			if name = fmt.Sprintf("%s%d", base, i); !structTaken(structs, name) {
+				break
+			}
+		}
+	}
+	structs[id] = &tmplStruct{Name: name, Fields: fields}
+	return name
+}
+
+// structTaken checks whether a struct type of the given name was already generated.
+func structTaken(structs map[string]*tmplStruct, name string) bool {
+	for _, s := range structs {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // namedType is a set of functions that transform language specific types to
 // named versions that my be used inside method names.
 var namedType = map[Lang]func(string, abi.Type) string{
@@ -0,0 +1,194 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package bind
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/megatilt/go-tilt/accounts/abi"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/math"
+	"github.com/megatilt/go-tilt/crypto"
+)
+
+// errEventSignatureMismatch is returned when unpacking a log whose first topic
+// doesn't match the signature of the requested event.
+var errEventSignatureMismatch = errors.New("event signature mismatch")
+
+// hashedTopic checks whether an indexed event argument is stored in the topics
+// as the hash of its value, which is the case for all but the elementary value
+// types.
+func hashedTopic(kind abi.Type) bool {
+	// Arrays keep the type of their elements, only their declaration tells them
+	// apart from the elementary types
+	if strings.HasSuffix(kind.String(), "]") {
+		return true
+	}
+	switch kind.T {
+	case abi.IntTy, abi.UintTy, abi.BoolTy, abi.AddressTy, abi.HashTy, abi.FixedBytesTy, abi.FunctionTy:
+		return false
+	}
+	return true
+}
+
+// makeTopics converts a filter query argument list into a filter topic set, with
+// the event signature prepended for non-anonymous events. Every argument list
+// holds the accepted values of the corresponding indexed event input, an empty
+// one matching any value.
+func makeTopics(event abi.Event, query ...[]interface{}) ([][]common.Hash, error) {
+	var topics [][]common.Hash
+	if !event.Anonymous {
+		topics = append(topics, []common.Hash{event.Id()})
+	}
+	for i, filter := range query {
+		var topic []common.Hash
+		for j, rule := range filter {
+			hash, err := makeTopic(rule)
+			if err != nil {
+				return nil, fmt.Errorf("topic %d, rule %d: %v", i, j, err)
+			}
+			topic = append(topic, hash)
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+// makeTopic encodes a single indexed event argument value into its topic form.
+func makeTopic(rule interface{}) (common.Hash, error) {
+	var topic common.Hash
+
+	switch rule := rule.(type) {
+	case common.Hash:
+		copy(topic[:], rule[:])
+	case common.Address:
+		copy(topic[common.HashLength-common.AddressLength:], rule[:])
+	case *big.Int:
+		copy(topic[:], math.PaddedBigBytes(math.U256(new(big.Int).Set(rule)), common.HashLength))
+	case bool:
+		if rule {
+			topic[common.HashLength-1] = 1
+		}
+	case int8:
+		copy(topic[:], math.PaddedBigBytes(math.U256(big.NewInt(int64(rule))), common.HashLength))
+	case int16:
+		copy(topic[:], math.PaddedBigBytes(math.U256(big.NewInt(int64(rule))), common.HashLength))
+	case int32:
+		copy(topic[:], math.PaddedBigBytes(math.U256(big.NewInt(int64(rule))), common.HashLength))
+	case int64:
+		copy(topic[:], math.PaddedBigBytes(math.U256(big.NewInt(rule)), common.HashLength))
+	case uint8:
+		copy(topic[:], math.PaddedBigBytes(new(big.Int).SetUint64(uint64(rule)), common.HashLength))
+	case uint16:
+		copy(topic[:], math.PaddedBigBytes(new(big.Int).SetUint64(uint64(rule)), common.HashLength))
+	case uint32:
+		copy(topic[:], math.PaddedBigBytes(new(big.Int).SetUint64(uint64(rule)), common.HashLength))
+	case uint64:
+		copy(topic[:], math.PaddedBigBytes(new(big.Int).SetUint64(rule), common.HashLength))
+	case string:
+		topic = crypto.Keccak256Hash([]byte(rule))
+	case []byte:
+		topic = crypto.Keccak256Hash(rule)
+	default:
+		// Fixed size byte arrays are left aligned, anything else is unsupported
+		val := reflect.ValueOf(rule)
+		if val.Kind() != reflect.Array || val.Type().Elem().Kind() != reflect.Uint8 || val.Len() > common.HashLength {
+			return common.Hash{}, fmt.Errorf("unsupported indexed type: %T", rule)
+		}
+		reflect.Copy(reflect.ValueOf(topic[:val.Len()]), val)
+	}
+	return topic, nil
+}
+
+// parseTopics converts the indexed topics of a log into the fields of the
+// provided output structure, matched by name to the indexed event inputs.
+func parseTopics(out interface{}, fields []abi.Argument, topics []common.Hash) error {
+	if len(fields) != len(topics) {
+		return fmt.Errorf("topic count mismatch: have %d, want %d", len(topics), len(fields))
+	}
+	value := reflect.ValueOf(out)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot unpack topics in to %T", out)
+	}
+	value = value.Elem()
+
+	for i, arg := range fields {
+		if arg.Name == "" {
+			continue // anonymous inputs can't be matched to fields
+		}
+		field := value.FieldByName(capitalise(arg.Name))
+		if !field.IsValid() || !field.CanSet() {
+			return fmt.Errorf("field %s can't be set", capitalise(arg.Name))
+		}
+		if err := setTopic(field, arg.Type, topics[i]); err != nil {
+			return fmt.Errorf("field %s: %v", capitalise(arg.Name), err)
+		}
+	}
+	return nil
+}
+
+// setTopic decodes a single topic into a field of the type bound to the given
+// indexed event input.
+func setTopic(field reflect.Value, kind abi.Type, topic common.Hash) error {
+	// Dynamic values are only available as their hash
+	if hashedTopic(kind) || kind.T == abi.HashTy {
+		return assignTopic(field, reflect.ValueOf(topic))
+	}
+	switch kind.T {
+	case abi.AddressTy:
+		return assignTopic(field, reflect.ValueOf(common.BytesToAddress(topic[:])))
+
+	case abi.BoolTy:
+		return assignTopic(field, reflect.ValueOf(topic[common.HashLength-1] != 0))
+
+	case abi.IntTy, abi.UintTy:
+		num := new(big.Int).SetBytes(topic[:])
+		if kind.T == abi.IntTy {
+			num = math.S256(num)
+		}
+		switch field.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			field.SetInt(num.Int64())
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			field.SetUint(num.Uint64())
+		default:
+			return assignTopic(field, reflect.ValueOf(num))
+		}
+		return nil
+
+	case abi.FixedBytesTy, abi.FunctionTy:
+		if field.Kind() != reflect.Array || field.Type().Elem().Kind() != reflect.Uint8 || field.Len() < kind.SliceSize {
+			return fmt.Errorf("cannot unpack %v in to %v", kind, field.Type())
+		}
+		reflect.Copy(field, reflect.ValueOf(topic[:kind.SliceSize]))
+		return nil
+	}
+	return fmt.Errorf("unsupported indexed type: %v", kind)
+}
+
+// assignTopic sets a decoded topic value into a field, if the types are compatible.
+func assignTopic(field, value reflect.Value) error {
+	if !value.Type().AssignableTo(field.Type()) {
+		return fmt.Errorf("cannot unpack %v in to %v", value.Type(), field.Type())
+	}
+	field.Set(value)
+	return nil
+}
@@ -33,6 +33,21 @@ type Event struct {
 	Inputs    []Argument
 }
 
+func (e Event) String() string {
+	inputs := make([]string, len(e.Inputs))
+	for i, input := range e.Inputs {
+		inputs[i] = fmt.Sprintf("%v %v", input.Type, input.Name)
+		if input.Indexed {
+			inputs[i] = fmt.Sprintf("%v indexed %v", input.Type, input.Name)
+		}
+	}
+	anonymous := ""
+	if e.Anonymous {
+		anonymous = " anonymous"
+	}
+	return fmt.Sprintf("event %v(%v)%s", e.Name, strings.Join(inputs, ", "), anonymous)
+}
+
 // Id returns the canonical representation of the event's signature used by the
 // abi definition to identify event names and types.
 func (e Event) Id() common.Hash {
@@ -44,3 +59,15 @@ func (e Event) Id() common.Hash {
 	}
 	return common.BytesToHash(crypto.Keccak256([]byte(fmt.Sprintf("%v(%v)", e.Name, strings.Join(types, ",")))))
 }
+
+// NonIndexed returns the inputs of the event which are not indexed, and thus
+// stored in the data of the log instead of its topics.
+func (e Event) NonIndexed() []Argument {
+	var args []Argument
+	for _, input := range e.Inputs {
+		if !input.Indexed {
+			args = append(args, input)
+		}
+	}
+	return args
+}
@@ -17,6 +17,7 @@
 package abi
 
 import (
+	"fmt"
 	"reflect"
 
 	"github.com/megatilt/go-tilt/common"
@@ -64,3 +65,81 @@ func packElement(t Type, reflectValue reflect.Value) []byte {
 	}
 	panic("abi: fatal error")
 }
+
+// packSequence packs the given values as a sequence of the given types, using
+// the head/tail encoding: static values are stored in place in the head, while
+// dynamic ones are appended to the tail and referenced by their offset from the
+// start of the sequence.
+func packSequence(types []Type, values []reflect.Value) ([]byte, error) {
+	var headLen int
+	for _, t := range types {
+		headLen += headSize(t)
+	}
+	var head, tail []byte
+	for i, t := range types {
+		packed, err := t.pack(values[i])
+		if err != nil {
+			return nil, err
+		}
+		if isDynamicType(t) {
+			head = append(head, packNum(reflect.ValueOf(headLen+len(tail)))...)
+			tail = append(tail, packed...)
+		} else {
+			head = append(head, packed...)
+		}
+	}
+	return append(head, tail...), nil
+}
+
+// packArray packs an array or slice as a sequence of its elements, prefixed by
+// its length if it's a slice. Dynamic elements are referenced by their offset
+// from the start of the elements, as any other sequence.
+func packArray(t Type, v reflect.Value) ([]byte, error) {
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, typeErr(t, v.Type())
+	}
+	if t.IsArray && v.Len() != t.SliceSize {
+		return nil, fmt.Errorf("abi: cannot use array of length %d as %v", v.Len(), t)
+	}
+	var (
+		types  = make([]Type, v.Len())
+		values = make([]reflect.Value, v.Len())
+	)
+	for i := 0; i < v.Len(); i++ {
+		types[i], values[i] = *t.Elem, v.Index(i)
+	}
+	packed, err := packSequence(types, values)
+	if err != nil {
+		return nil, err
+	}
+	if t.IsSlice {
+		return append(packNum(reflect.ValueOf(v.Len())), packed...), nil
+	}
+	return packed, nil
+}
+
+// packTuple packs a tuple from the given struct value. Struct fields are matched
+// to the tuple components by their abi tag or, failing that, by their
+// capitalised names.
+func packTuple(t Type, v reflect.Value) ([]byte, error) {
+	if v.Kind() != reflect.Struct {
+		return nil, typeErr(t, v.Type())
+	}
+	values := make([]reflect.Value, len(t.TupleElems))
+	for i, name := range t.TupleRawNames {
+		// anonymous components can only be matched by position
+		field, ok := fieldByABIName(v.Type(), name)
+		if !ok && name == "" && i < v.NumField() {
+			field, ok = i, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("abi: field for tuple component '%s' not found in %v", name, v.Type())
+		}
+		values[i] = v.Field(field)
+	}
+	types := make([]Type, len(t.TupleElems))
+	for i, elem := range t.TupleElems {
+		types[i] = *elem
+	}
+	return packSequence(types, values)
+}
@@ -19,6 +19,7 @@ package abi
 import (
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 // indirect recursively dereferences the value until it either gets the value
@@ -71,6 +72,11 @@ func mustArrayToByteSlice(value reflect.Value) reflect.Value {
 // set is a bit more lenient when it comes to assignment and doesn't force an as
 // strict ruleset as bare `reflect` does.
 func set(dst, src reflect.Value, output Argument) error {
+	// tuples and composite arrays are copied over element by element, in to any
+	// matching user type
+	if output.Type.T == TupleTy || isCompositeArray(output.Type) {
+		return assign(dst, src)
+	}
 	dstType := dst.Type()
 	srcType := src.Type()
 
@@ -91,3 +97,152 @@ func set(dst, src reflect.Value, output Argument) error {
 	}
 	return nil
 }
+
+// fieldByABIName returns the index of the exported struct field holding the abi
+// argument or tuple component of the given name: the one tagged `abi:"name"` or,
+// failing that, the one named after its capitalised or Go field name form.
+func fieldByABIName(typ reflect.Type, name string) (int, bool) {
+	if name == "" {
+		return 0, false
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		if field := typ.Field(i); field.PkgPath == "" && field.Tag.Get("abi") == name {
+			return i, true
+		}
+	}
+	for _, name := range []string{strings.ToUpper(name[:1]) + name[1:], toFieldName(name)} {
+		for i := 0; i < typ.NumField(); i++ {
+			if field := typ.Field(i); field.PkgPath == "" && field.Name == name {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// ToCamelCase converts an underscore separated name in to its camel case form,
+// e.g. "owner_id" in to "OwnerId".
+func ToCamelCase(input string) string {
+	parts := strings.Split(input, "_")
+	for i, part := range parts {
+		if len(part) > 0 {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// toFieldName converts an abi name in to an exported Go identifier, camel cased
+// and prefixed with an X if not starting with a letter (e.g. "_owner" in to
+// "XOwner" and "1st" in to "X1st").
+func toFieldName(name string) string {
+	if name == "" {
+		return ""
+	}
+	if c := name[0]; c == '_' || (c >= '0' && c <= '9') {
+		return "X" + ToCamelCase(name)
+	}
+	return ToCamelCase(name)
+}
+
+// validFieldName checks whether a name is an exported Go identifier made of
+// ASCII letters, digits and underscores.
+func validFieldName(name string) bool {
+	if name == "" || name[0] < 'A' || name[0] > 'Z' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// TupleFieldNames returns the names of the Go struct fields holding the tuple
+// components of the given names: anonymous components are numbered as FieldN,
+// named ones converted in to exported identifiers, and names colliding with an
+// earlier one suffixed with a number. An error is returned for component names
+// that can't be converted.
+func TupleFieldNames(components []string) ([]string, error) {
+	var (
+		names = make([]string, len(components))
+		used  = make(map[string]bool)
+	)
+	for i, component := range components {
+		name := fmt.Sprintf("Field%d", i)
+		if component != "" {
+			if name = toFieldName(component); !validFieldName(name) {
+				return nil, fmt.Errorf("abi: invalid tuple component name '%s'", component)
+			}
+		}
+		if used[name] {
+			base := name
+			for j := 0; used[name]; j++ {
+				name = fmt.Sprintf("%s%d", base, j)
+			}
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names, nil
+}
+
+// assign recursively copies the unpacked value of a tuple (or of one of its
+// components) in to dst. Structs are matched field by field on their abi names,
+// slices and arrays element by element, and pointers are allocated as needed.
+func assign(dst, src reflect.Value) error {
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assign(dst.Elem(), src)
+
+	case reflect.Struct:
+		if src.Kind() != reflect.Struct {
+			break
+		}
+		for i := 0; i < src.NumField(); i++ {
+			// anonymous components can only be matched by position
+			name := src.Type().Field(i).Tag.Get("abi")
+			j, ok := fieldByABIName(dst.Type(), name)
+			if !ok && name == "" && i < dst.NumField() {
+				j, ok = i, true
+			}
+			if !ok {
+				continue
+			}
+			if err := assign(dst.Field(j), src.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+			break
+		}
+		// fixed size bytes are unpacked as full words
+		if dst.Kind() == reflect.Array && src.Type() == r_bytes {
+			reflect.Copy(dst, src)
+			return nil
+		}
+		if dst.Kind() == reflect.Slice {
+			dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+		} else if dst.Len() != src.Len() {
+			return fmt.Errorf("abi: cannot unmarshal src (len=%d) in to dst (len=%d)", src.Len(), dst.Len())
+		}
+		for i := 0; i < src.Len(); i++ {
+			if err := assign(dst.Index(i), src.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("abi: cannot unmarshal %v in to %v", src.Type(), dst.Type())
+}
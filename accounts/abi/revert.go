@@ -0,0 +1,142 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/crypto"
+)
+
+// revertSelector is the selector prefixing the Error(string) payloads of reverts
+// carrying a plain reason string.
+var revertSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+// errShortRevert is returned when unpacking a revert payload too short to even
+// hold a selector.
+var errShortRevert = errors.New("abi: revert payload too short")
+
+// Error is a custom error a contract may revert with. The Error holds type
+// information (inputs) about the arguments it carries.
+type Error struct {
+	Name   string
+	Inputs []Argument
+}
+
+func (e Error) String() string {
+	inputs := make([]string, len(e.Inputs))
+	for i, input := range e.Inputs {
+		inputs[i] = fmt.Sprintf("%v %v", input.Type, input.Name)
+	}
+	return fmt.Sprintf("error %v(%v)", e.Name, strings.Join(inputs, ", "))
+}
+
+// Sig returns the error's string signature according to the ABI spec.
+//
+// Example
+//
+//	error Unauthorized(address caller)    =    "Unauthorized(address)"
+func (e Error) Sig() string {
+	types := make([]string, len(e.Inputs))
+	for i, input := range e.Inputs {
+		types[i] = input.Type.String()
+	}
+	return fmt.Sprintf("%v(%v)", e.Name, strings.Join(types, ","))
+}
+
+// Id returns the selector prefixing the revert payloads of the error.
+func (e Error) Id() []byte {
+	return crypto.Keccak256([]byte(e.Sig()))[:4]
+}
+
+// RevertError is the decoded payload of a reverted call, either a plain reason
+// string or one of the custom errors of the called contract.
+type RevertError struct {
+	Name   string        // Name of the custom error, "Error" for plain reasons, empty if unknown
+	Reason string        // Reason string of plain reverts
+	Args   []interface{} // Arguments carried by custom errors
+	Data   []byte        // Raw revert payload, selector included
+}
+
+func (e *RevertError) Error() string {
+	switch e.Name {
+	case "":
+		return "execution reverted"
+	case "Error":
+		return "execution reverted: " + e.Reason
+	}
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = fmt.Sprintf("%v", arg)
+	}
+	return fmt.Sprintf("execution reverted: %s(%s)", e.Name, strings.Join(args, ", "))
+}
+
+// ErrorData returns the raw revert payload, hex encoded the way nodes report it.
+func (e *RevertError) ErrorData() interface{} {
+	return hexutil.Encode(e.Data)
+}
+
+// UnpackRevert decodes the reason string of an Error(string) revert payload.
+func UnpackRevert(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", errShortRevert
+	}
+	if !bytes.Equal(data[:4], revertSelector) {
+		return "", fmt.Errorf("abi: not a revert reason: selector %x", data[:4])
+	}
+	typ, _ := NewType("string")
+	values, err := readSequence([]Type{typ}, data[4:])
+	if err != nil {
+		return "", err
+	}
+	return values[0].(string), nil
+}
+
+// UnpackRevert decodes a revert payload, resolving both plain reason strings and
+// the custom errors declared in the ABI.
+func (abi ABI) UnpackRevert(data []byte) (*RevertError, error) {
+	if len(data) < 4 {
+		return nil, errShortRevert
+	}
+	if bytes.Equal(data[:4], revertSelector) {
+		reason, err := UnpackRevert(data)
+		if err != nil {
+			return nil, err
+		}
+		return &RevertError{Name: "Error", Reason: reason, Data: data}, nil
+	}
+	for _, e := range abi.Errors {
+		if !bytes.Equal(data[:4], e.Id()) {
+			continue
+		}
+		types := make([]Type, len(e.Inputs))
+		for i, input := range e.Inputs {
+			types[i] = input.Type
+		}
+		args, err := readSequence(types, data[4:])
+		if err != nil {
+			return nil, fmt.Errorf("abi: error %s: %v", e.Name, err)
+		}
+		return &RevertError{Name: e.Name, Args: args, Data: data}, nil
+	}
+	return nil, fmt.Errorf("abi: unknown revert selector %x", data[:4])
+}
@@ -18,9 +18,11 @@ package abi
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -35,6 +37,7 @@ const (
 	HashTy
 	FixedpointTy
 	FunctionTy
+	TupleTy
 )
 
 // Type is the reflection of the supported argument type
@@ -49,6 +52,10 @@ type Type struct {
 	Size int
 	T    byte // Our own type checking
 
+	TupleElems    []*Type  // Type information of the tuple components
+	TupleRawNames []string // Original names of the tuple components
+	TupleRawName  string   // Name of the struct the tuple was declared as, if known
+
 	stringKind string // holds the unparsed string for deriving signatures
 }
 
@@ -181,25 +188,20 @@ func (t Type) pack(v reflect.Value) ([]byte, error) {
 	// dereference pointer first if it's a pointer
 	v = indirect(v)
 
+	// tuples are encoded component by component, arrays of them element by element
+	if t.T == TupleTy {
+		if isArrayType(t) {
+			return packArray(t, v)
+		}
+		return packTuple(t, v)
+	}
+
 	if err := typeCheck(t, v); err != nil {
 		return nil, err
 	}
 
-	if (t.IsSlice || t.IsArray) && t.T != BytesTy && t.T != FixedBytesTy && t.T != FunctionTy {
-		var packed []byte
-
-		for i := 0; i < v.Len(); i++ {
-			val, err := t.Elem.pack(v.Index(i))
-			if err != nil {
-				return nil, err
-			}
-			packed = append(packed, val...)
-		}
-		if t.IsSlice {
-			return packBytesSlice(packed, v.Len()), nil
-		} else if t.IsArray {
-			return packed, nil
-		}
+	if isArrayType(t) {
+		return packArray(t, v)
 	}
 
 	return packElement(t, v), nil
@@ -210,3 +212,170 @@ func (t Type) pack(v reflect.Value) ([]byte, error) {
 func (t Type) requiresLengthPrefix() bool {
 	return t.T != FixedBytesTy && (t.T == StringTy || t.T == BytesTy || t.IsSlice)
 }
+
+// NewTupleType creates a new reflection type of a tuple, or an array of tuples,
+// given the abi type in t (e.g. "tuple", "tuple[]" or "tuple[2]") and the
+// arguments describing its components. The internal type, if known, names the
+// struct the tuple was declared as (e.g. "struct Ballot.Voter").
+func NewTupleType(t string, internal string, components []Argument) (typ Type, err error) {
+	// Arrays of tuples wrap the tuple type described by the rest of the string
+	if strings.HasSuffix(t, "]") {
+		open := strings.LastIndex(t, "[")
+		if open < 0 {
+			return Type{}, fmt.Errorf("abi: type parse error: %s", t)
+		}
+		elem, err := NewTupleType(t[:open], strings.TrimSuffix(internal, t[open:]), components)
+		if err != nil {
+			return Type{}, err
+		}
+		typ = elem
+		typ.Elem = &elem
+		typ.TupleElems, typ.TupleRawNames, typ.TupleRawName = nil, nil, ""
+
+		if size := t[open+1 : len(t)-1]; size == "" {
+			typ.IsSlice, typ.IsArray, typ.SliceSize = true, false, -1
+			typ.Kind, typ.Type = reflect.Slice, reflect.SliceOf(elem.Type)
+		} else {
+			if typ.SliceSize, err = strconv.Atoi(size); err != nil {
+				return Type{}, fmt.Errorf("abi: error parsing array size: %v", err)
+			}
+			typ.IsSlice, typ.IsArray = false, true
+			typ.Kind, typ.Type = reflect.Array, reflect.ArrayOf(typ.SliceSize, elem.Type)
+		}
+		typ.stringKind = elem.stringKind + t[open:]
+		return typ, nil
+	}
+	if t != "tuple" {
+		return Type{}, fmt.Errorf("abi: type parse error: %s", t)
+	}
+	var (
+		fields = make([]reflect.StructField, len(components))
+		kinds  = make([]string, len(components))
+		raws   = make([]string, len(components))
+	)
+	for i, c := range components {
+		raws[i] = c.Name
+	}
+	names, err := TupleFieldNames(raws)
+	if err != nil {
+		return Type{}, err
+	}
+	for i, c := range components {
+		elem := c.Type
+		typ.TupleElems = append(typ.TupleElems, &elem)
+		typ.TupleRawNames = append(typ.TupleRawNames, c.Name)
+		kinds[i] = elem.String()
+
+		fields[i] = reflect.StructField{
+			Name: names[i],
+			Type: reflectType(elem),
+			Tag:  reflect.StructTag(fmt.Sprintf(`abi:"%s"`, c.Name)),
+		}
+	}
+	if strings.HasPrefix(internal, "struct ") {
+		name := internal[len("struct "):]
+		typ.TupleRawName = name[strings.LastIndex(name, ".")+1:]
+	}
+	typ.Kind = reflect.Struct
+	typ.Type = reflect.StructOf(fields)
+	typ.T = TupleTy
+	typ.stringKind = "(" + strings.Join(kinds, ",") + ")"
+
+	return typ, nil
+}
+
+// reflectType returns the Go type values of the abi type are unpacked in to.
+func reflectType(t Type) reflect.Type {
+	switch {
+	case isArrayType(t) && t.IsSlice:
+		return reflect.SliceOf(reflectType(*t.Elem))
+	case isArrayType(t):
+		return reflect.ArrayOf(t.SliceSize, reflectType(*t.Elem))
+	case t.T == TupleTy:
+		return t.Type
+	case t.T == StringTy:
+		return reflect.TypeOf("")
+	case t.T == BytesTy:
+		return reflect.TypeOf([]byte(nil))
+	case t.T == FixedBytesTy || t.T == FunctionTy:
+		return reflect.ArrayOf(t.SliceSize, reflect.TypeOf(byte(0)))
+	}
+	switch t.T {
+	case IntTy, UintTy:
+		switch t.Kind {
+		case reflect.Int8:
+			return int8_t
+		case reflect.Int16:
+			return int16_t
+		case reflect.Int32:
+			return int32_t
+		case reflect.Int64:
+			return int64_t
+		case reflect.Uint8:
+			return uint8_t
+		case reflect.Uint16:
+			return uint16_t
+		case reflect.Uint32:
+			return uint32_t
+		case reflect.Uint64:
+			return uint64_t
+		}
+		return reflect.TypeOf((*big.Int)(nil))
+	case BoolTy:
+		return reflect.TypeOf(false)
+	case AddressTy:
+		return address_t
+	case HashTy:
+		return hash_t
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
+// isArrayType returns whether the type is an array or slice of values, as
+// opposed to an elementary byte sequence like bytes, bytesN or function.
+func isArrayType(t Type) bool {
+	return (t.IsSlice || t.IsArray) && t.T != BytesTy && t.T != FixedBytesTy && t.T != FunctionTy
+}
+
+// isCompositeArray returns whether the type is an array whose elements are
+// dynamic or arrays themselves, encoded as a nested sequence of their own.
+func isCompositeArray(t Type) bool {
+	return isArrayType(t) && (isDynamicType(*t.Elem) || isArrayType(*t.Elem))
+}
+
+// isDynamicType returns whether the encoding of the type is stored in the tail
+// of its enclosing sequence, referenced by an offset from the head.
+func isDynamicType(t Type) bool {
+	if isArrayType(t) {
+		return t.IsSlice || isDynamicType(*t.Elem)
+	}
+	if t.T == TupleTy {
+		for _, elem := range t.TupleElems {
+			if isDynamicType(*elem) {
+				return true
+			}
+		}
+		return false
+	}
+	return t.T == StringTy || t.T == BytesTy
+}
+
+// headSize returns the number of bytes the type occupies in the head of its
+// enclosing sequence. Static tuples and arrays are stored in place, everything
+// else takes a single word.
+func headSize(t Type) int {
+	if isDynamicType(t) {
+		return 32
+	}
+	if isArrayType(t) {
+		return t.SliceSize * headSize(*t.Elem)
+	}
+	if t.T == TupleTy {
+		size := 0
+		for _, elem := range t.TupleElems {
+			size += headSize(*elem)
+		}
+		return size
+	}
+	return 32
+}
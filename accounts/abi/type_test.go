@@ -0,0 +1,165 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/megatilt/go-tilt/common"
+)
+
+// Tests that tuple component names are converted in to exported Go field names,
+// numbering anonymous components and de-duplicating colliding names.
+func TestTupleFieldNames(t *testing.T) {
+	tests := []struct {
+		components []string
+		names      []string
+		fail       bool
+	}{
+		{[]string{"owner", "amount"}, []string{"Owner", "Amount"}, false},
+		{[]string{"_owner", "owner_id", "1st"}, []string{"XOwner", "OwnerId", "X1st"}, false},
+		{[]string{"", "value", ""}, []string{"Field0", "Value", "Field2"}, false},
+		{[]string{"value", "Value", "_value"}, []string{"Value", "Value0", "XValue"}, false},
+		{[]string{"field1", ""}, []string{"Field1", "Field10"}, false},
+		{[]string{"a-b"}, nil, true},
+		{[]string{"ünicode"}, nil, true},
+	}
+	for i, tt := range tests {
+		names, err := TupleFieldNames(tt.components)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: expected failure, have %v", i, names)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to convert names: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("test %d: names mismatch: have %v, want %v", i, names, tt.names)
+		}
+	}
+}
+
+// Tests that tuples with component names which aren't valid Go identifiers are
+// rejected with an error instead of panicking.
+func TestTupleInvalidComponent(t *testing.T) {
+	def := `[{"name":"f","type":"function","inputs":[{"name":"t","type":"tuple","components":[{"name":"a b","type":"uint256"}]}]}]`
+	if _, err := JSON(strings.NewReader(def)); err == nil {
+		t.Fatalf("invalid component name accepted")
+	}
+}
+
+const tupleABI = `[
+	{"name":"single","type":"function",
+		"inputs":[{"name":"t","type":"tuple","components":[{"name":"_owner","type":"address"},{"name":"amount","type":"uint256"}]}],
+		"outputs":[{"name":"t","type":"tuple","components":[{"name":"_owner","type":"address"},{"name":"amount","type":"uint256"}]}]},
+	{"name":"list","type":"function",
+		"inputs":[{"name":"t","type":"tuple[]","components":[{"name":"_owner","type":"address"},{"name":"amount","type":"uint256"}]}],
+		"outputs":[{"name":"t","type":"tuple[]","components":[{"name":"_owner","type":"address"},{"name":"amount","type":"uint256"}]}]},
+	{"name":"nested","type":"function",
+		"inputs":[{"name":"t","type":"tuple","components":[{"name":"id","type":"uint256"},{"name":"inner","type":"tuple","components":[{"name":"flag","type":"bool"},{"name":"data","type":"bytes"}]}]}],
+		"outputs":[{"name":"t","type":"tuple","components":[{"name":"id","type":"uint256"},{"name":"inner","type":"tuple","components":[{"name":"flag","type":"bool"},{"name":"data","type":"bytes"}]}]}]}
+]`
+
+type testPayment struct {
+	XOwner common.Address
+	Amount *big.Int
+}
+
+type testInner struct {
+	Flag bool
+	Data []byte
+}
+
+type testOuter struct {
+	Id    *big.Int
+	Inner testInner
+}
+
+// Tests that tuples, tuple arrays and nested tuples are packed according to the
+// abi specification and unpacked back in to the same values.
+func TestTuplePackUnpack(t *testing.T) {
+	abi, err := JSON(strings.NewReader(tupleABI))
+	if err != nil {
+		t.Fatalf("failed to parse abi: %v", err)
+	}
+	owner := common.HexToAddress("0x0102030405060708090a0b0c0d0e0f1011121314")
+
+	tests := []struct {
+		method string
+		in     interface{}
+		out    interface{} // pointer to the zero value to unpack in to
+		words  []string
+	}{
+		{
+			"single", testPayment{owner, big.NewInt(5)}, new(testPayment),
+			[]string{
+				"0000000000000000000000000102030405060708090a0b0c0d0e0f1011121314",
+				"0000000000000000000000000000000000000000000000000000000000000005",
+			},
+		},
+		{
+			"list", []testPayment{{owner, big.NewInt(1)}, {common.Address{}, big.NewInt(2)}}, new([]testPayment),
+			[]string{
+				"0000000000000000000000000000000000000000000000000000000000000020",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+				"0000000000000000000000000102030405060708090a0b0c0d0e0f1011121314",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"0000000000000000000000000000000000000000000000000000000000000000",
+				"0000000000000000000000000000000000000000000000000000000000000002",
+			},
+		},
+		{
+			"nested", testOuter{big.NewInt(1), testInner{true, []byte{0xde, 0xad, 0xbe, 0xef}}}, new(testOuter),
+			[]string{
+				"0000000000000000000000000000000000000000000000000000000000000020",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"0000000000000000000000000000000000000000000000000000000000000040",
+				"0000000000000000000000000000000000000000000000000000000000000001",
+				"0000000000000000000000000000000000000000000000000000000000000040",
+				"0000000000000000000000000000000000000000000000000000000000000004",
+				"deadbeef00000000000000000000000000000000000000000000000000000000",
+			},
+		},
+	}
+	for _, tt := range tests {
+		packed, err := abi.Pack(tt.method, tt.in)
+		if err != nil {
+			t.Errorf("%s: failed to pack: %v", tt.method, err)
+			continue
+		}
+		want, _ := hex.DecodeString(strings.Join(tt.words, ""))
+		if !bytes.Equal(packed[4:], want) {
+			t.Errorf("%s: encoding mismatch:\nhave %x\nwant %x", tt.method, packed[4:], want)
+			continue
+		}
+		if err := abi.Unpack(tt.out, tt.method, packed[4:]); err != nil {
+			t.Errorf("%s: failed to unpack: %v", tt.method, err)
+			continue
+		}
+		if have := reflect.ValueOf(tt.out).Elem().Interface(); !reflect.DeepEqual(have, tt.in) {
+			t.Errorf("%s: unpacked value mismatch: have %+v, want %+v", tt.method, have, tt.in)
+		}
+	}
+}
@@ -148,8 +148,9 @@ type Backend interface {
 }
 
 // WalletEvent is an event fired by an account backend when a wallet arrival or
-// departure is detected.
+// departure is detected, or when the state of a wallet changes.
 type WalletEvent struct {
-	Wallet Wallet // Wallet instance arrived or departed
-	Arrive bool   // Whether the wallet was added or removed
+	Wallet  Wallet // Wallet instance arrived, departed or changed
+	Arrive  bool   // Whether the wallet was added or removed
+	Changed bool   // Whether the wallet only changed state (neither added nor removed)
 }
@@ -0,0 +1,353 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package external implements an accounts.Backend forwarding all signing
+// requests to an external signer process over JSON-RPC, keeping the private
+// keys out of the node's address space.
+package external
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	tiltnet "github.com/megatilt/go-tilt"
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/typeddata"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/event"
+	"github.com/megatilt/go-tilt/log"
+	"github.com/megatilt/go-tilt/rpc"
+)
+
+// Scheme is the protocol scheme prefixing the URLs of external signer wallets.
+const Scheme = "extapi"
+
+// errPassphraseNotSupported is returned if a passphrase based operation is
+// requested from an external signer, which authorizes requests on its own.
+var errPassphraseNotSupported = errors.New("passphrase operations not supported on external signers")
+
+// SendTxArgs represents the fields of a transaction sent to the external signer
+// for approval and signing.
+type SendTxArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      *hexutil.Big    `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Nonce    hexutil.Uint64  `json:"nonce"`
+	Data     hexutil.Bytes   `json:"data"`
+}
+
+// NewSendTxArgs converts an unsigned transaction and its sender into the
+// argument set of a signing request.
+func NewSendTxArgs(from common.Address, tx *types.Transaction) SendTxArgs {
+	return SendTxArgs{
+		From:     from,
+		To:       tx.To(),
+		Gas:      (*hexutil.Big)(tx.Gas()),
+		GasPrice: (*hexutil.Big)(tx.GasPrice()),
+		Value:    (*hexutil.Big)(tx.Value()),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		Data:     tx.Data(),
+	}
+}
+
+// ToTransaction assembles the unsigned transaction described by the arguments.
+func (args *SendTxArgs) ToTransaction() *types.Transaction {
+	var gas, gasPrice, value = new(big.Int), new(big.Int), new(big.Int)
+	if args.Gas != nil {
+		gas = args.Gas.ToInt()
+	}
+	if args.GasPrice != nil {
+		gasPrice = args.GasPrice.ToInt()
+	}
+	if args.Value != nil {
+		value = args.Value.ToInt()
+	}
+	if args.To == nil {
+		return types.NewContractCreation(uint64(args.Nonce), value, gas, gasPrice, args.Data)
+	}
+	return types.NewTransaction(uint64(args.Nonce), *args.To, value, gas, gasPrice, args.Data)
+}
+
+// SignTxResult is the reply of the external signer to a transaction signing
+// request.
+type SignTxResult struct {
+	Raw hexutil.Bytes      `json:"raw"`
+	Tx  *types.Transaction `json:"tx"`
+}
+
+// ExternalBackend is an accounts.Backend exposing the single wallet of an
+// external signer.
+type ExternalBackend struct {
+	signers []accounts.Wallet
+}
+
+// NewExternalBackend connects to the external signer at the given endpoint (an
+// IPC path or an HTTP/WebSocket URL) and creates a backend wrapping it.
+func NewExternalBackend(endpoint string) (*ExternalBackend, error) {
+	signer, err := NewExternalSigner(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &ExternalBackend{
+		signers: []accounts.Wallet{signer},
+	}, nil
+}
+
+// Wallets implements accounts.Backend, returning the external signer wallet.
+func (eb *ExternalBackend) Wallets() []accounts.Wallet {
+	return eb.signers
+}
+
+// Subscribe implements accounts.Backend. The wallet of an external signer never
+// arrives or departs, so no events are ever delivered.
+func (eb *ExternalBackend) Subscribe(sink chan<- accounts.WalletEvent) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	})
+}
+
+// ExternalSigner is an accounts.Wallet forwarding its requests to an external
+// signer process, which holds the keys and approves every operation itself.
+type ExternalSigner struct {
+	client   *rpc.Client
+	endpoint string
+	status   string
+
+	listLock  sync.Mutex         // Serializes the account listings prompting the user
+	cacheLock sync.RWMutex       // Protects the cached account list
+	cache     []accounts.Account // Accounts exposed by the signer, nil if not yet listed
+}
+
+// NewExternalSigner dials the external signer at the given endpoint, verifying
+// that it is reachable.
+func NewExternalSigner(endpoint string) (*ExternalSigner, error) {
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	signer := &ExternalSigner{
+		client:   client,
+		endpoint: endpoint,
+	}
+	// Check that the signer is reachable and speaks the expected protocol
+	var version string
+	if err := client.Call(&version, "account_version"); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("external signer unreachable: %v", err)
+	}
+	signer.status = fmt.Sprintf("ok [version=%v]", version)
+	return signer, nil
+}
+
+// URL implements accounts.Wallet, returning the endpoint of the external signer.
+func (api *ExternalSigner) URL() accounts.URL {
+	return accounts.URL{Scheme: Scheme, Path: api.endpoint}
+}
+
+// Status implements accounts.Wallet, returning the status of the connection to
+// the external signer.
+func (api *ExternalSigner) Status() string {
+	return api.status
+}
+
+// Open implements accounts.Wallet. The external signer manages its own keys, so
+// there is nothing to unlock, but opening refreshes the cached list of accounts
+// exposed by the signer, which may prompt its user to approve the listing.
+func (api *ExternalSigner) Open(passphrase string) error {
+	_, err := api.refresh()
+	return err
+}
+
+// Close implements accounts.Wallet, however the external signer manages its own
+// keys, so there is nothing to close.
+func (api *ExternalSigner) Close() error {
+	return accounts.ErrNotSupported
+}
+
+// Accounts implements accounts.Wallet, returning the accounts the external signer
+// is willing to expose. As every listing may require the approval of the signer's
+// user, the accounts are only retrieved on first use and cached afterwards, until
+// explicitly refreshed by opening the wallet.
+func (api *ExternalSigner) Accounts() []accounts.Account {
+	api.cacheLock.RLock()
+	cache := api.cache
+	api.cacheLock.RUnlock()
+
+	if cache == nil {
+		// Make sure concurrent first uses don't prompt the user multiple times
+		api.listLock.Lock()
+		api.cacheLock.RLock()
+		cache = api.cache
+		api.cacheLock.RUnlock()
+
+		if cache == nil {
+			var err error
+			if cache, err = api.listAccounts(); err != nil {
+				log.Error("Failed to list external signer accounts", "err", err)
+			}
+		}
+		api.listLock.Unlock()
+	}
+	cpy := make([]accounts.Account, len(cache))
+	copy(cpy, cache)
+	return cpy
+}
+
+// refresh retrieves the accounts the external signer is willing to expose and
+// replaces the cached list with them.
+func (api *ExternalSigner) refresh() ([]accounts.Account, error) {
+	api.listLock.Lock()
+	defer api.listLock.Unlock()
+
+	return api.listAccounts()
+}
+
+// listAccounts retrieves the accounts the external signer is willing to expose
+// and caches them. The caller must hold the listing lock.
+func (api *ExternalSigner) listAccounts() ([]accounts.Account, error) {
+	var addresses []common.Address
+	if err := api.client.Call(&addresses, "account_list"); err != nil {
+		return nil, err
+	}
+	res := make([]accounts.Account, 0, len(addresses))
+	for _, addr := range addresses {
+		res = append(res, accounts.Account{
+			URL:     accounts.URL{Scheme: Scheme, Path: api.endpoint},
+			Address: addr,
+		})
+	}
+	api.cacheLock.Lock()
+	api.cache = res
+	api.cacheLock.Unlock()
+
+	return res, nil
+}
+
+// Contains implements accounts.Wallet, returning whether the external signer
+// exposes the given account.
+func (api *ExternalSigner) Contains(account accounts.Account) bool {
+	for _, a := range api.Accounts() {
+		if a.Address == account.Address && (account.URL == (accounts.URL{}) || account.URL == api.URL()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Derive implements accounts.Wallet, however derivation is not supported for
+// external signers.
+func (api *ExternalSigner) Derive(path accounts.DerivationPath, pin bool) (accounts.Account, error) {
+	return accounts.Account{}, accounts.ErrNotSupported
+}
+
+// SelfDerive implements accounts.Wallet, however derivation is not supported
+// for external signers, so this method is a noop.
+func (api *ExternalSigner) SelfDerive(base accounts.DerivationPath, chain tiltnet.ChainStateReader) {
+	log.Error("Operation SelfDerive not supported on external signers")
+}
+
+// SignHash implements accounts.Wallet, requesting the external signer to sign
+// the given hash with the given account.
+func (api *ExternalSigner) SignHash(account accounts.Account, hash []byte) ([]byte, error) {
+	var signature hexutil.Bytes
+	if err := api.client.Call(&signature, "account_signHash", account.Address, hexutil.Bytes(hash)); err != nil {
+		return nil, err
+	}
+	if len(signature) != 65 {
+		return nil, fmt.Errorf("invalid signature length: %d", len(signature))
+	}
+	return signature, nil
+}
+
+// SignTypedData implements accounts.TypedDataSigner, forwarding the typed data
+// to the external signer so its fields can be displayed for approval. The
+// returned signature is verified to be made by the requested account.
+func (api *ExternalSigner) SignTypedData(account accounts.Account, data *typeddata.TypedData) ([]byte, error) {
+	var signature hexutil.Bytes
+	if err := api.client.Call(&signature, "account_signTypedData", account.Address, data); err != nil {
+		return nil, err
+	}
+	if len(signature) != 65 {
+		return nil, fmt.Errorf("invalid signature length: %d", len(signature))
+	}
+	// Never trust the signer blindly, make sure it signed what was requested
+	sig := make([]byte, 65)
+	copy(sig, signature)
+	sig[64] += 27
+
+	sender, err := typeddata.RecoverAddress(data, sig)
+	if err != nil {
+		return nil, err
+	}
+	if sender != account.Address {
+		return nil, fmt.Errorf("signer mismatch: expected %s, got %s", account.Address.Hex(), sender.Hex())
+	}
+	return signature, nil
+}
+
+// SignTx implements accounts.Wallet, requesting the external signer to approve
+// and sign the given transaction. The returned transaction is verified to be the
+// requested one, signed by the requested account.
+func (api *ExternalSigner) SignTx(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	var res SignTxResult
+	if err := api.client.Call(&res, "account_signTransaction", NewSendTxArgs(account.Address, tx), (*hexutil.Big)(chainID)); err != nil {
+		return nil, err
+	}
+	if res.Tx == nil {
+		return nil, errors.New("external signer returned no transaction")
+	}
+	// Never trust the signer blindly, make sure it signed what was requested
+	var signer types.Signer = types.OmahaSigner{}
+	if chainID != nil {
+		signer = types.NewTiltSigner(chainID)
+	}
+	if signer.Hash(res.Tx) != signer.Hash(tx) {
+		return nil, errors.New("external signer returned a different transaction")
+	}
+	sender, err := types.Sender(signer, res.Tx)
+	if err != nil {
+		return nil, err
+	}
+	if sender != account.Address {
+		return nil, fmt.Errorf("signer mismatch: expected %s, got %s", account.Address.Hex(), sender.Hex())
+	}
+	return res.Tx, nil
+}
+
+// SignTypedDataWithPassphrase implements accounts.TypedDataSigner, however the
+// external signer authorizes requests on its own, so passphrases are not supported.
+func (api *ExternalSigner) SignTypedDataWithPassphrase(account accounts.Account, passphrase string, data *typeddata.TypedData) ([]byte, error) {
+	return nil, errPassphraseNotSupported
+}
+
+// SignHashWithPassphrase implements accounts.Wallet, however the external signer
+// authorizes requests on its own, so passphrases are not supported.
+func (api *ExternalSigner) SignHashWithPassphrase(account accounts.Account, passphrase string, hash []byte) ([]byte, error) {
+	return nil, errPassphraseNotSupported
+}
+
+// SignTxWithPassphrase implements accounts.Wallet, however the external signer
+// authorizes requests on its own, so passphrases are not supported.
+func (api *ExternalSigner) SignTxWithPassphrase(account accounts.Account, passphrase string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return nil, errPassphraseNotSupported
+}
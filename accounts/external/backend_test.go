@@ -0,0 +1,252 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package external
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/typeddata"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/crypto"
+	"github.com/megatilt/go-tilt/rpc"
+)
+
+// Ways the test signer may misbehave when signing.
+const (
+	signHonest   = iota // Sign the requested data with the requested account
+	signTampered        // Sign a modified transaction or message
+	signOtherKey        // Sign with a different key than requested
+	signShort           // Return a truncated signature
+)
+
+// SignerService is a test external signer holding a single key.
+type SignerService struct {
+	key   *ecdsa.PrivateKey
+	other *ecdsa.PrivateKey
+	mode  int
+
+	lock  sync.Mutex
+	lists int // Number of account listings requested
+}
+
+func (s *SignerService) Version() string { return "1.0.0" }
+
+func (s *SignerService) List() []common.Address {
+	s.lock.Lock()
+	s.lists++
+	s.lock.Unlock()
+
+	return []common.Address{crypto.PubkeyToAddress(s.key.PublicKey)}
+}
+
+func (s *SignerService) SignTransaction(args SendTxArgs, chainID *hexutil.Big) (*SignTxResult, error) {
+	key := s.key
+	switch s.mode {
+	case signTampered:
+		args.Nonce++
+	case signOtherKey:
+		key = s.other
+	}
+	signed, err := types.SignTx(args.ToTransaction(), types.NewTiltSigner(chainID.ToInt()), key)
+	if err != nil {
+		return nil, err
+	}
+	return &SignTxResult{Tx: signed}, nil
+}
+
+func (s *SignerService) SignTypedData(addr common.Address, data typeddata.TypedData) (hexutil.Bytes, error) {
+	key := s.key
+	switch s.mode {
+	case signTampered:
+		data.Message["contents"] = "Goodbye, Bob!"
+	case signOtherKey:
+		key = s.other
+	}
+	hash, err := data.Hash()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(hash[:], key)
+	if err != nil {
+		return nil, err
+	}
+	if s.mode == signShort {
+		sig = sig[:64]
+	}
+	return sig, nil
+}
+
+// newTestSigner creates an external signer wallet talking to a test signer
+// over an in-process connection.
+func newTestSigner(t *testing.T, mode int) (*ExternalSigner, *SignerService) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	service := &SignerService{key: key, other: other, mode: mode}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("account", service); err != nil {
+		t.Fatalf("failed to register signer: %v", err)
+	}
+	return &ExternalSigner{client: rpc.DialInProc(server), endpoint: "test"}, service
+}
+
+// Tests that the account list is retrieved once and cached, as every listing may
+// prompt the user of the signer, until explicitly refreshed by opening.
+func TestAccountsCached(t *testing.T) {
+	signer, service := newTestSigner(t, signHonest)
+	addr := crypto.PubkeyToAddress(service.key.PublicKey)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if accs := signer.Accounts(); len(accs) != 1 || accs[0].Address != addr {
+				t.Errorf("accounts mismatch: have %v, want [%x]", accs, addr)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !signer.Contains(accounts.Account{Address: addr}) {
+		t.Errorf("signer account not contained")
+	}
+	if signer.Contains(accounts.Account{Address: common.Address{1}}) {
+		t.Errorf("unknown account contained")
+	}
+	if service.lists != 1 {
+		t.Errorf("listing count mismatch: have %d, want %d", service.lists, 1)
+	}
+	if err := signer.Open(""); err != nil {
+		t.Fatalf("failed to refresh accounts: %v", err)
+	}
+	signer.Accounts()
+	if service.lists != 2 {
+		t.Errorf("listing count after refresh mismatch: have %d, want %d", service.lists, 2)
+	}
+}
+
+// Tests that transactions signed by the external signer are only accepted if
+// they are the requested ones, signed by the requested account.
+func TestSignTxChecks(t *testing.T) {
+	tests := []struct {
+		mode int
+		ok   bool
+	}{
+		{signHonest, true},
+		{signTampered, false},
+		{signOtherKey, false},
+	}
+	for _, tt := range tests {
+		signer, service := newTestSigner(t, tt.mode)
+		account := accounts.Account{Address: crypto.PubkeyToAddress(service.key.PublicKey)}
+
+		tx := types.NewTransaction(1, common.Address{0xaa}, big.NewInt(1), big.NewInt(21000), big.NewInt(1), nil)
+		signed, err := signer.SignTx(account, tx, big.NewInt(7))
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("mode %d: misbehaving signer accepted", tt.mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("mode %d: failed to sign: %v", tt.mode, err)
+			continue
+		}
+		if from, err := types.Sender(types.NewTiltSigner(big.NewInt(7)), signed); err != nil || from != account.Address {
+			t.Errorf("mode %d: sender mismatch: have %x (%v), want %x", tt.mode, from, err, account.Address)
+		}
+	}
+}
+
+// mailTypedData is the example message of the EIP-712 spec.
+const mailTypedData = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
+
+// Tests that typed data signatures returned by the external signer are only
+// accepted if well formed and made over the requested data by the requested
+// account.
+func TestSignTypedDataChecks(t *testing.T) {
+	tests := []struct {
+		mode int
+		ok   bool
+	}{
+		{signHonest, true},
+		{signTampered, false},
+		{signOtherKey, false},
+		{signShort, false},
+	}
+	for _, tt := range tests {
+		signer, service := newTestSigner(t, tt.mode)
+		account := accounts.Account{Address: crypto.PubkeyToAddress(service.key.PublicKey)}
+
+		data := new(typeddata.TypedData)
+		if err := json.Unmarshal([]byte(mailTypedData), data); err != nil {
+			t.Fatalf("failed to decode typed data: %v", err)
+		}
+		sig, err := signer.SignTypedData(account, data)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("mode %d: misbehaving signer accepted", tt.mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("mode %d: failed to sign: %v", tt.mode, err)
+			continue
+		}
+		if len(sig) != 65 || sig[64] > 1 {
+			t.Errorf("mode %d: invalid signature returned: %x", tt.mode, sig)
+		}
+	}
+}
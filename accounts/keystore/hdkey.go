@@ -0,0 +1,89 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+package keystore
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/common/math"
+	"github.com/megatilt/go-tilt/crypto"
+)
+
+// errInvalidChildKey is returned in the astronomically unlikely case that a
+// BIP-32 derivation step results in an invalid key.
+var errInvalidChildKey = errors.New("invalid derived key, use the next index")
+
+// deriveKey derives the private key at the given BIP-32 path from a seed. Only
+// private parent to private child derivation is needed, both for hardened and
+// normal path components.
+//
+// https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki
+func deriveKey(seed []byte, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	curveOrder := crypto.S256().Params().N
+
+	// Generate the master key and chain code from the seed
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	key, chainCode := new(big.Int).SetBytes(sum[:32]), sum[32:]
+	if key.Sign() == 0 || key.Cmp(curveOrder) >= 0 {
+		return nil, errInvalidChildKey
+	}
+	// Walk the path, deriving a child key and chain code at every level
+	for _, index := range path {
+		var data []byte
+		if index >= 0x80000000 {
+			data = append([]byte{0x00}, math.PaddedBigBytes(key, 32)...)
+		} else {
+			data = compressedPubkey(key)
+		}
+		data = append(data, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], index)
+
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		tweak := new(big.Int).SetBytes(sum[:32])
+		if tweak.Cmp(curveOrder) >= 0 {
+			return nil, errInvalidChildKey
+		}
+		key = tweak.Add(tweak, key).Mod(tweak, curveOrder)
+		if key.Sign() == 0 {
+			return nil, errInvalidChildKey
+		}
+		chainCode = sum[32:]
+	}
+	return crypto.ToECDSA(math.PaddedBigBytes(key, 32)), nil
+}
+
+// compressedPubkey returns the 33 byte compressed public key of a private key.
+func compressedPubkey(key *big.Int) []byte {
+	x, y := crypto.S256().ScalarBaseMult(math.PaddedBigBytes(key, 32))
+
+	prefix := byte(0x02)
+	if y.Bit(0) == 1 {
+		prefix = 0x03
+	}
+	return append([]byte{prefix}, math.PaddedBigBytes(x, 32)...)
+}
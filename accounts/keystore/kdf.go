@@ -0,0 +1,201 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+package keystore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/megatilt/go-tilt/crypto/randentropy"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// KDFScrypt is the name of the scrypt key derivation function, the default.
+	KDFScrypt = "scrypt"
+
+	// KDFArgon2id is the name of the argon2id key derivation function.
+	KDFArgon2id = "argon2id"
+
+	// StandardArgon2Time is the number of passes of the argon2id algorithm, using
+	// 256MB memory and taking approximately 1s CPU time on a modern processor.
+	StandardArgon2Time = 3
+
+	// StandardArgon2Memory is the memory of the argon2id algorithm in KiB, using
+	// 256MB memory and taking approximately 1s CPU time on a modern processor.
+	StandardArgon2Memory = 256 * 1024
+
+	// LightArgon2Time is the number of passes of the argon2id algorithm, using 4MB
+	// memory and taking approximately 100ms CPU time on a modern processor.
+	LightArgon2Time = 6
+
+	// LightArgon2Memory is the memory of the argon2id algorithm in KiB, using 4MB
+	// memory and taking approximately 100ms CPU time on a modern processor.
+	LightArgon2Memory = 4 * 1024
+
+	argon2Threads = 4
+
+	// maxArgon2Time and maxArgon2Memory bound the argon2id parameters accepted from
+	// key files, preventing crafted ones from stalling or exhausting the node.
+	maxArgon2Time   = 64
+	maxArgon2Memory = 4 * 1024 * 1024
+)
+
+// KDF is a password based key derivation function along with its parameters,
+// deriving the encryption keys of key files from their passphrases.
+type KDF struct {
+	Name string // Derivation function, either KDFScrypt or KDFArgon2id
+
+	ScryptN int // CPU/memory cost parameter of scrypt
+	ScryptP int // Parallelization parameter of scrypt
+
+	Argon2Time   uint32 // Number of passes of argon2id
+	Argon2Memory uint32 // Memory of argon2id in KiB
+}
+
+// ScryptKDF returns the scrypt key derivation function with the given parameters.
+func ScryptKDF(scryptN, scryptP int) KDF {
+	return KDF{Name: KDFScrypt, ScryptN: scryptN, ScryptP: scryptP}
+}
+
+// Argon2idKDF returns the argon2id key derivation function with the given
+// number of passes and memory in KiB.
+func Argon2idKDF(time, memory uint32) KDF {
+	return KDF{Name: KDFArgon2id, Argon2Time: time, Argon2Memory: memory}
+}
+
+// String implements fmt.Stringer.
+func (kdf KDF) String() string {
+	switch kdf.Name {
+	case KDFScrypt:
+		return fmt.Sprintf("scrypt(n=%d, r=%d, p=%d)", kdf.ScryptN, scryptR, kdf.ScryptP)
+	case KDFArgon2id:
+		return fmt.Sprintf("argon2id(t=%d, m=%dKiB, p=%d)", kdf.Argon2Time, kdf.Argon2Memory, argon2Threads)
+	}
+	return kdf.Name
+}
+
+// deriveKey derives an encryption key from the passphrase with a fresh random
+// salt, returning it along with the parameters to store in the key file.
+func (kdf KDF) deriveKey(auth string) ([]byte, map[string]interface{}, error) {
+	salt := randentropy.GetEntropyCSPRNG(32)
+
+	params := make(map[string]interface{}, 5)
+	params["dklen"] = scryptDKLen
+	params["salt"] = hex.EncodeToString(salt)
+
+	switch kdf.Name {
+	case KDFScrypt:
+		derivedKey, err := scrypt.Key([]byte(auth), salt, kdf.ScryptN, scryptR, kdf.ScryptP, scryptDKLen)
+		if err != nil {
+			return nil, nil, err
+		}
+		params["n"] = kdf.ScryptN
+		params["r"] = scryptR
+		params["p"] = kdf.ScryptP
+		return derivedKey, params, nil
+
+	case KDFArgon2id:
+		if kdf.Argon2Time == 0 || kdf.Argon2Memory == 0 {
+			return nil, nil, fmt.Errorf("invalid argon2id parameters: %v", kdf)
+		}
+		derivedKey := argon2.IDKey([]byte(auth), salt, kdf.Argon2Time, kdf.Argon2Memory, argon2Threads, scryptDKLen)
+		params["t"] = kdf.Argon2Time
+		params["m"] = kdf.Argon2Memory
+		params["p"] = argon2Threads
+		return derivedKey, params, nil
+	}
+	return nil, nil, fmt.Errorf("Unsupported KDF: %s", kdf.Name)
+}
+
+// kdfOf returns the key derivation function some encrypted data was encrypted
+// with, so that it can be re-encrypted the same way.
+func kdfOf(cryptoJSON cryptoJSON) (KDF, error) {
+	switch cryptoJSON.KDF {
+	case KDFScrypt:
+		n, okN := kdfParam(cryptoJSON.KDFParams, "n")
+		p, okP := kdfParam(cryptoJSON.KDFParams, "p")
+		if !okN || !okP {
+			return KDF{}, fmt.Errorf("invalid scrypt parameters: %v", cryptoJSON.KDFParams)
+		}
+		return ScryptKDF(n, p), nil
+
+	case KDFArgon2id:
+		t, m, _, err := argon2Params(cryptoJSON.KDFParams)
+		if err != nil {
+			return KDF{}, err
+		}
+		return Argon2idKDF(t, m), nil
+	}
+	return KDF{}, fmt.Errorf("Unsupported KDF: %s", cryptoJSON.KDF)
+}
+
+// fileKDF returns the key derivation function the key or seed file at the given
+// path was encrypted with.
+func fileKDF(path string) (KDF, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return KDF{}, err
+	}
+	var encrypted struct {
+		Crypto cryptoJSON `json:"crypto"`
+	}
+	if err := json.Unmarshal(content, &encrypted); err != nil {
+		return KDF{}, err
+	}
+	return kdfOf(encrypted.Crypto)
+}
+
+// argon2Params extracts the argon2id parameters of some encrypted data, rejecting
+// those argon2 can't derive a key with or would exhaust the memory with.
+func argon2Params(params map[string]interface{}) (t, m uint32, p uint8, err error) {
+	time, okT := kdfParam(params, "t")
+	memory, okM := kdfParam(params, "m")
+	threads, okP := kdfParam(params, "p")
+	if !okT || !okM || !okP {
+		return 0, 0, 0, fmt.Errorf("invalid argon2id parameters: %v", params)
+	}
+	if time < 1 || time > maxArgon2Time {
+		return 0, 0, 0, fmt.Errorf("argon2id passes %d out of range [1, %d]", time, maxArgon2Time)
+	}
+	if threads < 1 || threads > 255 {
+		return 0, 0, 0, fmt.Errorf("argon2id threads %d out of range [1, 255]", threads)
+	}
+	if memory < 8*threads || memory > maxArgon2Memory {
+		return 0, 0, 0, fmt.Errorf("argon2id memory %dKiB out of range [%d, %d]", memory, 8*threads, maxArgon2Memory)
+	}
+	return uint32(time), uint32(memory), uint8(threads), nil
+}
+
+// kdfParam returns an integer parameter of a key derivation function, which is
+// a float64 if decoded from JSON.
+func kdfParam(params map[string]interface{}, name string) (int, bool) {
+	switch value := params[name].(type) {
+	case int:
+		return value, true
+	case uint32:
+		return int(value), true
+	case float64:
+		if value < 0 || value > 1<<32 || value != float64(int(value)) {
+			return 0, false
+		}
+		return int(value), true
+	}
+	return 0, false
+}
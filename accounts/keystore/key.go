@@ -46,6 +46,9 @@ type Key struct {
 	// we only store privkey as pubkey/address can be derived from it
 	// privkey in this struct is always in plaintext
 	PrivateKey *ecdsa.PrivateKey
+	// keys derived from a mnemonic seed reference it and their derivation path
+	HDSeed string
+	HDPath string
 }
 
 type keyStore interface {
@@ -69,6 +72,8 @@ type encryptedKeyJSONV3 struct {
 	Crypto  cryptoJSON `json:"crypto"`
 	Id      string     `json:"id"`
 	Version int        `json:"version"`
+	HDSeed  string     `json:"hdseed,omitempty"`
+	HDPath  string     `json:"hdpath,omitempty"`
 }
 
 type encryptedKeyJSONV1 struct {
@@ -38,6 +38,7 @@ import (
 	"github.com/megatilt/go-tilt/core/types"
 	"github.com/megatilt/go-tilt/crypto"
 	"github.com/megatilt/go-tilt/event"
+	"github.com/megatilt/go-tilt/log"
 )
 
 var (
@@ -423,13 +424,16 @@ func (ks *KeyStore) Export(a accounts.Account, passphrase, newPassphrase string)
 	if err != nil {
 		return nil, err
 	}
-	var N, P int
+	return encryptKeyWith(key, newPassphrase, ks.kdf())
+}
+
+// kdf returns the key derivation function the keystore encrypts with, falling
+// back to the standard one for plaintext keystores.
+func (ks *KeyStore) kdf() KDF {
 	if store, ok := ks.storage.(*keyStorePassphrase); ok {
-		N, P = store.scryptN, store.scryptP
-	} else {
-		N, P = StandardScryptN, StandardScryptP
+		return ScryptKDF(store.scryptN, store.scryptP)
 	}
-	return EncryptKey(key, newPassphrase, N, P)
+	return ScryptKDF(StandardScryptN, StandardScryptP)
 }
 
 // Import stores the given encrypted JSON key into the key directory.
@@ -465,12 +469,67 @@ func (ks *KeyStore) importKey(key *Key, passphrase string) (accounts.Account, er
 }
 
 // Update changes the passphrase of an existing account.
+//
+// The key keeps the key derivation function it was encrypted with. If it was
+// derived from a mnemonic seed encrypted with the same passphrase, the seed is
+// re-encrypted too, so that the wallet can still be opened to derive accounts.
+// As the seed is shared by all the accounts derived from it, those are updated
+// together, failing if any of them is encrypted with a different passphrase.
 func (ks *KeyStore) Update(a accounts.Account, passphrase, newPassphrase string) error {
 	a, key, err := ks.getDecryptedKey(a, passphrase)
 	if err != nil {
 		return err
 	}
-	return ks.storage.StoreKey(a.URL.Path, key, newPassphrase)
+	if _, ok := ks.storage.(*keyStorePassphrase); !ok {
+		return ks.storage.StoreKey(a.URL.Path, key, newPassphrase)
+	}
+	defer zeroKey(key.PrivateKey)
+
+	seed, err := ks.unlockSeed(a, key, passphrase)
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(seed)
+
+	accs, keys := []accounts.Account{a}, []*Key{key}
+	if seed != nil {
+		if accs, keys, err = ks.seedAccounts(key.HDSeed, passphrase); err != nil {
+			return err
+		}
+		defer func() {
+			for _, key := range keys {
+				zeroKey(key.PrivateKey)
+			}
+		}()
+	}
+	// Encrypt all the keys before writing any, so that a failure leaves all intact
+	keyjsons := make([][]byte, len(keys))
+	for i, key := range keys {
+		if keyjsons[i], err = encryptKeyWith(key, newPassphrase, ks.reuseKDF(accs[i].URL.Path)); err != nil {
+			return err
+		}
+	}
+	for i, keyjson := range keyjsons {
+		if err := writeKeyFile(accs[i].URL.Path, keyjson); err != nil {
+			return err
+		}
+	}
+	if seed != nil {
+		path := ks.seedPath(key.HDSeed)
+		return ks.writeSeed(key.HDSeed, seed, newPassphrase, ks.reuseKDF(path))
+	}
+	return nil
+}
+
+// reuseKDF returns the key derivation function the key or seed file at the given
+// path was encrypted with, or the keystore's own if it can't be reused.
+func (ks *KeyStore) reuseKDF(path string) KDF {
+	kdf, err := fileKDF(path)
+	if err != nil {
+		log.Debug("Re-encrypting with the default key derivation", "path", path, "err", err)
+		return ks.kdf()
+	}
+	return kdf
 }
 
 // ImportPreSaleKey decrypts the given Tiltnet presale wallet and stores
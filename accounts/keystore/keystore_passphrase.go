@@ -40,6 +40,7 @@ import (
 	"github.com/megatilt/go-tilt/crypto"
 	"github.com/megatilt/go-tilt/crypto/randentropy"
 	"github.com/pborman/uuid"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/scrypt"
 )
@@ -109,48 +110,57 @@ func (ks keyStorePassphrase) JoinPath(filename string) string {
 // EncryptKey encrypts a key using the specified scrypt parameters into a json
 // blob that can be decrypted later on.
 func EncryptKey(key *Key, auth string, scryptN, scryptP int) ([]byte, error) {
-	authArray := []byte(auth)
-	salt := randentropy.GetEntropyCSPRNG(32)
-	derivedKey, err := scrypt.Key(authArray, salt, scryptN, scryptR, scryptP, scryptDKLen)
+	return encryptKeyWith(key, auth, ScryptKDF(scryptN, scryptP))
+}
+
+// encryptKeyWith encrypts a key using the specified key derivation function into
+// a json blob that can be decrypted later on.
+func encryptKeyWith(key *Key, auth string, kdf KDF) ([]byte, error) {
+	keyBytes := math.PaddedBigBytes(key.PrivateKey.D, 32)
+
+	cryptoStruct, err := encryptData(keyBytes, auth, kdf)
 	if err != nil {
 		return nil, err
 	}
+	encryptedKeyJSONV3 := encryptedKeyJSONV3{
+		Address: hex.EncodeToString(key.Address[:]),
+		Crypto:  cryptoStruct,
+		Id:      key.Id.String(),
+		Version: version,
+		HDSeed:  key.HDSeed,
+		HDPath:  key.HDPath,
+	}
+	return json.Marshal(encryptedKeyJSONV3)
+}
+
+// encryptData encrypts arbitrary data using the specified key derivation function
+// into the crypto section of a json key file.
+func encryptData(data []byte, auth string, kdf KDF) (cryptoJSON, error) {
+	derivedKey, kdfParamsJSON, err := kdf.deriveKey(auth)
+	if err != nil {
+		return cryptoJSON{}, err
+	}
 	encryptKey := derivedKey[:16]
-	keyBytes := math.PaddedBigBytes(key.PrivateKey.D, 32)
 
 	iv := randentropy.GetEntropyCSPRNG(aes.BlockSize) // 16
-	cipherText, err := aesCTRXOR(encryptKey, keyBytes, iv)
+	cipherText, err := aesCTRXOR(encryptKey, data, iv)
 	if err != nil {
-		return nil, err
+		return cryptoJSON{}, err
 	}
 	mac := crypto.Keccak256(derivedKey[16:32], cipherText)
 
-	scryptParamsJSON := make(map[string]interface{}, 5)
-	scryptParamsJSON["n"] = scryptN
-	scryptParamsJSON["r"] = scryptR
-	scryptParamsJSON["p"] = scryptP
-	scryptParamsJSON["dklen"] = scryptDKLen
-	scryptParamsJSON["salt"] = hex.EncodeToString(salt)
-
 	cipherParamsJSON := cipherparamsJSON{
 		IV: hex.EncodeToString(iv),
 	}
 
-	cryptoStruct := cryptoJSON{
+	return cryptoJSON{
 		Cipher:       "aes-128-ctr",
 		CipherText:   hex.EncodeToString(cipherText),
 		CipherParams: cipherParamsJSON,
-		KDF:          "scrypt",
-		KDFParams:    scryptParamsJSON,
+		KDF:          kdf.Name,
+		KDFParams:    kdfParamsJSON,
 		MAC:          hex.EncodeToString(mac),
-	}
-	encryptedKeyJSONV3 := encryptedKeyJSONV3{
-		hex.EncodeToString(key.Address[:]),
-		cryptoStruct,
-		key.Id.String(),
-		version,
-	}
-	return json.Marshal(encryptedKeyJSONV3)
+	}, nil
 }
 
 // DecryptKey decrypts a key from a json blob, returning the private key itself.
@@ -163,6 +173,7 @@ func DecryptKey(keyjson []byte, auth string) (*Key, error) {
 	// Depending on the version try to parse one way or another
 	var (
 		keyBytes, keyId []byte
+		hdSeed, hdPath  string
 		err             error
 	)
 	if version, ok := m["version"].(string); ok && version == "1" {
@@ -177,6 +188,7 @@ func DecryptKey(keyjson []byte, auth string) (*Key, error) {
 			return nil, err
 		}
 		keyBytes, keyId, err = decryptKeyV3(k, auth)
+		hdSeed, hdPath = k.HDSeed, k.HDPath
 	}
 	// Handle any decryption errors and return the key
 	if err != nil {
@@ -187,6 +199,8 @@ func DecryptKey(keyjson []byte, auth string) (*Key, error) {
 		Id:         uuid.UUID(keyId),
 		Address:    crypto.PubkeyToAddress(key.PublicKey),
 		PrivateKey: key,
+		HDSeed:     hdSeed,
+		HDPath:     hdPath,
 	}, nil
 }
 
@@ -195,41 +209,44 @@ func decryptKeyV3(keyProtected *encryptedKeyJSONV3, auth string) (keyBytes []byt
 		return nil, nil, fmt.Errorf("Version not supported: %v", keyProtected.Version)
 	}
 
-	if keyProtected.Crypto.Cipher != "aes-128-ctr" {
-		return nil, nil, fmt.Errorf("Cipher not supported: %v", keyProtected.Crypto.Cipher)
+	plainText, err := decryptData(keyProtected.Crypto, auth)
+	if err != nil {
+		return nil, nil, err
 	}
+	return plainText, uuid.Parse(keyProtected.Id), nil
+}
 
-	keyId = uuid.Parse(keyProtected.Id)
-	mac, err := hex.DecodeString(keyProtected.Crypto.MAC)
+// decryptData decrypts the crypto section of a json key file, returning the
+// plaintext data encrypted within.
+func decryptData(cryptoStruct cryptoJSON, auth string) ([]byte, error) {
+	if cryptoStruct.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("Cipher not supported: %v", cryptoStruct.Cipher)
+	}
+	mac, err := hex.DecodeString(cryptoStruct.MAC)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	iv, err := hex.DecodeString(keyProtected.Crypto.CipherParams.IV)
+	iv, err := hex.DecodeString(cryptoStruct.CipherParams.IV)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	cipherText, err := hex.DecodeString(keyProtected.Crypto.CipherText)
+	cipherText, err := hex.DecodeString(cryptoStruct.CipherText)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	derivedKey, err := getKDFKey(keyProtected.Crypto, auth)
+	derivedKey, err := getKDFKey(cryptoStruct, auth)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	calculatedMAC := crypto.Keccak256(derivedKey[16:32], cipherText)
 	if !bytes.Equal(calculatedMAC, mac) {
-		return nil, nil, ErrDecrypt
-	}
-
-	plainText, err := aesCTRXOR(derivedKey[:16], cipherText, iv)
-	if err != nil {
-		return nil, nil, err
+		return nil, ErrDecrypt
 	}
-	return plainText, keyId, err
+	return aesCTRXOR(derivedKey[:16], cipherText, iv)
 }
 
 func decryptKeyV1(keyProtected *encryptedKeyJSONV1, auth string) (keyBytes []byte, keyId []byte, err error) {
@@ -272,7 +289,14 @@ func getKDFKey(cryptoJSON cryptoJSON, auth string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	dkLen := ensureInt(cryptoJSON.KDFParams["dklen"])
+	// The first half of the derived key encrypts, the second half authenticates
+	dkLen, ok := kdfParam(cryptoJSON.KDFParams, "dklen")
+	if !ok {
+		return nil, fmt.Errorf("invalid %s key length: %v", cryptoJSON.KDF, cryptoJSON.KDFParams["dklen"])
+	}
+	if dkLen < scryptDKLen || (cryptoJSON.KDF == "argon2id" && dkLen != scryptDKLen) {
+		return nil, fmt.Errorf("unsupported %s key length: %d", cryptoJSON.KDF, dkLen)
+	}
 
 	if cryptoJSON.KDF == "scrypt" {
 		n := ensureInt(cryptoJSON.KDFParams["n"])
@@ -280,6 +304,13 @@ func getKDFKey(cryptoJSON cryptoJSON, auth string) ([]byte, error) {
 		p := ensureInt(cryptoJSON.KDFParams["p"])
 		return scrypt.Key(authArray, salt, n, r, p, dkLen)
 
+	} else if cryptoJSON.KDF == "argon2id" {
+		t, m, p, err := argon2Params(cryptoJSON.KDFParams)
+		if err != nil {
+			return nil, err
+		}
+		return argon2.IDKey(authArray, salt, t, m, p, uint32(dkLen)), nil
+
 	} else if cryptoJSON.KDF == "pbkdf2" {
 		c := ensureInt(cryptoJSON.KDFParams["c"])
 		prf := cryptoJSON.KDFParams["prf"].(string)
@@ -0,0 +1,279 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package keystore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/crypto"
+)
+
+// newTestSeedAccounts imports the test mnemonic and derives a second account
+// from it, returning both.
+func newTestSeedAccounts(t *testing.T, ks *KeyStore, passphrase string) (accounts.Account, accounts.Account) {
+	first, err := ks.ImportMnemonic(testMnemonic, "", passphrase)
+	if err != nil {
+		t.Fatalf("failed to import mnemonic: %v", err)
+	}
+	path := append(accounts.DerivationPath{}, accounts.DefaultBaseDerivationPath...)
+	path[len(path)-1]++
+
+	second, err := ks.DeriveAccount(first, path, passphrase)
+	if err != nil {
+		t.Fatalf("failed to derive account: %v", err)
+	}
+	return first, second
+}
+
+// checkPassphrase checks that the key file of an account decrypts with the
+// passphrase.
+func checkPassphrase(t *testing.T, ks *KeyStore, a accounts.Account, passphrase string) {
+	if _, _, err := ks.getDecryptedKey(a, passphrase); err != nil {
+		t.Errorf("account %x: failed to decrypt with %q: %v", a.Address, passphrase, err)
+	}
+}
+
+// Tests that updating the passphrase of a plain account re-encrypts it with
+// the key derivation function it was encrypted with.
+func TestUpdate(t *testing.T) {
+	dir, ks := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+
+	account, err := ks.NewAccount("foo")
+	if err != nil {
+		t.Fatalf("failed to create account: %v", err)
+	}
+	if err := ks.Update(account, "bar", "baz"); err != ErrDecrypt {
+		t.Errorf("update with wrong passphrase error mismatch: have %v, want %v", err, ErrDecrypt)
+	}
+	if err := ks.Update(account, "foo", "bar"); err != nil {
+		t.Fatalf("failed to update account: %v", err)
+	}
+	checkPassphrase(t, ks, account, "bar")
+
+	kdf, err := fileKDF(account.URL.Path)
+	if err != nil {
+		t.Fatalf("failed to read key derivation: %v", err)
+	}
+	if want := ScryptKDF(LightScryptN, LightScryptP); kdf != want {
+		t.Errorf("key derivation mismatch: have %v, want %v", kdf, want)
+	}
+}
+
+// Tests that updating the passphrase of an account derived from a seed updates
+// the seed and all the other accounts derived from it too, so that they keep
+// sharing the passphrase of the seed.
+func TestUpdateSharedSeed(t *testing.T) {
+	dir, ks := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+
+	first, second := newTestSeedAccounts(t, ks, "foo")
+	plain, err := ks.NewAccount("foo")
+	if err != nil {
+		t.Fatalf("failed to create account: %v", err)
+	}
+	if err := ks.Update(second, "foo", "bar"); err != nil {
+		t.Fatalf("failed to update account: %v", err)
+	}
+	checkPassphrase(t, ks, first, "bar")
+	checkPassphrase(t, ks, second, "bar")
+	checkPassphrase(t, ks, plain, "foo")
+
+	path := append(accounts.DerivationPath{}, accounts.DefaultBaseDerivationPath...)
+	path[len(path)-1] += 2
+	if _, err := ks.DeriveAccount(first, path, "bar"); err != nil {
+		t.Errorf("failed to derive from updated seed: %v", err)
+	}
+}
+
+// Tests that updating an account derived from a seed fails without changing any
+// file if another account derived from it has a different passphrase.
+func TestUpdateSharedSeedMismatch(t *testing.T) {
+	dir, ks := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+
+	first, second := newTestSeedAccounts(t, ks, "foo")
+
+	// Re-encrypt the second account alone, as older releases did
+	_, key, err := ks.getDecryptedKey(second, "foo")
+	if err != nil {
+		t.Fatalf("failed to decrypt account: %v", err)
+	}
+	if err := ks.storage.StoreKey(second.URL.Path, key, "other"); err != nil {
+		t.Fatalf("failed to re-encrypt account: %v", err)
+	}
+	before := readKeyDir(t, dir)
+	if err := ks.Update(first, "foo", "bar"); err == nil {
+		t.Fatalf("update with diverging sibling passphrase succeeded")
+	}
+	if after := readKeyDir(t, dir); !equalFiles(before, after) {
+		t.Errorf("failed update modified the key directory")
+	}
+	checkPassphrase(t, ks, first, "foo")
+}
+
+// readKeyDir returns the contents of all the key and seed files in the key
+// directory, keyed by their relative path.
+func readKeyDir(t *testing.T, dir string) map[string][]byte {
+	files := make(map[string][]byte)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[rel] = content
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read key directory: %v", err)
+	}
+	return files
+}
+
+// equalFiles returns whether two key directory snapshots are identical.
+func equalFiles(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, content := range a {
+		if !bytes.Equal(content, b[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tests that exported keys are encrypted with the new passphrase, keep their
+// seed reference and can be imported into another keystore.
+func TestExport(t *testing.T) {
+	dir, ks := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+
+	account, _ := newTestSeedAccounts(t, ks, "foo")
+	if _, err := ks.Export(account, "bar", "baz"); err != ErrDecrypt {
+		t.Errorf("export with wrong passphrase error mismatch: have %v, want %v", err, ErrDecrypt)
+	}
+	keyjson, err := ks.Export(account, "foo", "bar")
+	if err != nil {
+		t.Fatalf("failed to export account: %v", err)
+	}
+	key, err := DecryptKey(keyjson, "bar")
+	if err != nil {
+		t.Fatalf("failed to decrypt exported key: %v", err)
+	}
+	if key.Address != account.Address {
+		t.Errorf("exported address mismatch: have %x, want %x", key.Address, account.Address)
+	}
+	if key.HDSeed == "" || key.HDPath != accounts.DefaultBaseDerivationPath.String() {
+		t.Errorf("exported seed reference mismatch: have %q at %q", key.HDSeed, key.HDPath)
+	}
+	otherDir, other := newTestKeyStore(t)
+	defer os.RemoveAll(otherDir)
+
+	imported, err := other.Import(keyjson, "bar", "baz")
+	if err != nil {
+		t.Fatalf("failed to import exported key: %v", err)
+	}
+	if imported.Address != account.Address {
+		t.Errorf("imported address mismatch: have %x, want %x", imported.Address, account.Address)
+	}
+	checkPassphrase(t, other, imported, "baz")
+}
+
+// Tests that migrating an account re-encrypts its key and seed with the new key
+// derivation function, backing up the original files first.
+func TestMigrate(t *testing.T) {
+	dir, ks := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+
+	first, second := newTestSeedAccounts(t, ks, "foo")
+	before := readKeyDir(t, dir)
+
+	backupDir := filepath.Join(dir, "..", filepath.Base(dir)+"-backup")
+	defer os.RemoveAll(backupDir)
+
+	kdf := Argon2idKDF(LightArgon2Time, LightArgon2Memory)
+	if err := ks.Migrate(first, "bar", kdf, backupDir); err != ErrDecrypt {
+		t.Errorf("migrate with wrong passphrase error mismatch: have %v, want %v", err, ErrDecrypt)
+	}
+	if err := ks.Migrate(first, "foo", kdf, backupDir); err != nil {
+		t.Fatalf("failed to migrate account: %v", err)
+	}
+	_, key, err := ks.getDecryptedKey(first, "foo")
+	if err != nil {
+		t.Fatalf("failed to decrypt migrated account: %v", err)
+	}
+	for _, path := range []string{first.URL.Path, ks.seedPath(key.HDSeed)} {
+		if have, err := fileKDF(path); err != nil || have != kdf {
+			t.Errorf("%s: key derivation mismatch: have %v (%v), want %v", filepath.Base(path), have, err, kdf)
+		}
+		rel, _ := filepath.Rel(dir, path)
+		backup, err := ioutil.ReadFile(filepath.Join(backupDir, rel))
+		if err != nil {
+			t.Errorf("%s: backup missing: %v", filepath.Base(path), err)
+			continue
+		}
+		if !bytes.Equal(backup, before[rel]) {
+			t.Errorf("%s: backup mismatch", filepath.Base(path))
+		}
+	}
+	// The sibling is untouched but can still derive from the migrated seed
+	if have, err := fileKDF(second.URL.Path); err != nil || have != ScryptKDF(LightScryptN, LightScryptP) {
+		t.Errorf("sibling key derivation mismatch: have %v (%v)", have, err)
+	}
+	path := append(accounts.DerivationPath{}, accounts.DefaultBaseDerivationPath...)
+	path[len(path)-1] += 2
+	if _, err := ks.DeriveAccount(second, path, "foo"); err != nil {
+		t.Errorf("failed to derive from migrated seed: %v", err)
+	}
+}
+
+// Tests that key files with a derived key length the cipher and MAC can't use
+// are rejected instead of crashing the decryption.
+func TestDecryptKeyLength(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	for _, kdf := range []KDF{ScryptKDF(LightScryptN, LightScryptP), Argon2idKDF(1, 64)} {
+		encrypted, err := encryptData(crypto.FromECDSA(key), "foo", kdf)
+		if err != nil {
+			t.Fatalf("%v: failed to encrypt: %v", kdf, err)
+		}
+		if _, err := decryptData(encrypted, "foo"); err != nil {
+			t.Errorf("%v: failed to decrypt: %v", kdf, err)
+		}
+		for _, dklen := range []interface{}{nil, 16.0, 31.0, -32.0, "32"} {
+			encrypted.KDFParams["dklen"] = dklen
+			if _, err := decryptData(encrypted, "foo"); err == nil {
+				t.Errorf("%v: dklen %v accepted", kdf, dklen)
+			}
+		}
+		if kdf.Name == KDFArgon2id {
+			encrypted.KDFParams["dklen"] = 64.0
+			if _, err := decryptData(encrypted, "foo"); err == nil {
+				t.Errorf("%v: dklen 64 accepted", kdf)
+			}
+		}
+	}
+}
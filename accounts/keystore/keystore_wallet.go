@@ -18,6 +18,7 @@ package keystore
 
 import (
 	"math/big"
+	"sync"
 
 	tiltnet "github.com/megatilt/go-tilt"
 	"github.com/megatilt/go-tilt/accounts"
@@ -29,6 +30,11 @@ import (
 type keystoreWallet struct {
 	account  accounts.Account // Single account contained in this wallet
 	keystore *KeyStore        // Keystore where the account originates from
+
+	seed       []byte     // Decrypted HD seed of the account while the wallet is open
+	seedID     string     // Identifier of the HD seed while the wallet is open
+	passphrase string     // Passphrase to encrypt derived keys with while the wallet is open
+	lock       sync.Mutex // Lock protecting the opened seed
 }
 
 // URL implements accounts.Wallet, returning the URL of the account within.
@@ -48,13 +54,43 @@ func (w *keystoreWallet) Status() string {
 	return "Locked"
 }
 
-// Open implements accounts.Wallet, but is a noop for plain wallets since there
-// is no connection or decryption step necessary to access the list of accounts.
-func (w *keystoreWallet) Open(passphrase string) error { return nil }
+// Open implements accounts.Wallet. It is a noop for plain wallets since there is
+// no connection or decryption step necessary to access the list of accounts. For
+// accounts derived from a mnemonic, it decrypts the HD seed with the passphrase
+// to allow deriving further accounts until the wallet is closed.
+func (w *keystoreWallet) Open(passphrase string) error {
+	id, err := seedOf(w.account)
+	if err == errNoSeed {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
 
-// Close implements accounts.Wallet, but is a noop for plain wallets since is no
-// meaningful open operation.
-func (w *keystoreWallet) Close() error { return nil }
+	if w.seed != nil {
+		return accounts.ErrWalletAlreadyOpen
+	}
+	seed, err := w.keystore.loadSeed(id, passphrase)
+	if err != nil {
+		return err
+	}
+	w.seed, w.seedID, w.passphrase = seed, id, passphrase
+	return nil
+}
+
+// Close implements accounts.Wallet, releasing the HD seed if it was decrypted.
+func (w *keystoreWallet) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.seed != nil {
+		zeroBytes(w.seed)
+	}
+	w.seed, w.seedID, w.passphrase = nil, "", ""
+	return nil
+}
 
 // Accounts implements accounts.Wallet, returning an account list consisting of
 // a single account that the plain kestore wallet contains.
@@ -68,10 +104,21 @@ func (w *keystoreWallet) Contains(account accounts.Account) bool {
 	return account.Address == w.account.Address && (account.URL == (accounts.URL{}) || account.URL == w.account.URL)
 }
 
-// Derive implements accounts.Wallet, but is a noop for plain wallets since there
-// is no notion of hierarchical account derivation for plain keystore accounts.
+// Derive implements accounts.Wallet, deriving a new account from the HD seed of
+// an opened wallet. If pinning is requested, the derived key is stored into the
+// keystore, encrypted with the passphrase the wallet was opened with. Plain keystore
+// accounts have no notion of hierarchical account derivation.
 func (w *keystoreWallet) Derive(path accounts.DerivationPath, pin bool) (accounts.Account, error) {
-	return accounts.Account{}, accounts.ErrNotSupported
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.seed == nil {
+		if _, err := seedOf(w.account); err != nil {
+			return accounts.Account{}, accounts.ErrNotSupported
+		}
+		return accounts.Account{}, accounts.ErrWalletClosed
+	}
+	return w.keystore.deriveAccount(w.seed, w.seedID, path, w.passphrase, pin)
 }
 
 // SelfDerive implements accounts.Wallet, but is a noop for plain wallets since
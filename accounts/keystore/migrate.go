@@ -0,0 +1,81 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+package keystore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/megatilt/go-tilt/accounts"
+)
+
+// Migrate re-encrypts the key file of an account with the given key derivation
+// function, keeping its passphrase. If the key was derived from a mnemonic seed
+// encrypted with the same passphrase, the seed is re-encrypted too.
+//
+// The original files are copied into the backup directory before being replaced,
+// and every file is replaced atomically, so an interrupted migration leaves each
+// key either in its old or its new form.
+func (ks *KeyStore) Migrate(a accounts.Account, passphrase string, kdf KDF, backupDir string) error {
+	a, key, err := ks.getDecryptedKey(a, passphrase)
+	if err != nil {
+		return err
+	}
+	defer zeroKey(key.PrivateKey)
+
+	seed, err := ks.unlockSeed(a, key, passphrase)
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(seed)
+
+	// Back up the original files, then replace them one by one
+	if err := backupFile(a.URL.Path, backupDir); err != nil {
+		return err
+	}
+	if seed != nil {
+		if err := backupFile(ks.seedPath(key.HDSeed), filepath.Join(backupDir, seedDir)); err != nil {
+			return err
+		}
+	}
+	keyjson, err := encryptKeyWith(key, passphrase, kdf)
+	if err != nil {
+		return err
+	}
+	if err := writeKeyFile(a.URL.Path, keyjson); err != nil {
+		return err
+	}
+	if seed != nil {
+		return ks.writeSeed(key.HDSeed, seed, passphrase, kdf)
+	}
+	return nil
+}
+
+// backupFile copies a key file into the backup directory. Existing backups are
+// never overwritten, so a seed shared by multiple accounts is backed up in its
+// original form only.
+func backupFile(path string, backupDir string) error {
+	backup := filepath.Join(backupDir, filepath.Base(path))
+	if _, err := os.Stat(backup); err == nil {
+		return nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(backup, content)
+}
@@ -0,0 +1,299 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+package keystore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/mnemonic"
+	"github.com/megatilt/go-tilt/log"
+	"github.com/pborman/uuid"
+)
+
+// seedDir is the subdirectory of the key directory holding the encrypted HD
+// seeds. Directories are skipped by the account cache, so seeds never show up
+// as accounts.
+const seedDir = "seeds"
+
+var (
+	// errNoSeed is returned if an account was not derived from a mnemonic seed.
+	errNoSeed = errors.New("account not derived from a mnemonic seed")
+
+	// errSeedlessAccount is returned if a mnemonic is imported whose first account
+	// already exists as a plain key, which can't reference the seed.
+	errSeedlessAccount = errors.New("account already exists without a mnemonic seed")
+
+	// errSeedMismatch is returned if a mnemonic is imported again, but the seed its
+	// first account references is a different one.
+	errSeedMismatch = errors.New("stored seed does not match the mnemonic")
+)
+
+// encryptedSeedJSON is the on-disk format of a HD seed, encrypted the same way
+// as the keys themselves.
+type encryptedSeedJSON struct {
+	Crypto  cryptoJSON `json:"crypto"`
+	Id      string     `json:"id"`
+	Version int        `json:"version"`
+}
+
+// NewMnemonicAccount generates a new mnemonic and imports it, returning the
+// mnemonic to be written down by the user along with the first account derived
+// from it. The seedPassphrase is the optional BIP-39 passphrase, while the
+// passphrase encrypts the seed and the derived keys on disk.
+func (ks *KeyStore) NewMnemonicAccount(seedPassphrase, passphrase string) (string, accounts.Account, error) {
+	entropy, err := mnemonic.NewEntropy(mnemonic.DefaultEntropyBits)
+	if err != nil {
+		return "", accounts.Account{}, err
+	}
+	sentence, err := mnemonic.NewMnemonic(entropy)
+	if err != nil {
+		return "", accounts.Account{}, err
+	}
+	account, err := ks.ImportMnemonic(sentence, seedPassphrase, passphrase)
+	if err != nil {
+		return "", accounts.Account{}, err
+	}
+	return sentence, account, nil
+}
+
+// ImportMnemonic stores the seed of the given mnemonic into the key directory,
+// encrypted with the passphrase, and derives its first account at the default
+// base derivation path. The seedPassphrase is the optional BIP-39 passphrase.
+//
+// Importing an already imported mnemonic returns its first account, reusing the
+// seed it references instead of storing a copy no account would reference.
+func (ks *KeyStore) ImportMnemonic(sentence, seedPassphrase, passphrase string) (accounts.Account, error) {
+	seed, err := mnemonic.NewSeed(sentence, seedPassphrase)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	defer zeroBytes(seed)
+
+	first, err := ks.deriveAccount(seed, "", accounts.DefaultBaseDerivationPath, passphrase, false)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	if ks.cache.hasAddress(first.Address) {
+		return ks.reimportMnemonic(first, seed, passphrase)
+	}
+	id, err := ks.storeSeed(seed, passphrase)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	return ks.deriveAccount(seed, id, accounts.DefaultBaseDerivationPath, passphrase, true)
+}
+
+// reimportMnemonic returns the existing first account of an already imported
+// mnemonic, after checking that the seed it references is the mnemonic's and is
+// encrypted with the passphrase. A missing seed file is written anew.
+func (ks *KeyStore) reimportMnemonic(a accounts.Account, seed []byte, passphrase string) (accounts.Account, error) {
+	a, err := ks.Find(a)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	id, err := seedOf(a)
+	if err == errNoSeed {
+		return accounts.Account{}, errSeedlessAccount
+	}
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	stored, err := ks.loadSeed(id, passphrase)
+	if os.IsNotExist(err) {
+		if err := ks.writeSeed(id, seed, passphrase, ks.kdf()); err != nil {
+			return accounts.Account{}, err
+		}
+		return a, nil
+	}
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	defer zeroBytes(stored)
+
+	if !bytes.Equal(stored, seed) {
+		return accounts.Account{}, errSeedMismatch
+	}
+	return a, nil
+}
+
+// DeriveAccount derives a new account along the given path from the seed the
+// given account was derived from, storing it encrypted with the passphrase of
+// the seed. Deriving an already existing account returns it.
+func (ks *KeyStore) DeriveAccount(a accounts.Account, path accounts.DerivationPath, passphrase string) (accounts.Account, error) {
+	a, err := ks.Find(a)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	id, err := seedOf(a)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	seed, err := ks.loadSeed(id, passphrase)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	defer zeroBytes(seed)
+
+	return ks.deriveAccount(seed, id, path, passphrase, true)
+}
+
+// deriveAccount derives the account at the given path from a decrypted seed,
+// optionally storing its key into the key directory.
+func (ks *KeyStore) deriveAccount(seed []byte, id string, path accounts.DerivationPath, passphrase string, store bool) (accounts.Account, error) {
+	priv, err := deriveKey(seed, path)
+	if err != nil {
+		return accounts.Account{}, err
+	}
+	key := newKeyFromECDSA(priv)
+	defer zeroKey(key.PrivateKey)
+
+	key.HDSeed, key.HDPath = id, path.String()
+
+	if !store {
+		return accounts.Account{Address: key.Address}, nil
+	}
+	if ks.cache.hasAddress(key.Address) {
+		return ks.Find(accounts.Account{Address: key.Address})
+	}
+	return ks.importKey(key, passphrase)
+}
+
+// storeSeed encrypts a HD seed with the passphrase and writes it into the seed
+// directory, returning the identifier it's referenced with.
+func (ks *KeyStore) storeSeed(seed []byte, passphrase string) (string, error) {
+	id := uuid.NewRandom().String()
+	if err := ks.writeSeed(id, seed, passphrase, ks.kdf()); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// writeSeed encrypts a HD seed with the passphrase using the given key derivation
+// function, and writes it into the seed directory under the given identifier.
+func (ks *KeyStore) writeSeed(id string, seed []byte, passphrase string, kdf KDF) error {
+	cryptoStruct, err := encryptData(seed, passphrase, kdf)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(encryptedSeedJSON{Crypto: cryptoStruct, Id: id, Version: version})
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(ks.seedPath(id), content)
+}
+
+// loadSeed reads and decrypts the HD seed with the given identifier.
+func (ks *KeyStore) loadSeed(id string, passphrase string) ([]byte, error) {
+	if uuid.Parse(id) == nil {
+		return nil, fmt.Errorf("invalid seed identifier %q", id)
+	}
+	content, err := ioutil.ReadFile(ks.seedPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var seedJSON encryptedSeedJSON
+	if err := json.Unmarshal(content, &seedJSON); err != nil {
+		return nil, err
+	}
+	if seedJSON.Version != version {
+		return nil, fmt.Errorf("Version not supported: %v", seedJSON.Version)
+	}
+	return decryptData(seedJSON.Crypto, passphrase)
+}
+
+// unlockSeed decrypts the seed a key was derived from, if any, for it to be
+// re-encrypted along with the key. Seeds encrypted with a different passphrase
+// are skipped, returning nil.
+func (ks *KeyStore) unlockSeed(a accounts.Account, key *Key, passphrase string) ([]byte, error) {
+	if key.HDSeed == "" {
+		return nil, nil
+	}
+	seed, err := ks.loadSeed(key.HDSeed, passphrase)
+	if err == ErrDecrypt {
+		log.Warn("Skipping seed encrypted with a different passphrase", "address", a.Address, "seed", key.HDSeed)
+		return nil, nil
+	}
+	return seed, err
+}
+
+// seedAccounts decrypts the keys of all the accounts derived from the HD seed
+// with the given identifier, failing if any of them can't be decrypted with the
+// passphrase.
+func (ks *KeyStore) seedAccounts(id string, passphrase string) ([]accounts.Account, []*Key, error) {
+	var (
+		accs []accounts.Account
+		keys []*Key
+	)
+	fail := func(a accounts.Account, err error) ([]accounts.Account, []*Key, error) {
+		for _, key := range keys {
+			zeroKey(key.PrivateKey)
+		}
+		return nil, nil, fmt.Errorf("account %x derived from the same seed: %v", a.Address, err)
+	}
+	for _, a := range ks.cache.accounts() {
+		seed, err := seedOf(a)
+		if err == errNoSeed || (err == nil && seed != id) {
+			continue
+		}
+		if err != nil {
+			return fail(a, err)
+		}
+		acc, key, err := ks.getDecryptedKey(a, passphrase)
+		if err != nil {
+			return fail(a, err)
+		}
+		accs, keys = append(accs, acc), append(keys, key)
+	}
+	return accs, keys, nil
+}
+
+// seedPath returns the file path of the HD seed with the given identifier.
+func (ks *KeyStore) seedPath(id string) string {
+	return ks.storage.JoinPath(filepath.Join(seedDir, id))
+}
+
+// seedOf returns the identifier of the HD seed an account was derived from, as
+// recorded in the unencrypted part of its key file.
+func seedOf(a accounts.Account) (string, error) {
+	content, err := ioutil.ReadFile(a.URL.Path)
+	if err != nil {
+		return "", err
+	}
+	var keyJSON struct {
+		HDSeed string `json:"hdseed"`
+	}
+	if err := json.Unmarshal(content, &keyJSON); err != nil {
+		return "", err
+	}
+	if keyJSON.HDSeed == "" {
+		return "", errNoSeed
+	}
+	return keyJSON.HDSeed, nil
+}
+
+// zeroBytes zeroes a byte slice in memory.
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package keystore
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/mnemonic"
+	"github.com/megatilt/go-tilt/common/math"
+)
+
+// bip32Vectors are the private keys of the official BIP-32 test vectors 1 and 2.
+//
+// https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki#test-vectors
+var bip32Vectors = []struct {
+	seed string
+	path accounts.DerivationPath
+	key  string
+}{
+	{"000102030405060708090a0b0c0d0e0f", accounts.DerivationPath{}, "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35"},
+	{"000102030405060708090a0b0c0d0e0f", accounts.DerivationPath{0x80000000}, "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"},
+	{"000102030405060708090a0b0c0d0e0f", accounts.DerivationPath{0x80000000, 1}, "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368"},
+	{"000102030405060708090a0b0c0d0e0f", accounts.DerivationPath{0x80000000, 1, 0x80000002}, "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca"},
+	{"000102030405060708090a0b0c0d0e0f", accounts.DerivationPath{0x80000000, 1, 0x80000002, 2}, "0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4"},
+	{"000102030405060708090a0b0c0d0e0f", accounts.DerivationPath{0x80000000, 1, 0x80000002, 2, 1000000000}, "471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8"},
+
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", accounts.DerivationPath{}, "4b03d6fc340455b363f51020ad3ecca4f0850280cf436c70c727923f6db46c3e"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", accounts.DerivationPath{0}, "abe74a98f6c7eabee0428f53798f0ab8aa1bd37873999041703c742f15ac7e1e"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", accounts.DerivationPath{0, 0xffffffff}, "877c779ad9687164e9c2f4f0f4ff0340814392330693ce95a58fe18fd52e6e93"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", accounts.DerivationPath{0, 0xffffffff, 1}, "704addf544a06e5ee4bea37098463c23613da32020d604506da8c0518e1da4b7"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", accounts.DerivationPath{0, 0xffffffff, 1, 0xfffffffe}, "f1c7c871a54a804afe328b4c83a1c33b8e5ff48f5087273f04efa83b247d6a2d"},
+	{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", accounts.DerivationPath{0, 0xffffffff, 1, 0xfffffffe, 2}, "bb7d39bdb83ecf58f2fd82b6d918341cbef428661ef01ab97c28a4842125ac23"},
+}
+
+// Tests that keys are derived from seeds according to the official vectors.
+func TestDeriveKeyVectors(t *testing.T) {
+	for i, tt := range bip32Vectors {
+		seed, _ := hex.DecodeString(tt.seed)
+
+		key, err := deriveKey(seed, tt.path)
+		if err != nil {
+			t.Errorf("vector %d: failed to derive key: %v", i, err)
+			continue
+		}
+		if have := hex.EncodeToString(math.PaddedBigBytes(key.D, 32)); have != tt.key {
+			t.Errorf("vector %d: key mismatch: have %s, want %s", i, have, tt.key)
+		}
+	}
+}
+
+// testMnemonic is the first official BIP-39 test vector.
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// newTestKeyStore creates a keystore in a temporary directory, using the light
+// scrypt parameters.
+func newTestKeyStore(t *testing.T) (string, *KeyStore) {
+	dir, err := ioutil.TempDir("", "keystore-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	return dir, NewKeyStore(dir, LightScryptN, LightScryptP)
+}
+
+// seedFiles returns the names of the seed files in the key directory.
+func seedFiles(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(filepath.Join(dir, seedDir))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("failed to list seeds: %v", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names
+}
+
+// Tests that a mnemonic derives the account at the default path and that
+// importing it again reuses the stored seed instead of writing another one.
+func TestImportMnemonicTwice(t *testing.T) {
+	dir, ks := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+
+	account, err := ks.ImportMnemonic(testMnemonic, "", "foo")
+	if err != nil {
+		t.Fatalf("failed to import mnemonic: %v", err)
+	}
+	// m/44'/60'/0'/0 of the mnemonic
+	if want := "0xb8fd42000d00202dcbcf5e18d6640d656345fd6a"; account.Address.Hex() != want {
+		t.Errorf("account mismatch: have %s, want %s", account.Address.Hex(), want)
+	}
+	seeds := seedFiles(t, dir)
+	if len(seeds) != 1 {
+		t.Fatalf("seed count mismatch: have %d, want %d", len(seeds), 1)
+	}
+	again, err := ks.ImportMnemonic(testMnemonic, "", "foo")
+	if err != nil {
+		t.Fatalf("failed to import mnemonic again: %v", err)
+	}
+	if again != account {
+		t.Errorf("reimported account mismatch: have %v, want %v", again, account)
+	}
+	if have := seedFiles(t, dir); len(have) != 1 || have[0] != seeds[0] {
+		t.Errorf("seeds mismatch: have %v, want %v", have, seeds)
+	}
+	// The seed must only be reused if the passphrase decrypts it
+	if _, err := ks.ImportMnemonic(testMnemonic, "", "bar"); err != ErrDecrypt {
+		t.Errorf("import with wrong passphrase error mismatch: have %v, want %v", err, ErrDecrypt)
+	}
+	// A missing seed is written anew under the same identifier
+	if err := os.Remove(filepath.Join(dir, seedDir, seeds[0])); err != nil {
+		t.Fatalf("failed to remove seed: %v", err)
+	}
+	if _, err := ks.ImportMnemonic(testMnemonic, "", "foo"); err != nil {
+		t.Fatalf("failed to import mnemonic with missing seed: %v", err)
+	}
+	if have := seedFiles(t, dir); len(have) != 1 || have[0] != seeds[0] {
+		t.Errorf("restored seeds mismatch: have %v, want %v", have, seeds)
+	}
+	if _, err := ks.DeriveAccount(account, accounts.DerivationPath{0x80000000 + 44, 0x80000000 + 60, 0x80000000, 0, 1}, "foo"); err != nil {
+		t.Errorf("failed to derive from restored seed: %v", err)
+	}
+	// A different BIP-39 passphrase is a different seed altogether
+	other, err := ks.ImportMnemonic(testMnemonic, "TREZOR", "foo")
+	if err != nil {
+		t.Fatalf("failed to import mnemonic with passphrase: %v", err)
+	}
+	if other.Address == account.Address {
+		t.Errorf("BIP-39 passphrase ignored")
+	}
+	if have := seedFiles(t, dir); len(have) != 2 {
+		t.Errorf("seed count mismatch: have %d, want %d", len(have), 2)
+	}
+}
+
+// Tests that a mnemonic whose first account was imported as a plain key is
+// rejected, as the key file can't reference the seed.
+func TestImportMnemonicPlainKey(t *testing.T) {
+	dir, ks := newTestKeyStore(t)
+	defer os.RemoveAll(dir)
+
+	seed, _ := mnemonic.NewSeed(testMnemonic, "")
+	key, err := deriveKey(seed, accounts.DefaultBaseDerivationPath)
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	if _, err := ks.ImportECDSA(key, "foo"); err != nil {
+		t.Fatalf("failed to import key: %v", err)
+	}
+	if _, err := ks.ImportMnemonic(testMnemonic, "", "foo"); err != errSeedlessAccount {
+		t.Errorf("error mismatch: have %v, want %v", err, errSeedlessAccount)
+	}
+	if seeds := seedFiles(t, dir); len(seeds) != 0 {
+		t.Errorf("orphan seeds written: %v", seeds)
+	}
+}
@@ -96,9 +96,12 @@ func (am *Manager) update() {
 		case event := <-am.updates:
 			// Wallet event arrived, update local cache
 			am.lock.Lock()
-			if event.Arrive {
+			switch {
+			case event.Changed:
+				// Wallet already tracked, nothing to update
+			case event.Arrive:
 				am.wallets = merge(am.wallets, event.Wallet)
-			} else {
+			default:
 				am.wallets = drop(am.wallets, event.Wallet)
 			}
 			am.lock.Unlock()
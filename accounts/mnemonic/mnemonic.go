@@ -0,0 +1,161 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package mnemonic implements BIP-39 mnemonic sentences, encoding random entropy
+// as a list of English words from which a deterministic wallet seed is derived.
+//
+// https://github.com/bitcoin/bips/blob/master/bip-0039.mediawiki
+package mnemonic
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// DefaultEntropyBits is the entropy of newly generated mnemonics, resulting
+	// in a 12 word sentence.
+	DefaultEntropyBits = 128
+
+	seedIterations = 2048 // PBKDF2 iterations of the seed derivation
+	seedLength     = 64   // Length of the derived seed in bytes
+)
+
+var (
+	// ErrInvalidEntropy is returned if the entropy length isn't a multiple of 32
+	// bits between 128 and 256 bits.
+	ErrInvalidEntropy = errors.New("entropy must be 128-256 bits, in 32 bit increments")
+
+	// ErrInvalidMnemonic is returned if a mnemonic has an invalid word count or
+	// contains words not in the wordlist.
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+	// ErrChecksum is returned if the checksum embedded in a mnemonic does not
+	// match its entropy, usually indicating a mistyped word.
+	ErrChecksum = errors.New("mnemonic checksum mismatch")
+)
+
+// NewEntropy returns the given number of bits of cryptographically secure random
+// entropy to create a mnemonic from.
+func NewEntropy(bits int) ([]byte, error) {
+	if err := validateEntropyBits(bits); err != nil {
+		return nil, err
+	}
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+// NewMnemonic encodes the entropy into a mnemonic sentence, with each word
+// encoding 11 bits of the entropy followed by its SHA256 checksum.
+func NewMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if err := validateEntropyBits(bits); err != nil {
+		return "", err
+	}
+	// Append the checksum (one bit per 32 entropy bits) and split into words
+	data := append(append([]byte{}, entropy...), sha256.Sum256(entropy)[0])
+
+	words := make([]string, (bits+bits/32)/11)
+	for i := range words {
+		words[i] = englishWords[readBits(data, i*11, 11)]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// Entropy decodes a mnemonic sentence into the entropy it encodes, verifying
+// the words and the checksum.
+func Entropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(norm.NFKD.String(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrInvalidMnemonic
+	}
+	// Concatenate the 11 bit word indices into the entropy and checksum bits
+	var (
+		total = len(words) * 11
+		bits  = total * 32 / 33
+		data  = make([]byte, (total+7)/8)
+	)
+	for i, word := range words {
+		index := sort.SearchStrings(englishWords[:], word)
+		if index == len(englishWords) || englishWords[index] != word {
+			return nil, fmt.Errorf("%v: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		writeBits(data, i*11, 11, index)
+	}
+	entropy := data[:bits/8]
+
+	checksum, checksumBits := sha256.Sum256(entropy), total-bits
+	if readBits(data, bits, checksumBits) != readBits(checksum[:], 0, checksumBits) {
+		return nil, ErrChecksum
+	}
+	return entropy, nil
+}
+
+// NewSeed verifies the mnemonic and derives the 64 byte wallet seed from it,
+// salted with the optional passphrase. Different passphrases result in entirely
+// different, but equally valid seeds.
+func NewSeed(mnemonic string, passphrase string) ([]byte, error) {
+	if _, err := Entropy(mnemonic); err != nil {
+		return nil, err
+	}
+	sentence := strings.Join(strings.Fields(norm.NFKD.String(mnemonic)), " ")
+	salt := "mnemonic" + norm.NFKD.String(passphrase)
+
+	return pbkdf2.Key([]byte(sentence), []byte(salt), seedIterations, seedLength, sha512.New), nil
+}
+
+// validateEntropyBits checks that the entropy size is allowed by BIP-39.
+func validateEntropyBits(bits int) error {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return ErrInvalidEntropy
+	}
+	return nil
+}
+
+// readBits reads a big endian integer of the given bit length, starting at the
+// given bit offset of the data.
+func readBits(data []byte, offset, length int) int {
+	value := 0
+	for i := offset; i < offset+length; i++ {
+		value <<= 1
+		if data[i/8]&(0x80>>uint(i%8)) != 0 {
+			value |= 1
+		}
+	}
+	return value
+}
+
+// writeBits writes a big endian integer of the given bit length at the given
+// bit offset of the data.
+func writeBits(data []byte, offset, length int, value int) {
+	for i := 0; i < length; i++ {
+		if value&(1<<uint(length-1-i)) != 0 {
+			pos := offset + i
+			data[pos/8] |= 0x80 >> uint(pos%8)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+// Package multisig implements M-of-N multisig wallets, assembling the approvals
+// of the owners of a multisig contract for the calls it is proposed to make.
+package multisig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/event"
+	"github.com/megatilt/go-tilt/log"
+)
+
+// Scheme is the protocol scheme prefixing multisig wallet URLs.
+const Scheme = "multisig"
+
+// BackendType is the reflect type of a multisig backend.
+var BackendType = reflect.TypeOf(&Backend{})
+
+// walletJSON is the on-disk format of a multisig wallet.
+type walletJSON struct {
+	Config
+	Proposals []*Proposal `json:"proposals"`
+}
+
+// Backend implements accounts.Backend, managing the multisig wallets stored in
+// a directory, one file per contract along with its pending proposals.
+type Backend struct {
+	dir     string            // Directory holding the wallet files
+	wallets []accounts.Wallet // Multisig wallets, sorted by URL
+
+	updateFeed  event.Feed              // Event feed to notify wallet additions and changes
+	updateScope event.SubscriptionScope // Subscription scope tracking current live listeners
+
+	lock sync.RWMutex
+}
+
+// NewBackend creates a multisig backend, loading the wallets stored in the given
+// directory.
+func NewBackend(dir string) (*Backend, error) {
+	backend := &Backend{dir: dir}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var stored walletJSON
+		if err := json.Unmarshal(content, &stored); err != nil {
+			return nil, fmt.Errorf("invalid multisig wallet %s: %v", path, err)
+		}
+		if err := stored.Config.validate(); err != nil {
+			return nil, fmt.Errorf("invalid multisig wallet %s: %v", path, err)
+		}
+		wallet := backend.newWallet(stored.Config)
+		for _, proposal := range stored.Proposals {
+			if err := proposal.validate(&stored.Config); err != nil {
+				return nil, fmt.Errorf("invalid multisig wallet %s: %v", path, err)
+			}
+			if proposal.Signatures == nil {
+				proposal.Signatures = make(map[common.Address]hexutil.Bytes)
+			}
+			wallet.proposals[proposal.ID] = proposal
+		}
+		backend.wallets = append(backend.wallets, wallet)
+	}
+	sort.Sort(walletsByURL(backend.wallets))
+	return backend, nil
+}
+
+// newWallet creates an empty multisig wallet for the given configuration.
+func (b *Backend) newWallet(config Config) *Wallet {
+	return &Wallet{
+		backend:   b,
+		config:    config,
+		url:       accounts.URL{Scheme: Scheme, Path: strings.ToLower(config.Address.Hex())},
+		proposals: make(map[common.Hash]*Proposal),
+	}
+}
+
+// Wallets implements accounts.Backend, returning all the multisig wallets.
+func (b *Backend) Wallets() []accounts.Wallet {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	cpy := make([]accounts.Wallet, len(b.wallets))
+	copy(cpy, b.wallets)
+	return cpy
+}
+
+// Subscribe implements accounts.Backend, creating an async subscription to
+// receive notifications on the addition of multisig wallets and the changes of
+// their pending proposals.
+func (b *Backend) Subscribe(sink chan<- accounts.WalletEvent) event.Subscription {
+	return b.updateScope.Track(b.updateFeed.Subscribe(sink))
+}
+
+// Create adds a new multisig wallet for a deployed contract with the given
+// configuration.
+func (b *Backend) Create(config Config) (*Wallet, error) {
+	config = config.copy()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	for _, wallet := range b.wallets {
+		if wallet.(*Wallet).config.Address == config.Address {
+			b.lock.Unlock()
+			return nil, fmt.Errorf("multisig wallet %s already exists", config.Address.Hex())
+		}
+	}
+	wallet := b.newWallet(config)
+	if err := b.store(wallet); err != nil {
+		b.lock.Unlock()
+		return nil, err
+	}
+	b.wallets = append(b.wallets, wallet)
+	sort.Sort(walletsByURL(b.wallets))
+	b.lock.Unlock()
+
+	log.Info("Multisig wallet created", "multisig", config.Address, "owners", len(config.Owners), "threshold", config.Threshold)
+	b.updateFeed.Send(accounts.WalletEvent{Wallet: wallet, Arrive: true})
+	return wallet, nil
+}
+
+// Find returns the multisig wallet of the contract with the given address.
+func (b *Backend) Find(address common.Address) (*Wallet, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for _, wallet := range b.wallets {
+		if wallet := wallet.(*Wallet); wallet.config.Address == address {
+			return wallet, nil
+		}
+	}
+	return nil, accounts.ErrUnknownWallet
+}
+
+// store persists a multisig wallet along with its pending proposals. The caller
+// must prevent concurrent modifications of the proposals.
+func (b *Backend) store(w *Wallet) error {
+	stored := walletJSON{Config: w.config}
+	for _, proposal := range w.proposals {
+		stored.Proposals = append(stored.Proposals, proposal)
+	}
+	sort.Sort(proposalsByNonce(stored.Proposals))
+
+	content, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(b.dir, strings.ToLower(w.config.Address.Hex()[2:])+".json"), content)
+}
+
+// changed notifies the subscribers about a change of a multisig wallet.
+func (b *Backend) changed(w *Wallet) {
+	b.updateFeed.Send(accounts.WalletEvent{Wallet: w, Changed: true})
+}
+
+// writeFile atomically writes a file by creating a temporary hidden file first
+// and then moving it into place.
+func writeFile(file string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	f.Close()
+	return os.Rename(f.Name(), file)
+}
+
+// walletsByURL implements sort.Interface, ordering wallets by URL.
+type walletsByURL []accounts.Wallet
+
+func (s walletsByURL) Len() int           { return len(s) }
+func (s walletsByURL) Less(i, j int) bool { return s[i].URL().Cmp(s[j].URL()) < 0 }
+func (s walletsByURL) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,86 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+package multisig
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/megatilt/go-tilt/accounts/abi"
+	"github.com/megatilt/go-tilt/common"
+)
+
+// ABI is the interface of the multisig contracts the wallets assemble approvals
+// for. The contract executes a call once signatures of enough distinct owners
+// over the proposal hash are provided, ordered by increasing owner address, and
+// increments its nonce to prevent replays. The chain id in the proposal hash is
+// the one the contract was deployed with, as the EVM can't query it.
+const ABI = `[{"constant":true,"inputs":[],"name":"nonce","outputs":[{"name":"","type":"uint256"}],"payable":false,"type":"function"},{"constant":false,"inputs":[{"name":"sigV","type":"uint8[]"},{"name":"sigR","type":"bytes32[]"},{"name":"sigS","type":"bytes32[]"},{"name":"destination","type":"address"},{"name":"value","type":"uint256"},{"name":"data","type":"bytes"}],"name":"execute","outputs":[],"payable":false,"type":"function"}]`
+
+// parsedABI is the parsed form of the multisig contract interface.
+var parsedABI abi.ABI
+
+func init() {
+	var err error
+	if parsedABI, err = abi.JSON(strings.NewReader(ABI)); err != nil {
+		panic(err)
+	}
+}
+
+// PackNonce returns the call data retrieving the current nonce of a multisig
+// contract, the one new proposals need to be made for.
+func PackNonce() ([]byte, error) {
+	return parsedABI.Pack("nonce")
+}
+
+// UnpackNonce decodes the output of a nonce call.
+func UnpackNonce(output []byte) (uint64, error) {
+	nonce := new(big.Int)
+	if err := parsedABI.Unpack(&nonce, "nonce", output); err != nil {
+		return 0, err
+	}
+	if nonce.BitLen() > 64 {
+		return 0, errors.New("nonce overflows 64 bits")
+	}
+	return nonce.Uint64(), nil
+}
+
+// packExecute returns the call data executing a proposal with the signatures of
+// the given owners, which must be sorted by increasing address as the contract
+// requires.
+func packExecute(proposal *Proposal, owners []common.Address) ([]byte, error) {
+	var (
+		sigV = make([]uint8, len(owners))
+		sigR = make([][32]byte, len(owners))
+		sigS = make([][32]byte, len(owners))
+	)
+	for i, owner := range owners {
+		sig := proposal.Signatures[owner]
+		copy(sigR[i][:], sig[:32])
+		copy(sigS[i][:], sig[32:64])
+		sigV[i] = sig[64]
+	}
+	return parsedABI.Pack("execute", sigV, sigR, sigS, proposal.Destination, proposal.Value.ToInt(), []byte(proposal.Data))
+}
+
+// addressesByValue implements sort.Interface, ordering addresses by value.
+type addressesByValue []common.Address
+
+func (s addressesByValue) Len() int           { return len(s) }
+func (s addressesByValue) Less(i, j int) bool { return bytes.Compare(s[i][:], s[j][:]) < 0 }
+func (s addressesByValue) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,182 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+package multisig
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/common/math"
+	"github.com/megatilt/go-tilt/crypto"
+)
+
+// errInvalidSignature is returned if an approval signature is malformed.
+var errInvalidSignature = errors.New("invalid signature (must be 65 bytes with V of 0, 1, 27 or 28)")
+
+// Config is the immutable configuration of a multisig wallet, mirroring the one
+// of its contract.
+type Config struct {
+	ChainID   *hexutil.Big     `json:"chainId"`   // Chain the contract is deployed on
+	Address   common.Address   `json:"address"`   // Address of the multisig contract
+	Owners    []common.Address `json:"owners"`    // Owners whose approvals are accepted
+	Threshold int              `json:"threshold"` // Number of approvals needed to execute
+}
+
+// copy returns a deep copy of the configuration.
+func (c *Config) copy() Config {
+	cpy := *c
+	if c.ChainID != nil {
+		cpy.ChainID = (*hexutil.Big)(new(big.Int).Set(c.ChainID.ToInt()))
+	}
+	cpy.Owners = append([]common.Address{}, c.Owners...)
+	return cpy
+}
+
+// validate checks that the configuration describes a satisfiable M-of-N scheme.
+func (c *Config) validate() error {
+	if c.ChainID == nil || c.ChainID.ToInt().Sign() <= 0 {
+		return errors.New("missing multisig chain id")
+	}
+	if c.Address == (common.Address{}) {
+		return errors.New("missing multisig contract address")
+	}
+	if c.Threshold < 1 || c.Threshold > len(c.Owners) {
+		return fmt.Errorf("invalid threshold %d for %d owners", c.Threshold, len(c.Owners))
+	}
+	seen := make(map[common.Address]bool)
+	for _, owner := range c.Owners {
+		if owner == (common.Address{}) {
+			return errors.New("zero owner address")
+		}
+		if seen[owner] {
+			return fmt.Errorf("duplicate owner %s", owner.Hex())
+		}
+		seen[owner] = true
+	}
+	return nil
+}
+
+// isOwner returns whether the address is one of the owners.
+func (c *Config) isOwner(addr common.Address) bool {
+	for _, owner := range c.Owners {
+		if owner == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Proposal is a call to be executed by a multisig contract once approved by
+// enough owners, along with the approvals collected so far. Its identifier is
+// the hash the owners sign to approve it. Once submitted, the transaction
+// executing it is tracked until its outcome is known.
+type Proposal struct {
+	ID          common.Hash                      `json:"id"`
+	Destination common.Address                   `json:"destination"`
+	Value       *hexutil.Big                     `json:"value"`
+	Data        hexutil.Bytes                    `json:"data"`
+	Nonce       hexutil.Uint64                   `json:"nonce"`
+	Signatures  map[common.Address]hexutil.Bytes `json:"signatures"`
+	Execution   *common.Hash                     `json:"execution,omitempty"`
+}
+
+// copy returns a deep copy of the proposal, safe to hand out to callers.
+func (p *Proposal) copy() *Proposal {
+	cpy := *p
+	cpy.Value = (*hexutil.Big)(new(big.Int).Set(p.Value.ToInt()))
+	cpy.Data = common.CopyBytes(p.Data)
+	cpy.Signatures = make(map[common.Address]hexutil.Bytes, len(p.Signatures))
+	for owner, sig := range p.Signatures {
+		cpy.Signatures[owner] = common.CopyBytes(sig)
+	}
+	if p.Execution != nil {
+		tx := *p.Execution
+		cpy.Execution = &tx
+	}
+	return &cpy
+}
+
+// validate checks that a proposal loaded from disk is well formed: its identifier
+// must match its contents and every approval must be signed by its owner.
+func (p *Proposal) validate(config *Config) error {
+	if p.Value == nil {
+		return fmt.Errorf("proposal %x: missing value", p.ID)
+	}
+	if id := proposalHash(config.ChainID.ToInt(), config.Address, p.Destination, p.Value.ToInt(), p.Data, uint64(p.Nonce)); id != p.ID {
+		return fmt.Errorf("proposal %x: identifier mismatch, computed %x", p.ID, id)
+	}
+	for owner, sig := range p.Signatures {
+		if !config.isOwner(owner) {
+			return fmt.Errorf("proposal %x: approval of %s: %v", p.ID, owner.Hex(), ErrNotOwner)
+		}
+		signer, _, err := recoverOwner(p.ID, sig)
+		if err != nil {
+			return fmt.Errorf("proposal %x: approval of %s: %v", p.ID, owner.Hex(), err)
+		}
+		if signer != owner {
+			return fmt.Errorf("proposal %x: approval of %s signed by %s", p.ID, owner.Hex(), signer.Hex())
+		}
+	}
+	return nil
+}
+
+// proposalHash returns the hash owners sign to approve a call of the multisig
+// contract, binding it to the contract, the chain it is deployed on and its
+// current nonce:
+//
+//   keccak256(0x19 ‖ 0x00 ‖ contract ‖ chainId ‖ destination ‖ value ‖ data ‖ nonce)
+//
+// The chain id keeps approvals from being replayed on another chain where the
+// same contract address holds a contract with the same owners and nonce, e.g.
+// after a chain split.
+func proposalHash(chainID *big.Int, contract, destination common.Address, value *big.Int, data []byte, nonce uint64) common.Hash {
+	return crypto.Keccak256Hash(
+		[]byte{0x19, 0x00},
+		contract[:],
+		math.PaddedBigBytes(chainID, 32),
+		destination[:],
+		math.PaddedBigBytes(value, 32),
+		data,
+		math.PaddedBigBytes(new(big.Int).SetUint64(nonce), 32),
+	)
+}
+
+// recoverOwner returns the address that signed a proposal hash, along with the
+// signature in the [R || S || V] format where V is 27 or 28, as the contract
+// expects it.
+func recoverOwner(id common.Hash, sig []byte) (common.Address, []byte, error) {
+	if len(sig) != 65 {
+		return common.Address{}, nil, errInvalidSignature
+	}
+	cpy := common.CopyBytes(sig)
+	switch cpy[64] {
+	case 0, 1:
+	case 27, 28:
+		cpy[64] -= 27 // Transform yellow paper V from 27/28 to 0/1
+	default:
+		return common.Address{}, nil, errInvalidSignature
+	}
+	pubkey, err := crypto.Ecrecover(id[:], cpy)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	cpy[64] += 27 // Transform V from 0/1 to 27/28 according to the yellow paper
+
+	return crypto.PubkeyToAddress(*crypto.ToECDSAPub(pubkey)), cpy, nil
+}
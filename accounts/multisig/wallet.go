@@ -0,0 +1,351 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+package multisig
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	tiltnet "github.com/megatilt/go-tilt"
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/log"
+)
+
+var (
+	// ErrUnknownProposal is returned if a requested proposal is not pending.
+	ErrUnknownProposal = errors.New("unknown proposal")
+
+	// ErrNotOwner is returned if an approval is made by an account not among the
+	// owners of the multisig contract.
+	ErrNotOwner = errors.New("not a multisig owner")
+
+	// ErrApprovalsNeeded is returned if a proposal is executed before collecting
+	// enough approvals.
+	ErrApprovalsNeeded = errors.New("not enough approvals")
+)
+
+// Wallet implements accounts.Wallet for a multisig contract, whose single account
+// can't sign anything on its own. Instead, calls of the contract are proposed and
+// approved by its owners, each signing the proposal with its own wallet, until
+// enough approvals are collected to execute it.
+type Wallet struct {
+	backend *Backend     // Backend persisting the wallet and announcing changes
+	config  Config       // Immutable configuration of the multisig contract
+	url     accounts.URL // Canonical URL of the wallet
+
+	proposals map[common.Hash]*Proposal // Pending proposals, by identifier
+	lock      sync.RWMutex              // Lock protecting the pending proposals
+}
+
+// URL implements accounts.Wallet, returning the URL of the multisig contract.
+func (w *Wallet) URL() accounts.URL {
+	return w.url
+}
+
+// Status implements accounts.Wallet, returning the approval scheme and the number
+// of pending proposals.
+func (w *Wallet) Status() string {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	return fmt.Sprintf("%d-of-%d, %d pending", w.config.Threshold, len(w.config.Owners), len(w.proposals))
+}
+
+// Open implements accounts.Wallet, but is a noop for multisig wallets since there
+// is no connection or decryption step necessary to access the contract account.
+func (w *Wallet) Open(passphrase string) error { return nil }
+
+// Close implements accounts.Wallet, but is a noop for multisig wallets since there
+// is no meaningful open operation.
+func (w *Wallet) Close() error { return nil }
+
+// Accounts implements accounts.Wallet, returning the account of the contract.
+func (w *Wallet) Accounts() []accounts.Account {
+	return []accounts.Account{{Address: w.config.Address, URL: w.url}}
+}
+
+// Contains implements accounts.Wallet, returning whether the account is the one
+// of the multisig contract.
+func (w *Wallet) Contains(account accounts.Account) bool {
+	return account.Address == w.config.Address && (account.URL == (accounts.URL{}) || account.URL == w.url)
+}
+
+// Derive implements accounts.Wallet, but is a noop for multisig wallets since
+// there is no notion of hierarchical account derivation for contracts.
+func (w *Wallet) Derive(path accounts.DerivationPath, pin bool) (accounts.Account, error) {
+	return accounts.Account{}, accounts.ErrNotSupported
+}
+
+// SelfDerive implements accounts.Wallet, but is a noop for multisig wallets since
+// there is no notion of hierarchical account derivation for contracts.
+func (w *Wallet) SelfDerive(base accounts.DerivationPath, chain tiltnet.ChainStateReader) {}
+
+// SignHash implements accounts.Wallet, but a contract has no key to sign with.
+func (w *Wallet) SignHash(account accounts.Account, hash []byte) ([]byte, error) {
+	return nil, accounts.ErrNotSupported
+}
+
+// SignTx implements accounts.Wallet, but a contract has no key to sign with.
+// Transactions from the contract need to be proposed and approved instead.
+func (w *Wallet) SignTx(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return nil, accounts.ErrNotSupported
+}
+
+// SignHashWithPassphrase implements accounts.Wallet, but a contract has no key
+// to sign with.
+func (w *Wallet) SignHashWithPassphrase(account accounts.Account, passphrase string, hash []byte) ([]byte, error) {
+	return nil, accounts.ErrNotSupported
+}
+
+// SignTxWithPassphrase implements accounts.Wallet, but a contract has no key to
+// sign with. Transactions from the contract need to be proposed and approved
+// instead.
+func (w *Wallet) SignTxWithPassphrase(account accounts.Account, passphrase string, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return nil, accounts.ErrNotSupported
+}
+
+// Config returns the configuration of the multisig contract.
+func (w *Wallet) Config() Config {
+	return w.config.copy()
+}
+
+// Proposals returns all pending proposals, ordered by nonce.
+func (w *Wallet) Proposals() []*Proposal {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	proposals := make([]*Proposal, 0, len(w.proposals))
+	for _, proposal := range w.proposals {
+		proposals = append(proposals, proposal.copy())
+	}
+	sort.Sort(proposalsByNonce(proposals))
+	return proposals
+}
+
+// Proposal returns the pending proposal with the given identifier.
+func (w *Wallet) Proposal(id common.Hash) (*Proposal, error) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	proposal, ok := w.proposals[id]
+	if !ok {
+		return nil, ErrUnknownProposal
+	}
+	return proposal.copy(), nil
+}
+
+// Propose creates a pending proposal for the contract to call the destination
+// with the given value and data. The nonce must be the current one of the
+// contract for the approvals to be valid.
+func (w *Wallet) Propose(destination common.Address, value *big.Int, data []byte, nonce uint64) (*Proposal, error) {
+	if value == nil {
+		value = new(big.Int)
+	}
+	proposal := &Proposal{
+		ID:          proposalHash(w.config.ChainID.ToInt(), w.config.Address, destination, value, data, nonce),
+		Destination: destination,
+		Value:       (*hexutil.Big)(new(big.Int).Set(value)),
+		Data:        common.CopyBytes(data),
+		Nonce:       hexutil.Uint64(nonce),
+		Signatures:  make(map[common.Address]hexutil.Bytes),
+	}
+	w.lock.Lock()
+	if existing, ok := w.proposals[proposal.ID]; ok {
+		w.lock.Unlock()
+		return existing.copy(), nil
+	}
+	w.proposals[proposal.ID] = proposal
+	err := w.backend.store(w)
+	w.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Multisig call proposed", "multisig", w.config.Address, "id", proposal.ID, "destination", destination, "nonce", nonce)
+	w.backend.changed(w)
+	return proposal.copy(), nil
+}
+
+// Approve signs a pending proposal with the given owner account through the
+// wallet holding it, e.g. a keystore, a hardware wallet or an external signer,
+// and records the approval.
+func (w *Wallet) Approve(id common.Hash, signer accounts.Wallet, owner accounts.Account) error {
+	if !w.config.isOwner(owner.Address) {
+		return ErrNotOwner
+	}
+	if _, err := w.Proposal(id); err != nil {
+		return err
+	}
+	sig, err := signer.SignHash(owner, id[:])
+	if err != nil {
+		return err
+	}
+	approver, err := w.AddSignature(id, sig)
+	if err != nil {
+		return err
+	}
+	if approver != owner.Address {
+		return fmt.Errorf("signer mismatch: expected %s, got %s", owner.Address.Hex(), approver.Hex())
+	}
+	return nil
+}
+
+// AddSignature records an approval signature of a pending proposal, made by an
+// owner elsewhere, returning the owner who signed it.
+func (w *Wallet) AddSignature(id common.Hash, sig []byte) (common.Address, error) {
+	owner, sig, err := recoverOwner(id, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if !w.config.isOwner(owner) {
+		return common.Address{}, ErrNotOwner
+	}
+	w.lock.Lock()
+	proposal, ok := w.proposals[id]
+	if !ok {
+		w.lock.Unlock()
+		return common.Address{}, ErrUnknownProposal
+	}
+	proposal.Signatures[owner] = sig
+	approvals := len(proposal.Signatures)
+	err = w.backend.store(w)
+	w.lock.Unlock()
+
+	if err != nil {
+		return common.Address{}, err
+	}
+	log.Info("Multisig proposal approved", "multisig", w.config.Address, "id", id, "owner", owner, "approvals", approvals, "threshold", w.config.Threshold)
+	w.backend.changed(w)
+	return owner, nil
+}
+
+// Execution returns the call data executing an approved proposal, to be sent
+// to the multisig contract in a transaction from any account. Exactly as many
+// signatures as the threshold are included, ordered by owner address.
+func (w *Wallet) Execution(id common.Hash) ([]byte, error) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	proposal, ok := w.proposals[id]
+	if !ok {
+		return nil, ErrUnknownProposal
+	}
+	if len(proposal.Signatures) < w.config.Threshold {
+		return nil, fmt.Errorf("%v: have %d, want %d", ErrApprovalsNeeded, len(proposal.Signatures), w.config.Threshold)
+	}
+	owners := make([]common.Address, 0, len(proposal.Signatures))
+	for owner := range proposal.Signatures {
+		owners = append(owners, owner)
+	}
+	sort.Sort(addressesByValue(owners))
+
+	return packExecute(proposal, owners[:w.config.Threshold])
+}
+
+// SetExecution records the transaction submitted to execute an approved proposal,
+// or clears it if the execution failed. The proposal and its approvals are kept
+// until the execution is confirmed and the proposal discarded.
+func (w *Wallet) SetExecution(id common.Hash, tx *common.Hash) error {
+	w.lock.Lock()
+	proposal, ok := w.proposals[id]
+	if !ok {
+		w.lock.Unlock()
+		return ErrUnknownProposal
+	}
+	proposal.Execution = nil
+	if tx != nil {
+		hash := *tx
+		proposal.Execution = &hash
+	}
+	err := w.backend.store(w)
+	w.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+	w.backend.changed(w)
+	return nil
+}
+
+// Discard drops a pending proposal, after it was executed or abandoned.
+func (w *Wallet) Discard(id common.Hash) error {
+	w.lock.Lock()
+	if _, ok := w.proposals[id]; !ok {
+		w.lock.Unlock()
+		return ErrUnknownProposal
+	}
+	delete(w.proposals, id)
+	err := w.backend.store(w)
+	w.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+	w.backend.changed(w)
+	return nil
+}
+
+// Prune discards the proposals made for nonces below the given current one of
+// the contract, which can never be executed, returning their identifiers. The
+// proposals with a submitted execution are kept until it is confirmed.
+func (w *Wallet) Prune(nonce uint64) ([]common.Hash, error) {
+	w.lock.Lock()
+	var stale []*Proposal
+	for _, proposal := range w.proposals {
+		if uint64(proposal.Nonce) < nonce && proposal.Execution == nil {
+			stale = append(stale, proposal)
+		}
+	}
+	if len(stale) == 0 {
+		w.lock.Unlock()
+		return nil, nil
+	}
+	sort.Sort(proposalsByNonce(stale))
+
+	ids := make([]common.Hash, len(stale))
+	for i, proposal := range stale {
+		ids[i] = proposal.ID
+		delete(w.proposals, proposal.ID)
+	}
+	err := w.backend.store(w)
+	w.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Stale multisig proposals discarded", "multisig", w.config.Address, "nonce", nonce, "count", len(ids))
+	w.backend.changed(w)
+	return ids, nil
+}
+
+// proposalsByNonce implements sort.Interface, ordering proposals by nonce and
+// identifier.
+type proposalsByNonce []*Proposal
+
+func (s proposalsByNonce) Len() int { return len(s) }
+func (s proposalsByNonce) Less(i, j int) bool {
+	if s[i].Nonce != s[j].Nonce {
+		return s[i].Nonce < s[j].Nonce
+	}
+	return s[i].ID.Big().Cmp(s[j].ID.Big()) < 0
+}
+func (s proposalsByNonce) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
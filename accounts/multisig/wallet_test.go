@@ -0,0 +1,358 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package multisig
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/common/math"
+	"github.com/megatilt/go-tilt/crypto"
+)
+
+// testOwners are the keys of the owners of the test multisig contract.
+var testOwners []*ecdsa.PrivateKey
+
+func init() {
+	for i := 0; i < 3; i++ {
+		key, _ := crypto.GenerateKey()
+		testOwners = append(testOwners, key)
+	}
+}
+
+// newTestWallet creates a multisig backend in a temporary directory, holding a
+// 2-of-3 wallet of the test owners.
+func newTestWallet(t *testing.T) (string, *Backend, *Wallet) {
+	dir, err := ioutil.TempDir("", "multisig-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	backend, err := NewBackend(dir)
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+	config := Config{
+		ChainID:   (*hexutil.Big)(big.NewInt(7)),
+		Address:   common.Address{0xaa},
+		Threshold: 2,
+	}
+	for _, key := range testOwners {
+		config.Owners = append(config.Owners, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	wallet, err := backend.Create(config)
+	if err != nil {
+		t.Fatalf("failed to create wallet: %v", err)
+	}
+	return dir, backend, wallet
+}
+
+// approve signs a proposal with the given owner key and records the approval.
+func approve(t *testing.T, wallet *Wallet, id common.Hash, key *ecdsa.PrivateKey) {
+	sig, err := crypto.Sign(id[:], key)
+	if err != nil {
+		t.Fatalf("failed to sign proposal: %v", err)
+	}
+	owner, err := wallet.AddSignature(id, sig)
+	if err != nil {
+		t.Fatalf("failed to add signature: %v", err)
+	}
+	if want := crypto.PubkeyToAddress(key.PublicKey); owner != want {
+		t.Fatalf("approver mismatch: have %x, want %x", owner, want)
+	}
+}
+
+// Tests that the proposal hash binds the call to the contract, the chain and
+// the nonce.
+func TestProposalHash(t *testing.T) {
+	var (
+		contract    = common.Address{0xaa}
+		destination = common.Address{0xbb}
+		value       = big.NewInt(1000)
+		data        = []byte{0xca, 0xfe}
+	)
+	want := crypto.Keccak256Hash(
+		[]byte{0x19, 0x00},
+		contract[:],
+		math.PaddedBigBytes(big.NewInt(7), 32),
+		destination[:],
+		math.PaddedBigBytes(value, 32),
+		data,
+		math.PaddedBigBytes(big.NewInt(3), 32),
+	)
+	if have := proposalHash(big.NewInt(7), contract, destination, value, data, 3); have != want {
+		t.Errorf("hash mismatch: have %x, want %x", have, want)
+	}
+	ids := map[common.Hash]string{
+		want: "original",
+		proposalHash(big.NewInt(8), contract, destination, value, data, 3):               "chain",
+		proposalHash(big.NewInt(7), common.Address{0xab}, destination, value, data, 3):   "contract",
+		proposalHash(big.NewInt(7), contract, destination, value, data, 4):               "nonce",
+		proposalHash(big.NewInt(7), contract, destination, big.NewInt(1001), data, 3):    "value",
+		proposalHash(big.NewInt(7), contract, common.Address{0xbc}, value, data, 3):      "destination",
+		proposalHash(big.NewInt(7), contract, destination, value, []byte{0xca, 0xff}, 3): "data",
+	}
+	if len(ids) != 7 {
+		t.Errorf("colliding proposal hashes: have %d distinct, want %d", len(ids), 7)
+	}
+}
+
+// Tests that the execution call data is only assembled once enough owners
+// approved, and holds exactly threshold signatures ordered by owner address.
+func TestExecutionThreshold(t *testing.T) {
+	dir, _, wallet := newTestWallet(t)
+	defer os.RemoveAll(dir)
+
+	proposal, err := wallet.Propose(common.Address{0xbb}, big.NewInt(1000), []byte{0xca, 0xfe}, 0)
+	if err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	if _, err := wallet.Execution(proposal.ID); err == nil {
+		t.Fatalf("execution without approvals assembled")
+	}
+	approve(t, wallet, proposal.ID, testOwners[2])
+	if _, err := wallet.Execution(proposal.ID); err == nil {
+		t.Fatalf("execution below threshold assembled")
+	}
+	// Approvals of non-owners must be rejected
+	outsider, _ := crypto.GenerateKey()
+	sig, _ := crypto.Sign(proposal.ID[:], outsider)
+	if _, err := wallet.AddSignature(proposal.ID, sig); err != ErrNotOwner {
+		t.Errorf("outsider approval error mismatch: have %v, want %v", err, ErrNotOwner)
+	}
+	approve(t, wallet, proposal.ID, testOwners[0])
+	approve(t, wallet, proposal.ID, testOwners[1])
+
+	input, err := wallet.Execution(proposal.ID)
+	if err != nil {
+		t.Fatalf("failed to assemble execution: %v", err)
+	}
+	// Decode the signature arrays from the call data and check that they are the
+	// ones of the two lowest owner addresses, in order
+	stored, _ := wallet.Proposal(proposal.ID)
+	owners := make([]common.Address, 0, len(stored.Signatures))
+	for owner := range stored.Signatures {
+		owners = append(owners, owner)
+	}
+	sort.Sort(addressesByValue(owners))
+
+	args := input[4:]
+	word := func(offset int) []byte { return args[offset : offset+32] }
+	array := func(head int) [][]byte {
+		offset := int(new(big.Int).SetBytes(word(head * 32)).Int64())
+		items := make([][]byte, new(big.Int).SetBytes(word(offset)).Int64())
+		for i := range items {
+			items[i] = word(offset + 32 + i*32)
+		}
+		return items
+	}
+	sigV, sigR, sigS := array(0), array(1), array(2)
+	if len(sigV) != 2 || len(sigR) != 2 || len(sigS) != 2 {
+		t.Fatalf("signature count mismatch: have %d/%d/%d, want 2", len(sigV), len(sigR), len(sigS))
+	}
+	for i, owner := range owners[:2] {
+		sig := stored.Signatures[owner]
+		if !bytes.Equal(sigR[i], sig[:32]) || !bytes.Equal(sigS[i], sig[32:64]) || sigV[i][31] != sig[64] {
+			t.Errorf("signature %d: not the one of owner %x", i, owner)
+		}
+		if sig[64] != 27 && sig[64] != 28 {
+			t.Errorf("signature %d: V mismatch: have %d, want 27 or 28", i, sig[64])
+		}
+	}
+}
+
+// Tests that wallets and their proposals survive a reload, and that tampered
+// wallet files are rejected.
+func TestPersistence(t *testing.T) {
+	dir, _, wallet := newTestWallet(t)
+	defer os.RemoveAll(dir)
+
+	proposal, err := wallet.Propose(common.Address{0xbb}, big.NewInt(1000), []byte{0xca, 0xfe}, 0)
+	if err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	approve(t, wallet, proposal.ID, testOwners[0])
+	tx := common.Hash{0x01}
+	if err := wallet.SetExecution(proposal.ID, &tx); err != nil {
+		t.Fatalf("failed to set execution: %v", err)
+	}
+	backend, err := NewBackend(dir)
+	if err != nil {
+		t.Fatalf("failed to reload backend: %v", err)
+	}
+	reloaded, err := backend.Find(wallet.config.Address)
+	if err != nil {
+		t.Fatalf("failed to find reloaded wallet: %v", err)
+	}
+	if have, want := reloaded.Config(), wallet.Config(); have.ChainID.ToInt().Cmp(want.ChainID.ToInt()) != 0 || have.Threshold != want.Threshold || len(have.Owners) != len(want.Owners) {
+		t.Errorf("config mismatch: have %+v, want %+v", have, want)
+	}
+	want, _ := wallet.Proposal(proposal.ID)
+	have, err := reloaded.Proposal(proposal.ID)
+	if err != nil {
+		t.Fatalf("failed to find reloaded proposal: %v", err)
+	}
+	haveJSON, _ := json.Marshal(have)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(haveJSON, wantJSON) {
+		t.Errorf("proposal mismatch: have %s, want %s", haveJSON, wantJSON)
+	}
+	// Tamper with the stored wallet in various ways and ensure they are caught
+	path := filepath.Join(dir, common.Bytes2Hex(wallet.config.Address[:])+".json")
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read wallet file: %v", err)
+	}
+	outsider, _ := crypto.GenerateKey()
+	tamperers := map[string]func(*walletJSON){
+		"chain id missing": func(w *walletJSON) { w.ChainID = nil },
+		"chain id changed": func(w *walletJSON) { w.ChainID = (*hexutil.Big)(big.NewInt(8)) },
+		"threshold":        func(w *walletJSON) { w.Threshold = 4 },
+		"value":            func(w *walletJSON) { w.Proposals[0].Value = (*hexutil.Big)(big.NewInt(1001)) },
+		"destination":      func(w *walletJSON) { w.Proposals[0].Destination = common.Address{0xbc} },
+		"nonce":            func(w *walletJSON) { w.Proposals[0].Nonce++ },
+		"misattributed approval": func(w *walletJSON) {
+			owner := crypto.PubkeyToAddress(testOwners[1].PublicKey)
+			w.Proposals[0].Signatures[owner] = w.Proposals[0].Signatures[crypto.PubkeyToAddress(testOwners[0].PublicKey)]
+		},
+		"outsider approval": func(w *walletJSON) {
+			sig, _ := crypto.Sign(proposal.ID[:], outsider)
+			w.Proposals[0].Signatures[crypto.PubkeyToAddress(outsider.PublicKey)] = sig
+		},
+	}
+	for name, tamper := range tamperers {
+		var stored walletJSON
+		if err := json.Unmarshal(original, &stored); err != nil {
+			t.Fatalf("failed to decode wallet file: %v", err)
+		}
+		tamper(&stored)
+		content, _ := json.Marshal(stored)
+		if err := ioutil.WriteFile(path, content, 0600); err != nil {
+			t.Fatalf("failed to write wallet file: %v", err)
+		}
+		if _, err := NewBackend(dir); err == nil {
+			t.Errorf("%s: tampered wallet accepted", name)
+		}
+	}
+}
+
+// Tests that every modification of the pending proposals is announced to the
+// subscribers as a change of the wallet.
+func TestWalletEvents(t *testing.T) {
+	dir, backend, wallet := newTestWallet(t)
+	defer os.RemoveAll(dir)
+
+	sink := make(chan accounts.WalletEvent, 16)
+	sub := backend.Subscribe(sink)
+	defer sub.Unsubscribe()
+
+	expect := func(action string) {
+		select {
+		case event := <-sink:
+			if event.Wallet != wallet || !event.Changed || event.Arrive {
+				t.Errorf("%s: event mismatch: have %+v", action, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: no change event", action)
+		}
+	}
+	proposal, err := wallet.Propose(common.Address{0xbb}, big.NewInt(1000), nil, 0)
+	if err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	expect("propose")
+
+	approve(t, wallet, proposal.ID, testOwners[0])
+	expect("approve")
+
+	tx := common.Hash{0x01}
+	if err := wallet.SetExecution(proposal.ID, &tx); err != nil {
+		t.Fatalf("failed to set execution: %v", err)
+	}
+	expect("execute")
+
+	if err := wallet.Discard(proposal.ID); err != nil {
+		t.Fatalf("failed to discard: %v", err)
+	}
+	expect("discard")
+
+	if _, err := wallet.Propose(common.Address{0xbb}, big.NewInt(1000), nil, 1); err != nil {
+		t.Fatalf("failed to propose: %v", err)
+	}
+	expect("propose")
+
+	if _, err := wallet.Prune(2); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	expect("prune")
+
+	// Noops must not be announced
+	if _, err := wallet.Prune(2); err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	select {
+	case event := <-sink:
+		t.Errorf("unexpected event: %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Tests that pruning discards the proposals made for used nonces, except those
+// whose execution awaits confirmation, and persists the outcome.
+func TestPrune(t *testing.T) {
+	dir, _, wallet := newTestWallet(t)
+	defer os.RemoveAll(dir)
+
+	var ids []common.Hash
+	for nonce := uint64(0); nonce < 4; nonce++ {
+		proposal, err := wallet.Propose(common.Address{0xbb}, big.NewInt(int64(nonce)), nil, nonce)
+		if err != nil {
+			t.Fatalf("failed to propose: %v", err)
+		}
+		ids = append(ids, proposal.ID)
+	}
+	tx := common.Hash{0x01}
+	if err := wallet.SetExecution(ids[1], &tx); err != nil {
+		t.Fatalf("failed to set execution: %v", err)
+	}
+	stale, err := wallet.Prune(3)
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if len(stale) != 2 || stale[0] != ids[0] || stale[1] != ids[2] {
+		t.Errorf("pruned proposals mismatch: have %x, want %x", stale, []common.Hash{ids[0], ids[2]})
+	}
+	backend, err := NewBackend(dir)
+	if err != nil {
+		t.Fatalf("failed to reload backend: %v", err)
+	}
+	reloaded, _ := backend.Find(wallet.config.Address)
+	proposals := reloaded.Proposals()
+	if len(proposals) != 2 || proposals[0].ID != ids[1] || proposals[1].ID != ids[3] {
+		t.Errorf("remaining proposals mismatch: have %d", len(proposals))
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package accounts
+
+import "github.com/megatilt/go-tilt/accounts/typeddata"
+
+// TypedDataSigner is an optional interface of wallets able to sign typed data
+// natively, e.g. hardware wallets displaying the individual fields to the user
+// instead of an opaque hash.
+type TypedDataSigner interface {
+	// SignTypedData requests the wallet to sign the hash of the given typed data.
+	// The returned signature is in the [R || S || V] format where V is 0 or 1.
+	SignTypedData(account Account, data *typeddata.TypedData) ([]byte, error)
+
+	// SignTypedDataWithPassphrase requests the wallet to sign the hash of the
+	// given typed data with the passphrase as extra authentication information.
+	SignTypedDataWithPassphrase(account Account, passphrase string, data *typeddata.TypedData) ([]byte, error)
+}
+
+// SignTypedData signs the typed data with the given account, natively if the
+// wallet supports it, or by signing its hash otherwise.
+func SignTypedData(wallet Wallet, account Account, data *typeddata.TypedData) ([]byte, error) {
+	if signer, ok := wallet.(TypedDataSigner); ok {
+		return signer.SignTypedData(account, data)
+	}
+	hash, err := data.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return wallet.SignHash(account, hash[:])
+}
+
+// SignTypedDataWithPassphrase signs the typed data with the given account and
+// passphrase, natively if the wallet supports it, or by signing its hash otherwise.
+func SignTypedDataWithPassphrase(wallet Wallet, account Account, passphrase string, data *typeddata.TypedData) ([]byte, error) {
+	if signer, ok := wallet.(TypedDataSigner); ok {
+		return signer.SignTypedDataWithPassphrase(account, passphrase, data)
+	}
+	hash, err := data.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return wallet.SignHashWithPassphrase(account, passphrase, hash[:])
+}
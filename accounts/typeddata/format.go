@@ -0,0 +1,147 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package typeddata
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/megatilt/go-tilt/common/hexutil"
+)
+
+// Field is a decoded field of a typed data message, meant to be displayed to
+// the user before signing. The value is a string for primitive types, a list of
+// fields for structs and a list of fields named after their index for arrays.
+type Field struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// Format decodes the domain and message of the typed data into a field tree,
+// validating all values along the way.
+func (td *TypedData) Format() ([]*Field, error) {
+	if err := td.Validate(); err != nil {
+		return nil, err
+	}
+	domain, err := td.formatData(DomainType, td.Domain)
+	if err != nil {
+		return nil, fmt.Errorf("domain: %v", err)
+	}
+	message, err := td.formatData(td.PrimaryType, td.Message)
+	if err != nil {
+		return nil, fmt.Errorf("message: %v", err)
+	}
+	return []*Field{
+		{Name: "domain", Type: DomainType, Value: domain},
+		{Name: "message", Type: td.PrimaryType, Value: message},
+	}, nil
+}
+
+// formatData decodes a value of the given struct type into its fields.
+func (td *TypedData) formatData(primaryType string, data map[string]interface{}) ([]*Field, error) {
+	var fields []*Field
+	for _, field := range td.Types[primaryType] {
+		value, ok := data[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("type %s: missing value for field %s", primaryType, field.Name)
+		}
+		formatted, err := td.formatValue(field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("type %s: field %s: %v", primaryType, field.Name, err)
+		}
+		fields = append(fields, &Field{Name: field.Name, Type: field.Type, Value: formatted})
+	}
+	return fields, nil
+}
+
+// formatValue decodes a single value of the given type into its displayable form.
+func (td *TypedData) formatValue(typ string, value interface{}) (interface{}, error) {
+	if elemType, _, ok := arrayType(typ); ok {
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid %s value %v", typ, value)
+		}
+		fields := make([]*Field, len(items))
+		for i, item := range items {
+			formatted, err := td.formatValue(elemType, item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %v", i, err)
+			}
+			fields[i] = &Field{Name: fmt.Sprintf("%d", i), Type: elemType, Value: formatted}
+		}
+		return fields, nil
+	}
+	if _, ok := td.Types[typ]; ok {
+		data, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid %s value %v", typ, value)
+		}
+		return td.formatData(typ, data)
+	}
+	// Primitive values are validated by encoding them, then rendered canonically
+	if _, err := encodePrimitive(typ, value); err != nil {
+		return nil, err
+	}
+	switch {
+	case typ == "address":
+		addr, _ := parseAddress(value)
+		return addr.Hex(), nil
+	case typ == "bytes":
+		blob, _ := parseBytes(value)
+		return hexutil.Encode(blob), nil
+	case typ == "string":
+		return value.(string), nil
+	case typ == "bool":
+		return fmt.Sprintf("%t", value.(bool)), nil
+	}
+	if _, ok := fixedBytesType(typ); ok {
+		blob, _ := parseBytes(value)
+		return hexutil.Encode(blob), nil
+	}
+	bits, signed, _ := integerType(typ)
+	num, _ := parseInteger(typ, bits, signed, value)
+	return num.String(), nil
+}
+
+// Pprint renders a field tree as indented text, one field per line.
+func Pprint(fields []*Field) string {
+	var buf bytes.Buffer
+	pprint(&buf, fields, 0)
+	return buf.String()
+}
+
+// pprint writes the fields into the buffer at the given indentation depth.
+// Strings are the only values not rendered canonically, so they are quoted and
+// escaped to keep control characters from forging or hiding prompt lines.
+func pprint(buf *bytes.Buffer, fields []*Field, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, field := range fields {
+		switch value := field.Value.(type) {
+		case []*Field:
+			fmt.Fprintf(buf, "%s%s [%s]\n", indent, field.Name, field.Type)
+			pprint(buf, value, depth+1)
+		default:
+			if field.Type == "string" {
+				fmt.Fprintf(buf, "%s%s [%s]: %q\n", indent, field.Name, field.Type, value)
+			} else {
+				fmt.Fprintf(buf, "%s%s [%s]: %v\n", indent, field.Name, field.Type, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,495 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package typeddata implements the hashing of typed structured data (EIP-712),
+// allowing users to sign domain separated messages whose fields can be shown to
+// them, instead of opaque byte blobs.
+//
+// The signed digest of a typed data message is:
+//
+//   keccak256("\x19\x01" ‖ hashStruct(domain) ‖ hashStruct(message))
+//
+// where hashStruct(s) = keccak256(typeHash ‖ encodeData(s)) and typeHash is the
+// hash of the struct's type encoding, e.g. "Mail(Person from,Person to)Person(...)".
+package typeddata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/common/math"
+	"github.com/megatilt/go-tilt/crypto"
+)
+
+// DomainType is the name of the struct type describing the signing domain.
+const DomainType = "EIP712Domain"
+
+// typeNameRegexp matches the valid names of struct types and their fields.
+var typeNameRegexp = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z_$0-9]*$`)
+
+// fieldTypeRegexp matches the valid field types: a type name followed by any
+// number of fixed or dynamic array suffixes.
+var fieldTypeRegexp = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z_$0-9]*(\[[0-9]*\])*$`)
+
+// errInvalidSignature is returned if a typed data signature can't be recovered.
+var errInvalidSignature = errors.New("invalid signature (must be 65 bytes with V of 27 or 28)")
+
+// Type is a named and typed field of a struct type.
+type Type struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Types maps the struct type names to their fields.
+type Types map[string][]Type
+
+// TypedData is a typed structured message to be hashed and signed, along with
+// the type definitions and the signing domain needed to do so.
+type TypedData struct {
+	Types       Types                  `json:"types"`
+	PrimaryType string                 `json:"primaryType"`
+	Domain      map[string]interface{} `json:"domain"`
+	Message     map[string]interface{} `json:"message"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding numbers as json.Number to
+// retain the precision of 256 bit integers.
+func (td *TypedData) UnmarshalJSON(input []byte) error {
+	type typedData TypedData
+
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.UseNumber()
+
+	var data typedData
+	if err := dec.Decode(&data); err != nil {
+		return err
+	}
+	*td = TypedData(data)
+	return nil
+}
+
+// Validate checks that the type definitions are well formed and that both the
+// domain and primary types are defined. Type and field names are restricted to
+// identifiers, so that they can be shown to the user verbatim.
+func (td *TypedData) Validate() error {
+	if _, ok := td.Types[DomainType]; !ok {
+		return fmt.Errorf("type %s is undefined", DomainType)
+	}
+	if _, ok := td.Types[td.PrimaryType]; !ok {
+		return fmt.Errorf("primary type %q is undefined", td.PrimaryType)
+	}
+	for name, fields := range td.Types {
+		if !typeNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid type name %q", name)
+		}
+		for _, field := range fields {
+			if field.Name == "" {
+				return fmt.Errorf("type %s: unnamed field", name)
+			}
+			if !typeNameRegexp.MatchString(field.Name) {
+				return fmt.Errorf("type %s: invalid field name %q", name, field.Name)
+			}
+			if !fieldTypeRegexp.MatchString(field.Type) {
+				return fmt.Errorf("type %s: field %s has invalid type %q", name, field.Name, field.Type)
+			}
+			base := baseType(field.Type)
+			if _, ok := td.Types[base]; !ok && !isPrimitive(base) {
+				return fmt.Errorf("type %s: field %s has unknown type %q", name, field.Name, field.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// Hash returns the digest to sign for the typed data, binding the message to
+// its signing domain.
+func (td *TypedData) Hash() (common.Hash, error) {
+	if err := td.Validate(); err != nil {
+		return common.Hash{}, err
+	}
+	domainSeparator, err := td.HashStruct(DomainType, td.Domain)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("domain: %v", err)
+	}
+	messageHash, err := td.HashStruct(td.PrimaryType, td.Message)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("message: %v", err)
+	}
+	raw := make([]byte, 0, 2+2*common.HashLength)
+	raw = append(raw, 0x19, 0x01)
+	raw = append(raw, domainSeparator[:]...)
+	raw = append(raw, messageHash[:]...)
+
+	return crypto.Keccak256Hash(raw), nil
+}
+
+// HashStruct computes hashStruct(data) = keccak256(typeHash ‖ encodeData(data))
+// for a value of the given struct type.
+func (td *TypedData) HashStruct(primaryType string, data map[string]interface{}) (common.Hash, error) {
+	enc, err := td.EncodeData(primaryType, data)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(enc), nil
+}
+
+// Dependencies returns the struct types the given type references, directly or
+// transitively, starting with the type itself.
+func (td *TypedData) Dependencies(primaryType string, found []string) []string {
+	primaryType = baseType(primaryType)
+	for _, dep := range found {
+		if dep == primaryType {
+			return found
+		}
+	}
+	if _, ok := td.Types[primaryType]; !ok {
+		return found
+	}
+	found = append(found, primaryType)
+	for _, field := range td.Types[primaryType] {
+		found = td.Dependencies(field.Type, found)
+	}
+	return found
+}
+
+// EncodeType returns the type encoding of a struct type: its own signature
+// followed by the signatures of all referenced struct types, sorted by name.
+//
+//   Mail(Person from,Person to,string contents)Person(string name,address wallet)
+func (td *TypedData) EncodeType(primaryType string) []byte {
+	deps := td.Dependencies(primaryType, nil)
+	if len(deps) > 1 {
+		sort.Strings(deps[1:])
+	}
+	var buf bytes.Buffer
+	for _, dep := range deps {
+		buf.WriteString(dep)
+		buf.WriteString("(")
+		for i, field := range td.Types[dep] {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString(field.Type)
+			buf.WriteString(" ")
+			buf.WriteString(field.Name)
+		}
+		buf.WriteString(")")
+	}
+	return buf.Bytes()
+}
+
+// TypeHash returns the hash of the type encoding of a struct type.
+func (td *TypedData) TypeHash(primaryType string) common.Hash {
+	return crypto.Keccak256Hash(td.EncodeType(primaryType))
+}
+
+// EncodeData returns the encoding of a value of the given struct type: its type
+// hash followed by the 32 byte encodings of each field, in definition order.
+// Data fields not defined by the type are rejected, so nothing can be signed
+// without being shown.
+func (td *TypedData) EncodeData(primaryType string, data map[string]interface{}) ([]byte, error) {
+	fields, ok := td.Types[primaryType]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q", primaryType)
+	}
+	if len(data) > len(fields) {
+		return nil, fmt.Errorf("type %s: extra data provided (%d fields, %d values)", primaryType, len(fields), len(data))
+	}
+	typeHash := td.TypeHash(primaryType)
+
+	buf := make([]byte, 0, (1+len(fields))*32)
+	buf = append(buf, typeHash[:]...)
+	for _, field := range fields {
+		value, ok := data[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("type %s: missing value for field %s", primaryType, field.Name)
+		}
+		enc, err := td.encodeValue(field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("type %s: field %s: %v", primaryType, field.Name, err)
+		}
+		buf = append(buf, enc...)
+	}
+	return buf, nil
+}
+
+// encodeValue returns the 32 byte encoding of a single value of the given type.
+// Arrays, structs and dynamic values are encoded as their hashes.
+func (td *TypedData) encodeValue(typ string, value interface{}) ([]byte, error) {
+	// Arrays are encoded as the hash of their concatenated element encodings
+	if elemType, size, ok := arrayType(typ); ok {
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid %s value %v", typ, value)
+		}
+		if size >= 0 && len(items) != size {
+			return nil, fmt.Errorf("invalid %s length: have %d, want %d", typ, len(items), size)
+		}
+		var buf []byte
+		for i, item := range items {
+			enc, err := td.encodeValue(elemType, item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %v", i, err)
+			}
+			buf = append(buf, enc...)
+		}
+		return crypto.Keccak256(buf), nil
+	}
+	// Structs are encoded as their hashStruct
+	if _, ok := td.Types[typ]; ok {
+		data, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid %s value %v", typ, value)
+		}
+		hash, err := td.HashStruct(typ, data)
+		if err != nil {
+			return nil, err
+		}
+		return hash[:], nil
+	}
+	return encodePrimitive(typ, value)
+}
+
+// encodePrimitive returns the 32 byte encoding of an atomic or dynamic value.
+func encodePrimitive(typ string, value interface{}) ([]byte, error) {
+	switch typ {
+	case "address":
+		addr, err := parseAddress(value)
+		if err != nil {
+			return nil, err
+		}
+		return common.LeftPadBytes(addr[:], 32), nil
+
+	case "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid bool value %v", value)
+		}
+		enc := make([]byte, 32)
+		if b {
+			enc[31] = 1
+		}
+		return enc, nil
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid string value %v", value)
+		}
+		return crypto.Keccak256([]byte(str)), nil
+
+	case "bytes":
+		blob, err := parseBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.Keccak256(blob), nil
+	}
+	if size, ok := fixedBytesType(typ); ok {
+		blob, err := parseBytes(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(blob) != size {
+			return nil, fmt.Errorf("invalid %s length: have %d", typ, len(blob))
+		}
+		return common.RightPadBytes(blob, 32), nil
+	}
+	if bits, signed, ok := integerType(typ); ok {
+		num, err := parseInteger(typ, bits, signed, value)
+		if err != nil {
+			return nil, err
+		}
+		return math.PaddedBigBytes(math.U256(num), 32), nil
+	}
+	return nil, fmt.Errorf("unknown type %q", typ)
+}
+
+// isPrimitive returns whether the type name is an atomic or dynamic type.
+func isPrimitive(typ string) bool {
+	switch typ {
+	case "address", "bool", "string", "bytes":
+		return true
+	}
+	if _, ok := fixedBytesType(typ); ok {
+		return true
+	}
+	_, _, ok := integerType(typ)
+	return ok
+}
+
+// baseType strips all array suffixes from a type name.
+func baseType(typ string) string {
+	if i := strings.Index(typ, "["); i >= 0 {
+		return typ[:i]
+	}
+	return typ
+}
+
+// arrayType splits an array type into its element type and length, the latter
+// being -1 for dynamic arrays.
+func arrayType(typ string) (string, int, bool) {
+	if !strings.HasSuffix(typ, "]") {
+		return "", 0, false
+	}
+	i := strings.LastIndex(typ, "[")
+	if i < 0 {
+		return "", 0, false
+	}
+	if typ[i+1:len(typ)-1] == "" {
+		return typ[:i], -1, true
+	}
+	size, err := strconv.Atoi(typ[i+1 : len(typ)-1])
+	if err != nil || size < 0 {
+		return "", 0, false
+	}
+	return typ[:i], size, true
+}
+
+// fixedBytesType returns the size of a bytes1 to bytes32 type.
+func fixedBytesType(typ string) (int, bool) {
+	if !strings.HasPrefix(typ, "bytes") {
+		return 0, false
+	}
+	size, err := strconv.Atoi(typ[5:])
+	if err != nil || size < 1 || size > 32 {
+		return 0, false
+	}
+	return size, true
+}
+
+// integerType returns the bit size and signedness of an int8 to uint256 type.
+func integerType(typ string) (int, bool, bool) {
+	signed := true
+	switch {
+	case strings.HasPrefix(typ, "uint"):
+		typ, signed = typ[4:], false
+	case strings.HasPrefix(typ, "int"):
+		typ = typ[3:]
+	default:
+		return 0, false, false
+	}
+	bits, err := strconv.Atoi(typ)
+	if err != nil || bits < 8 || bits > 256 || bits%8 != 0 {
+		return 0, false, false
+	}
+	return bits, signed, true
+}
+
+// parseAddress converts a hex string into an address.
+func parseAddress(value interface{}) (common.Address, error) {
+	switch v := value.(type) {
+	case common.Address:
+		return v, nil
+	case string:
+		if !common.IsHexAddress(v) {
+			return common.Address{}, fmt.Errorf("invalid address %q", v)
+		}
+		return common.HexToAddress(v), nil
+	}
+	return common.Address{}, fmt.Errorf("invalid address value %v", value)
+}
+
+// parseBytes converts a hex string into a byte slice.
+func parseBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case hexutil.Bytes:
+		return v, nil
+	case string:
+		return hexutil.Decode(v)
+	}
+	return nil, fmt.Errorf("invalid bytes value %v", value)
+}
+
+// parseInteger converts a JSON number or a decimal or hex string into an integer
+// of the given type, checking that it fits.
+func parseInteger(typ string, bits int, signed bool, value interface{}) (*big.Int, error) {
+	var (
+		num *big.Int
+		ok  bool
+	)
+	switch v := value.(type) {
+	case *big.Int:
+		if v != nil {
+			num, ok = new(big.Int).Set(v), true
+		}
+	case json.Number:
+		num, ok = math.ParseBig256(string(v))
+	case string:
+		num, ok = math.ParseBig256(v)
+		if !ok && strings.HasPrefix(v, "-") {
+			if num, ok = math.ParseBig256(v[1:]); ok {
+				num.Neg(num)
+			}
+		}
+	case float64:
+		if v == float64(int64(v)) && v <= 1<<53 && v >= -(1<<53) {
+			num, ok = big.NewInt(int64(v)), true
+		}
+	case int:
+		num, ok = big.NewInt(int64(v)), true
+	case int64:
+		num, ok = big.NewInt(v), true
+	case uint64:
+		num, ok = new(big.Int).SetUint64(v), true
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid %s value %v", typ, value)
+	}
+	// Ensure the number fits into the requested type
+	if signed {
+		limit := new(big.Int).Lsh(common.Big1, uint(bits-1))
+		if num.Cmp(limit) >= 0 || num.Cmp(new(big.Int).Neg(limit)) < 0 {
+			return nil, fmt.Errorf("%s overflow: %v", typ, num)
+		}
+	} else if num.Sign() < 0 || num.BitLen() > bits {
+		return nil, fmt.Errorf("%s overflow: %v", typ, num)
+	}
+	return num, nil
+}
+
+// RecoverAddress returns the address of the account that signed the typed data.
+// The signature must conform to the secp256k1 curve R, S and V values, where the
+// V value must be 27 or 28 for legacy reasons.
+func RecoverAddress(td *TypedData, sig []byte) (common.Address, error) {
+	if len(sig) != 65 || (sig[64] != 27 && sig[64] != 28) {
+		return common.Address{}, errInvalidSignature
+	}
+	hash, err := td.Hash()
+	if err != nil {
+		return common.Address{}, err
+	}
+	cpy := make([]byte, 65)
+	copy(cpy, sig)
+	cpy[64] -= 27 // Transform yellow paper V from 27/28 to 0/1
+
+	rpk, err := crypto.Ecrecover(hash[:], cpy)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*crypto.ToECDSAPub(rpk)), nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package typeddata
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/crypto"
+)
+
+// mailTypedData is the example message of the EIP-712 spec.
+const mailTypedData = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
+
+// groupTypedData is a message with arrays of primitives and of nested structs.
+const groupTypedData = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Group": [
+			{"name": "name", "type": "string"},
+			{"name": "members", "type": "Person[]"},
+			{"name": "scores", "type": "uint8[2]"}
+		]
+	},
+	"primaryType": "Group",
+	"domain": {"name": "Groups"},
+	"message": {
+		"name": "Herd",
+		"members": [
+			{"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+			{"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"}
+		],
+		"scores": [1, "0x02"]
+	}
+}`
+
+// decodeTypedData decodes a typed data message, failing the test on error.
+func decodeTypedData(t *testing.T, input string) *TypedData {
+	data := new(TypedData)
+	if err := json.Unmarshal([]byte(input), data); err != nil {
+		t.Fatalf("failed to decode typed data: %v", err)
+	}
+	return data
+}
+
+// Tests the intermediate and final hashes of the EIP-712 spec example, as well
+// as its signature by the key keccak256("cow").
+func TestMailVector(t *testing.T) {
+	data := decodeTypedData(t, mailTypedData)
+
+	if have, want := string(data.EncodeType("Mail")), "Mail(Person from,Person to,string contents)Person(string name,address wallet)"; have != want {
+		t.Errorf("type encoding mismatch: have %s, want %s", have, want)
+	}
+	if have, want := data.TypeHash("Mail"), common.HexToHash("0xa0cedeb2dc280ba39b857546d74f5549c3a1d7bdc2dd96bf881f76108e23dac2"); have != want {
+		t.Errorf("type hash mismatch: have %x, want %x", have, want)
+	}
+	domain, err := data.HashStruct(DomainType, data.Domain)
+	if err != nil {
+		t.Fatalf("failed to hash domain: %v", err)
+	}
+	if want := common.HexToHash("0xf2cee375fa42b42143804025fc449deafd50cc031ca257e0b194a650a912090f"); domain != want {
+		t.Errorf("domain separator mismatch: have %x, want %x", domain, want)
+	}
+	message, err := data.HashStruct(data.PrimaryType, data.Message)
+	if err != nil {
+		t.Fatalf("failed to hash message: %v", err)
+	}
+	if want := common.HexToHash("0xc52c0ee5d84264471806290a3f2c4cecfc5490626bf912d01f240d7a274b371e"); message != want {
+		t.Errorf("message hash mismatch: have %x, want %x", message, want)
+	}
+	hash, err := data.Hash()
+	if err != nil {
+		t.Fatalf("failed to hash typed data: %v", err)
+	}
+	if want := common.HexToHash("0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2"); hash != want {
+		t.Errorf("digest mismatch: have %x, want %x", hash, want)
+	}
+	key := crypto.ToECDSA(crypto.Keccak256([]byte("cow")))
+	sig, err := crypto.Sign(hash[:], key)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sig[64] += 27
+	if have, want := hexutil.Encode(sig), "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c"; have != want {
+		t.Errorf("signature mismatch: have %s, want %s", have, want)
+	}
+	addr, err := RecoverAddress(data, sig)
+	if err != nil {
+		t.Fatalf("failed to recover signer: %v", err)
+	}
+	if want := common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"); addr != want {
+		t.Errorf("signer mismatch: have %x, want %x", addr, want)
+	}
+}
+
+// Tests that arrays of primitives and of nested structs are hashed as the hash
+// of their concatenated element encodings, structs being encoded as hashStruct.
+func TestArrayVector(t *testing.T) {
+	data := decodeTypedData(t, groupTypedData)
+
+	if have, want := string(data.EncodeType("Group")), "Group(string name,Person[] members,uint8[2] scores)Person(string name,address wallet)"; have != want {
+		t.Errorf("type encoding mismatch: have %s, want %s", have, want)
+	}
+	// Assemble the expected message hash by hand
+	person := func(name string, wallet string) []byte {
+		typeHash := crypto.Keccak256([]byte("Person(string name,address wallet)"))
+		return crypto.Keccak256(typeHash, crypto.Keccak256([]byte(name)), common.LeftPadBytes(common.HexToAddress(wallet).Bytes(), 32))
+	}
+	members := crypto.Keccak256(
+		person("Cow", "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"),
+		person("Bob", "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"),
+	)
+	scores := crypto.Keccak256(common.LeftPadBytes([]byte{1}, 32), common.LeftPadBytes([]byte{2}, 32))
+	want := crypto.Keccak256Hash(
+		crypto.Keccak256([]byte("Group(string name,Person[] members,uint8[2] scores)Person(string name,address wallet)")),
+		crypto.Keccak256([]byte("Herd")),
+		members,
+		scores,
+	)
+	have, err := data.HashStruct(data.PrimaryType, data.Message)
+	if err != nil {
+		t.Fatalf("failed to hash message: %v", err)
+	}
+	if have != want {
+		t.Errorf("message hash mismatch: have %x, want %x", have, want)
+	}
+	// Fixed size arrays must have exactly the declared length
+	data.Message["scores"] = []interface{}{json.Number("1")}
+	if _, err := data.HashStruct(data.PrimaryType, data.Message); err == nil {
+		t.Errorf("short fixed size array accepted")
+	}
+}
+
+// Tests that the field tree shown to the user covers nested structs and arrays,
+// and that strings are quoted so control characters can't forge prompt lines.
+func TestPprint(t *testing.T) {
+	data := decodeTypedData(t, groupTypedData)
+	data.Message["name"] = "Herd\nmessage [Group]\n  name [string]: Fake"
+
+	fields, err := data.Format()
+	if err != nil {
+		t.Fatalf("failed to format typed data: %v", err)
+	}
+	want := `domain [EIP712Domain]
+  name [string]: "Groups"
+message [Group]
+  name [string]: "Herd\nmessage [Group]\n  name [string]: Fake"
+  members [Person[]]
+    0 [Person]
+      name [string]: "Cow"
+      wallet [address]: 0xcd2a3d9f938e13cd947ec05abc7fe734df8dd826
+    1 [Person]
+      name [string]: "Bob"
+      wallet [address]: 0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb
+  scores [uint8[2]]
+    0 [uint8]: 1
+    1 [uint8]: 2
+`
+	if have := Pprint(fields); have != want {
+		t.Errorf("rendering mismatch:\nhave:\n%s\nwant:\n%s", have, want)
+	}
+}
+
+// Tests that type definitions with names or types that can't be shown verbatim
+// are rejected.
+func TestValidateNames(t *testing.T) {
+	tests := []Type{
+		{Name: "na\nme", Type: "string"},
+		{Name: "name ", Type: "string"},
+		{Name: "1name", Type: "string"},
+		{Name: "name", Type: "string\n"},
+		{Name: "name", Type: "Person[\n]"},
+		{Name: "name", Type: "string[2"},
+	}
+	for _, tt := range tests {
+		data := decodeTypedData(t, mailTypedData)
+		data.Types["Person"] = []Type{tt, {Name: "wallet", Type: "address"}}
+		if err := data.Validate(); err == nil {
+			t.Errorf("field %q of type %q accepted", tt.Name, tt.Type)
+		}
+	}
+	data := decodeTypedData(t, mailTypedData)
+	data.Types["Per\nson"] = data.Types["Person"]
+	if err := data.Validate(); err == nil || !strings.Contains(err.Error(), "invalid type name") {
+		t.Errorf("invalid type name error mismatch: have %v", err)
+	}
+}
@@ -0,0 +1,649 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package trezor contains the wire protocol messages used to communicate with
+// Trezor hardware wallets, along with a minimal protocol buffer codec for them.
+//
+// The message definitions mirror the relevant subset of the protobuf schemas in
+// the Trezor common repository:
+// https://github.com/trezor/trezor-common/blob/master/protob/messages.proto
+package trezor
+
+import "fmt"
+
+// MessageType is the identifier of a Trezor message on the wire.
+type MessageType uint16
+
+// Message types used by the Tiltnet wallet integration.
+const (
+	MessageType_Initialize        MessageType = 0
+	MessageType_Ping              MessageType = 1
+	MessageType_Success           MessageType = 2
+	MessageType_Failure           MessageType = 3
+	MessageType_Features          MessageType = 17
+	MessageType_PinMatrixRequest  MessageType = 18
+	MessageType_PinMatrixAck      MessageType = 19
+	MessageType_ButtonRequest     MessageType = 26
+	MessageType_ButtonAck         MessageType = 27
+	MessageType_PassphraseRequest MessageType = 41
+	MessageType_PassphraseAck     MessageType = 42
+	MessageType_TiltnetGetAddress MessageType = 56
+	MessageType_TiltnetAddress    MessageType = 57
+	MessageType_TiltnetSignTx     MessageType = 58
+	MessageType_TiltnetTxRequest  MessageType = 59
+	MessageType_TiltnetTxAck      MessageType = 60
+)
+
+// messageNames maps the known message types to their human readable names.
+var messageNames = map[MessageType]string{
+	MessageType_Initialize:        "Initialize",
+	MessageType_Ping:              "Ping",
+	MessageType_Success:           "Success",
+	MessageType_Failure:           "Failure",
+	MessageType_Features:          "Features",
+	MessageType_PinMatrixRequest:  "PinMatrixRequest",
+	MessageType_PinMatrixAck:      "PinMatrixAck",
+	MessageType_ButtonRequest:     "ButtonRequest",
+	MessageType_ButtonAck:         "ButtonAck",
+	MessageType_PassphraseRequest: "PassphraseRequest",
+	MessageType_PassphraseAck:     "PassphraseAck",
+	MessageType_TiltnetGetAddress: "TiltnetGetAddress",
+	MessageType_TiltnetAddress:    "TiltnetAddress",
+	MessageType_TiltnetSignTx:     "TiltnetSignTx",
+	MessageType_TiltnetTxRequest:  "TiltnetTxRequest",
+	MessageType_TiltnetTxAck:      "TiltnetTxAck",
+}
+
+// String implements fmt.Stringer, returning the name of the message type.
+func (t MessageType) String() string {
+	if name, ok := messageNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("MessageType(%d)", uint16(t))
+}
+
+// Failure types reported by the device in Failure messages.
+const (
+	FailureUnexpectedMessage = 1
+	FailureButtonExpected    = 2
+	FailureDataError         = 3
+	FailureActionCancelled   = 4
+	FailurePinExpected       = 5
+	FailurePinCancelled      = 6
+	FailurePinInvalid        = 7
+	FailureInvalidSignature  = 8
+	FailureProcessError      = 9
+	FailureNotEnoughFunds    = 10
+	FailureNotInitialized    = 11
+	FailurePinMismatch       = 12
+	FailureFirmwareError     = 99
+)
+
+// Message is a Trezor wire protocol message that can be serialized into and
+// parsed from its protocol buffer encoding.
+type Message interface {
+	// Type returns the identifier of the message on the wire.
+	Type() MessageType
+
+	// Marshal serializes the message into its protocol buffer encoding.
+	Marshal() []byte
+
+	// Unmarshal parses the protocol buffer encoding of the message, skipping any
+	// unknown fields.
+	Unmarshal(data []byte) error
+}
+
+// Initialize requests the device to reset its session and report its features.
+type Initialize struct{}
+
+// Type implements Message.
+func (m *Initialize) Type() MessageType { return MessageType_Initialize }
+
+// Marshal implements Message.
+func (m *Initialize) Marshal() []byte {
+	return nil
+}
+
+// Unmarshal implements Message.
+func (m *Initialize) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for d.next() {
+	}
+	return d.err
+}
+
+// Ping tests the connectivity to the device, optionally running it through the
+// requested protections.
+type Ping struct {
+	Message              *string // Message to send back in the Success reply
+	ButtonProtection     *bool   // Ask for a button press before replying
+	PinProtection        *bool   // Ask for the PIN before replying
+	PassphraseProtection *bool   // Ask for the passphrase before replying
+}
+
+// Type implements Message.
+func (m *Ping) Type() MessageType { return MessageType_Ping }
+
+// Marshal implements Message.
+func (m *Ping) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Message)
+	e.bool(2, m.ButtonProtection)
+	e.bool(3, m.PinProtection)
+	e.bool(4, m.PassphraseProtection)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *Ping) Unmarshal(data []byte) error {
+	*m = Ping{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Message = d.string()
+		case 2:
+			m.ButtonProtection = d.bool()
+		case 3:
+			m.PinProtection = d.bool()
+		case 4:
+			m.PassphraseProtection = d.bool()
+		}
+	}
+	return d.err
+}
+
+// Success is the generic reply of the device for a successful request.
+type Success struct {
+	Message *string // Optional human readable message
+}
+
+// Type implements Message.
+func (m *Success) Type() MessageType { return MessageType_Success }
+
+// Marshal implements Message.
+func (m *Success) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Message)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *Success) Unmarshal(data []byte) error {
+	*m = Success{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Message = d.string()
+		}
+	}
+	return d.err
+}
+
+// Failure is the generic reply of the device for a failed request.
+type Failure struct {
+	Code    *uint32 // Failure type, see the Failure* constants
+	Message *string // Human readable failure description
+}
+
+// Type implements Message.
+func (m *Failure) Type() MessageType { return MessageType_Failure }
+
+// Marshal implements Message.
+func (m *Failure) Marshal() []byte {
+	var e encoder
+	e.uint32(1, m.Code)
+	e.string(2, m.Message)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *Failure) Unmarshal(data []byte) error {
+	*m = Failure{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Code = d.uint32()
+		case 2:
+			m.Message = d.string()
+		}
+	}
+	return d.err
+}
+
+// Features reports the capabilities and configuration of the device.
+type Features struct {
+	Vendor               *string // Name of the manufacturer
+	MajorVersion         *uint32 // Major version of the firmware
+	MinorVersion         *uint32 // Minor version of the firmware
+	PatchVersion         *uint32 // Patch version of the firmware
+	DeviceId             *string // Unique identifier of the device
+	PinProtection        *bool   // Whether the device is protected by a PIN
+	PassphraseProtection *bool   // Whether the device is protected by a passphrase
+	Label                *string // User label of the device
+	Initialized          *bool   // Whether the device holds a seed
+}
+
+// Type implements Message.
+func (m *Features) Type() MessageType { return MessageType_Features }
+
+// Marshal implements Message.
+func (m *Features) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Vendor)
+	e.uint32(2, m.MajorVersion)
+	e.uint32(3, m.MinorVersion)
+	e.uint32(4, m.PatchVersion)
+	e.string(6, m.DeviceId)
+	e.bool(7, m.PinProtection)
+	e.bool(8, m.PassphraseProtection)
+	e.string(10, m.Label)
+	e.bool(12, m.Initialized)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *Features) Unmarshal(data []byte) error {
+	*m = Features{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Vendor = d.string()
+		case 2:
+			m.MajorVersion = d.uint32()
+		case 3:
+			m.MinorVersion = d.uint32()
+		case 4:
+			m.PatchVersion = d.uint32()
+		case 6:
+			m.DeviceId = d.string()
+		case 7:
+			m.PinProtection = d.bool()
+		case 8:
+			m.PassphraseProtection = d.bool()
+		case 10:
+			m.Label = d.string()
+		case 12:
+			m.Initialized = d.bool()
+		}
+	}
+	return d.err
+}
+
+// PinMatrixRequest asks for the PIN, entered as positions of the randomized
+// matrix displayed on the device.
+type PinMatrixRequest struct {
+	Kind *uint32 // Kind of PIN being requested
+}
+
+// Type implements Message.
+func (m *PinMatrixRequest) Type() MessageType { return MessageType_PinMatrixRequest }
+
+// Marshal implements Message.
+func (m *PinMatrixRequest) Marshal() []byte {
+	var e encoder
+	e.uint32(1, m.Kind)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *PinMatrixRequest) Unmarshal(data []byte) error {
+	*m = PinMatrixRequest{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Kind = d.uint32()
+		}
+	}
+	return d.err
+}
+
+// PinMatrixAck sends the scrambled PIN to the device.
+type PinMatrixAck struct {
+	Pin *string // PIN digits as matrix positions
+}
+
+// Type implements Message.
+func (m *PinMatrixAck) Type() MessageType { return MessageType_PinMatrixAck }
+
+// Marshal implements Message.
+func (m *PinMatrixAck) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Pin)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *PinMatrixAck) Unmarshal(data []byte) error {
+	*m = PinMatrixAck{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Pin = d.string()
+		}
+	}
+	return d.err
+}
+
+// ButtonRequest notifies that the device waits for a user confirmation.
+type ButtonRequest struct {
+	Code *uint32 // Kind of confirmation being requested
+	Data *string // Optional data associated with the request
+}
+
+// Type implements Message.
+func (m *ButtonRequest) Type() MessageType { return MessageType_ButtonRequest }
+
+// Marshal implements Message.
+func (m *ButtonRequest) Marshal() []byte {
+	var e encoder
+	e.uint32(1, m.Code)
+	e.string(2, m.Data)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *ButtonRequest) Unmarshal(data []byte) error {
+	*m = ButtonRequest{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Code = d.uint32()
+		case 2:
+			m.Data = d.string()
+		}
+	}
+	return d.err
+}
+
+// ButtonAck lets the device proceed with waiting for the user confirmation.
+type ButtonAck struct{}
+
+// Type implements Message.
+func (m *ButtonAck) Type() MessageType { return MessageType_ButtonAck }
+
+// Marshal implements Message.
+func (m *ButtonAck) Marshal() []byte {
+	return nil
+}
+
+// Unmarshal implements Message.
+func (m *ButtonAck) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for d.next() {
+	}
+	return d.err
+}
+
+// PassphraseRequest asks for the passphrase protecting the seed.
+type PassphraseRequest struct{}
+
+// Type implements Message.
+func (m *PassphraseRequest) Type() MessageType { return MessageType_PassphraseRequest }
+
+// Marshal implements Message.
+func (m *PassphraseRequest) Marshal() []byte {
+	return nil
+}
+
+// Unmarshal implements Message.
+func (m *PassphraseRequest) Unmarshal(data []byte) error {
+	d := decoder{buf: data}
+	for d.next() {
+	}
+	return d.err
+}
+
+// PassphraseAck sends the passphrase to the device.
+type PassphraseAck struct {
+	Passphrase *string // Passphrase to derive the seed with
+}
+
+// Type implements Message.
+func (m *PassphraseAck) Type() MessageType { return MessageType_PassphraseAck }
+
+// Marshal implements Message.
+func (m *PassphraseAck) Marshal() []byte {
+	var e encoder
+	e.string(1, m.Passphrase)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *PassphraseAck) Unmarshal(data []byte) error {
+	*m = PassphraseAck{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Passphrase = d.string()
+		}
+	}
+	return d.err
+}
+
+// TiltnetGetAddress requests the Tiltnet address at a derivation path.
+type TiltnetGetAddress struct {
+	AddressN    []uint32 // BIP-32 derivation path
+	ShowDisplay *bool    // Whether to show the address on the device
+}
+
+// Type implements Message.
+func (m *TiltnetGetAddress) Type() MessageType { return MessageType_TiltnetGetAddress }
+
+// Marshal implements Message.
+func (m *TiltnetGetAddress) Marshal() []byte {
+	var e encoder
+	e.repeated(1, m.AddressN)
+	e.bool(2, m.ShowDisplay)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *TiltnetGetAddress) Unmarshal(data []byte) error {
+	*m = TiltnetGetAddress{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			if v := d.uint32(); v != nil {
+				m.AddressN = append(m.AddressN, *v)
+			}
+		case 2:
+			m.ShowDisplay = d.bool()
+		}
+	}
+	return d.err
+}
+
+// TiltnetAddress reports the Tiltnet address at a derivation path.
+type TiltnetAddress struct {
+	Address []byte // 20 byte Tiltnet address
+}
+
+// Type implements Message.
+func (m *TiltnetAddress) Type() MessageType { return MessageType_TiltnetAddress }
+
+// Marshal implements Message.
+func (m *TiltnetAddress) Marshal() []byte {
+	var e encoder
+	e.bytes(1, m.Address)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *TiltnetAddress) Unmarshal(data []byte) error {
+	*m = TiltnetAddress{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.Address = d.bytes()
+		}
+	}
+	return d.err
+}
+
+// TiltnetSignTx requests the device to sign a transaction. Data longer than
+// the initial chunk is streamed in via TiltnetTxAck messages.
+type TiltnetSignTx struct {
+	AddressN         []uint32 // BIP-32 derivation path of the signing key
+	Nonce            []byte   // Big endian transaction nonce
+	GasPrice         []byte   // Big endian gas price
+	GasLimit         []byte   // Big endian gas limit
+	To               []byte   // Recipient address, empty for contract creation
+	Value            []byte   // Big endian transferred value
+	DataInitialChunk []byte   // First chunk of the transaction data (at most 1024 bytes)
+	DataLength       *uint32  // Total length of the transaction data
+	ChainId          *uint32  // Chain identifier for EIP-155 signing
+}
+
+// Type implements Message.
+func (m *TiltnetSignTx) Type() MessageType { return MessageType_TiltnetSignTx }
+
+// Marshal implements Message.
+func (m *TiltnetSignTx) Marshal() []byte {
+	var e encoder
+	e.repeated(1, m.AddressN)
+	e.bytes(2, m.Nonce)
+	e.bytes(3, m.GasPrice)
+	e.bytes(4, m.GasLimit)
+	e.bytes(5, m.To)
+	e.bytes(6, m.Value)
+	e.bytes(7, m.DataInitialChunk)
+	e.uint32(8, m.DataLength)
+	e.uint32(9, m.ChainId)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *TiltnetSignTx) Unmarshal(data []byte) error {
+	*m = TiltnetSignTx{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			if v := d.uint32(); v != nil {
+				m.AddressN = append(m.AddressN, *v)
+			}
+		case 2:
+			m.Nonce = d.bytes()
+		case 3:
+			m.GasPrice = d.bytes()
+		case 4:
+			m.GasLimit = d.bytes()
+		case 5:
+			m.To = d.bytes()
+		case 6:
+			m.Value = d.bytes()
+		case 7:
+			m.DataInitialChunk = d.bytes()
+		case 8:
+			m.DataLength = d.uint32()
+		case 9:
+			m.ChainId = d.uint32()
+		}
+	}
+	return d.err
+}
+
+// TiltnetTxRequest either requests the next chunk of transaction data, or
+// reports the signature once all of it was received.
+type TiltnetTxRequest struct {
+	DataLength *uint32 // Length of the next data chunk requested
+	SignatureV *uint32 // Signature recovery identifier
+	SignatureR []byte  // Signature R component
+	SignatureS []byte  // Signature S component
+}
+
+// Type implements Message.
+func (m *TiltnetTxRequest) Type() MessageType { return MessageType_TiltnetTxRequest }
+
+// Marshal implements Message.
+func (m *TiltnetTxRequest) Marshal() []byte {
+	var e encoder
+	e.uint32(1, m.DataLength)
+	e.uint32(2, m.SignatureV)
+	e.bytes(3, m.SignatureR)
+	e.bytes(4, m.SignatureS)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *TiltnetTxRequest) Unmarshal(data []byte) error {
+	*m = TiltnetTxRequest{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.DataLength = d.uint32()
+		case 2:
+			m.SignatureV = d.uint32()
+		case 3:
+			m.SignatureR = d.bytes()
+		case 4:
+			m.SignatureS = d.bytes()
+		}
+	}
+	return d.err
+}
+
+// TiltnetTxAck sends the next chunk of transaction data to the device.
+type TiltnetTxAck struct {
+	DataChunk []byte // Next chunk of the transaction data
+}
+
+// Type implements Message.
+func (m *TiltnetTxAck) Type() MessageType { return MessageType_TiltnetTxAck }
+
+// Marshal implements Message.
+func (m *TiltnetTxAck) Marshal() []byte {
+	var e encoder
+	e.bytes(1, m.DataChunk)
+	return e.buf
+}
+
+// Unmarshal implements Message.
+func (m *TiltnetTxAck) Unmarshal(data []byte) error {
+	*m = TiltnetTxAck{}
+
+	d := decoder{buf: data}
+	for d.next() {
+		switch d.field {
+		case 1:
+			m.DataChunk = d.bytes()
+		}
+	}
+	return d.err
+}
@@ -0,0 +1,207 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package trezor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protocol buffer wire types used by the Trezor messages.
+const (
+	wireVarint = 0 // int32, int64, uint32, uint64, bool, enum
+	wire64bit  = 1 // fixed64, sfixed64, double
+	wireBytes  = 2 // string, bytes, embedded messages, packed repeated fields
+	wire32bit  = 5 // fixed32, sfixed32, float
+)
+
+// errTruncated is returned if a protocol buffer message ends in the middle of a
+// field.
+var errTruncated = errors.New("truncated message")
+
+// appendUvarint appends the varint encoding of a number to a byte slice.
+func appendUvarint(buf []byte, value uint64) []byte {
+	var enc [binary.MaxVarintLen64]byte
+	return append(buf, enc[:binary.PutUvarint(enc[:], value)]...)
+}
+
+// encoder accumulates the serialized fields of a protocol buffer message. Fields
+// are only emitted if they are set, mirroring the optional proto2 semantics.
+type encoder struct {
+	buf []byte
+}
+
+// key appends a field key made of the field number and wire type.
+func (e *encoder) key(field int, wire int) {
+	e.buf = appendUvarint(e.buf, uint64(field)<<3|uint64(wire))
+}
+
+// uint32 appends an optional varint field.
+func (e *encoder) uint32(field int, value *uint32) {
+	if value != nil {
+		e.key(field, wireVarint)
+		e.buf = appendUvarint(e.buf, uint64(*value))
+	}
+}
+
+// bool appends an optional boolean field.
+func (e *encoder) bool(field int, value *bool) {
+	if value != nil {
+		e.key(field, wireVarint)
+		if *value {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	}
+}
+
+// bytes appends an optional length delimited field.
+func (e *encoder) bytes(field int, value []byte) {
+	if value != nil {
+		e.key(field, wireBytes)
+		e.buf = appendUvarint(e.buf, uint64(len(value)))
+		e.buf = append(e.buf, value...)
+	}
+}
+
+// string appends an optional string field.
+func (e *encoder) string(field int, value *string) {
+	if value != nil {
+		e.bytes(field, []byte(*value))
+	}
+}
+
+// repeated appends a non-packed repeated varint field.
+func (e *encoder) repeated(field int, values []uint32) {
+	for _, value := range values {
+		e.key(field, wireVarint)
+		e.buf = appendUvarint(e.buf, uint64(value))
+	}
+}
+
+// decoder iterates over the fields of a serialized protocol buffer message.
+type decoder struct {
+	buf []byte
+
+	field int    // Number of the field last read
+	wire  int    // Wire type of the field last read
+	num   uint64 // Value of the field last read if it was a number
+	data  []byte // Value of the field last read if it was length delimited
+	err   error  // Any error encountered while decoding
+}
+
+// next reads the next field from the message, returning false if the message is
+// exhausted or malformed.
+func (d *decoder) next() bool {
+	if d.err != nil || len(d.buf) == 0 {
+		return false
+	}
+	key, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return false
+	}
+	d.buf = d.buf[n:]
+	d.field, d.wire = int(key>>3), int(key&7)
+
+	switch d.wire {
+	case wireVarint:
+		if d.num, n = binary.Uvarint(d.buf); n <= 0 {
+			d.err = errTruncated
+			return false
+		}
+		d.buf = d.buf[n:]
+
+	case wire64bit, wire32bit:
+		size := 8
+		if d.wire == wire32bit {
+			size = 4
+		}
+		if len(d.buf) < size {
+			d.err = errTruncated
+			return false
+		}
+		d.buf = d.buf[size:]
+
+	case wireBytes:
+		size, n := binary.Uvarint(d.buf)
+		if n <= 0 || uint64(len(d.buf)-n) < size {
+			d.err = errTruncated
+			return false
+		}
+		d.data, d.buf = d.buf[n:n+int(size)], d.buf[n+int(size):]
+
+	default:
+		d.err = fmt.Errorf("unsupported wire type %d", d.wire)
+		return false
+	}
+	return true
+}
+
+// uint32 returns the current field as a varint, if it has the correct wire type.
+func (d *decoder) uint32() *uint32 {
+	if d.wire != wireVarint {
+		d.err = fmt.Errorf("field %d: wire type mismatch", d.field)
+		return nil
+	}
+	value := uint32(d.num)
+	return &value
+}
+
+// bool returns the current field as a boolean, if it has the correct wire type.
+func (d *decoder) bool() *bool {
+	if d.wire != wireVarint {
+		d.err = fmt.Errorf("field %d: wire type mismatch", d.field)
+		return nil
+	}
+	value := d.num != 0
+	return &value
+}
+
+// bytes returns a copy of the current field as a byte slice, if it has the
+// correct wire type.
+func (d *decoder) bytes() []byte {
+	if d.wire != wireBytes {
+		d.err = fmt.Errorf("field %d: wire type mismatch", d.field)
+		return nil
+	}
+	return append([]byte{}, d.data...)
+}
+
+// string returns the current field as a string, if it has the correct wire type.
+func (d *decoder) string() *string {
+	if d.wire != wireBytes {
+		d.err = fmt.Errorf("field %d: wire type mismatch", d.field)
+		return nil
+	}
+	value := string(d.data)
+	return &value
+}
+
+// Bool is a helper routine that allocates a new bool value to store v and
+// returns a pointer to it.
+func Bool(v bool) *bool { return &v }
+
+// Uint32 is a helper routine that allocates a new uint32 value to store v and
+// returns a pointer to it.
+func Uint32(v uint32) *uint32 { return &v }
+
+// String is a helper routine that allocates a new string value to store v and
+// returns a pointer to it.
+func String(v string) *string { return &v }
@@ -0,0 +1,122 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package trezor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// Tests that all the messages survive an encoding round trip, both with all and
+// with none of their fields set.
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []Message{
+		&Initialize{},
+		&Ping{Message: String("hello"), ButtonProtection: Bool(true), PinProtection: Bool(false), PassphraseProtection: Bool(true)},
+		&Success{Message: String("done")},
+		&Failure{Code: Uint32(FailureUnexpectedMessage), Message: String("unexpected")},
+		&Features{
+			Vendor: String("trezor.io"), MajorVersion: Uint32(1), MinorVersion: Uint32(5), PatchVersion: Uint32(300),
+			DeviceId: String("0123456789"), PinProtection: Bool(true), PassphraseProtection: Bool(false),
+			Label: String("wallet"), Initialized: Bool(true),
+		},
+		&PinMatrixRequest{Kind: Uint32(1)},
+		&PinMatrixAck{Pin: String("1234")},
+		&ButtonRequest{Code: Uint32(8), Data: String("data")},
+		&ButtonAck{},
+		&PassphraseRequest{},
+		&PassphraseAck{Passphrase: String("")},
+		&TiltnetGetAddress{AddressN: []uint32{0x80000000 + 44, 0x80000000 + 60, 0x80000000, 0, 7}, ShowDisplay: Bool(true)},
+		&TiltnetAddress{Address: bytes.Repeat([]byte{0xaa}, 20)},
+		&TiltnetSignTx{
+			AddressN: []uint32{0x80000000 + 44, 0}, Nonce: []byte{0x01}, GasPrice: []byte{0x04, 0xa8, 0x17, 0xc8, 0x00},
+			GasLimit: []byte{0x52, 0x08}, To: bytes.Repeat([]byte{0xbb}, 20), Value: []byte{0x0d, 0xe0, 0xb6},
+			DataInitialChunk: []byte{0xca, 0xfe}, DataLength: Uint32(2), ChainId: Uint32(0xffffffff),
+		},
+		&TiltnetTxRequest{DataLength: Uint32(1024), SignatureV: Uint32(38), SignatureR: []byte{0x01}, SignatureS: []byte{0x02}},
+		&TiltnetTxAck{DataChunk: []byte{0x00, 0x01, 0x02}},
+	}
+	for _, msg := range tests {
+		for _, full := range []bool{true, false} {
+			want := msg
+			if !full {
+				want = reflect.New(reflect.TypeOf(msg).Elem()).Interface().(Message)
+			}
+			have := reflect.New(reflect.TypeOf(msg).Elem()).Interface().(Message)
+			if err := have.Unmarshal(want.Marshal()); err != nil {
+				t.Errorf("%v (full %v): failed to unmarshal: %v", want.Type(), full, err)
+				continue
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("%v (full %v): round trip mismatch: have %+v, want %+v", want.Type(), full, have, want)
+			}
+		}
+	}
+}
+
+// Tests that messages are encoded according to the protocol buffer spec: keys
+// and varints in base 128, optional fields omitted and repeated ones unpacked.
+func TestMessageEncoding(t *testing.T) {
+	tests := []struct {
+		msg  Message
+		want string
+	}{
+		{&Initialize{}, ""},
+		{&Ping{Message: String("hi"), ButtonProtection: Bool(true)}, "0a026869" + "1001"},
+		{&Failure{Code: Uint32(300), Message: String("x")}, "08ac02" + "120178"},
+		{&TiltnetGetAddress{AddressN: []uint32{0x8000002c, 1}, ShowDisplay: Bool(false)}, "08ac8080800808011000"},
+		{&TiltnetTxAck{DataChunk: []byte{}}, "0a00"},
+	}
+	for _, tt := range tests {
+		if have := hex.EncodeToString(tt.msg.Marshal()); have != tt.want {
+			t.Errorf("%v: encoding mismatch: have %s, want %s", tt.msg.Type(), have, tt.want)
+		}
+	}
+}
+
+// Tests that unknown fields of all wire types are skipped when decoding, while
+// truncated messages and mismatching wire types are rejected.
+func TestMessageDecoding(t *testing.T) {
+	// Success with the message "ok" surrounded by unknown varint, 64 bit, length
+	// delimited and 32 bit fields
+	blob, _ := hex.DecodeString("1001" + "190102030405060708" + "0a026f6b" + "2203abcdef" + "2d01020304")
+
+	success := new(Success)
+	if err := success.Unmarshal(blob); err != nil {
+		t.Fatalf("failed to skip unknown fields: %v", err)
+	}
+	if success.Message == nil || *success.Message != "ok" {
+		t.Fatalf("message mismatch: have %v, want %q", success.Message, "ok")
+	}
+	failures := []string{
+		"0a",         // key without value
+		"0a05616263", // length overflowing the message
+		"08",         // varint missing
+		"08ff",       // varint truncated
+		"1901020304", // 64 bit value truncated
+		"0b",         // unsupported group wire type
+		"0801",       // message field with varint wire type
+	}
+	for _, blob := range failures {
+		data, _ := hex.DecodeString(blob)
+		if err := new(Success).Unmarshal(data); err == nil {
+			t.Errorf("malformed message %s accepted", blob)
+		}
+	}
+}
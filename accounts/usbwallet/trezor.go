@@ -0,0 +1,431 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+// This file contains the implementation for interacting with the Trezor hardware
+// wallets. The wire protocol spec can be found on the SatoshiLabs website:
+// https://doc.satoshilabs.com/trezor-tech/api-protobuf.html
+
+package usbwallet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/usbwallet/internal/trezor"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/log"
+)
+
+// ErrTrezorPINNeeded is returned if opening the Trezor requires a PIN code. In
+// this case, the calling application should display a pinpad and send back the
+// encoded passphrase (the positions of the PIN digits on the device's scrambled
+// matrix, numbered like a numeric keypad) via another Open call.
+var ErrTrezorPINNeeded = errors.New("trezor: pin needed")
+
+// ErrTrezorPassphraseNeeded is returned if opening the Trezor requires the
+// passphrase protecting its seed. In this case, the calling application should
+// request it from the user and send it back via another Open call.
+var ErrTrezorPassphraseNeeded = errors.New("trezor: passphrase needed")
+
+// errTrezorReplyInvalidHeader is the error message returned by a Trezor data
+// exchange if the device replies with a mismatching header.
+var errTrezorReplyInvalidHeader = errors.New("trezor: invalid reply header")
+
+// trezorInitialChunk is the maximum amount of transaction data sent along with
+// the signing request, the rest being streamed on demand.
+const trezorInitialChunk = 1024
+
+// trezorDriver implements the communication with a Trezor hardware wallet.
+type trezorDriver struct {
+	device         io.ReadWriter // USB device connection to communicate through
+	version        [3]uint32     // Current version of the Trezor firmware
+	label          string        // Current textual label of the Trezor device
+	pinwait        bool          // Flags whether the device is waiting for PIN entry
+	passphrasewait bool          // Flags whether the device is waiting for passphrase entry
+	log            log.Logger    // Logger to tag the trezor with its id
+}
+
+// newTrezorDriver creates a new instance of a Trezor USB protocol driver.
+func newTrezorDriver(logger log.Logger) driver {
+	return &trezorDriver{
+		log: logger,
+	}
+}
+
+// Status implements usbwallet.driver, returning the firmware version and label
+// of the Trezor, along with any authentication it is still waiting for.
+func (w *trezorDriver) Status() string {
+	if w.device == nil {
+		return "Trezor initialization failed"
+	}
+	status := fmt.Sprintf("Trezor v%d.%d.%d '%s'", w.version[0], w.version[1], w.version[2], w.label)
+	switch {
+	case w.pinwait:
+		return status + " waiting for PIN"
+	case w.passphrasewait:
+		return status + " waiting for passphrase"
+	}
+	return status + " online"
+}
+
+// Offline implements usbwallet.driver, returning whether the Trezor is not yet
+// initialized or still waiting for the user to authenticate.
+func (w *trezorDriver) Offline() bool {
+	return w.device == nil || w.pinwait || w.passphrasewait
+}
+
+// Open implements usbwallet.driver, attempting to initialize the connection to
+// the Trezor hardware wallet. Initializing the Trezor is a multi or single phase
+// operation depending on the protections configured on the device:
+//  - The first phase initializes the connection and requests the user to unlock
+//    the device, which requires the PIN and/or the passphrase if set. The first
+//    missing credential is reported via ErrTrezorPINNeeded or
+//    ErrTrezorPassphraseNeeded.
+//  - The subsequent phases send the requested credential over to the device,
+//    the passphrase parameter holding the scrambled PIN or the seed passphrase.
+func (w *trezorDriver) Open(device io.ReadWriter, passphrase string) error {
+	switch {
+	case w.pinwait:
+		// Phase 2 requested with the scrambled PIN entry
+		if passphrase == "" {
+			return ErrTrezorPINNeeded
+		}
+		w.pinwait = false
+
+		res, err := w.trezorExchange(&trezor.PinMatrixAck{Pin: &passphrase}, new(trezor.Success), new(trezor.PassphraseRequest))
+		if err != nil {
+			w.device = nil
+			return err
+		}
+		if res == 1 {
+			w.passphrasewait = true
+			return ErrTrezorPassphraseNeeded
+		}
+		return nil
+
+	case w.passphrasewait:
+		// Phase 3 requested with the seed passphrase (empty being a valid one)
+		w.passphrasewait = false
+
+		if _, err := w.trezorExchange(&trezor.PassphraseAck{Passphrase: &passphrase}, new(trezor.Success)); err != nil {
+			w.device = nil
+			return err
+		}
+		return nil
+
+	case w.device != nil:
+		// If the wallet was already opened, don't try to open again
+		return accounts.ErrWalletAlreadyOpen
+	}
+	// Phase 1 requested, initialize the connection to the device
+	w.device = device
+
+	features := new(trezor.Features)
+	if _, err := w.trezorExchange(&trezor.Initialize{}, features); err != nil {
+		w.device = nil
+		return err
+	}
+	w.version = [3]uint32{derefUint32(features.MajorVersion), derefUint32(features.MinorVersion), derefUint32(features.PatchVersion)}
+	if features.Label != nil {
+		w.label = *features.Label
+	}
+	// Do a manual ping, forcing the device to ask for its PIN and passphrase
+	ping := &trezor.Ping{
+		PinProtection:        trezor.Bool(true),
+		PassphraseProtection: trezor.Bool(true),
+	}
+	res, err := w.trezorExchange(ping, new(trezor.Success), new(trezor.PinMatrixRequest), new(trezor.PassphraseRequest))
+	if err != nil {
+		w.device = nil
+		return err
+	}
+	switch res {
+	case 1:
+		w.pinwait = true
+		return ErrTrezorPINNeeded
+	case 2:
+		w.passphrasewait = true
+		return ErrTrezorPassphraseNeeded
+	}
+	return nil // Device responded with trezor.Success, already unlocked
+}
+
+// Close implements usbwallet.driver, cleaning up and metadata maintained within
+// the Trezor driver.
+func (w *trezorDriver) Close() error {
+	w.device, w.version, w.label = nil, [3]uint32{}, ""
+	w.pinwait, w.passphrasewait = false, false
+	return nil
+}
+
+// Heartbeat implements usbwallet.driver, performing a sanity check against the
+// Trezor to see if it's still online. While waiting for user authentication the
+// device can't be pinged without aborting the request, so the check is skipped.
+func (w *trezorDriver) Heartbeat() error {
+	if w.Offline() {
+		return nil
+	}
+	if _, err := w.trezorExchange(&trezor.Ping{}, new(trezor.Success)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Derive implements usbwallet.driver, sending a derivation request to the Trezor
+// and returning the Tiltnet address located on that derivation path.
+func (w *trezorDriver) Derive(path accounts.DerivationPath) (common.Address, error) {
+	return w.trezorDerive(path)
+}
+
+// SignTx implements usbwallet.driver, sending the transaction to the Trezor and
+// waiting for the user to confirm or deny the transaction.
+func (w *trezorDriver) SignTx(path accounts.DerivationPath, tx *types.Transaction, chainID *big.Int) (common.Address, *types.Transaction, error) {
+	if w.device == nil {
+		return common.Address{}, nil, accounts.ErrWalletClosed
+	}
+	return w.trezorSign(path, tx, chainID)
+}
+
+// trezorDerive sends a derivation request to the Trezor device and returns the
+// Tiltnet address located on that path.
+func (w *trezorDriver) trezorDerive(derivationPath []uint32) (common.Address, error) {
+	address := new(trezor.TiltnetAddress)
+	if _, err := w.trezorExchange(&trezor.TiltnetGetAddress{AddressN: derivationPath}, address); err != nil {
+		return common.Address{}, err
+	}
+	if len(address.Address) != common.AddressLength {
+		return common.Address{}, errors.New("reply lacks address entry")
+	}
+	return common.BytesToAddress(address.Address), nil
+}
+
+// trezorSign sends the transaction to the Trezor wallet, and waits for the user
+// to confirm or deny the transaction. Transaction data not fitting into the
+// initial request is streamed in chunks of the size requested by the device.
+func (w *trezorDriver) trezorSign(derivationPath []uint32, tx *types.Transaction, chainID *big.Int) (common.Address, *types.Transaction, error) {
+	// Create the transaction initiation message
+	data := tx.Data()
+	length := uint32(len(data))
+
+	request := &trezor.TiltnetSignTx{
+		AddressN:   derivationPath,
+		Nonce:      new(big.Int).SetUint64(tx.Nonce()).Bytes(),
+		GasPrice:   tx.GasPrice().Bytes(),
+		GasLimit:   tx.Gas().Bytes(),
+		Value:      tx.Value().Bytes(),
+		DataLength: &length,
+	}
+	if to := tx.To(); to != nil {
+		request.To = (*to)[:] // Non contract deploy, set recipient explicitly
+	}
+	if length > trezorInitialChunk {
+		request.DataInitialChunk, data = data[:trezorInitialChunk], data[trezorInitialChunk:]
+	} else {
+		request.DataInitialChunk, data = data, nil
+	}
+	if chainID != nil {
+		if chainID.Sign() < 0 || chainID.BitLen() > 32 {
+			return common.Address{}, nil, fmt.Errorf("chain id %v not supported by Trezor", chainID)
+		}
+		request.ChainId = trezor.Uint32(uint32(chainID.Uint64()))
+	}
+	// Send the initiation message and stream content until a signature is returned
+	response := new(trezor.TiltnetTxRequest)
+	if _, err := w.trezorExchange(request, response); err != nil {
+		return common.Address{}, nil, err
+	}
+	for response.DataLength != nil && int(*response.DataLength) <= len(data) {
+		chunk := data[:*response.DataLength]
+		data = data[*response.DataLength:]
+
+		if _, err := w.trezorExchange(&trezor.TiltnetTxAck{DataChunk: chunk}, response); err != nil {
+			return common.Address{}, nil, err
+		}
+	}
+	// Extract the Tiltnet signature and do a sanity validation
+	if response.SignatureV == nil || len(response.SignatureR) == 0 || len(response.SignatureR) > 32 || len(response.SignatureS) == 0 || len(response.SignatureS) > 32 {
+		return common.Address{}, nil, errors.New("reply lacks signature")
+	}
+	v, err := trezorRecoveryID(*response.SignatureV, chainID)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	signature := make([]byte, 65)
+	copy(signature[32-len(response.SignatureR):32], response.SignatureR)
+	copy(signature[64-len(response.SignatureS):64], response.SignatureS)
+	signature[64] = v
+
+	// Create the correct signer based on the chain ID
+	var signer types.Signer
+	if chainID == nil {
+		signer = new(types.OmahaSigner)
+	} else {
+		signer = types.NewTiltSigner(chainID)
+	}
+	// Inject the final signature into the transaction and retrieve the sender
+	signed, err := tx.WithSignature(signer, signature)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	sender, err := types.Sender(signer, signed)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	return sender, signed, nil
+}
+
+// trezorRecoveryID converts the V value of a signature reported by the Trezor
+// into the recovery identifier of the signature. Depending on the firmware, V is
+// either the bare identifier, offset by 27, or offset by the EIP-155 value of
+// the chain ID, which newer firmwares omit for chain IDs too large to encode.
+func trezorRecoveryID(v uint32, chainID *big.Int) (byte, error) {
+	id := uint64(v)
+	switch {
+	case id < 2:
+	case id == 27 || id == 28:
+		id -= 27
+	case chainID != nil && id >= chainID.Uint64()*2+35:
+		id -= chainID.Uint64()*2 + 35
+	}
+	if id > 1 {
+		return 0, fmt.Errorf("trezor: invalid signature V %d", v)
+	}
+	return byte(id), nil
+}
+
+// trezorExchange performs a data exchange with the Trezor wallet, sending it a
+// message and retrieving the response. If multiple responses are possible, the
+// method will also return the index of the destination object used.
+//
+// Messages are framed into 64 byte USB HID reports, each starting with a '?'
+// marker byte. The first report of a message additionally carries a header:
+//
+//  Description                       | Length
+//  ----------------------------------+----------
+//  Magic marker "##"                 | 2 bytes
+//  Message type (big endian)         | 2 bytes
+//  Payload length (big endian)       | 4 bytes
+//  Protocol buffer encoded payload   | arbitrary
+//
+// Generic Failure replies are converted into errors, whereas ButtonRequests are
+// acknowledged transparently, waiting for the user to confirm on the device.
+func (w *trezorDriver) trezorExchange(req trezor.Message, results ...trezor.Message) (int, error) {
+	// Construct the original message payload to chunk up
+	data := req.Marshal()
+
+	payload := make([]byte, 8+len(data))
+	copy(payload, []byte{0x23, 0x23})
+	binary.BigEndian.PutUint16(payload[2:], uint16(req.Type()))
+	binary.BigEndian.PutUint32(payload[4:], uint32(len(data)))
+	copy(payload[8:], data)
+
+	// Stream all the chunks to the device
+	chunk := make([]byte, 64)
+	chunk[0] = 0x3f // Report ID magic number
+
+	for len(payload) > 0 {
+		// Construct the new message to stream, padding with zeroes if needed
+		if len(payload) > 63 {
+			copy(chunk[1:], payload[:63])
+			payload = payload[63:]
+		} else {
+			copy(chunk[1:], payload)
+			copy(chunk[1+len(payload):], make([]byte, 63-len(payload)))
+			payload = nil
+		}
+		// Send over to the device
+		w.log.Trace("Data chunk sent to the Trezor", "chunk", hexutil.Bytes(chunk))
+		if _, err := w.device.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	// Stream the reply back from the wallet in 64 byte chunks
+	var (
+		kind  uint16
+		reply []byte
+	)
+	for {
+		// Read the next chunk from the Trezor wallet
+		if _, err := io.ReadFull(w.device, chunk); err != nil {
+			return 0, err
+		}
+		w.log.Trace("Data chunk received from the Trezor", "chunk", hexutil.Bytes(chunk))
+
+		// Make sure the transport header matches
+		if chunk[0] != 0x3f || (len(reply) == 0 && (chunk[1] != 0x23 || chunk[2] != 0x23)) {
+			return 0, errTrezorReplyInvalidHeader
+		}
+		// If it's the first chunk, retrieve the reply message type and total message length
+		var payload []byte
+
+		if len(reply) == 0 {
+			kind = binary.BigEndian.Uint16(chunk[3:5])
+			reply = make([]byte, 0, int(binary.BigEndian.Uint32(chunk[5:9])))
+			payload = chunk[9:]
+		} else {
+			payload = chunk[1:]
+		}
+		// Append to the reply and stop when filled up
+		if left := cap(reply) - len(reply); left > len(payload) {
+			reply = append(reply, payload...)
+		} else {
+			reply = append(reply, payload[:left]...)
+			break
+		}
+	}
+	// Try to parse the reply into the requested reply message
+	switch trezor.MessageType(kind) {
+	case trezor.MessageType_Failure:
+		// Trezor returned a failure, extract and return the message
+		failure := new(trezor.Failure)
+		if err := failure.Unmarshal(reply); err != nil {
+			return 0, err
+		}
+		if failure.Message == nil {
+			return 0, errors.New("trezor: unknown failure")
+		}
+		return 0, errors.New("trezor: " + *failure.Message)
+
+	case trezor.MessageType_ButtonRequest:
+		// Trezor is waiting for user confirmation, ack and wait for the next message
+		return w.trezorExchange(&trezor.ButtonAck{}, results...)
+	}
+	for i, res := range results {
+		if res.Type() == trezor.MessageType(kind) {
+			return i, res.Unmarshal(reply)
+		}
+	}
+	expected := make([]string, len(results))
+	for i, res := range results {
+		expected[i] = res.Type().String()
+	}
+	return 0, fmt.Errorf("trezor: expected reply types %v, got %v", expected, trezor.MessageType(kind))
+}
+
+// derefUint32 returns the value of an optional protocol buffer field, or zero if
+// the field was not set.
+func derefUint32(value *uint32) uint32 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
@@ -0,0 +1,437 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package usbwallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/usbwallet/internal/trezor"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/crypto"
+	"github.com/megatilt/go-tilt/log"
+)
+
+// Ways the simulated Trezor may report the V value of signatures.
+const (
+	trezorVOffset = iota // Offset by 27, or by the EIP-155 value of the chain ID if it fits
+	trezorVLegacy        // Offset by 27 regardless of the chain ID
+	trezorVRaw           // Bare recovery identifier
+)
+
+// fakeTrezor simulates a Trezor on the USB HID transport, deriving a key from
+// every derivation path and signing transactions with it.
+type fakeTrezor struct {
+	pin        string // PIN protecting the device, empty if none
+	passphrase bool   // Whether the seed is protected by a passphrase
+	vmode      int    // Way the V value of signatures is reported
+	chunk      int    // Size of the transaction data chunks requested
+
+	unlocked bool                  // Whether the user already authenticated
+	signing  *trezor.TiltnetSignTx // Signing request being streamed in
+	data     []byte                // Transaction data received so far
+	confirm  bool                  // Whether a button confirmation is pending
+
+	request []byte // Framed request being received
+	reply   []byte // Framed reply being sent back
+}
+
+// key derives the private key of a derivation path.
+func (d *fakeTrezor) key(path []uint32) *ecdsa.PrivateKey {
+	blob := make([]byte, 4*len(path))
+	for i, index := range path {
+		binary.BigEndian.PutUint32(blob[4*i:], index)
+	}
+	return crypto.ToECDSA(crypto.Keccak256(blob))
+}
+
+// Write implements io.Writer, receiving a 64 byte HID report and handling the
+// request once all of it arrived.
+func (d *fakeTrezor) Write(chunk []byte) (int, error) {
+	if len(chunk) != 64 || chunk[0] != 0x3f {
+		return 0, errors.New("invalid report")
+	}
+	if len(d.request) == 0 && (chunk[1] != 0x23 || chunk[2] != 0x23) {
+		return 0, errors.New("invalid header")
+	}
+	d.request = append(d.request, chunk[1:]...)
+
+	size := int(binary.BigEndian.Uint32(d.request[4:8]))
+	if len(d.request) < 8+size {
+		return len(chunk), nil
+	}
+	kind, payload := trezor.MessageType(binary.BigEndian.Uint16(d.request[2:4])), d.request[8:8+size]
+	d.request = nil
+
+	res := d.handle(kind, payload)
+	data := res.Marshal()
+
+	frame := make([]byte, 8+len(data))
+	copy(frame, []byte{0x23, 0x23})
+	binary.BigEndian.PutUint16(frame[2:], uint16(res.Type()))
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(data)))
+	copy(frame[8:], data)
+
+	for len(frame) > 0 {
+		report := make([]byte, 64)
+		report[0] = 0x3f
+		n := copy(report[1:], frame)
+		frame = frame[n:]
+		d.reply = append(d.reply, report...)
+	}
+	return len(chunk), nil
+}
+
+// Read implements io.Reader, returning the reply to the last request.
+func (d *fakeTrezor) Read(buf []byte) (int, error) {
+	if len(d.reply) == 0 {
+		return 0, errors.New("no reply pending")
+	}
+	n := copy(buf, d.reply)
+	d.reply = d.reply[n:]
+	return n, nil
+}
+
+// failure creates a Failure reply with the given message.
+func failure(format string, args ...interface{}) trezor.Message {
+	return &trezor.Failure{Code: trezor.Uint32(99), Message: trezor.String(fmt.Sprintf(format, args...))}
+}
+
+// handle processes a single request, returning the reply to send back.
+func (d *fakeTrezor) handle(kind trezor.MessageType, payload []byte) trezor.Message {
+	switch kind {
+	case trezor.MessageType_Initialize:
+		d.unlocked, d.signing = false, nil
+		return &trezor.Features{MajorVersion: trezor.Uint32(1), MinorVersion: trezor.Uint32(5), PatchVersion: trezor.Uint32(2), Label: trezor.String("test")}
+
+	case trezor.MessageType_Ping:
+		ping := new(trezor.Ping)
+		if err := ping.Unmarshal(payload); err != nil {
+			return failure("%v", err)
+		}
+		if !d.unlocked && ping.PinProtection != nil && *ping.PinProtection && d.pin != "" {
+			return &trezor.PinMatrixRequest{Kind: trezor.Uint32(1)}
+		}
+		if !d.unlocked && ping.PassphraseProtection != nil && *ping.PassphraseProtection && d.passphrase {
+			return new(trezor.PassphraseRequest)
+		}
+		d.unlocked = true
+		return new(trezor.Success)
+
+	case trezor.MessageType_PinMatrixAck:
+		ack := new(trezor.PinMatrixAck)
+		if err := ack.Unmarshal(payload); err != nil {
+			return failure("%v", err)
+		}
+		if ack.Pin == nil || *ack.Pin != d.pin {
+			return failure("PIN invalid")
+		}
+		if d.passphrase {
+			return new(trezor.PassphraseRequest)
+		}
+		d.unlocked = true
+		return new(trezor.Success)
+
+	case trezor.MessageType_PassphraseAck:
+		d.unlocked = true
+		return new(trezor.Success)
+
+	case trezor.MessageType_TiltnetGetAddress:
+		req := new(trezor.TiltnetGetAddress)
+		if err := req.Unmarshal(payload); err != nil {
+			return failure("%v", err)
+		}
+		addr := crypto.PubkeyToAddress(d.key(req.AddressN).PublicKey)
+		return &trezor.TiltnetAddress{Address: addr[:]}
+
+	case trezor.MessageType_TiltnetSignTx:
+		req := new(trezor.TiltnetSignTx)
+		if err := req.Unmarshal(payload); err != nil {
+			return failure("%v", err)
+		}
+		if len(req.DataInitialChunk) > 1024 {
+			return failure("initial chunk too long")
+		}
+		d.signing, d.data = req, req.DataInitialChunk
+		return d.stream()
+
+	case trezor.MessageType_TiltnetTxAck:
+		ack := new(trezor.TiltnetTxAck)
+		if err := ack.Unmarshal(payload); err != nil {
+			return failure("%v", err)
+		}
+		if d.signing == nil {
+			return failure("unexpected data chunk")
+		}
+		d.data = append(d.data, ack.DataChunk...)
+		return d.stream()
+
+	case trezor.MessageType_ButtonAck:
+		if !d.confirm {
+			return failure("unexpected button ack")
+		}
+		d.confirm = false
+		return d.sign()
+	}
+	return failure("unexpected message %v", kind)
+}
+
+// stream requests the next chunk of transaction data, or the confirmation of
+// the user once all of it was received.
+func (d *fakeTrezor) stream() trezor.Message {
+	if left := int(*d.signing.DataLength) - len(d.data); left > 0 {
+		if left > d.chunk {
+			left = d.chunk
+		}
+		return &trezor.TiltnetTxRequest{DataLength: trezor.Uint32(uint32(left))}
+	}
+	d.confirm = true
+	return &trezor.ButtonRequest{Code: trezor.Uint32(8)}
+}
+
+// sign signs the transaction streamed in, reporting V as configured.
+func (d *fakeTrezor) sign() trezor.Message {
+	req := d.signing
+	d.signing = nil
+
+	var (
+		nonce    = new(big.Int).SetBytes(req.Nonce).Uint64()
+		value    = new(big.Int).SetBytes(req.Value)
+		gasLimit = new(big.Int).SetBytes(req.GasLimit)
+		gasPrice = new(big.Int).SetBytes(req.GasPrice)
+		tx       *types.Transaction
+	)
+	if len(req.To) == 0 {
+		tx = types.NewContractCreation(nonce, value, gasLimit, gasPrice, d.data)
+	} else {
+		tx = types.NewTransaction(nonce, common.BytesToAddress(req.To), value, gasLimit, gasPrice, d.data)
+	}
+	var hash common.Hash
+	if req.ChainId == nil {
+		hash = types.OmahaSigner{}.Hash(tx)
+	} else {
+		hash = types.NewTiltSigner(new(big.Int).SetUint64(uint64(*req.ChainId))).Hash(tx)
+	}
+	sig, err := crypto.Sign(hash[:], d.key(req.AddressN))
+	if err != nil {
+		return failure("%v", err)
+	}
+	v := uint32(sig[64])
+	switch {
+	case d.vmode == trezorVLegacy || d.vmode == trezorVOffset && req.ChainId == nil:
+		v += 27
+	case d.vmode == trezorVOffset:
+		// Offsets overflowing the V field are omitted, as the firmware does
+		if offset := uint64(*req.ChainId)*2 + 35; offset < math.MaxUint32 {
+			v += uint32(offset)
+		}
+	}
+	return &trezor.TiltnetTxRequest{SignatureV: &v, SignatureR: sig[:32], SignatureS: sig[32:64]}
+}
+
+// newTestTrezor opens a Trezor driver over the given simulated device.
+func newTestTrezor(t *testing.T, device *fakeTrezor) *trezorDriver {
+	driver := newTrezorDriver(log.New()).(*trezorDriver)
+	if err := driver.Open(device, ""); err != nil {
+		t.Fatalf("failed to open trezor: %v", err)
+	}
+	return driver
+}
+
+// Tests that opening a Trezor requests the PIN and the passphrase protecting it,
+// in order, and that invalid PINs fail the opening.
+func TestTrezorOpen(t *testing.T) {
+	tests := []struct {
+		pin        string
+		passphrase bool
+	}{
+		{"", false},
+		{"1234", false},
+		{"", true},
+		{"1234", true},
+	}
+	for i, tt := range tests {
+		var (
+			device = &fakeTrezor{pin: tt.pin, passphrase: tt.passphrase}
+			driver = newTrezorDriver(log.New()).(*trezorDriver)
+			err    = driver.Open(device, "")
+		)
+		if tt.pin != "" {
+			if err != ErrTrezorPINNeeded {
+				t.Fatalf("test %d: pin request mismatch: have %v, want %v", i, err, ErrTrezorPINNeeded)
+			}
+			if !driver.Offline() {
+				t.Errorf("test %d: online while waiting for the pin", i)
+			}
+			err = driver.Open(nil, tt.pin)
+		}
+		if tt.passphrase {
+			if err != ErrTrezorPassphraseNeeded {
+				t.Fatalf("test %d: passphrase request mismatch: have %v, want %v", i, err, ErrTrezorPassphraseNeeded)
+			}
+			if !driver.Offline() {
+				t.Errorf("test %d: online while waiting for the passphrase", i)
+			}
+			err = driver.Open(nil, "secret")
+		}
+		if err != nil {
+			t.Fatalf("test %d: failed to open: %v", i, err)
+		}
+		if driver.Offline() {
+			t.Errorf("test %d: offline after opening", i)
+		}
+		if status, want := driver.Status(), "Trezor v1.5.2 'test' online"; status != want {
+			t.Errorf("test %d: status mismatch: have %q, want %q", i, status, want)
+		}
+		if err := driver.Heartbeat(); err != nil {
+			t.Errorf("test %d: heartbeat failed: %v", i, err)
+		}
+		if err := driver.Open(device, ""); err != accounts.ErrWalletAlreadyOpen {
+			t.Errorf("test %d: reopen mismatch: have %v, want %v", i, err, accounts.ErrWalletAlreadyOpen)
+		}
+	}
+	// Invalid PINs are reported as device failures, resetting the driver
+	driver := newTrezorDriver(log.New()).(*trezorDriver)
+	if err := driver.Open(&fakeTrezor{pin: "1234"}, ""); err != ErrTrezorPINNeeded {
+		t.Fatalf("pin request mismatch: have %v, want %v", err, ErrTrezorPINNeeded)
+	}
+	if err := driver.Open(nil, "4321"); err == nil || err.Error() != "trezor: PIN invalid" {
+		t.Fatalf("invalid pin error mismatch: have %v, want %v", err, "trezor: PIN invalid")
+	}
+	if !driver.Offline() {
+		t.Errorf("online after invalid pin")
+	}
+}
+
+// Tests that addresses are derived on the device at the requested paths.
+func TestTrezorDerive(t *testing.T) {
+	device := new(fakeTrezor)
+	driver := newTestTrezor(t, device)
+
+	for i := uint32(0); i < 3; i++ {
+		path := append(accounts.DerivationPath{}, accounts.DefaultBaseDerivationPath...)
+		path[len(path)-1] = i
+
+		addr, err := driver.Derive(path)
+		if err != nil {
+			t.Fatalf("path %v: failed to derive: %v", path, err)
+		}
+		if want := crypto.PubkeyToAddress(device.key(path).PublicKey); addr != want {
+			t.Errorf("path %v: address mismatch: have %x, want %x", path, addr, want)
+		}
+	}
+}
+
+// Tests that transactions are signed on the device, streaming the data that
+// doesn't fit in the signing request and accepting all the V encodings used by
+// the Trezor firmwares.
+func TestTrezorSignTx(t *testing.T) {
+	var (
+		to    = common.HexToAddress("0x0102030405060708090a0b0c0d0e0f1011121314")
+		small = []byte{0xca, 0xfe}
+		large = bytes.Repeat([]byte{0xab}, 3000)
+	)
+	tests := []struct {
+		vmode   int
+		chainID *big.Int
+		data    []byte
+		create  bool
+	}{
+		{trezorVOffset, nil, nil, false},
+		{trezorVOffset, big.NewInt(1), small, false},
+		{trezorVOffset, big.NewInt(1337), large, false},
+		{trezorVOffset, big.NewInt(1), large, true},
+		{trezorVOffset, big.NewInt(0x7fffffff), small, false},
+		{trezorVLegacy, nil, small, false},
+		{trezorVLegacy, big.NewInt(3), small, false},
+		{trezorVRaw, nil, small, false},
+		{trezorVRaw, big.NewInt(0xffffffff), large, false},
+	}
+	for i, tt := range tests {
+		device := &fakeTrezor{vmode: tt.vmode, chunk: 700}
+		driver := newTestTrezor(t, device)
+
+		tx := types.NewTransaction(uint64(i), to, big.NewInt(int64(i)), big.NewInt(100000), big.NewInt(1), tt.data)
+		if tt.create {
+			tx = types.NewContractCreation(uint64(i), big.NewInt(int64(i)), big.NewInt(100000), big.NewInt(1), tt.data)
+		}
+		path := accounts.DefaultBaseDerivationPath
+
+		sender, signed, err := driver.SignTx(path, tx, tt.chainID)
+		if err != nil {
+			t.Errorf("test %d: failed to sign: %v", i, err)
+			continue
+		}
+		want := crypto.PubkeyToAddress(device.key(path).PublicKey)
+		if sender != want {
+			t.Errorf("test %d: sender mismatch: have %x, want %x", i, sender, want)
+		}
+		var signer types.Signer = types.OmahaSigner{}
+		if tt.chainID != nil {
+			signer = types.NewTiltSigner(tt.chainID)
+		}
+		if from, err := types.Sender(signer, signed); err != nil || from != want {
+			t.Errorf("test %d: recovered sender mismatch: have %x (%v), want %x", i, from, err, want)
+		}
+		if tt.chainID != nil && signed.ChainId().Cmp(tt.chainID) != 0 {
+			t.Errorf("test %d: chain id mismatch: have %v, want %v", i, signed.ChainId(), tt.chainID)
+		}
+		if !bytes.Equal(signed.Data(), tt.data) {
+			t.Errorf("test %d: data mismatch", i)
+		}
+	}
+}
+
+// Tests that signing is refused for chain IDs the Trezor can't encode and for
+// signatures with invalid V values.
+func TestTrezorSignTxInvalid(t *testing.T) {
+	tx := types.NewTransaction(0, common.Address{}, new(big.Int), big.NewInt(21000), big.NewInt(1), nil)
+
+	driver := newTestTrezor(t, new(fakeTrezor))
+	if _, _, err := driver.SignTx(accounts.DefaultBaseDerivationPath, tx, new(big.Int).Lsh(big.NewInt(1), 32)); err == nil {
+		t.Errorf("oversized chain id accepted")
+	}
+	driver.Close()
+	if _, _, err := driver.SignTx(accounts.DefaultBaseDerivationPath, tx, nil); err != accounts.ErrWalletClosed {
+		t.Errorf("closed wallet error mismatch: have %v, want %v", err, accounts.ErrWalletClosed)
+	}
+	tests := []struct {
+		v       uint32
+		chainID *big.Int
+	}{
+		{2, nil},
+		{26, nil},
+		{29, nil},
+		{37, nil},
+		{29, big.NewInt(1)},
+		{36, big.NewInt(1)},
+		{39, big.NewInt(1)},
+	}
+	for _, tt := range tests {
+		if id, err := trezorRecoveryID(tt.v, tt.chainID); err == nil {
+			t.Errorf("v %d, chain %v: invalid v accepted as %d", tt.v, tt.chainID, id)
+		}
+	}
+}
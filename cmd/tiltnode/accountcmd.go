@@ -19,6 +19,9 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/megatilt/go-tilt/accounts"
 	"github.com/megatilt/go-tilt/accounts/keystore"
@@ -30,6 +33,23 @@ import (
 )
 
 var (
+	mnemonicFlag = cli.BoolFlag{
+		Name:  "mnemonic",
+		Usage: "Use a BIP-39 mnemonic seed, allowing further accounts to be derived from it",
+	}
+	mnemonicPasswordFlag = cli.StringFlag{
+		Name:  "mnemonicpassword",
+		Usage: "Password file holding the optional BIP-39 mnemonic passphrase",
+	}
+	kdfFlag = cli.StringFlag{
+		Name:  "kdf",
+		Usage: "Key derivation function to migrate the keys to (scrypt, argon2id)",
+		Value: keystore.KDFScrypt,
+	}
+	lightKDFFlag = cli.BoolFlag{
+		Name:  "lightkdf",
+		Usage: "Reduce key-derivation RAM & CPU usage at some expense of KDF strength",
+	}
 	walletCommand = cli.Command{
 		Name:     "wallet",
 		Usage:    "Import Tiltnet presale wallets",
@@ -93,6 +113,8 @@ Print a short summary of all accounts`,
 					utils.DataDirFlag,
 					utils.KeyStoreDirFlag,
 					utils.PasswordFileFlag,
+					mnemonicFlag,
+					mnemonicPasswordFlag,
 				},
 				Description: `
     tiltnode account new
@@ -107,6 +129,12 @@ For non-interactive use the passphrase can be specified with the --password flag
 
 Note, this is meant to be used for testing only, it is a bad idea to save your
 password to file or expose in any other way.
+
+With the --mnemonic flag, the account is derived from a newly generated BIP-39
+mnemonic, which is printed once and must be written down as a backup. The seed
+is stored encrypted with the same passphrase, so further accounts can be derived
+with personal.deriveAccount after opening the wallet with personal.openWallet.
+An optional BIP-39 passphrase is prompted for, or read from --mnemonicpassword.
 `,
 			},
 			{
@@ -135,6 +163,60 @@ For non-interactive use the passphrase can be specified with the --password flag
 
 Since only one password can be given, only format update can be performed,
 changing your password is only possible interactively.
+`,
+			},
+			{
+				Name:      "export",
+				Usage:     "Export an account into an encrypted key file",
+				Action:    utils.MigrateFlags(accountExport),
+				ArgsUsage: "<address> <keyFile>",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.KeyStoreDirFlag,
+					utils.PasswordFileFlag,
+				},
+				Description: `
+    tiltnode account export <address> <keyfile>
+
+Exports an existing account into <keyfile>, encrypted with a new passphrase, to
+be backed up or imported into another node.
+
+You are prompted for the passphrase of the account and for the passphrase to
+encrypt the exported key with.
+
+For non-interactive use the passphrases can be specified with the --password flag,
+holding the passphrase of the account on the first line and the passphrase of the
+exported key on the second:
+
+    tiltnode account export [options] <address> <keyfile>
+`,
+			},
+			{
+				Name:   "migrate",
+				Usage:  "Re-encrypt all accounts with new key derivation parameters",
+				Action: utils.MigrateFlags(accountMigrate),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.KeyStoreDirFlag,
+					utils.PasswordFileFlag,
+					kdfFlag,
+					lightKDFFlag,
+				},
+				Description: `
+    tiltnode account migrate
+
+Re-encrypts the key files of all accounts, and the mnemonic seeds they were
+derived from, with the key derivation function selected by --kdf and --lightkdf.
+The passphrases of the accounts are left unchanged.
+
+The original key files are copied into a backup directory inside the keystore
+before being replaced, and each file is replaced atomically.
+
+You are prompted for the passphrase of every account. For non-interactive use
+the passphrases can be specified with the --password flag, one per line in the
+order the accounts are listed by "tiltnode account list":
+
+    tiltnode account migrate [options]
 `,
 			},
 			{
@@ -145,6 +227,8 @@ changing your password is only possible interactively.
 					utils.DataDirFlag,
 					utils.KeyStoreDirFlag,
 					utils.PasswordFileFlag,
+					mnemonicFlag,
+					mnemonicPasswordFlag,
 				},
 				ArgsUsage: "<keyFile>",
 				Description: `
@@ -153,7 +237,9 @@ changing your password is only possible interactively.
 Imports an unencrypted private key from <keyfile> and creates a new account.
 Prints the address.
 
-The keyfile is assumed to contain an unencrypted private key in hexadecimal format.
+The keyfile is assumed to contain an unencrypted private key in hexadecimal format,
+or a BIP-39 mnemonic sentence if the --mnemonic flag is given. Mnemonics are imported
+as seeds, deriving their first account.
 
 The account is saved in encrypted format, you are prompted for a passphrase.
 
@@ -276,6 +362,19 @@ func accountCreate(ctx *cli.Context) error {
 	password := getPassPhrase("Your new account is locked with a password. Please give a password. Do not forget this password.", true, 0, utils.MakePasswordList(ctx))
 
 	ks := stack.AccountManager().Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+	if ctx.Bool(mnemonicFlag.Name) {
+		sentence, account, err := ks.NewMnemonicAccount(getMnemonicPassphrase(ctx), password)
+		if err != nil {
+			utils.Fatalf("Failed to create account: %v", err)
+		}
+		fmt.Println("Your new account was derived from the mnemonic below. Write it down and keep it")
+		fmt.Println("safe, it is the only backup of all the accounts derived from it:")
+		fmt.Println()
+		fmt.Printf("  %s\n", sentence)
+		fmt.Println()
+		fmt.Printf("Address: {%x}\n", account.Address)
+		return nil
+	}
 	account, err := ks.NewAccount(password)
 	if err != nil {
 		utils.Fatalf("Failed to create account: %v", err)
@@ -284,6 +383,25 @@ func accountCreate(ctx *cli.Context) error {
 	return nil
 }
 
+// getMnemonicPassphrase retrieves the optional BIP-39 passphrase of a mnemonic,
+// either from the file given by the CLI flags or requested interactively from
+// the user if no password file is used.
+func getMnemonicPassphrase(ctx *cli.Context) string {
+	if path := ctx.String(mnemonicPasswordFlag.Name); path != "" {
+		text, err := ioutil.ReadFile(path)
+		if err != nil {
+			utils.Fatalf("Failed to read mnemonic password file: %v", err)
+		}
+		return strings.TrimRight(strings.Split(string(text), "\n")[0], "\r")
+	}
+	if ctx.GlobalString(utils.PasswordFileFlag.Name) != "" {
+		return ""
+	}
+	fmt.Println("You may protect the mnemonic with an additional BIP-39 passphrase. Leave it empty")
+	fmt.Println("for none. Without it, the mnemonic alone does not restore your accounts.")
+	return getPassPhrase("", true, 0, nil)
+}
+
 // accountUpdate transitions an account from a previous format to the current
 // one, also providing the possibility to change the pass-phrase.
 func accountUpdate(ctx *cli.Context) error {
@@ -303,6 +421,66 @@ func accountUpdate(ctx *cli.Context) error {
 	return nil
 }
 
+// accountMigrate re-encrypts all accounts with the key derivation function
+// defined by the CLI flags.
+func accountMigrate(ctx *cli.Context) error {
+	var kdf keystore.KDF
+	switch name, light := ctx.String(kdfFlag.Name), ctx.Bool(lightKDFFlag.Name); {
+	case name == keystore.KDFScrypt && light:
+		kdf = keystore.ScryptKDF(keystore.LightScryptN, keystore.LightScryptP)
+	case name == keystore.KDFScrypt:
+		kdf = keystore.ScryptKDF(keystore.StandardScryptN, keystore.StandardScryptP)
+	case name == keystore.KDFArgon2id && light:
+		kdf = keystore.Argon2idKDF(keystore.LightArgon2Time, keystore.LightArgon2Memory)
+	case name == keystore.KDFArgon2id:
+		kdf = keystore.Argon2idKDF(keystore.StandardArgon2Time, keystore.StandardArgon2Memory)
+	default:
+		utils.Fatalf("Unknown key derivation function: %s", name)
+	}
+	stack, _ := makeConfigNode(ctx)
+	ks := stack.AccountManager().Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+
+	accs := ks.Accounts()
+	if len(accs) == 0 {
+		utils.Fatalf("No accounts to migrate")
+	}
+	backupDir := filepath.Join(filepath.Dir(accs[0].URL.Path), fmt.Sprintf("backup-%d", time.Now().Unix()))
+	passwords := utils.MakePasswordList(ctx)
+
+	for i, account := range accs {
+		password := getPassPhrase(fmt.Sprintf("Migrating account %x to %v", account.Address, kdf), false, i, passwords)
+		if err := ks.Migrate(account, password, kdf, backupDir); err != nil {
+			utils.Fatalf("Failed to migrate account %x: %v (original key files backed up in %s)", account.Address, err, backupDir)
+		}
+		fmt.Printf("Migrated account {%x}\n", account.Address)
+	}
+	fmt.Printf("Original key files backed up in %s\n", backupDir)
+	return nil
+}
+
+// accountExport writes an account into a key file encrypted with a new passphrase.
+func accountExport(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		utils.Fatalf("Address and key file must be given as arguments")
+	}
+	stack, _ := makeConfigNode(ctx)
+	ks := stack.AccountManager().Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+
+	passwords := utils.MakePasswordList(ctx)
+	account, password := unlockAccount(ctx, ks, ctx.Args()[0], 0, passwords)
+	newPassword := getPassPhrase("Please give a password for the exported key. Do not forget this password.", true, 1, passwords)
+
+	keyJSON, err := ks.Export(account, password, newPassword)
+	if err != nil {
+		utils.Fatalf("Failed to export account: %v", err)
+	}
+	if err := ioutil.WriteFile(ctx.Args()[1], keyJSON, 0600); err != nil {
+		utils.Fatalf("Failed to write key file: %v", err)
+	}
+	fmt.Printf("Exported account {%x} to %s\n", account.Address, ctx.Args()[1])
+	return nil
+}
+
 func importWallet(ctx *cli.Context) error {
 	keyfile := ctx.Args().First()
 	if len(keyfile) == 0 {
@@ -330,6 +508,9 @@ func accountImport(ctx *cli.Context) error {
 	if len(keyfile) == 0 {
 		utils.Fatalf("keyfile must be given as argument")
 	}
+	if ctx.Bool(mnemonicFlag.Name) {
+		return accountImportMnemonic(ctx, keyfile)
+	}
 	key, err := crypto.LoadECDSA(keyfile)
 	if err != nil {
 		utils.Fatalf("Failed to load the private key: %v", err)
@@ -345,3 +526,22 @@ func accountImport(ctx *cli.Context) error {
 	fmt.Printf("Address: {%x}\n", acct.Address)
 	return nil
 }
+
+// accountImportMnemonic imports the BIP-39 mnemonic contained in a file as a
+// seed, deriving its first account.
+func accountImportMnemonic(ctx *cli.Context, file string) error {
+	sentence, err := ioutil.ReadFile(file)
+	if err != nil {
+		utils.Fatalf("Failed to read the mnemonic: %v", err)
+	}
+	stack, _ := makeConfigNode(ctx)
+	passphrase := getPassPhrase("Your new account is locked with a password. Please give a password. Do not forget this password.", true, 0, utils.MakePasswordList(ctx))
+
+	ks := stack.AccountManager().Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+	acct, err := ks.ImportMnemonic(strings.TrimSpace(string(sentence)), getMnemonicPassphrase(ctx), passphrase)
+	if err != nil {
+		utils.Fatalf("Could not create the account: %v", err)
+	}
+	fmt.Printf("Address: {%x}\n", acct.Address)
+	return nil
+}
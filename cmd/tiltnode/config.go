@@ -141,6 +141,11 @@ func makeFullNode(ctx *cli.Context) *node.Node {
 		utils.RegisterTiltStatsService(stack, cfg.Tiltstats.URL)
 	}
 
+	// Add the GraphQL query service if requested.
+	if ctx.GlobalBool(utils.GraphQLEnabledFlag.Name) {
+		utils.RegisterGraphQLService(stack)
+	}
+
 	return stack
 }
 
@@ -55,6 +55,7 @@ var (
 		utils.DataDirFlag,
 		utils.KeyStoreDirFlag,
 		utils.NoUSBFlag,
+		utils.ExternalSignerFlag,
 		utils.TilthashCacheDirFlag,
 		utils.TilthashCachesInMemoryFlag,
 		utils.TilthashCachesOnDiskFlag,
@@ -97,11 +98,23 @@ var (
 		utils.RPCListenAddrFlag,
 		utils.RPCPortFlag,
 		utils.RPCApiFlag,
+		utils.RPCPathPrefixFlag,
+		utils.RPCSinglePortFlag,
+		utils.GraphQLEnabledFlag,
 		utils.WSEnabledFlag,
 		utils.WSListenAddrFlag,
 		utils.WSPortFlag,
 		utils.WSApiFlag,
 		utils.WSAllowedOriginsFlag,
+		utils.JWTSecretFlag,
+		utils.RPCRolesFlag,
+		utils.RPCCallTimeoutFlag,
+		utils.RPCBatchLimitFlag,
+		utils.RPCResponseLimitFlag,
+		utils.RPCConcurrencyFlag,
+		utils.RPCSafeDepthFlag,
+		utils.HealthMinPeersFlag,
+		utils.HealthMaxHeadAgeFlag,
 		utils.IPCDisabledFlag,
 		utils.IPCPathFlag,
 	}
@@ -216,6 +229,10 @@ func startNode(ctx *cli.Context, stack *node.Node) {
 		}
 		// Listen for wallet event till termination
 		for event := range events {
+			if event.Changed {
+				log.Info("Wallet changed", "url", event.Wallet.URL(), "status", event.Wallet.Status())
+				continue
+			}
 			if event.Arrive {
 				if err := event.Wallet.Open(""); err != nil {
 					log.Warn("New wallet appeared, failed to open", "url", event.Wallet.URL(), "err", err)
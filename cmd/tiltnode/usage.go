@@ -68,6 +68,7 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.DataDirFlag,
 			utils.KeyStoreDirFlag,
 			utils.NoUSBFlag,
+			utils.ExternalSignerFlag,
 			utils.NetworkIdFlag,
 			utils.TestnetFlag,
 			utils.DevModeFlag,
@@ -108,11 +109,23 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.RPCListenAddrFlag,
 			utils.RPCPortFlag,
 			utils.RPCApiFlag,
+			utils.RPCPathPrefixFlag,
+			utils.RPCSinglePortFlag,
+			utils.GraphQLEnabledFlag,
 			utils.WSEnabledFlag,
 			utils.WSListenAddrFlag,
 			utils.WSPortFlag,
 			utils.WSApiFlag,
 			utils.WSAllowedOriginsFlag,
+			utils.JWTSecretFlag,
+			utils.RPCRolesFlag,
+			utils.RPCCallTimeoutFlag,
+			utils.RPCBatchLimitFlag,
+			utils.RPCResponseLimitFlag,
+			utils.RPCConcurrencyFlag,
+			utils.RPCSafeDepthFlag,
+			utils.HealthMinPeersFlag,
+			utils.HealthMaxHeadAgeFlag,
 			utils.IPCDisabledFlag,
 			utils.IPCPathFlag,
 			utils.RPCCORSDomainFlag,
@@ -0,0 +1,157 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of go-tiltnet.
+//
+// go-tiltnet is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-tiltnet is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-tiltnet. If not, see <http://www.gnu.org/licenses/>.
+
+// tiltsigner is an external signer daemon holding the account keys outside of
+// tiltnode, approving every request interactively or through a rule set.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/keystore"
+	"github.com/megatilt/go-tilt/cmd/utils"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/console"
+	"github.com/megatilt/go-tilt/log"
+	"github.com/megatilt/go-tilt/node"
+	"github.com/megatilt/go-tilt/rpc"
+	"github.com/megatilt/go-tilt/signer"
+)
+
+func main() {
+	var (
+		keystoreDir = flag.String("keystore", filepath.Join(node.DefaultDataDir(), "keystore"), "directory of the keystore to sign with")
+		lightKDF    = flag.Bool("lightkdf", false, "reduce key-derivation RAM & CPU usage at some expense of KDF strength")
+		unlock      = flag.String("unlock", "", "comma separated list of accounts to unlock at startup (required for rule approved signing)")
+		ipcPath     = flag.String("ipcpath", filepath.Join(node.DefaultDataDir(), "tiltsigner.ipc"), "filename for the IPC socket/pipe (empty to disable)")
+		rpcEnabled  = flag.Bool("rpc", false, "enable the HTTP-RPC server")
+		rpcAddr     = flag.String("rpcaddr", "localhost", "HTTP-RPC server listening interface")
+		rpcPort     = flag.Int("rpcport", 8550, "HTTP-RPC server listening port")
+		rpcCors     = flag.String("rpccorsdomain", "", "comma separated list of domains from which to accept cross origin requests")
+		rulesFile   = flag.String("rules", "", "JavaScript rule set to automatically approve or reject requests with")
+		auditLog    = flag.String("auditlog", "audit.log", "file to write the audit log to (empty to disable)")
+		verbosity   = flag.Int("verbosity", int(log.LvlInfo), "log verbosity (0-9)")
+		vmodule     = flag.String("vmodule", "", "log verbosity pattern")
+	)
+	flag.Parse()
+
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(*verbosity))
+	glogger.Vmodule(*vmodule)
+	log.Root().SetHandler(glogger)
+
+	// Open the keystore and unlock any accounts requested
+	scryptN, scryptP := keystore.StandardScryptN, keystore.StandardScryptP
+	if *lightKDF {
+		scryptN, scryptP = keystore.LightScryptN, keystore.LightScryptP
+	}
+	ks := keystore.NewKeyStore(*keystoreDir, scryptN, scryptP)
+	am := accounts.NewManager(ks)
+
+	if *unlock != "" {
+		for _, addr := range strings.Split(*unlock, ",") {
+			unlockAccount(ks, strings.TrimSpace(addr))
+		}
+	}
+	// Assemble the approval frontend and the signing API
+	var ui signer.UI = signer.NewCommandlineUI()
+	if *rulesFile != "" {
+		rules, err := ioutil.ReadFile(*rulesFile)
+		if err != nil {
+			utils.Fatalf("Failed to read rules: %v", err)
+		}
+		if ui, err = signer.NewRulesetUI(ui, string(rules)); err != nil {
+			utils.Fatalf("%v", err)
+		}
+	}
+	var api signer.ExternalAPI = signer.NewSignerAPI(am, ui)
+	if *auditLog != "" {
+		audit, err := signer.NewAuditLogger(*auditLog, api)
+		if err != nil {
+			utils.Fatalf("Failed to open audit log: %v", err)
+		}
+		api = audit
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("account", api); err != nil {
+		utils.Fatalf("Failed to register signer API: %v", err)
+	}
+	defer server.Stop()
+
+	// Start the requested endpoints
+	if *ipcPath != "" {
+		listener, err := rpc.CreateIPCListener(*ipcPath)
+		if err != nil {
+			utils.Fatalf("Failed to start IPC endpoint: %v", err)
+		}
+		defer listener.Close()
+		go server.ServeListener(listener)
+
+		log.Info("IPC endpoint opened", "url", *ipcPath)
+	}
+	if *rpcEnabled {
+		endpoint := fmt.Sprintf("%s:%d", *rpcAddr, *rpcPort)
+		listener, err := net.Listen("tcp", endpoint)
+		if err != nil {
+			utils.Fatalf("Failed to start HTTP endpoint: %v", err)
+		}
+		defer listener.Close()
+
+		var cors []string
+		if *rpcCors != "" {
+			cors = strings.Split(*rpcCors, ",")
+		}
+		go rpc.NewHTTPServer(cors, server).Serve(listener)
+
+		log.Info("HTTP endpoint opened", "url", fmt.Sprintf("http://%s", endpoint))
+	}
+	// Serve requests until interrupted
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+
+	<-sigc
+	log.Info("Got interrupt, shutting down...")
+}
+
+// unlockAccount prompts for the password of an account and unlocks it in the
+// keystore indefinitely.
+func unlockAccount(ks *keystore.KeyStore, addr string) {
+	if !common.IsHexAddress(addr) {
+		utils.Fatalf("Invalid account address: %s", addr)
+	}
+	account, err := ks.Find(accounts.Account{Address: common.HexToAddress(addr)})
+	if err != nil {
+		utils.Fatalf("Failed to find account %s: %v", addr, err)
+	}
+	password, err := console.Stdin.PromptPassword(fmt.Sprintf("Password for %s: ", account.Address.Hex()))
+	if err != nil {
+		utils.Fatalf("Failed to read password: %v", err)
+	}
+	if err := ks.Unlock(account, password); err != nil {
+		utils.Fatalf("Failed to unlock account %s: %v", account.Address.Hex(), err)
+	}
+	log.Info("Unlocked account", "address", account.Address.Hex())
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/megatilt/go-tilt/core/vm"
 	"github.com/megatilt/go-tilt/crypto"
 	"github.com/megatilt/go-tilt/event"
+	"github.com/megatilt/go-tilt/graphql"
 	"github.com/megatilt/go-tilt/log"
 	"github.com/megatilt/go-tilt/metrics"
 	"github.com/megatilt/go-tilt/node"
@@ -112,6 +113,10 @@ var (
 		Name:  "keystore",
 		Usage: "Directory for the keystore (default = inside the datadir)",
 	}
+	ExternalSignerFlag = cli.StringFlag{
+		Name:  "signer",
+		Usage: "External signer (IPC path or HTTP/WebSocket URL) to forward account operations to",
+	}
 	NoUSBFlag = cli.BoolFlag{
 		Name:  "nousb",
 		Usage: "Disables monitoring for and managine USB hardware wallets",
@@ -170,7 +175,7 @@ var (
 	defaultSyncMode = tilt.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
-		Usage: `Blockchain sync mode`,
+		Usage: `Blockchain sync mode ("full" or "snap")`,
 		Value: &defaultSyncMode,
 	}
 
@@ -304,6 +309,60 @@ var (
 		Usage: "Origins from which to accept websockets requests",
 		Value: "",
 	}
+	RPCPathPrefixFlag = cli.StringFlag{
+		Name:  "rpcprefix",
+		Usage: "URL path prefix under which the HTTP-RPC API is served (e.g. /rpc)",
+		Value: "",
+	}
+	RPCSinglePortFlag = cli.BoolFlag{
+		Name:  "rpcsingleport",
+		Usage: "Serve the WS-RPC server on the HTTP-RPC listener, routing websocket upgrades to it (requires --rpc and --ws)",
+	}
+	GraphQLEnabledFlag = cli.BoolFlag{
+		Name:  "graphql",
+		Usage: "Enable the GraphQL query service on the HTTP-RPC server (requires --rpc)",
+	}
+	JWTSecretFlag = cli.StringFlag{
+		Name:  "jwtsecret",
+		Usage: "Path to a hex encoded secret authenticating HTTP-RPC and WS-RPC requests via JWT bearer tokens",
+		Value: "",
+	}
+	RPCRolesFlag = cli.StringFlag{
+		Name:  "rpcroles",
+		Usage: "API namespaces or methods granted to each JWT role (e.g. \"reader=tilt,net;ops=admin_peers\", \"*\" grants all)",
+		Value: "",
+	}
+	RPCCallTimeoutFlag = cli.DurationFlag{
+		Name:  "rpccalltimeout",
+		Usage: "Maximum execution time of a single HTTP-RPC and WS-RPC call (0 = no limit)",
+	}
+	RPCBatchLimitFlag = cli.IntFlag{
+		Name:  "rpcbatchlimit",
+		Usage: "Maximum number of requests in a single HTTP-RPC and WS-RPC batch (0 = no limit)",
+		Value: node.DefaultConfig.RPCBatchLimit,
+	}
+	RPCResponseLimitFlag = cli.IntFlag{
+		Name:  "rpcresponselimit",
+		Usage: "Maximum size in bytes of a single HTTP-RPC and WS-RPC response (0 = no limit)",
+		Value: node.DefaultConfig.RPCResponseSizeLimit,
+	}
+	RPCConcurrencyFlag = cli.IntFlag{
+		Name:  "rpcconcurrency",
+		Usage: "Maximum number of concurrently executing requests per WS-RPC connection (0 = no limit)",
+	}
+	RPCSafeDepthFlag = cli.Uint64Flag{
+		Name:  "rpcsafedepth",
+		Usage: "Number of confirmations after which the \"safe\" RPC block selector deems a block final",
+		Value: tilt.DefaultConfig.SafeBlockDepth,
+	}
+	HealthMinPeersFlag = cli.IntFlag{
+		Name:  "healthminpeers",
+		Usage: "Minimum number of peers for the /ready HTTP endpoint to report the node ready (0 = unchecked)",
+	}
+	HealthMaxHeadAgeFlag = cli.DurationFlag{
+		Name:  "healthmaxheadage",
+		Usage: "Maximum age of the head block for the /ready HTTP endpoint to report the node ready (0 = unchecked)",
+	}
 	ExecFlag = cli.StringFlag{
 		Name:  "exec",
 		Usage: "Execute JavaScript statement",
@@ -474,6 +533,28 @@ func splitAndTrim(input string) []string {
 	return result
 }
 
+// parseRoles converts a list of semicolon separated "role=rule,rule" entries into
+// the namespaces and methods granted to each JWT role.
+func parseRoles(input string) (map[string][]string, error) {
+	roles := make(map[string][]string)
+	for _, entry := range strings.Split(input, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		role := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || role == "" {
+			return nil, fmt.Errorf("invalid role entry %q, want role=rule,rule", entry)
+		}
+		for _, rule := range splitAndTrim(parts[1]) {
+			if rule != "" {
+				roles[role] = append(roles[role], rule)
+			}
+		}
+	}
+	return roles, nil
+}
+
 // setHTTP creates the HTTP RPC listener interface string from the set
 // command line flags, returning empty if the HTTP endpoint is disabled.
 func setHTTP(ctx *cli.Context, cfg *node.Config) {
@@ -493,6 +574,12 @@ func setHTTP(ctx *cli.Context, cfg *node.Config) {
 	if ctx.GlobalIsSet(RPCApiFlag.Name) {
 		cfg.HTTPModules = splitAndTrim(ctx.GlobalString(RPCApiFlag.Name))
 	}
+	if ctx.GlobalIsSet(RPCPathPrefixFlag.Name) {
+		cfg.HTTPPathPrefix = ctx.GlobalString(RPCPathPrefixFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCSinglePortFlag.Name) {
+		cfg.RPCSinglePort = ctx.GlobalBool(RPCSinglePortFlag.Name)
+	}
 }
 
 // setWS creates the WebSocket RPC listener interface string from the set
@@ -643,6 +730,32 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	setWS(ctx, cfg)
 	setNodeUserIdent(ctx, cfg)
 
+	if ctx.GlobalIsSet(JWTSecretFlag.Name) {
+		cfg.JWTSecret = ctx.GlobalString(JWTSecretFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCRolesFlag.Name) {
+		roles, err := parseRoles(ctx.GlobalString(RPCRolesFlag.Name))
+		if err != nil {
+			Fatalf("Option %q: %v", RPCRolesFlag.Name, err)
+		}
+		cfg.RPCRoles = roles
+	}
+	if ctx.GlobalIsSet(RPCCallTimeoutFlag.Name) {
+		cfg.RPCCallTimeout = ctx.GlobalDuration(RPCCallTimeoutFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCBatchLimitFlag.Name) {
+		cfg.RPCBatchLimit = ctx.GlobalInt(RPCBatchLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCResponseLimitFlag.Name) {
+		cfg.RPCResponseSizeLimit = ctx.GlobalInt(RPCResponseLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(RPCConcurrencyFlag.Name) {
+		cfg.RPCConcurrencyLimit = ctx.GlobalInt(RPCConcurrencyFlag.Name)
+	}
+	if ctx.GlobalIsSet(HealthMinPeersFlag.Name) {
+		cfg.HealthMinPeers = ctx.GlobalInt(HealthMinPeersFlag.Name)
+	}
+
 	switch {
 	case ctx.GlobalIsSet(DataDirFlag.Name):
 		cfg.DataDir = ctx.GlobalString(DataDirFlag.Name)
@@ -658,6 +771,9 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	if ctx.GlobalIsSet(NoUSBFlag.Name) {
 		cfg.NoUSB = ctx.GlobalBool(NoUSBFlag.Name)
 	}
+	if ctx.GlobalIsSet(ExternalSignerFlag.Name) {
+		cfg.ExternalSigner = ctx.GlobalString(ExternalSignerFlag.Name)
+	}
 }
 
 func setGPO(ctx *cli.Context, cfg *gasprice.Config) {
@@ -720,6 +836,12 @@ func SetTiltConfig(ctx *cli.Context, stack *node.Node, cfg *tilt.Config) {
 	if ctx.GlobalIsSet(NetworkIdFlag.Name) {
 		cfg.NetworkId = ctx.GlobalUint64(NetworkIdFlag.Name)
 	}
+	if ctx.GlobalIsSet(RPCSafeDepthFlag.Name) {
+		cfg.SafeBlockDepth = ctx.GlobalUint64(RPCSafeDepthFlag.Name)
+	}
+	if ctx.GlobalIsSet(HealthMaxHeadAgeFlag.Name) {
+		cfg.HealthMaxHeadAge = ctx.GlobalDuration(HealthMaxHeadAgeFlag.Name)
+	}
 
 	// Tiltnet needs to know maxPeers to calculate the light server peer ratio.
 	// TODO(fjl): ensure Tiltnet can get MaxPeers from node.
@@ -794,6 +916,20 @@ func RegisterTiltStatsService(stack *node.Node, url string) {
 	}
 }
 
+// RegisterGraphQLService configures the GraphQL query service, serving the data
+// of the Tiltnet service on the HTTP-RPC endpoint of the given node.
+func RegisterGraphQLService(stack *node.Node) {
+	if err := stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+		var tiltServ *tilt.Tiltnet
+		if err := ctx.Service(&tiltServ); err != nil {
+			return nil, err
+		}
+		return graphql.New(tiltServ.ApiBackend)
+	}); err != nil {
+		Fatalf("Failed to register the GraphQL service: %v", err)
+	}
+}
+
 // SetupNetwork configures the system for either the main net or some test network.
 func SetupNetwork(ctx *cli.Context) {
 	// TODO(fjl): move target gas limit into config
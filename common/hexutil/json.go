@@ -64,6 +64,19 @@ func (b *Bytes) UnmarshalText(input []byte) error {
 	return err
 }
 
+// ImplementsGraphQLType returns true if Bytes implements the specified GraphQL type.
+func (b Bytes) ImplementsGraphQLType(name string) bool { return name == "Bytes" }
+
+// UnmarshalGraphQL unmarshals the provided GraphQL query data.
+func (b *Bytes) UnmarshalGraphQL(input interface{}) error {
+	switch input := input.(type) {
+	case string:
+		return b.UnmarshalText([]byte(input))
+	default:
+		return fmt.Errorf("unexpected type %T for Bytes", input)
+	}
+}
+
 // String returns the hex encoding of b.
 func (b Bytes) String() string {
 	return Encode(b)
@@ -169,6 +182,22 @@ func (b *Big) ToInt() *big.Int {
 	return (*big.Int)(b)
 }
 
+// ImplementsGraphQLType returns true if Big implements the provided GraphQL type.
+func (b Big) ImplementsGraphQLType(name string) bool { return name == "BigInt" }
+
+// UnmarshalGraphQL unmarshals the provided GraphQL query data.
+func (b *Big) UnmarshalGraphQL(input interface{}) error {
+	switch input := input.(type) {
+	case string:
+		return b.UnmarshalText([]byte(input))
+	case int32:
+		b.ToInt().SetInt64(int64(input))
+		return nil
+	default:
+		return fmt.Errorf("unexpected type %T for BigInt", input)
+	}
+}
+
 // String returns the hex encoding of b.
 func (b *Big) String() string {
 	return EncodeBig(b.ToInt())
@@ -77,6 +77,19 @@ func (h Hash) MarshalText() ([]byte, error) {
 	return hexutil.Bytes(h[:]).MarshalText()
 }
 
+// ImplementsGraphQLType returns true if Hash implements the specified GraphQL type.
+func (h Hash) ImplementsGraphQLType(name string) bool { return name == "Bytes32" }
+
+// UnmarshalGraphQL unmarshals the provided GraphQL query data.
+func (h *Hash) UnmarshalGraphQL(input interface{}) error {
+	switch input := input.(type) {
+	case string:
+		return h.UnmarshalText([]byte(input))
+	default:
+		return fmt.Errorf("unexpected type %T for Hash", input)
+	}
+}
+
 // Sets the hash to the value of b. If b is larger than len(h) it will panic
 func (h *Hash) SetBytes(b []byte) {
 	if len(b) > len(h) {
@@ -194,6 +207,19 @@ func (a *Address) UnmarshalText(input []byte) error {
 	return hexutil.UnmarshalFixedText("Address", input, a[:])
 }
 
+// ImplementsGraphQLType returns true if Address implements the specified GraphQL type.
+func (a Address) ImplementsGraphQLType(name string) bool { return name == "Address" }
+
+// UnmarshalGraphQL unmarshals the provided GraphQL query data.
+func (a *Address) UnmarshalGraphQL(input interface{}) error {
+	switch input := input.(type) {
+	case string:
+		return a.UnmarshalText([]byte(input))
+	default:
+		return fmt.Errorf("unexpected type %T for Address", input)
+	}
+}
+
 // UnprefixedHash allows marshaling an Address without 0x prefix.
 type UnprefixedAddress Address
 
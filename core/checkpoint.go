@@ -0,0 +1,267 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/crypto/sha3"
+	"github.com/megatilt/go-tilt/event"
+	"github.com/megatilt/go-tilt/log"
+	"github.com/megatilt/go-tilt/params"
+	"github.com/megatilt/go-tilt/rlp"
+	"github.com/megatilt/go-tilt/tiltdb"
+	"github.com/megatilt/go-tilt/trie"
+)
+
+var (
+	checkpointPrefix     = []byte("checkpoint-")     // checkpointPrefix + section (uint64 big endian) -> checkpoint
+	checkpointCountKey   = []byte("CheckpointCount") // number of sections indexed
+	checkpointTriePrefix = "checkpoint-trie-"        // checkpointTriePrefix + hash -> CHT and BloomTrie nodes
+)
+
+// chtEntry is the value stored in the canonical hash trie for each block.
+type chtEntry struct {
+	Hash common.Hash
+	Td   *big.Int
+}
+
+// CheckpointIndexer computes the trusted checkpoints of the local canonical
+// chain section by section as new blocks arrive, persisting the results so
+// they can be published by the operator.
+//
+// For every section the canonical hash trie (CHT) maps block numbers to their
+// hash and total difficulty, whereas the BloomTrie maps section indexes to the
+// hash of the section's concatenated header blooms. Both tries are cumulative,
+// each section extending the tries of the previous one.
+type CheckpointIndexer struct {
+	chain *BlockChain
+	db    tiltdb.Database // Database holding the chain and the indexed checkpoints
+	tries tiltdb.Database // Database section holding the CHT and BloomTrie nodes
+
+	lock   sync.Mutex    // Lock preventing concurrent updates
+	update chan struct{} // Notification channel to trigger an update
+	quit   chan struct{} // Channel to terminate the background updates
+}
+
+// NewCheckpointIndexer creates a checkpoint indexer tracking the given chain,
+// updating it every time a new head block is announced on the event mux.
+func NewCheckpointIndexer(chain *BlockChain, db tiltdb.Database, mux *event.TypeMux) *CheckpointIndexer {
+	c := &CheckpointIndexer{
+		chain:  chain,
+		db:     db,
+		tries:  tiltdb.NewTable(db, checkpointTriePrefix),
+		update: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+	}
+	sub := mux.Subscribe(ChainHeadEvent{})
+	go c.eventLoop(sub)
+	go c.updateLoop()
+	return c
+}
+
+// eventLoop forwards new head announcements to the update loop. It never blocks
+// on the indexing itself, as that would stall every poster of the event mux.
+func (c *CheckpointIndexer) eventLoop(sub *event.TypeMuxSubscription) {
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case _, ok := <-sub.Chan():
+			if !ok {
+				return
+			}
+			// Coalesce multiple heads arriving during a running update into one
+			select {
+			case c.update <- struct{}{}:
+			default:
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// updateLoop keeps the checkpoints updated with the head of the chain.
+func (c *CheckpointIndexer) updateLoop() {
+	// Index anything missed while the node was offline
+	if err := c.Update(); err != nil {
+		log.Error("Failed to update checkpoints", "err", err)
+	}
+	for {
+		select {
+		case <-c.update:
+			if err := c.Update(); err != nil {
+				log.Error("Failed to update checkpoints", "err", err)
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// Stop terminates the indexer's background updates.
+func (c *CheckpointIndexer) Stop() {
+	close(c.quit)
+}
+
+// Latest returns the checkpoint of the most recently indexed section, or nil
+// if not enough blocks are available to complete a section yet.
+func (c *CheckpointIndexer) Latest() *params.TrustedCheckpoint {
+	count := c.sections()
+	if count == 0 {
+		return nil
+	}
+	return c.checkpoint(count - 1)
+}
+
+// Update indexes all the sections of the canonical chain that gathered enough
+// confirmations, first reverting any sections invalidated by a reorg.
+func (c *CheckpointIndexer) Update() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Drop any sections no longer part of the canonical chain
+	count := c.sections()
+	for count > 0 {
+		cp := c.checkpoint(count - 1)
+		if cp != nil && GetCanonicalHash(c.db, cp.HeadNumber()) == cp.SectionHead {
+			break
+		}
+		count--
+	}
+	if err := c.writeSections(count); err != nil {
+		return err
+	}
+	// Index every confirmed section not yet processed
+	head := c.chain.CurrentBlock().NumberU64()
+	for {
+		last := (count+1)*params.CheckpointSectionSize - 1
+		if head < last+params.CheckpointConfirmations {
+			break
+		}
+		prev := new(params.TrustedCheckpoint)
+		if count > 0 {
+			if prev = c.checkpoint(count - 1); prev == nil {
+				return fmt.Errorf("checkpoint of section %d missing", count-1)
+			}
+		}
+		cp, err := c.process(count, prev)
+		if err != nil {
+			return err
+		}
+		if err := c.writeCheckpoint(cp); err != nil {
+			return err
+		}
+		count++
+		if err := c.writeSections(count); err != nil {
+			return err
+		}
+		log.Info("Computed new checkpoint", "section", cp.SectionIndex, "head", cp.SectionHead, "cht", cp.CHTRoot, "bloom", cp.BloomRoot)
+	}
+	return nil
+}
+
+// process extends the tries of the previous checkpoint with the given section.
+func (c *CheckpointIndexer) process(section uint64, prev *params.TrustedCheckpoint) (*params.TrustedCheckpoint, error) {
+	cht, err := trie.New(prev.CHTRoot, c.tries)
+	if err != nil {
+		return nil, err
+	}
+	bloomTrie, err := trie.New(prev.BloomRoot, c.tries)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		hasher = sha3.NewKeccak256()
+		head   common.Hash
+	)
+	for number := section * params.CheckpointSectionSize; number < (section+1)*params.CheckpointSectionSize; number++ {
+		hash := GetCanonicalHash(c.db, number)
+		if hash == (common.Hash{}) {
+			return nil, fmt.Errorf("canonical block #%d unknown", number)
+		}
+		header, td := GetHeader(c.db, hash, number), GetTd(c.db, hash, number)
+		if header == nil || td == nil {
+			return nil, fmt.Errorf("block #%d [%x…] incomplete", number, hash[:4])
+		}
+		entry, err := rlp.EncodeToBytes(chtEntry{Hash: hash, Td: td})
+		if err != nil {
+			return nil, err
+		}
+		cht.Update(encodeBlockNumber(number), entry)
+		hasher.Write(header.Bloom.Bytes())
+		head = hash
+	}
+	bloomTrie.Update(encodeBlockNumber(section), hasher.Sum(nil))
+
+	chtRoot, err := cht.CommitTo(c.tries)
+	if err != nil {
+		return nil, err
+	}
+	bloomRoot, err := bloomTrie.CommitTo(c.tries)
+	if err != nil {
+		return nil, err
+	}
+	return &params.TrustedCheckpoint{
+		SectionIndex: section,
+		SectionHead:  head,
+		CHTRoot:      chtRoot,
+		BloomRoot:    bloomRoot,
+	}, nil
+}
+
+// sections retrieves the number of sections indexed so far.
+func (c *CheckpointIndexer) sections() uint64 {
+	data, _ := c.db.Get(checkpointCountKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// writeSections stores the number of sections indexed so far.
+func (c *CheckpointIndexer) writeSections(count uint64) error {
+	return c.db.Put(checkpointCountKey, encodeBlockNumber(count))
+}
+
+// checkpoint retrieves the stored checkpoint of the given section.
+func (c *CheckpointIndexer) checkpoint(section uint64) *params.TrustedCheckpoint {
+	data, _ := c.db.Get(append(append([]byte{}, checkpointPrefix...), encodeBlockNumber(section)...))
+	if len(data) == 0 {
+		return nil
+	}
+	cp := new(params.TrustedCheckpoint)
+	if err := rlp.DecodeBytes(data, cp); err != nil {
+		log.Error("Invalid checkpoint RLP", "section", section, "err", err)
+		return nil
+	}
+	return cp
+}
+
+// writeCheckpoint stores the checkpoint of a section.
+func (c *CheckpointIndexer) writeCheckpoint(cp *params.TrustedCheckpoint) error {
+	data, err := rlp.EncodeToBytes(cp)
+	if err != nil {
+		return err
+	}
+	return c.db.Put(append(append([]byte{}, checkpointPrefix...), encodeBlockNumber(cp.SectionIndex)...), data)
+}
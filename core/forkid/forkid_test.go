@@ -0,0 +1,142 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package forkid
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/params"
+	"github.com/megatilt/go-tilt/rlp"
+)
+
+// testForks is a fork schedule exercising the checksum and validation rules, as
+// the chain configs themselves don't schedule any forks yet.
+var testForks = []uint64{1150000, 1920000, 2463000, 2675000, 4370000, 7280000, 9069000, 9200000}
+
+// Tests that the fork IDs are calculated correctly before, at and after each
+// fork transition.
+func TestCreation(t *testing.T) {
+	tests := []struct {
+		head uint64
+		want ID
+	}{
+		{0, ID{Hash: checksumToBytes(0xfc64ec04), Next: 1150000}},       // Unsynced
+		{1149999, ID{Hash: checksumToBytes(0xfc64ec04), Next: 1150000}}, // Last genesis block
+		{1150000, ID{Hash: checksumToBytes(0x97c2c34c), Next: 1920000}}, // First fork block
+		{1919999, ID{Hash: checksumToBytes(0x97c2c34c), Next: 1920000}},
+		{1920000, ID{Hash: checksumToBytes(0x91d1f948), Next: 2463000}},
+		{2462999, ID{Hash: checksumToBytes(0x91d1f948), Next: 2463000}},
+		{2463000, ID{Hash: checksumToBytes(0x7a64da13), Next: 2675000}},
+		{2674999, ID{Hash: checksumToBytes(0x7a64da13), Next: 2675000}},
+		{2675000, ID{Hash: checksumToBytes(0x3edd5b10), Next: 4370000}},
+		{4369999, ID{Hash: checksumToBytes(0x3edd5b10), Next: 4370000}},
+		{4370000, ID{Hash: checksumToBytes(0xa00bc324), Next: 7280000}},
+		{7279999, ID{Hash: checksumToBytes(0xa00bc324), Next: 7280000}},
+		{7280000, ID{Hash: checksumToBytes(0x668db0af), Next: 9069000}},
+		{9068999, ID{Hash: checksumToBytes(0x668db0af), Next: 9069000}},
+		{9069000, ID{Hash: checksumToBytes(0x879d6e30), Next: 9200000}},
+		{9199999, ID{Hash: checksumToBytes(0x879d6e30), Next: 9200000}},
+		{9200000, ID{Hash: checksumToBytes(0xe029e991), Next: 0}},  // Last fork block
+		{10000000, ID{Hash: checksumToBytes(0xe029e991), Next: 0}}, // Future block
+	}
+	for i, tt := range tests {
+		if have := newID(testForks, params.MainNetGenesisHash, tt.head); have != tt.want {
+			t.Errorf("test %d: fork ID mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that the fork ID of the configured networks is the genesis checksum,
+// as long as no forks are scheduled.
+func TestCreationNoForks(t *testing.T) {
+	if forks := gatherForks(params.MainnetChainConfig); len(forks) != 0 {
+		t.Fatalf("unexpected mainnet forks: %v", forks)
+	}
+	want := ID{Hash: checksumToBytes(0xfc64ec04), Next: 0}
+	if have := newID(gatherForks(params.MainnetChainConfig), params.MainNetGenesisHash, 10000000); have != want {
+		t.Errorf("fork ID mismatch: have %x, want %x", have, want)
+	}
+}
+
+// Tests that remote fork IDs are accepted or rejected based on the local head
+// and fork schedule.
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		head uint64
+		id   ID
+		err  error
+	}{
+		// Local and remote are in the same fork state, no future forks known by either
+		{7987396, ID{Hash: checksumToBytes(0x668db0af), Next: 0}, nil},
+
+		// Local and remote are in the same fork state, remote announces the next
+		// local fork or a fork unknown locally but not yet passed
+		{7279999, ID{Hash: checksumToBytes(0xa00bc324), Next: 7280000}, nil},
+		{7279999, ID{Hash: checksumToBytes(0xa00bc324), Next: math.MaxUint64}, nil},
+
+		// Local is ahead, remote is syncing and announces the next fork correctly
+		{7987396, ID{Hash: checksumToBytes(0xa00bc324), Next: 7280000}, nil},
+		{7987396, ID{Hash: checksumToBytes(0x3edd5b10), Next: 4370000}, nil},
+
+		// Local is ahead, remote is stale: it doesn't know of a fork we passed
+		{7987396, ID{Hash: checksumToBytes(0xa00bc324), Next: 0}, ErrRemoteStale},
+		{7987396, ID{Hash: checksumToBytes(0x3edd5b10), Next: 4370001}, ErrRemoteStale},
+
+		// Local is behind, remote already passed forks we know of but haven't reached
+		{7279999, ID{Hash: checksumToBytes(0x668db0af), Next: 0}, nil},
+		{4369999, ID{Hash: checksumToBytes(0x879d6e30), Next: 9200000}, nil},
+
+		// Remote announces a future fork we already passed without knowing about it
+		{7987396, ID{Hash: checksumToBytes(0x668db0af), Next: 7987396}, ErrLocalIncompatibleOrStale},
+		{10000000, ID{Hash: checksumToBytes(0xe029e991), Next: 9500000}, ErrLocalIncompatibleOrStale},
+
+		// Remote is on a different chain altogether
+		{7987396, ID{Hash: checksumToBytes(0xafec6b27), Next: 0}, ErrLocalIncompatibleOrStale},
+		{0, ID{Hash: checksumToBytes(0xafec6b27), Next: 1150000}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		filter := newFilter(testForks, params.MainNetGenesisHash, func() uint64 { return tt.head })
+		if err := filter(tt.id); err != tt.err {
+			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that fork IDs are RLP encoded as expected by the handshake.
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		id   ID
+		want []byte
+	}{
+		{ID{Hash: checksumToBytes(0), Next: 0}, common.Hex2Bytes("c6840000000080")},
+		{ID{Hash: checksumToBytes(0xdeadbeef), Next: 0xBADDCAFE}, common.Hex2Bytes("ca84deadbeef84baddcafe")},
+		{ID{Hash: checksumToBytes(math.MaxUint32), Next: math.MaxUint64}, common.Hex2Bytes("ce84ffffffff88ffffffffffffffff")},
+	}
+	for i, tt := range tests {
+		have, err := rlp.EncodeToBytes(tt.id)
+		if err != nil {
+			t.Errorf("test %d: failed to encode fork ID: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(have, tt.want) {
+			t.Errorf("test %d: RLP mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+}
@@ -127,14 +127,15 @@ func NewStateTransition(tiltvm *vm.TiltVM, msg Message, gp *GasPool) *StateTrans
 // against the old state within the environment.
 //
 // ApplyMessage returns the bytes returned by any TiltVM execution (if it took place),
-// the gas used (which includes gas refunds) and an error if it failed. An error always
-// indicates a core error meaning that the message would always fail for that particular
-// state and would never be accepted within a block.
-func ApplyMessage(tiltvm *vm.TiltVM, msg Message, gp *GasPool) ([]byte, *big.Int, error) {
+// the gas used (which includes gas refunds), whether the execution failed and an
+// error if it couldn't be applied. An error always indicates a core error meaning
+// that the message would always fail for that particular state and would never be
+// accepted within a block.
+func ApplyMessage(tiltvm *vm.TiltVM, msg Message, gp *GasPool) ([]byte, *big.Int, bool, error) {
 	st := NewStateTransition(tiltvm, msg, gp)
 
-	ret, _, gasUsed, err := st.TransitionDb()
-	return ret, gasUsed, err
+	ret, _, gasUsed, failed, err := st.TransitionDb()
+	return ret, gasUsed, failed, err
 }
 
 func (self *StateTransition) from() vm.AccountRef {
@@ -211,7 +212,7 @@ func (self *StateTransition) preCheck() error {
 // TransitionDb will transition the state by applying the current message and returning the result
 // including the required gas for the operation as well as the used gas. It returns an error if it
 // failed. An error indicates a consensus issue.
-func (self *StateTransition) TransitionDb() (ret []byte, requiredGas, usedGas *big.Int, err error) {
+func (self *StateTransition) TransitionDb() (ret []byte, requiredGas, usedGas *big.Int, failed bool, err error) {
 	if err = self.preCheck(); err != nil {
 		return
 	}
@@ -223,10 +224,10 @@ func (self *StateTransition) TransitionDb() (ret []byte, requiredGas, usedGas *b
 	// TODO convert to uint64
 	intrinsicGas := IntrinsicGas(self.data, contractCreation)
 	if intrinsicGas.BitLen() > 64 {
-		return nil, nil, nil, vm.ErrOutOfGas
+		return nil, nil, nil, false, vm.ErrOutOfGas
 	}
 	if err = self.useGas(intrinsicGas.Uint64()); err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, false, err
 	}
 
 	var (
@@ -244,12 +245,12 @@ func (self *StateTransition) TransitionDb() (ret []byte, requiredGas, usedGas *b
 		ret, self.gas, vmerr = tiltvm.Call(sender, self.to().Address(), self.data, self.gas, self.value)
 	}
 	if vmerr != nil {
-		log.Debug("VM returned with error", "err", err)
+		log.Debug("VM returned with error", "err", vmerr)
 		// The only possible consensus-error would be if there wasn't
 		// sufficient balance to make the transfer happen. The first
 		// balance transfer may never fail.
 		if vmerr == vm.ErrInsufficientBalance {
-			return nil, nil, nil, vmerr
+			return nil, nil, nil, false, vmerr
 		}
 	}
 	requiredGas = new(big.Int).Set(self.gasUsed())
@@ -257,7 +258,7 @@ func (self *StateTransition) TransitionDb() (ret []byte, requiredGas, usedGas *b
 	self.refundGas()
 	self.state.AddBalance(self.tiltvm.Coinbase, new(big.Int).Mul(self.gasUsed(), self.gasPrice))
 
-	return ret, requiredGas, self.gasUsed(), err
+	return ret, requiredGas, self.gasUsed(), vmerr != nil, err
 }
 
 func (self *StateTransition) refundGas() {
@@ -28,6 +28,19 @@ import (
 
 //go:generate gencodec -type Receipt -field-override receiptMarshaling -out gen_receipt_json.go
 
+const (
+	// ReceiptStatusUnknown is the status of receipts whose execution outcome is
+	// not known locally, such as the ones retrieved from the network or stored
+	// by older versions. The status is not part of the consensus fields.
+	ReceiptStatusUnknown = uint(0)
+
+	// ReceiptStatusFailed is the status of receipts whose execution failed.
+	ReceiptStatusFailed = uint(1)
+
+	// ReceiptStatusSuccessful is the status of receipts whose execution succeeded.
+	ReceiptStatusSuccessful = uint(2)
+)
+
 // Receipt represents the results of a transaction.
 type Receipt struct {
 	// Consensus fields
@@ -40,12 +53,14 @@ type Receipt struct {
 	TxHash          common.Hash    `json:"transactionHash" gencodec:"required"`
 	ContractAddress common.Address `json:"contractAddress"`
 	GasUsed         *big.Int       `json:"gasUsed" gencodec:"required"`
+	Status          uint           `json:"status"`
 }
 
 type receiptMarshaling struct {
 	PostState         hexutil.Bytes
 	CumulativeGasUsed *hexutil.Big
 	GasUsed           *hexutil.Big
+	Status            hexutil.Uint
 }
 
 // NewReceipt creates a barebone transaction receipt, copying the init fields.
@@ -91,7 +106,7 @@ func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
 	for i, log := range r.Logs {
 		logs[i] = (*LogForStorage)(log)
 	}
-	return rlp.Encode(w, []interface{}{r.PostState, r.CumulativeGasUsed, r.Bloom, r.TxHash, r.ContractAddress, logs, r.GasUsed})
+	return rlp.Encode(w, []interface{}{r.PostState, r.CumulativeGasUsed, r.Bloom, r.TxHash, r.ContractAddress, logs, r.GasUsed, r.Status})
 }
 
 // DecodeRLP implements rlp.Decoder, and loads both consensus and implementation
@@ -105,6 +120,7 @@ func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
 		ContractAddress   common.Address
 		Logs              []*LogForStorage
 		GasUsed           *big.Int
+		Status            []uint `rlp:"tail"` // Missing from receipts stored by older versions
 	}
 	if err := s.Decode(&receipt); err != nil {
 		return err
@@ -117,6 +133,9 @@ func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
 	}
 	// Assign the implementation fields
 	r.TxHash, r.ContractAddress, r.GasUsed = receipt.TxHash, receipt.ContractAddress, receipt.GasUsed
+	if len(receipt.Status) > 0 {
+		r.Status = receipt.Status[0]
+	}
 
 	return nil
 }
@@ -0,0 +1,957 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package graphql provides a GraphQL interface to Tiltnet node data.
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/core"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/core/vm"
+	"github.com/megatilt/go-tilt/internal/tiltapi"
+	"github.com/megatilt/go-tilt/rpc"
+	"github.com/megatilt/go-tilt/tilt/filters"
+)
+
+// maxQueryBlocks is the maximum number of blocks a single blocks query may span.
+const maxQueryBlocks = 1024
+
+var (
+	errBlockNotFound       = errors.New("block not found")
+	errTransactionNotFound = errors.New("transaction not found")
+	errNegativeBlock       = errors.New("negative block number")
+	errTooManyBlocks       = fmt.Errorf("too many blocks requested (max %d)", maxQueryBlocks)
+)
+
+// Long is a 64 bit unsigned integer, accepting both decimal and 0x-prefixed
+// hexadecimal input.
+type Long int64
+
+// ImplementsGraphQLType returns true if Long implements the provided GraphQL type.
+func (b Long) ImplementsGraphQLType(name string) bool { return name == "Long" }
+
+// UnmarshalGraphQL unmarshals the provided GraphQL query data, rejecting
+// negative values.
+func (b *Long) UnmarshalGraphQL(input interface{}) error {
+	var value int64
+	switch input := input.(type) {
+	case string:
+		var err error
+		if strings.HasPrefix(input, "0x") || strings.HasPrefix(input, "0X") {
+			value, err = strconv.ParseInt(input[2:], 16, 64)
+		} else {
+			value, err = strconv.ParseInt(input, 10, 64)
+		}
+		if err != nil {
+			return err
+		}
+	case int32:
+		value = int64(input)
+	case int64:
+		value = input
+	case float64:
+		value = int64(input)
+	default:
+		return fmt.Errorf("unexpected type %T for Long", input)
+	}
+	if value < 0 {
+		return fmt.Errorf("negative value %d for Long", value)
+	}
+	*b = Long(value)
+	return nil
+}
+
+// Account represents a Tiltnet account at a particular block.
+type Account struct {
+	backend     tiltapi.Backend
+	address     common.Address
+	blockNumber rpc.BlockNumber
+}
+
+// getState fetches the state of the block the account is looked up at.
+func (a *Account) getState(ctx context.Context) (tiltapi.State, error) {
+	state, _, err := a.backend.StateAndHeaderByNumber(ctx, a.blockNumber)
+	if state == nil && err == nil {
+		err = errBlockNotFound
+	}
+	return state, err
+}
+
+func (a *Account) Address(ctx context.Context) (common.Address, error) {
+	return a.address, nil
+}
+
+func (a *Account) Balance(ctx context.Context) (hexutil.Big, error) {
+	state, err := a.getState(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	balance, err := state.GetBalance(ctx, a.address)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return hexutil.Big(*balance), nil
+}
+
+func (a *Account) TransactionCount(ctx context.Context) (Long, error) {
+	state, err := a.getState(ctx)
+	if err != nil {
+		return 0, err
+	}
+	nonce, err := state.GetNonce(ctx, a.address)
+	return Long(nonce), err
+}
+
+func (a *Account) Code(ctx context.Context) (hexutil.Bytes, error) {
+	state, err := a.getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	code, err := state.GetCode(ctx, a.address)
+	return hexutil.Bytes(code), err
+}
+
+func (a *Account) Storage(ctx context.Context, args struct{ Slot common.Hash }) (common.Hash, error) {
+	state, err := a.getState(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return state.GetState(ctx, a.address, args.Slot)
+}
+
+// BlockNumberArgs encapsulates arguments to accessors that specify a block number.
+type BlockNumberArgs struct {
+	Block *Long
+}
+
+// number returns the provided block number, or rpc.LatestBlockNumber if none
+// was provided.
+func (a BlockNumberArgs) number() rpc.BlockNumber {
+	if a.Block != nil {
+		return rpc.BlockNumber(*a.Block)
+	}
+	return rpc.LatestBlockNumber
+}
+
+// Log represents an individual log entry emitted by a transaction.
+type Log struct {
+	backend     tiltapi.Backend
+	transaction *Transaction
+	log         *types.Log
+}
+
+func (l *Log) Transaction(ctx context.Context) *Transaction {
+	return l.transaction
+}
+
+func (l *Log) Account(ctx context.Context, args BlockNumberArgs) *Account {
+	return &Account{
+		backend:     l.backend,
+		address:     l.log.Address,
+		blockNumber: args.number(),
+	}
+}
+
+func (l *Log) Index(ctx context.Context) int32 {
+	return int32(l.log.Index)
+}
+
+func (l *Log) Topics(ctx context.Context) []common.Hash {
+	return l.log.Topics
+}
+
+func (l *Log) Data(ctx context.Context) hexutil.Bytes {
+	return hexutil.Bytes(l.log.Data)
+}
+
+// Transaction represents a Tiltnet transaction, either mined or pending.
+type Transaction struct {
+	backend tiltapi.Backend
+	hash    common.Hash
+	tx      *types.Transaction
+	block   *Block // Block the transaction was mined in (nil = pending)
+	index   uint64 // Index of the transaction in its block
+
+	lock sync.Mutex // Lock protecting the lazily resolved fields
+}
+
+// resolve returns the internal transaction object, fetching it from the chain
+// or the transaction pool if needed.
+func (t *Transaction) resolve(ctx context.Context) (*types.Transaction, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.tx == nil {
+		if tx, blockHash, _, index := core.GetTransaction(t.backend.ChainDb(), t.hash); tx != nil {
+			t.tx = tx
+			t.block = &Block{backend: t.backend, hash: blockHash}
+			t.index = index
+		} else {
+			t.tx = t.backend.GetPoolTransaction(t.hash)
+		}
+	}
+	if t.tx == nil {
+		return nil, errTransactionNotFound
+	}
+	return t.tx, nil
+}
+
+// getReceipt returns the receipt of a mined transaction, or nil if the
+// transaction is still pending.
+func (t *Transaction) getReceipt(ctx context.Context) (*types.Receipt, error) {
+	if _, err := t.resolve(ctx); err != nil || t.block == nil {
+		return nil, err
+	}
+	receipts, err := t.block.resolveReceipts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t.index >= uint64(len(receipts)) {
+		return nil, nil
+	}
+	return receipts[t.index], nil
+}
+
+func (t *Transaction) Hash(ctx context.Context) common.Hash {
+	return t.hash
+}
+
+func (t *Transaction) Nonce(ctx context.Context) (Long, error) {
+	tx, err := t.resolve(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return Long(tx.Nonce()), nil
+}
+
+func (t *Transaction) Index(ctx context.Context) (*int32, error) {
+	if _, err := t.resolve(ctx); err != nil || t.block == nil {
+		return nil, err
+	}
+	index := int32(t.index)
+	return &index, nil
+}
+
+func (t *Transaction) From(ctx context.Context, args BlockNumberArgs) (*Account, error) {
+	tx, err := t.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var signer types.Signer = types.HoldemSigner{}
+	if tx.Protected() {
+		signer = types.NewTiltSigner(tx.ChainId())
+	}
+	from, _ := types.Sender(signer, tx)
+
+	return &Account{
+		backend:     t.backend,
+		address:     from,
+		blockNumber: args.number(),
+	}, nil
+}
+
+func (t *Transaction) To(ctx context.Context, args BlockNumberArgs) (*Account, error) {
+	tx, err := t.resolve(ctx)
+	if err != nil || tx.To() == nil {
+		return nil, err
+	}
+	return &Account{
+		backend:     t.backend,
+		address:     *tx.To(),
+		blockNumber: args.number(),
+	}, nil
+}
+
+func (t *Transaction) Value(ctx context.Context) (hexutil.Big, error) {
+	tx, err := t.resolve(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return hexutil.Big(*tx.Value()), nil
+}
+
+func (t *Transaction) GasPrice(ctx context.Context) (hexutil.Big, error) {
+	tx, err := t.resolve(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return hexutil.Big(*tx.GasPrice()), nil
+}
+
+func (t *Transaction) Gas(ctx context.Context) (hexutil.Big, error) {
+	tx, err := t.resolve(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return hexutil.Big(*tx.Gas()), nil
+}
+
+func (t *Transaction) InputData(ctx context.Context) (hexutil.Bytes, error) {
+	tx, err := t.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(tx.Data()), nil
+}
+
+func (t *Transaction) Block(ctx context.Context) (*Block, error) {
+	if _, err := t.resolve(ctx); err != nil {
+		return nil, err
+	}
+	return t.block, nil
+}
+
+func (t *Transaction) GasUsed(ctx context.Context) (*hexutil.Big, error) {
+	receipt, err := t.getReceipt(ctx)
+	if err != nil || receipt == nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(receipt.GasUsed), nil
+}
+
+func (t *Transaction) CumulativeGasUsed(ctx context.Context) (*hexutil.Big, error) {
+	receipt, err := t.getReceipt(ctx)
+	if err != nil || receipt == nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(receipt.CumulativeGasUsed), nil
+}
+
+func (t *Transaction) Status(ctx context.Context) (*Long, error) {
+	receipt, err := t.getReceipt(ctx)
+	if err != nil || receipt == nil {
+		return nil, err
+	}
+	var status Long
+	switch receipt.Status {
+	case types.ReceiptStatusSuccessful:
+		status = 1
+	case types.ReceiptStatusFailed:
+		status = 0
+	default:
+		return nil, nil
+	}
+	return &status, nil
+}
+
+func (t *Transaction) CreatedContract(ctx context.Context, args BlockNumberArgs) (*Account, error) {
+	receipt, err := t.getReceipt(ctx)
+	if err != nil || receipt == nil || receipt.ContractAddress == (common.Address{}) {
+		return nil, err
+	}
+	return &Account{
+		backend:     t.backend,
+		address:     receipt.ContractAddress,
+		blockNumber: args.number(),
+	}, nil
+}
+
+func (t *Transaction) Logs(ctx context.Context) (*[]*Log, error) {
+	receipt, err := t.getReceipt(ctx)
+	if err != nil || receipt == nil {
+		return nil, err
+	}
+	logs := make([]*Log, 0, len(receipt.Logs))
+	for _, log := range receipt.Logs {
+		logs = append(logs, &Log{
+			backend:     t.backend,
+			transaction: t,
+			log:         log,
+		})
+	}
+	return &logs, nil
+}
+
+// Block represents a Tiltnet block, identified either by number or by hash.
+type Block struct {
+	backend  tiltapi.Backend
+	num      *rpc.BlockNumber
+	hash     common.Hash
+	block    *types.Block
+	receipts types.Receipts
+
+	lock sync.Mutex // Lock protecting the lazily resolved fields
+}
+
+// resolve returns the internal block object, fetching it if needed.
+func (b *Block) resolve(ctx context.Context) (*types.Block, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.block != nil {
+		return b.block, nil
+	}
+	var err error
+	if b.num != nil {
+		b.block, err = b.backend.BlockByNumber(ctx, *b.num)
+	} else {
+		b.block, err = b.backend.GetBlock(ctx, b.hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b.block == nil {
+		return nil, errBlockNotFound
+	}
+	b.hash = b.block.Hash()
+	return b.block, nil
+}
+
+// resolveReceipts returns the receipts of the block, fetching them if needed.
+func (b *Block) resolveReceipts(ctx context.Context) (types.Receipts, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.receipts == nil {
+		receipts, err := b.backend.GetReceipts(ctx, block.Hash())
+		if err != nil {
+			return nil, err
+		}
+		b.receipts = receipts
+	}
+	return b.receipts, nil
+}
+
+// number returns the number of the block, resolving it if needed.
+func (b *Block) number(ctx context.Context) (rpc.BlockNumber, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return rpc.BlockNumber(block.NumberU64()), nil
+}
+
+func (b *Block) Number(ctx context.Context) (Long, error) {
+	number, err := b.number(ctx)
+	return Long(number), err
+}
+
+func (b *Block) Hash(ctx context.Context) (common.Hash, error) {
+	if _, err := b.resolve(ctx); err != nil {
+		return common.Hash{}, err
+	}
+	return b.hash, nil
+}
+
+func (b *Block) Parent(ctx context.Context) (*Block, error) {
+	block, err := b.resolve(ctx)
+	if err != nil || block.NumberU64() == 0 {
+		return nil, err
+	}
+	return &Block{
+		backend: b.backend,
+		hash:    block.ParentHash(),
+	}, nil
+}
+
+func (b *Block) Nonce(ctx context.Context) (hexutil.Bytes, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nonce := block.Header().Nonce
+	return hexutil.Bytes(nonce[:]), nil
+}
+
+func (b *Block) TransactionsRoot(ctx context.Context) (common.Hash, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return block.TxHash(), nil
+}
+
+func (b *Block) TransactionCount(ctx context.Context) (int32, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int32(len(block.Transactions())), nil
+}
+
+func (b *Block) StateRoot(ctx context.Context) (common.Hash, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return block.Root(), nil
+}
+
+func (b *Block) ReceiptsRoot(ctx context.Context) (common.Hash, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return block.ReceiptHash(), nil
+}
+
+func (b *Block) Miner(ctx context.Context, args BlockNumberArgs) (*Account, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		backend:     b.backend,
+		address:     block.Coinbase(),
+		blockNumber: args.number(),
+	}, nil
+}
+
+func (b *Block) ExtraData(ctx context.Context) (hexutil.Bytes, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(block.Extra()), nil
+}
+
+func (b *Block) GasLimit(ctx context.Context) (hexutil.Big, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return hexutil.Big(*block.GasLimit()), nil
+}
+
+func (b *Block) GasUsed(ctx context.Context) (hexutil.Big, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return hexutil.Big(*block.GasUsed()), nil
+}
+
+func (b *Block) Timestamp(ctx context.Context) (hexutil.Big, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return hexutil.Big(*block.Time()), nil
+}
+
+func (b *Block) LogsBloom(ctx context.Context) (hexutil.Bytes, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(block.Bloom().Bytes()), nil
+}
+
+func (b *Block) MixHash(ctx context.Context) (common.Hash, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return block.MixDigest(), nil
+}
+
+func (b *Block) Difficulty(ctx context.Context) (hexutil.Big, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return hexutil.Big(*block.Difficulty()), nil
+}
+
+func (b *Block) TotalDifficulty(ctx context.Context) (hexutil.Big, error) {
+	if _, err := b.resolve(ctx); err != nil {
+		return hexutil.Big{}, err
+	}
+	td := b.backend.GetTd(b.hash)
+	if td == nil {
+		return hexutil.Big{}, fmt.Errorf("total difficulty of block %x unknown", b.hash)
+	}
+	return hexutil.Big(*td), nil
+}
+
+func (b *Block) OmmerCount(ctx context.Context) (int32, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int32(len(block.Uncles())), nil
+}
+
+func (b *Block) Ommers(ctx context.Context) ([]*Block, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ommers := make([]*Block, 0, len(block.Uncles()))
+	for _, uncle := range block.Uncles() {
+		ommers = append(ommers, &Block{
+			backend: b.backend,
+			hash:    uncle.Hash(),
+			block:   types.NewBlockWithHeader(uncle),
+		})
+	}
+	return ommers, nil
+}
+
+func (b *Block) Transactions(ctx context.Context) ([]*Transaction, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]*Transaction, 0, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		txs = append(txs, &Transaction{
+			backend: b.backend,
+			hash:    tx.Hash(),
+			tx:      tx,
+			block:   b,
+			index:   uint64(i),
+		})
+	}
+	return txs, nil
+}
+
+func (b *Block) TransactionAt(ctx context.Context, args struct{ Index int32 }) (*Transaction, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txs := block.Transactions()
+	if args.Index < 0 || int(args.Index) >= len(txs) {
+		return nil, nil
+	}
+	tx := txs[args.Index]
+	return &Transaction{
+		backend: b.backend,
+		hash:    tx.Hash(),
+		tx:      tx,
+		block:   b,
+		index:   uint64(args.Index),
+	}, nil
+}
+
+func (b *Block) Logs(ctx context.Context) ([]*Log, error) {
+	block, err := b.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := b.resolveReceipts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var logs []*Log
+	for i, receipt := range receipts {
+		if i >= len(block.Transactions()) {
+			break
+		}
+		tx := &Transaction{
+			backend: b.backend,
+			hash:    block.Transactions()[i].Hash(),
+			tx:      block.Transactions()[i],
+			block:   b,
+			index:   uint64(i),
+		}
+		for _, log := range receipt.Logs {
+			logs = append(logs, &Log{
+				backend:     b.backend,
+				transaction: tx,
+				log:         log,
+			})
+		}
+	}
+	return logs, nil
+}
+
+func (b *Block) Account(ctx context.Context, args struct{ Address common.Address }) (*Account, error) {
+	number, err := b.number(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		backend:     b.backend,
+		address:     args.Address,
+		blockNumber: number,
+	}, nil
+}
+
+func (b *Block) Call(ctx context.Context, args struct{ Data CallData }) (*CallResult, error) {
+	number, err := b.number(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return doCall(ctx, b.backend, args.Data, number)
+}
+
+func (b *Block) EstimateGas(ctx context.Context, args struct{ Data CallData }) (hexutil.Big, error) {
+	number, err := b.number(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return doEstimateGas(ctx, b.backend, args.Data, number)
+}
+
+// CallData encapsulates the arguments of a local contract call.
+type CallData struct {
+	From     *common.Address // The Tiltnet address the call is from
+	To       *common.Address // The Tiltnet address the call is to
+	Gas      *hexutil.Big    // The amount of gas provided for the call
+	GasPrice *hexutil.Big    // The price of each unit of gas, in wei
+	Value    *hexutil.Big    // The value sent along with the call
+	Data     *hexutil.Bytes  // Any data sent with the call
+}
+
+// toCallArgs converts the call data into the arguments of the RPC API.
+func (d *CallData) toCallArgs() tiltapi.CallArgs {
+	var args tiltapi.CallArgs
+	if d.From != nil {
+		args.From = *d.From
+	}
+	args.To = d.To
+	if d.Gas != nil {
+		args.Gas = *d.Gas
+	}
+	if d.GasPrice != nil {
+		args.GasPrice = *d.GasPrice
+	}
+	if d.Value != nil {
+		args.Value = *d.Value
+	}
+	if d.Data != nil {
+		args.Data = *d.Data
+	}
+	return args
+}
+
+// CallResult encapsulates the result of an invocation of the `call` accessor.
+type CallResult struct {
+	data    hexutil.Bytes // The return data from the call
+	gasUsed hexutil.Big   // The amount of gas used
+}
+
+func (c *CallResult) Data() hexutil.Bytes {
+	return c.data
+}
+
+func (c *CallResult) GasUsed() hexutil.Big {
+	return c.gasUsed
+}
+
+// doCall executes a local call on the state of the given block.
+func doCall(ctx context.Context, backend tiltapi.Backend, data CallData, number rpc.BlockNumber) (*CallResult, error) {
+	result, gas, err := tiltapi.DoCall(ctx, backend, data.toCallArgs(), rpc.BlockNumberOrHashWithNumber(number), vm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return &CallResult{
+		data:    hexutil.Bytes(result),
+		gasUsed: hexutil.Big(*gas),
+	}, nil
+}
+
+// doEstimateGas estimates the gas needed by a call on the state of the given block.
+func doEstimateGas(ctx context.Context, backend tiltapi.Backend, data CallData, number rpc.BlockNumber) (hexutil.Big, error) {
+	gas, err := tiltapi.DoEstimateGas(ctx, backend, data.toCallArgs(), rpc.BlockNumberOrHashWithNumber(number))
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return *gas, nil
+}
+
+// Pending represents the current pending state.
+type Pending struct {
+	backend tiltapi.Backend
+}
+
+func (p *Pending) TransactionCount(ctx context.Context) (int32, error) {
+	txs, err := p.backend.GetPoolTransactions()
+	return int32(len(txs)), err
+}
+
+func (p *Pending) Transactions(ctx context.Context) (*[]*Transaction, error) {
+	txs, err := p.backend.GetPoolTransactions()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Transaction, 0, len(txs))
+	for _, tx := range txs {
+		ret = append(ret, &Transaction{
+			backend: p.backend,
+			hash:    tx.Hash(),
+			tx:      tx,
+		})
+	}
+	return &ret, nil
+}
+
+func (p *Pending) Account(ctx context.Context, args struct{ Address common.Address }) *Account {
+	return &Account{
+		backend:     p.backend,
+		address:     args.Address,
+		blockNumber: rpc.PendingBlockNumber,
+	}
+}
+
+func (p *Pending) Call(ctx context.Context, args struct{ Data CallData }) (*CallResult, error) {
+	return doCall(ctx, p.backend, args.Data, rpc.PendingBlockNumber)
+}
+
+func (p *Pending) EstimateGas(ctx context.Context, args struct{ Data CallData }) (hexutil.Big, error) {
+	return doEstimateGas(ctx, p.backend, args.Data, rpc.PendingBlockNumber)
+}
+
+// FilterCriteria encapsulates the arguments to a log search.
+type FilterCriteria struct {
+	FromBlock *Long             // Beginning of the queried range, nil means latest block
+	ToBlock   *Long             // End of the range, nil means latest block
+	Addresses *[]common.Address // Restricts matches to events created by specific contracts
+	Topics    *[][]common.Hash  // Restricts matches to events with the given topic prefixes
+}
+
+// Resolver is the root resolver of the GraphQL schema.
+type Resolver struct {
+	backend tiltapi.Backend
+}
+
+func (r *Resolver) Block(ctx context.Context, args struct {
+	Number *Long
+	Hash   *common.Hash
+}) (*Block, error) {
+	block := &Block{backend: r.backend}
+	switch {
+	case args.Number != nil:
+		number := rpc.BlockNumber(*args.Number)
+		block.num = &number
+	case args.Hash != nil:
+		block.hash = *args.Hash
+	default:
+		number := rpc.LatestBlockNumber
+		block.num = &number
+	}
+	// Make sure the block exists, returning null otherwise
+	if _, err := block.resolve(ctx); err != nil {
+		if err == errBlockNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return block, nil
+}
+
+func (r *Resolver) Blocks(ctx context.Context, args struct {
+	From Long
+	To   *Long
+}) ([]*Block, error) {
+	if args.From < 0 || (args.To != nil && *args.To < 0) {
+		return nil, errNegativeBlock
+	}
+	from := uint64(args.From)
+	to := r.backend.CurrentBlock().NumberU64()
+	if args.To != nil {
+		to = uint64(*args.To)
+	}
+	if from > to {
+		return []*Block{}, nil
+	}
+	if to-from >= maxQueryBlocks {
+		return nil, errTooManyBlocks
+	}
+	var blocks []*Block
+	for i := from; i <= to; i++ {
+		number := rpc.BlockNumber(i)
+		block := &Block{backend: r.backend, num: &number}
+		if _, err := block.resolve(ctx); err != nil {
+			if err == errBlockNotFound {
+				break
+			}
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func (r *Resolver) Pending(ctx context.Context) *Pending {
+	return &Pending{r.backend}
+}
+
+func (r *Resolver) Transaction(ctx context.Context, args struct{ Hash common.Hash }) (*Transaction, error) {
+	tx := &Transaction{
+		backend: r.backend,
+		hash:    args.Hash,
+	}
+	// Make sure the transaction exists, returning null otherwise
+	if _, err := tx.resolve(ctx); err != nil {
+		if err == errTransactionNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (r *Resolver) Logs(ctx context.Context, args struct{ Filter FilterCriteria }) ([]*Log, error) {
+	// Convert the criteria into a log filter
+	begin, end := rpc.LatestBlockNumber.Int64(), rpc.LatestBlockNumber.Int64()
+	if args.Filter.FromBlock != nil {
+		begin = int64(*args.Filter.FromBlock)
+	}
+	if args.Filter.ToBlock != nil {
+		end = int64(*args.Filter.ToBlock)
+	}
+	filter := filters.New(r.backend, false)
+	filter.SetBeginBlock(begin)
+	filter.SetEndBlock(end)
+	if args.Filter.Addresses != nil {
+		filter.SetAddresses(*args.Filter.Addresses)
+	}
+	if args.Filter.Topics != nil {
+		filter.SetTopics(*args.Filter.Topics)
+	}
+	// Run the filter and wrap the results
+	logs, err := filter.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Log, 0, len(logs))
+	for _, log := range logs {
+		ret = append(ret, &Log{
+			backend:     r.backend,
+			transaction: &Transaction{backend: r.backend, hash: log.TxHash},
+			log:         log,
+		})
+	}
+	return ret, nil
+}
+
+func (r *Resolver) GasPrice(ctx context.Context) (hexutil.Big, error) {
+	price, err := r.backend.SuggestPrice(ctx)
+	if err != nil {
+		return hexutil.Big{}, err
+	}
+	return hexutil.Big(*price), nil
+}
+
+func (r *Resolver) ProtocolVersion(ctx context.Context) (int32, error) {
+	return int32(r.backend.ProtocolVersion()), nil
+}
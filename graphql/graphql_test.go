@@ -0,0 +1,233 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	graphqlgo "github.com/graph-gophers/graphql-go"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/internal/tiltapi"
+	"github.com/megatilt/go-tilt/rpc"
+	"github.com/megatilt/go-tilt/tiltdb"
+)
+
+// testBackend is a minimal chain backend serving a few blocks, their receipts
+// and a pooled transaction. Any other backend method panics.
+type testBackend struct {
+	tiltapi.Backend
+
+	db     *tiltdb.MemDatabase
+	blocks []*types.Block
+	pool   map[common.Hash]*types.Transaction
+}
+
+func newTestBackend(t *testing.T) (*testBackend, []*types.Transaction) {
+	db, _ := tiltdb.NewMemDatabase()
+	backend := &testBackend{db: db, pool: make(map[common.Hash]*types.Transaction)}
+
+	to := common.HexToAddress("0x0102030405060708090a0b0c0d0e0f1011121314")
+	txs := []*types.Transaction{
+		types.NewTransaction(0, to, big.NewInt(1), big.NewInt(21000), big.NewInt(1), nil),
+		types.NewTransaction(1, to, big.NewInt(1), big.NewInt(50000), big.NewInt(1), []byte{0xfe}),
+		types.NewTransaction(2, to, big.NewInt(1), big.NewInt(21000), big.NewInt(1), nil),
+		types.NewTransaction(3, to, big.NewInt(1), big.NewInt(21000), big.NewInt(1), nil),
+	}
+	statuses := []uint{types.ReceiptStatusSuccessful, types.ReceiptStatusFailed, types.ReceiptStatusUnknown}
+
+	parent := common.Hash{}
+	for i := 0; i < 4; i++ {
+		header := &types.Header{ParentHash: parent, Number: big.NewInt(int64(i)), Difficulty: big.NewInt(1)}
+
+		var (
+			body     []*types.Transaction
+			receipts types.Receipts
+		)
+		if i == 1 {
+			for j, tx := range txs[:3] {
+				receipt := types.NewReceipt(nil, big.NewInt(int64(21000*(j+1))))
+				receipt.TxHash = tx.Hash()
+				receipt.GasUsed = big.NewInt(21000)
+				receipt.Status = statuses[j]
+				receipt.Logs = []*types.Log{}
+				body, receipts = append(body, tx), append(receipts, receipt)
+			}
+		}
+		block := types.NewBlock(header, body, nil, receipts)
+		if err := core.WriteBlock(db, block); err != nil {
+			t.Fatalf("failed to write block %d: %v", i, err)
+		}
+		if err := core.WriteCanonicalHash(db, block.Hash(), block.NumberU64()); err != nil {
+			t.Fatalf("failed to write canonical hash %d: %v", i, err)
+		}
+		if err := core.WriteTransactions(db, block); err != nil {
+			t.Fatalf("failed to write transactions %d: %v", i, err)
+		}
+		if err := core.WriteBlockReceipts(db, block.Hash(), block.NumberU64(), receipts); err != nil {
+			t.Fatalf("failed to write receipts %d: %v", i, err)
+		}
+		backend.blocks = append(backend.blocks, block)
+		parent = block.Hash()
+	}
+	backend.pool[txs[3].Hash()] = txs[3]
+	return backend, txs
+}
+
+func (b *testBackend) ChainDb() tiltdb.Database { return b.db }
+
+func (b *testBackend) CurrentBlock() *types.Block { return b.blocks[len(b.blocks)-1] }
+
+func (b *testBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	if number == rpc.LatestBlockNumber {
+		return b.CurrentBlock(), nil
+	}
+	if number < 0 || int(number) >= len(b.blocks) {
+		return nil, nil
+	}
+	return b.blocks[number], nil
+}
+
+func (b *testBackend) GetBlock(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	for _, block := range b.blocks {
+		if block.Hash() == hash {
+			return block, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	block, _ := b.GetBlock(ctx, hash)
+	if block == nil {
+		return nil, nil
+	}
+	return core.GetBlockReceipts(b.db, hash, block.NumberU64()), nil
+}
+
+func (b *testBackend) GetPoolTransaction(hash common.Hash) *types.Transaction {
+	return b.pool[hash]
+}
+
+// query executes a GraphQL query against the test backend, returning the JSON
+// result decoded into a generic map and the reported errors.
+func query(t *testing.T, backend tiltapi.Backend, q string, vars map[string]interface{}) (map[string]interface{}, []string) {
+	schema := graphqlgo.MustParseSchema(schema, &Resolver{backend})
+	res := schema.Exec(context.Background(), q, "", vars)
+
+	var errs []string
+	for _, err := range res.Errors {
+		errs = append(errs, err.Message)
+	}
+	var data map[string]interface{}
+	if len(res.Data) > 0 {
+		if err := json.Unmarshal(res.Data, &data); err != nil {
+			t.Fatalf("failed to decode result %s: %v", res.Data, err)
+		}
+	}
+	return data, errs
+}
+
+// Tests that block ranges are resolved up to the chain head and that negative
+// block numbers are rejected instead of wrapping around.
+func TestBlocksRange(t *testing.T) {
+	backend, _ := newTestBackend(t)
+
+	tests := []struct {
+		query string
+		want  []interface{}
+		fail  bool
+	}{
+		{`{ blocks(from: 0) { number } }`, []interface{}{0.0, 1.0, 2.0, 3.0}, false},
+		{`{ blocks(from: 1, to: 2) { number } }`, []interface{}{1.0, 2.0}, false},
+		{`{ blocks(from: "0x2") { number } }`, []interface{}{2.0, 3.0}, false},
+		{`{ blocks(from: 3, to: 1) { number } }`, []interface{}{}, false},
+		{`{ blocks(from: 2, to: 10) { number } }`, []interface{}{2.0, 3.0}, false},
+		{`{ blocks(from: -1) { number } }`, nil, true},
+		{`{ blocks(from: 0, to: -1) { number } }`, nil, true},
+		{`{ blocks(from: "-1") { number } }`, nil, true},
+	}
+	for i, tt := range tests {
+		data, errs := query(t, backend, tt.query, nil)
+		if tt.fail {
+			if len(errs) == 0 {
+				t.Errorf("test %d: expected failure, have %v", i, data)
+			}
+			continue
+		}
+		if len(errs) > 0 {
+			t.Errorf("test %d: query failed: %v", i, errs)
+			continue
+		}
+		var have []interface{}
+		for _, block := range data["blocks"].([]interface{}) {
+			have = append(have, block.(map[string]interface{})["number"])
+		}
+		if have == nil {
+			have = []interface{}{}
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("test %d: block numbers mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that the execution status and gas usage of mined transactions are
+// reported, and null for pending ones or if unknown.
+func TestTransactionStatus(t *testing.T) {
+	backend, txs := newTestBackend(t)
+
+	tests := []struct {
+		tx      *types.Transaction
+		status  interface{}
+		gasUsed interface{}
+	}{
+		{txs[0], 1.0, "0x5208"},
+		{txs[1], 0.0, "0x5208"},
+		{txs[2], nil, "0x5208"},
+		{txs[3], nil, nil},
+	}
+	for i, tt := range tests {
+		data, errs := query(t, backend, `query($hash: Bytes32!) { transaction(hash: $hash) { status gasUsed } }`,
+			map[string]interface{}{"hash": tt.tx.Hash().Hex()})
+		if len(errs) > 0 {
+			t.Errorf("test %d: query failed: %v", i, errs)
+			continue
+		}
+		tx := data["transaction"].(map[string]interface{})
+		if tx["status"] != tt.status {
+			t.Errorf("test %d: status mismatch: have %v, want %v", i, tx["status"], tt.status)
+		}
+		if tx["gasUsed"] != tt.gasUsed {
+			t.Errorf("test %d: gas used mismatch: have %v, want %v", i, tx["gasUsed"], tt.gasUsed)
+		}
+	}
+}
+
+// Tests that the schema is read only, not exposing any mutations.
+func TestNoMutations(t *testing.T) {
+	backend, _ := newTestBackend(t)
+
+	if _, errs := query(t, backend, `mutation { sendRawTransaction(data: "0x00") }`, nil); len(errs) == 0 {
+		t.Errorf("mutation accepted")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"encoding/json"
+	"net/http"
+
+	graphqlgo "github.com/graph-gophers/graphql-go"
+	"github.com/megatilt/go-tilt/internal/tiltapi"
+	"github.com/megatilt/go-tilt/log"
+	"github.com/megatilt/go-tilt/p2p"
+	"github.com/megatilt/go-tilt/rpc"
+)
+
+const (
+	// maxQuerySize is the maximum size of an accepted GraphQL request body.
+	maxQuerySize = 1024 * 128
+
+	// Paths on the HTTP endpoint under which the service is reachable.
+	queryPath = "/graphql"
+	uiPath    = "/graphql/ui"
+)
+
+// Service encapsulates a GraphQL service, served through the HTTP RPC endpoint
+// of the node.
+type Service struct {
+	schema *graphqlgo.Schema
+}
+
+// New constructs a new GraphQL service instance, resolving queries via the
+// given API backend.
+func New(backend tiltapi.Backend) (*Service, error) {
+	parsed, err := graphqlgo.ParseSchema(schema, &Resolver{backend})
+	if err != nil {
+		return nil, err
+	}
+	return &Service{schema: parsed}, nil
+}
+
+// Protocols implements node.Service, returning the P2P network protocols used
+// by the GraphQL service (nil as it doesn't use the devp2p overlay network).
+func (s *Service) Protocols() []p2p.Protocol { return nil }
+
+// APIs implements node.Service, returning the RPC API endpoints provided by the
+// GraphQL service (nil as it provides no RPC methods).
+func (s *Service) APIs() []rpc.API { return nil }
+
+// Start implements node.Service, starting the GraphQL service.
+func (s *Service) Start(server *p2p.Server) error {
+	log.Info("GraphQL service enabled", "path", queryPath, "ui", uiPath)
+	return nil
+}
+
+// Stop implements node.Service, terminating the GraphQL service.
+func (s *Service) Stop() error { return nil }
+
+// HTTPHandlers implements node.HTTPService, returning the query endpoint and the
+// in-browser query UI to mount on the HTTP RPC endpoint.
+func (s *Service) HTTPHandlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		queryPath: s,
+		uiPath:    http.HandlerFunc(serveUI),
+	}
+}
+
+// ServeHTTP serves a single GraphQL query sent as a JSON POST request.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "GraphQL queries must be sent via POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQuerySize)).Decode(&params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response := s.schema.Exec(r.Context(), params.Query, params.OperationName, params.Variables)
+	blob, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(blob)
+}
+
+// serveUI serves the in-browser GraphiQL query UI.
+func serveUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(graphiql))
+}
+
+// graphiql is the page hosting the GraphiQL query UI, sending the queries to
+// the query endpoint of the service.
+const graphiql = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Tiltnet GraphQL</title>
+    <link rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/graphiql/0.11.11/graphiql.min.css" />
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/fetch/2.0.3/fetch.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/react/16.2.0/umd/react.production.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/react-dom/16.2.0/umd/react-dom.production.min.js"></script>
+    <script src="https://cdnjs.cloudflare.com/ajax/libs/graphiql/0.11.11/graphiql.min.js"></script>
+  </head>
+  <body style="width: 100%; height: 100%; margin: 0; overflow: hidden;">
+    <div id="graphiql" style="height: 100vh;">Loading...</div>
+    <script>
+      function graphQLFetcher(params) {
+        return fetch("` + queryPath + `", {
+          method: "post",
+          body: JSON.stringify(params),
+          credentials: "include",
+        }).then(function (response) {
+          return response.text();
+        }).then(function (body) {
+          try {
+            return JSON.parse(body);
+          } catch (error) {
+            return body;
+          }
+        });
+      }
+      ReactDOM.render(
+        React.createElement(GraphiQL, {fetcher: graphQLFetcher}),
+        document.getElementById("graphiql")
+      );
+    </script>
+  </body>
+</html>
+`
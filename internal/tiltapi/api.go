@@ -28,6 +28,7 @@ import (
 
 	"github.com/megatilt/go-tilt/accounts"
 	"github.com/megatilt/go-tilt/accounts/keystore"
+	"github.com/megatilt/go-tilt/accounts/typeddata"
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/common/hexutil"
 	"github.com/megatilt/go-tilt/common/math"
@@ -50,6 +51,10 @@ const (
 	defaultGas      = 90000
 	defaultGasPrice = 50 * params.Blom
 	emptyHex        = "0x"
+
+	// maxCallManyCalls is the maximum number of calls executed by a single
+	// CallMany request.
+	maxCallManyCalls = 128
 )
 
 // PublicTiltnetAPI provides an API to access Tiltnet related information.
@@ -119,7 +124,7 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 	for account, txs := range pending {
 		dump := make(map[string]*RPCTransaction)
 		for nonce, tx := range txs {
-			dump[fmt.Sprintf("%d", nonce)] = newRPCPendingTransaction(tx)
+			dump[fmt.Sprintf("%d", nonce)] = NewRPCPendingTransaction(tx)
 		}
 		content["pending"][account.Hex()] = dump
 	}
@@ -127,7 +132,7 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 	for account, txs := range queue {
 		dump := make(map[string]*RPCTransaction)
 		for nonce, tx := range txs {
-			dump[fmt.Sprintf("%d", nonce)] = newRPCPendingTransaction(tx)
+			dump[fmt.Sprintf("%d", nonce)] = NewRPCPendingTransaction(tx)
 		}
 		content["queued"][account.Hex()] = dump
 	}
@@ -248,6 +253,22 @@ func (s *PrivateAccountAPI) ListWallets() []rawWallet {
 	return wallets
 }
 
+// OpenWallet initiates a hardware wallet opening procedure, establishing a USB
+// connection and attempting to authenticate via the provided passphrase. Note,
+// the method may return an extra challenge requiring a second open (e.g. the
+// Trezor PIN matrix challenge).
+func (s *PrivateAccountAPI) OpenWallet(url string, passphrase *string) error {
+	wallet, err := s.am.Wallet(url)
+	if err != nil {
+		return err
+	}
+	pass := ""
+	if passphrase != nil {
+		pass = *passphrase
+	}
+	return wallet.Open(pass)
+}
+
 // DeriveAccount requests a HD wallet to derive a new account, optionally pinning
 // it for later reuse.
 func (s *PrivateAccountAPI) DeriveAccount(url string, path string, pin *bool) (accounts.Account, error) {
@@ -291,6 +312,27 @@ func (s *PrivateAccountAPI) ImportRawKey(privkey string, password string) (commo
 	return acc.Address, err
 }
 
+// ImportMnemonic stores the seed of the given BIP-39 mnemonic into the key
+// directory, encrypted with the password, and returns the address of its first
+// account. Further accounts can be derived with DeriveAccount once the wallet of
+// an account of the seed is opened with the same password.
+func (s *PrivateAccountAPI) ImportMnemonic(mnemonic string, password string, seedPassphrase *string) (common.Address, error) {
+	pass := ""
+	if seedPassphrase != nil {
+		pass = *seedPassphrase
+	}
+	acc, err := fetchKeystore(s.am).ImportMnemonic(mnemonic, pass, password)
+	return acc.Address, err
+}
+
+// ChangePassword re-encrypts the key of the account associated with the given
+// address with a new password, after decrypting it with the old one. Accounts
+// derived from a mnemonic seed are re-encrypted along with the seed and all the
+// other accounts derived from it.
+func (s *PrivateAccountAPI) ChangePassword(addr common.Address, oldPassword, newPassword string) error {
+	return fetchKeystore(s.am).Update(accounts.Account{Address: addr}, oldPassword, newPassword)
+}
+
 // UnlockAccount will unlock the account associated with the given address with
 // the given password for duration seconds. If duration is nil it will use a
 // default of 300 seconds. It returns an indication if the account was unlocked.
@@ -407,6 +449,39 @@ func (s *PrivateAccountAPI) EcRecover(ctx context.Context, data, sig hexutil.Byt
 	return recoveredAddr, nil
 }
 
+// SignTypedData calculates an ECDSA signature for the hash of the typed data:
+// keccak256("\x19\x01" + hashStruct(domain) + hashStruct(message)).
+//
+// The signature conforms to the secp256k1 curve R, S and V values, where the V
+// value will be 27 or 28 for legacy reasons. The key used is decrypted with the
+// given password.
+func (s *PrivateAccountAPI) SignTypedData(ctx context.Context, addr common.Address, data typeddata.TypedData, passwd string) (hexutil.Bytes, error) {
+	// Look up the wallet containing the requested signer
+	account := accounts.Account{Address: addr}
+
+	wallet, err := s.b.AccountManager().Find(account)
+	if err != nil {
+		return nil, err
+	}
+	// Sign the typed data with the wallet, letting it display the fields if able
+	signature, err := accounts.SignTypedDataWithPassphrase(wallet, account, passwd, &data)
+	if err != nil {
+		return nil, err
+	}
+	signature[64] += 27 // Transform V from 0/1 to 27/28 according to the yellow paper
+	return signature, nil
+}
+
+// EcRecoverTypedData returns the address for the account that was used to sign
+// the typed data, compatible with the signatures of tilt_signTypedData and
+// personal_signTypedData.
+//
+// Note, the signature must conform to the secp256k1 curve R, S and V values, where
+// the V value must be be 27 or 28 for legacy reasons.
+func (s *PrivateAccountAPI) EcRecoverTypedData(ctx context.Context, data typeddata.TypedData, sig hexutil.Bytes) (common.Address, error) {
+	return typeddata.RecoverAddress(&data, sig)
+}
+
 // SignAndSendTransaction was renamed to SendTransaction. This method is deprecated
 // and will be removed in the future. It primary goal is to give clients time to update.
 func (s *PrivateAccountAPI) SignAndSendTransaction(ctx context.Context, args SendTxArgs, passwd string) (common.Hash, error) {
@@ -431,10 +506,10 @@ func (s *PublicBlockChainAPI) BlockNumber() *big.Int {
 }
 
 // GetBalance returns the amount of dwan for the given address in the state of the
-// given block number. The rpc.LatestBlockNumber and rpc.PendingBlockNumber meta
-// block numbers are also allowed.
-func (s *PublicBlockChainAPI) GetBalance(ctx context.Context, address common.Address, blockNr rpc.BlockNumber) (*big.Int, error) {
-	state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+// given block number or hash. The rpc.LatestBlockNumber, rpc.PendingBlockNumber
+// and rpc.SafeBlockNumber meta block numbers are also allowed.
+func (s *PublicBlockChainAPI) GetBalance(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*big.Int, error) {
+	state, _, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
 	if state == nil || err != nil {
 		return nil, err
 	}
@@ -519,9 +594,9 @@ func (s *PublicBlockChainAPI) GetUncleCountByBlockHash(ctx context.Context, bloc
 	return nil
 }
 
-// GetCode returns the code stored at the given address in the state for the given block number.
-func (s *PublicBlockChainAPI) GetCode(ctx context.Context, address common.Address, blockNr rpc.BlockNumber) (string, error) {
-	state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+// GetCode returns the code stored at the given address in the state for the given block number or hash.
+func (s *PublicBlockChainAPI) GetCode(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (string, error) {
+	state, _, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
 	if state == nil || err != nil {
 		return "", err
 	}
@@ -533,10 +608,10 @@ func (s *PublicBlockChainAPI) GetCode(ctx context.Context, address common.Addres
 }
 
 // GetStorageAt returns the storage from the state at the given address, key and
-// block number. The rpc.LatestBlockNumber and rpc.PendingBlockNumber meta block
-// numbers are also allowed.
-func (s *PublicBlockChainAPI) GetStorageAt(ctx context.Context, address common.Address, key string, blockNr rpc.BlockNumber) (string, error) {
-	state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+// block number or hash. The rpc.LatestBlockNumber, rpc.PendingBlockNumber and
+// rpc.SafeBlockNumber meta block numbers are also allowed.
+func (s *PublicBlockChainAPI) GetStorageAt(ctx context.Context, address common.Address, key string, blockNrOrHash rpc.BlockNumberOrHash) (string, error) {
+	state, _, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
 	if state == nil || err != nil {
 		return "0x", err
 	}
@@ -577,17 +652,25 @@ type CallArgs struct {
 	Data     hexutil.Bytes   `json:"data"`
 }
 
-func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, vmCfg vm.Config) ([]byte, *big.Int, error) {
+// DoCall executes the given call on the state of the given block, returning the
+// output of the execution and the amount of gas used.
+func DoCall(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.BlockNumberOrHash, vmCfg vm.Config) ([]byte, *big.Int, error) {
 	defer func(start time.Time) { log.Debug("Executing TiltVM call finished", "runtime", time.Since(start)) }(time.Now())
 
-	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	state, header, err := b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
 	if state == nil || err != nil {
 		return nil, common.Big0, err
 	}
+	return doCall(ctx, b, args, state, header, vmCfg)
+}
+
+// doCall executes the given call on top of an already resolved state, leaving
+// any modifications it makes in place.
+func doCall(ctx context.Context, b Backend, args CallArgs, state State, header *types.Header, vmCfg vm.Config) ([]byte, *big.Int, error) {
 	// Set sender address or use a default if none specified
 	addr := args.From
 	if addr == (common.Address{}) {
-		if wallets := s.b.AccountManager().Wallets(); len(wallets) > 0 {
+		if wallets := b.AccountManager().Wallets(); len(wallets) > 0 {
 			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
 				addr = accounts[0].Address
 			}
@@ -618,7 +701,7 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 	defer func() { cancel() }()
 
 	// Get a new instance of the TiltVM.
-	tiltvm, vmError, err := s.b.GetTiltVM(ctx, msg, state, header, vmCfg)
+	tiltvm, vmError, err := b.GetTiltVM(ctx, msg, state, header, vmCfg)
 	if err != nil {
 		return nil, common.Big0, err
 	}
@@ -634,40 +717,145 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 	// Setup the gas pool (also for unmetered requests)
 	// and apply the message.
 	gp := new(core.GasPool).AddGas(math.MaxBig256)
-	res, gas, err := core.ApplyMessage(tiltvm, msg, gp)
+	res, gas, failed, err := core.ApplyMessage(tiltvm, msg, gp)
 	if err := vmError(); err != nil {
 		return nil, common.Big0, err
 	}
+	if err == nil && failed {
+		err = &callError{data: res}
+	}
 	return res, gas, err
 }
 
-// Call executes the given transaction on the state for the given block number.
+// callError is returned if the execution of a call failed in the TiltVM. It
+// carries the data returned by the failed execution, if any, for the caller to
+// decode the revert reason from.
+type callError struct {
+	data []byte
+}
+
+func (e *callError) Error() string { return "execution failed" }
+
+// ErrorCode implements rpc.Error, distinguishing failed executions from other
+// call errors.
+func (e *callError) ErrorCode() int { return 3 }
+
+// ErrorData implements rpc.DataError, returning the hex encoded return data.
+func (e *callError) ErrorData() interface{} { return hexutil.Encode(e.data) }
+
+// Call executes the given transaction on the state for the given block number or hash.
 // It doesn't make and changes in the state/blockchain and is useful to execute and retrieve values.
-func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
-	result, _, err := s.doCall(ctx, args, blockNr, vm.Config{DisableGasMetering: true})
+func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	result, _, err := DoCall(ctx, s.b, args, blockNrOrHash, vm.Config{DisableGasMetering: true})
 	return (hexutil.Bytes)(result), err
 }
 
-// EstimateGas returns an estimate of the amount of gas needed to execute the given transaction.
-func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (*hexutil.Big, error) {
+// OverrideAccount specifies the fields of an account to replace before executing
+// calls. Nil fields are left untouched, storage slots are overridden one by one.
+type OverrideAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   *hexutil.Uint64             `json:"nonce"`
+	Code    *hexutil.Bytes              `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// StateOverride is the collection of accounts overridden before executing calls.
+type StateOverride map[common.Address]OverrideAccount
+
+// Apply replaces the overridden account fields in the given state.
+func (diff StateOverride) Apply(state State) {
+	for addr, account := range diff {
+		if account.Balance != nil {
+			state.SetBalance(addr, account.Balance.ToInt())
+		}
+		if account.Nonce != nil {
+			state.SetNonce(addr, uint64(*account.Nonce))
+		}
+		if account.Code != nil {
+			state.SetCode(addr, *account.Code)
+		}
+		for key, value := range account.Storage {
+			state.SetState(addr, key, value)
+		}
+	}
+}
+
+// CallResult is the outcome of a single call executed by CallMany.
+type CallResult struct {
+	Output  hexutil.Bytes `json:"output"`
+	GasUsed *hexutil.Big  `json:"gasUsed"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// CallMany executes the given calls one after the other on a single copy of the
+// state of the given block, with the optional account overrides applied first.
+// Every call sees the changes made by the ones preceding it, none are persisted.
+// A failing call is reported in its result and doesn't abort the remaining ones.
+// Contrary to Call, gas is metered, bounding the execution by the gas allowance.
+func (s *PublicBlockChainAPI) CallMany(ctx context.Context, calls []CallArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *StateOverride) ([]CallResult, error) {
+	if len(calls) > maxCallManyCalls {
+		return nil, fmt.Errorf("too many calls (%d>%d)", len(calls), maxCallManyCalls)
+	}
+	defer func(start time.Time) {
+		log.Debug("Executing TiltVM calls finished", "calls", len(calls), "runtime", time.Since(start))
+	}(time.Now())
+
+	state, header, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	if overrides != nil {
+		overrides.Apply(state)
+	}
+	results := make([]CallResult, len(calls))
+	for i, args := range calls {
+		// Bail out if the request was abandoned, the rest would be wasted work
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		output, gas, err := doCall(ctx, s.b, args, state, header, vm.Config{})
+		results[i] = CallResult{Output: output, GasUsed: (*hexutil.Big)(gas)}
+		if err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+	return results, nil
+}
+
+// EstimateGas returns an estimate of the amount of gas needed to execute the given
+// transaction on the state of the given block, defaulting to the pending one.
+func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs, blockNrOrHash *rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	block := rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber)
+	if blockNrOrHash != nil {
+		block = *blockNrOrHash
+	}
+	return DoEstimateGas(ctx, s.b, args, block)
+}
+
+// DoEstimateGas binary searches the amount of gas needed to execute the given
+// call on the state of the given block.
+func DoEstimateGas(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
 	// Binary search the gas requirement, as it may be higher than the amount used
 	var lo, hi uint64
 	if (*big.Int)(&args.Gas).Sign() != 0 {
 		hi = (*big.Int)(&args.Gas).Uint64()
 	} else {
-		// Retrieve the current pending block to act as the gas ceiling
-		block, err := s.b.BlockByNumber(ctx, rpc.PendingBlockNumber)
+		// Retrieve the requested block to act as the gas ceiling
+		header, err := b.HeaderByNumberOrHash(ctx, blockNrOrHash)
 		if err != nil {
 			return nil, err
 		}
-		hi = block.GasLimit().Uint64()
+		if header == nil {
+			return nil, errors.New("header not found")
+		}
+		hi = header.GasLimit.Uint64()
 	}
 	for lo+1 < hi {
 		// Take a guess at the gas, and check transaction validity
 		mid := (hi + lo) / 2
 		(*big.Int)(&args.Gas).SetUint64(mid)
 
-		_, gas, err := s.doCall(ctx, args, rpc.PendingBlockNumber, vm.Config{})
+		_, gas, err := DoCall(ctx, b, args, blockNrOrHash, vm.Config{})
 
 		// If the transaction became invalid or used all the gas (failed), raise the gas limit
 		if err != nil || gas.Cmp((*big.Int)(&args.Gas)) == 0 {
@@ -809,8 +997,8 @@ type RPCTransaction struct {
 	S                *hexutil.Big    `json:"s"`
 }
 
-// newRPCPendingTransaction returns a pending transaction that will serialize to the RPC representation
-func newRPCPendingTransaction(tx *types.Transaction) *RPCTransaction {
+// NewRPCPendingTransaction returns a pending transaction that will serialize to the RPC representation
+func NewRPCPendingTransaction(tx *types.Transaction) *RPCTransaction {
 	var signer types.Signer = types.HoldemSigner{}
 	if tx.Protected() {
 		signer = types.NewTiltSigner(tx.ChainId())
@@ -962,9 +1150,9 @@ func (s *PublicTransactionPoolAPI) GetRawTransactionByBlockHashAndIndex(ctx cont
 	return nil, nil
 }
 
-// GetTransactionCount returns the number of transactions the given address has sent for the given block number
-func (s *PublicTransactionPoolAPI) GetTransactionCount(ctx context.Context, address common.Address, blockNr rpc.BlockNumber) (*hexutil.Uint64, error) {
-	state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+// GetTransactionCount returns the number of transactions the given address has sent for the given block number or hash
+func (s *PublicTransactionPoolAPI) GetTransactionCount(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Uint64, error) {
+	state, _, err := s.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
 	if state == nil || err != nil {
 		return nil, err
 	}
@@ -1010,7 +1198,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, has
 		return nil, nil
 	}
 	if isPending {
-		return newRPCPendingTransaction(tx), nil
+		return NewRPCPendingTransaction(tx), nil
 	}
 
 	blockHash, _, _, err := getTransactionBlockData(s.b.ChainDb(), hash)
@@ -1079,6 +1267,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(hash common.Hash) (map[
 		"contractAddress":   nil,
 		"logs":              receipt.Logs,
 		"logsBloom":         receipt.Bloom,
+		"status":            hexutil.Uint(receipt.Status),
 	}
 	if receipt.Logs == nil {
 		fields["logs"] = [][]*types.Log{}
@@ -1244,6 +1433,29 @@ func (s *PublicTransactionPoolAPI) Sign(addr common.Address, data hexutil.Bytes)
 	return signature, err
 }
 
+// SignTypedData calculates an ECDSA signature for the hash of the typed data:
+// keccak256("\x19\x01" + hashStruct(domain) + hashStruct(message)).
+//
+// Note, the produced signature conforms to the secp256k1 curve R, S and V values,
+// where the V value will be 27 or 28 for legacy reasons.
+//
+// The account associated with addr must be unlocked.
+func (s *PublicTransactionPoolAPI) SignTypedData(addr common.Address, data typeddata.TypedData) (hexutil.Bytes, error) {
+	// Look up the wallet containing the requested signer
+	account := accounts.Account{Address: addr}
+
+	wallet, err := s.b.AccountManager().Find(account)
+	if err != nil {
+		return nil, err
+	}
+	// Sign the typed data with the wallet, letting it display the fields if able
+	signature, err := accounts.SignTypedData(wallet, account, &data)
+	if err == nil {
+		signature[64] += 27 // Transform V from 0/1 to 27/28 according to the yellow paper
+	}
+	return signature, err
+}
+
 // SignTransactionResult represents a RLP encoded signed transaction.
 type SignTransactionResult struct {
 	Raw hexutil.Bytes      `json:"raw"`
@@ -1284,7 +1496,7 @@ func (s *PublicTransactionPoolAPI) PendingTransactions() ([]*RPCTransaction, err
 		}
 		from, _ := types.Sender(signer, tx)
 		if _, err := s.b.AccountManager().Find(accounts.Account{Address: from}); err == nil {
-			transactions = append(transactions, newRPCPendingTransaction(tx))
+			transactions = append(transactions, NewRPCPendingTransaction(tx))
 		}
 	}
 	return transactions, nil
@@ -0,0 +1,215 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+package tiltapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/multisig"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/core"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/core/vm"
+	"github.com/megatilt/go-tilt/log"
+	"github.com/megatilt/go-tilt/rpc"
+)
+
+// errNoMultisig is returned if the multisig backend failed to load.
+var errNoMultisig = errors.New("multisig wallets unavailable")
+
+// fetchMultisig retrieves the multisig wallet of the given contract from the
+// account manager.
+func fetchMultisig(am *accounts.Manager, address common.Address) (*multisig.Wallet, error) {
+	backends := am.Backends(multisig.BackendType)
+	if len(backends) == 0 {
+		return nil, errNoMultisig
+	}
+	return backends[0].(*multisig.Backend).Find(address)
+}
+
+// NewMultisig starts tracking the multisig contract deployed at the given address,
+// executing calls once approved by threshold of the owners.
+func (s *PrivateAccountAPI) NewMultisig(address common.Address, owners []common.Address, threshold int) (accounts.URL, error) {
+	backends := s.am.Backends(multisig.BackendType)
+	if len(backends) == 0 {
+		return accounts.URL{}, errNoMultisig
+	}
+	wallet, err := backends[0].(*multisig.Backend).Create(multisig.Config{
+		ChainID:   (*hexutil.Big)(s.b.ChainConfig().ChainId),
+		Address:   address,
+		Owners:    owners,
+		Threshold: threshold,
+	})
+	if err != nil {
+		return accounts.URL{}, err
+	}
+	return wallet.URL(), nil
+}
+
+// ProposeMultisig creates a pending proposal for the multisig contract to call
+// the destination with the given value and data, at the current contract nonce.
+// The returned proposal identifier is the hash the owners need to sign.
+func (s *PrivateAccountAPI) ProposeMultisig(ctx context.Context, address common.Address, to common.Address, value *hexutil.Big, data hexutil.Bytes) (*multisig.Proposal, error) {
+	wallet, err := fetchMultisig(s.am, address)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := multisigNonce(ctx, s.b, address, rpc.PendingBlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	return wallet.Propose(to, value.ToInt(), data, nonce)
+}
+
+// MultisigProposals returns the pending proposals of the multisig contract along
+// with the approvals collected so far.
+func (s *PrivateAccountAPI) MultisigProposals(address common.Address) ([]*multisig.Proposal, error) {
+	wallet, err := fetchMultisig(s.am, address)
+	if err != nil {
+		return nil, err
+	}
+	return wallet.Proposals(), nil
+}
+
+// ApproveMultisig signs a pending proposal of the multisig contract with the
+// given owner account, through whichever wallet holds it. The account needs to
+// be unlocked, or approved in the external signer if one is used.
+func (s *PrivateAccountAPI) ApproveMultisig(address common.Address, id common.Hash, owner common.Address) error {
+	wallet, err := fetchMultisig(s.am, address)
+	if err != nil {
+		return err
+	}
+	account := accounts.Account{Address: owner}
+
+	signer, err := s.am.Find(account)
+	if err != nil {
+		return err
+	}
+	return wallet.Approve(id, signer, account)
+}
+
+// AddMultisigSignature records an approval of a pending proposal signed by an
+// owner elsewhere, returning the address of the owner.
+func (s *PrivateAccountAPI) AddMultisigSignature(address common.Address, id common.Hash, sig hexutil.Bytes) (common.Address, error) {
+	wallet, err := fetchMultisig(s.am, address)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return wallet.AddSignature(id, sig)
+}
+
+// ExecuteMultisig submits a transaction from the given account executing an
+// approved proposal of the multisig contract. If no gas allowance is given, it is
+// estimated. The proposal is kept until the execution is confirmed by
+// ConfirmMultisig, so a failed execution can be retried.
+func (s *PrivateAccountAPI) ExecuteMultisig(ctx context.Context, address common.Address, id common.Hash, from common.Address, passwd string, gas *hexutil.Big) (common.Hash, error) {
+	wallet, err := fetchMultisig(s.am, address)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	input, err := wallet.Execution(id)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if gas == nil {
+		pending := rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber)
+		call := CallArgs{From: from, To: &address, Data: input}
+		if gas, err = DoEstimateGas(ctx, s.b, call, pending); err != nil {
+			return common.Hash{}, err
+		}
+		// Don't waste the gas on an execution failing even with the whole allowance
+		call.Gas = *gas
+		if _, _, err := DoCall(ctx, s.b, call, pending, vm.Config{}); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	hash, err := s.SendTransaction(ctx, SendTxArgs{From: from, To: &address, Gas: gas, Data: input}, passwd)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return hash, wallet.SetExecution(id, &hash)
+}
+
+// MultisigConfirmation is the outcome of confirming the executions of a multisig
+// contract's proposals.
+type MultisigConfirmation struct {
+	Executed  []common.Hash `json:"executed"`  // Proposals executed, now discarded
+	Failed    []common.Hash `json:"failed"`    // Proposals whose execution failed, to be retried
+	Discarded []common.Hash `json:"discarded"` // Proposals never executed, superseded by the contract nonce
+}
+
+// ConfirmMultisig checks the outcome of the submitted executions of the multisig
+// contract's proposals. Proposals whose execution succeeded are discarded, while
+// those whose execution failed are made available to be executed again. Finally
+// the proposals made for an already used nonce are discarded, as they can never
+// be executed anymore.
+func (s *PrivateAccountAPI) ConfirmMultisig(ctx context.Context, address common.Address) (*MultisigConfirmation, error) {
+	wallet, err := fetchMultisig(s.am, address)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := multisigNonce(ctx, s.b, address, rpc.LatestBlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	result := new(MultisigConfirmation)
+	for _, proposal := range wallet.Proposals() {
+		if proposal.Execution == nil {
+			continue
+		}
+		receipt := core.GetReceipt(s.b.ChainDb(), *proposal.Execution)
+		if receipt == nil {
+			continue // not yet mined
+		}
+		// Receipts without a known status only tell if the nonce advanced, which a
+		// competing proposal for the same nonce might have done
+		executed := receipt.Status == types.ReceiptStatusSuccessful ||
+			(receipt.Status == types.ReceiptStatusUnknown && uint64(proposal.Nonce) < nonce)
+		if executed {
+			if err := wallet.Discard(proposal.ID); err != nil {
+				return result, err
+			}
+			result.Executed = append(result.Executed, proposal.ID)
+			continue
+		}
+		log.Warn("Multisig execution failed", "multisig", address, "id", proposal.ID, "tx", *proposal.Execution)
+		if err := wallet.SetExecution(proposal.ID, nil); err != nil {
+			return result, err
+		}
+		result.Failed = append(result.Failed, proposal.ID)
+	}
+	if result.Discarded, err = wallet.Prune(nonce); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// multisigNonce retrieves the current nonce of a multisig contract at the given
+// block, the one proposals need to be made for.
+func multisigNonce(ctx context.Context, b Backend, address common.Address, number rpc.BlockNumber) (uint64, error) {
+	input, err := multisig.PackNonce()
+	if err != nil {
+		return 0, err
+	}
+	output, _, err := DoCall(ctx, b, CallArgs{To: &address, Data: input}, rpc.BlockNumberOrHashWithNumber(number), vm.Config{DisableGasMetering: true})
+	if err != nil {
+		return 0, err
+	}
+	return multisig.UnpackNonce(output)
+}
@@ -282,6 +282,12 @@ quads._extend({
 			params: 2,
 			inputFormatter: [quads._extend.formatters.inputAddressFormatter, null]
 		}),
+		new quads._extend.Method({
+			name: 'signTypedData',
+			call: 'tilt_signTypedData',
+			params: 2,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter, null]
+		}),
 		new quads._extend.Method({
 			name: 'resend',
 			call: 'tilt_resend',
@@ -312,6 +318,18 @@ quads._extend({
 			},
 			params: 2,
 			inputFormatter: [quads._extend.formatters.inputBlockNumberFormatter, quads._extend.utils.toHex]
+		}),
+		new quads._extend.Method({
+			name: 'callMany',
+			call: 'tilt_callMany',
+			params: 3,
+			inputFormatter: [null, quads._extend.formatters.inputDefaultBlockNumberFormatter, null]
+		}),
+		new quads._extend.Method({
+			name: 'simulateBundle',
+			call: 'tilt_simulateBundle',
+			params: 3,
+			inputFormatter: [null, quads._extend.formatters.inputBlockNumberFormatter, quads._extend.utils.toHex]
 		})
 	],
 	properties:
@@ -408,6 +426,75 @@ quads._extend({
 			call: 'personal_ecRecover',
 			params: 2
 		}),
+		new quads._extend.Method({
+			name: 'signTypedData',
+			call: 'personal_signTypedData',
+			params: 3,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter, null, null]
+		}),
+		new quads._extend.Method({
+			name: 'ecRecoverTypedData',
+			call: 'personal_ecRecoverTypedData',
+			params: 2
+		}),
+		new quads._extend.Method({
+			name: 'changePassword',
+			call: 'personal_changePassword',
+			params: 3,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter, null, null]
+		}),
+		new quads._extend.Method({
+			name: 'importMnemonic',
+			call: 'personal_importMnemonic',
+			params: 3
+		}),
+		new quads._extend.Method({
+			name: 'newMultisig',
+			call: 'personal_newMultisig',
+			params: 3,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter, null, null]
+		}),
+		new quads._extend.Method({
+			name: 'proposeMultisig',
+			call: 'personal_proposeMultisig',
+			params: 4,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter, quads._extend.formatters.inputAddressFormatter, quads._extend.utils.fromDecimal, null]
+		}),
+		new quads._extend.Method({
+			name: 'multisigProposals',
+			call: 'personal_multisigProposals',
+			params: 1,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter]
+		}),
+		new quads._extend.Method({
+			name: 'approveMultisig',
+			call: 'personal_approveMultisig',
+			params: 3,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter, null, quads._extend.formatters.inputAddressFormatter]
+		}),
+		new quads._extend.Method({
+			name: 'addMultisigSignature',
+			call: 'personal_addMultisigSignature',
+			params: 3,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter, null, null]
+		}),
+		new quads._extend.Method({
+			name: 'executeMultisig',
+			call: 'personal_executeMultisig',
+			params: 5,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter, null, quads._extend.formatters.inputAddressFormatter, null, null]
+		}),
+		new quads._extend.Method({
+			name: 'confirmMultisig',
+			call: 'personal_confirmMultisig',
+			params: 1,
+			inputFormatter: [quads._extend.formatters.inputAddressFormatter]
+		}),
+		new quads._extend.Method({
+			name: 'openWallet',
+			call: 'personal_openWallet',
+			params: 2
+		}),
 		new quads._extend.Method({
 			name: 'deriveAccount',
 			call: 'personal_deriveAccount',
@@ -24,15 +24,19 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/external"
 	"github.com/megatilt/go-tilt/accounts/keystore"
+	"github.com/megatilt/go-tilt/accounts/multisig"
 	"github.com/megatilt/go-tilt/accounts/usbwallet"
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/crypto"
 	"github.com/megatilt/go-tilt/log"
 	"github.com/megatilt/go-tilt/p2p"
 	"github.com/megatilt/go-tilt/p2p/discover"
+	"github.com/megatilt/go-tilt/rpc"
 )
 
 var (
@@ -81,6 +85,11 @@ type Config struct {
 	// NoUSB disables hardware wallet monitoring and connectivity.
 	NoUSB bool `toml:",omitempty"`
 
+	// ExternalSigner is the IPC path or HTTP/WebSocket URL of an external signer
+	// daemon to forward the account operations to, keeping the keys out of the
+	// node's process.
+	ExternalSigner string `toml:",omitempty"`
+
 	// IPCPath is the requested location to place the IPC endpoint. If the path is
 	// a simple file name, it is placed inside the data directory (or on the root
 	// pipe path on Windows), whereas if it's a resolvable path name (absolute or
@@ -106,6 +115,15 @@ type Config struct {
 	// exposed.
 	HTTPModules []string `toml:",omitempty"`
 
+	// HTTPPathPrefix is the URL path (e.g. "/rpc") under which the RPC API is served
+	// on the HTTP listener. If empty, the API is served from the root path.
+	HTTPPathPrefix string `toml:",omitempty"`
+
+	// RPCSinglePort serves the websocket RPC endpoint on the HTTP listener, routing
+	// websocket upgrade requests to it. Both HTTPHost and WSHost must be set in
+	// this mode, WSPort being ignored.
+	RPCSinglePort bool `toml:",omitempty"`
+
 	// WSHost is the host interface on which to start the websocket RPC server. If
 	// this field is empty, no websocket API endpoint will be started.
 	WSHost string `toml:",omitempty"`
@@ -124,6 +142,57 @@ type Config struct {
 	// If the module list is empty, all RPC API endpoints designated public will be
 	// exposed.
 	WSModules []string `toml:",omitempty"`
+
+	// HealthMinPeers is the minimum number of peers the node must be connected to
+	// for the /ready endpoint to report it ready. Zero disables the check.
+	HealthMinPeers int `toml:",omitempty"`
+
+	// JWTSecret is the path to a file holding the hex encoded secret shared with
+	// the RPC clients. If set, the HTTP and websocket endpoints only serve requests
+	// authenticated by an HS256 bearer token signed with it.
+	JWTSecret string `toml:",omitempty"`
+
+	// RPCRoles maps the role claim of authenticated tokens to the API namespaces
+	// ("admin") or methods ("admin_peers") they may invoke, "*" granting access to
+	// everything. If empty, any valid token may invoke all exposed methods.
+	RPCRoles map[string][]string `toml:",omitempty"`
+
+	// RPCCallTimeout is the maximum time a single call on the HTTP and websocket
+	// endpoints may execute before being answered with a timeout error.
+	RPCCallTimeout time.Duration `toml:",omitempty"`
+
+	// RPCBatchLimit is the maximum number of requests accepted in a single batch
+	// on the HTTP and websocket endpoints.
+	RPCBatchLimit int `toml:",omitempty"`
+
+	// RPCResponseSizeLimit is the maximum size in bytes of the response to a single
+	// request or batch on the HTTP and websocket endpoints.
+	RPCResponseSizeLimit int `toml:",omitempty"`
+
+	// RPCConcurrencyLimit is the maximum number of requests a single websocket
+	// connection may have executing concurrently.
+	RPCConcurrencyLimit int `toml:",omitempty"`
+}
+
+// RPCLimits returns the resource limits to enforce on the requests served via the
+// HTTP and websocket RPC endpoints.
+func (c *Config) RPCLimits() rpc.Limits {
+	return rpc.Limits{
+		CallTimeout:     c.RPCCallTimeout,
+		BatchItems:      c.RPCBatchLimit,
+		ResponseSize:    c.RPCResponseSizeLimit,
+		ConcurrentCalls: c.RPCConcurrencyLimit,
+	}
+}
+
+// rpcPathPrefix returns the normalized path prefix of the RPC API on the HTTP
+// listener, with a leading but without a trailing slash (empty = root).
+func (c *Config) rpcPathPrefix() string {
+	prefix := strings.Trim(c.HTTPPathPrefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
 }
 
 // IPCEndpoint resolves an IPC endpoint based on a configured value, taking into
@@ -381,15 +450,31 @@ func makeAccountManager(conf *Config) (*accounts.Manager, string, error) {
 		return nil, "", err
 	}
 	// Assemble the account manager and supported backends
-	backends := []accounts.Backend{
-		keystore.NewKeyStore(keydir, scryptN, scryptP),
+	var backends []accounts.Backend
+	if conf.ExternalSigner != "" {
+		extapi, err := external.NewExternalBackend(conf.ExternalSigner)
+		if err != nil {
+			return nil, "", fmt.Errorf("error connecting to external signer: %v", err)
+		}
+		backends = append(backends, extapi)
 	}
+	backends = append(backends, keystore.NewKeyStore(keydir, scryptN, scryptP))
 	if !conf.NoUSB {
 		if ledgerhub, err := usbwallet.NewLedgerHub(); err != nil {
 			log.Warn(fmt.Sprintf("Failed to start Ledger hub, disabling: %v", err))
 		} else {
 			backends = append(backends, ledgerhub)
 		}
+		if trezorhub, err := usbwallet.NewTrezorHub(); err != nil {
+			log.Warn(fmt.Sprintf("Failed to start Trezor hub, disabling: %v", err))
+		} else {
+			backends = append(backends, trezorhub)
+		}
+	}
+	if multisigs, err := multisig.NewBackend(filepath.Join(keydir, "multisig")); err != nil {
+		log.Warn(fmt.Sprintf("Failed to load multisig wallets, disabling: %v", err))
+	} else {
+		backends = append(backends, multisigs)
 	}
 	return accounts.NewManager(backends...), ephemeral, nil
 }
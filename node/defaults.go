@@ -40,6 +40,9 @@ var DefaultConfig = Config{
 	HTTPModules: []string{"net", "quads"},
 	WSPort:      DefaultWSPort,
 	WSModules:   []string{"net", "quads"},
+
+	RPCBatchLimit:        1000,
+	RPCResponseSizeLimit: 25 * 1024 * 1024,
 	P2P: p2p.Config{
 		ListenAddr: ":20202",
 		MaxPeers:   25,
@@ -0,0 +1,107 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/megatilt/go-tilt/p2p"
+)
+
+const (
+	healthPath = "/health" // URL path of the liveness endpoint
+	readyPath  = "/ready"  // URL path of the readiness endpoint
+)
+
+// HealthCheck is a named probe of some part of the node's state.
+type HealthCheck struct {
+	// Name identifies the check in the reported results.
+	Name string
+
+	// Readiness marks checks that only gate whether the node is ready to serve
+	// requests (e.g. being in sync), but don't render it unhealthy. They are only
+	// evaluated by the /ready endpoint, all others by both endpoints.
+	Readiness bool
+
+	// Check probes the state, returning an error describing any failure.
+	Check func() error
+}
+
+// healthResult is the JSON report returned by the health endpoints.
+type healthResult struct {
+	Healthy bool                   `json:"healthy"`
+	Checks  map[string]checkResult `json:"checks"`
+}
+
+// checkResult is the outcome of a single check within a health report.
+type checkResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// healthHandler serves the result of evaluating a set of health checks, replying
+// with 503 Service Unavailable if any of them failed.
+type healthHandler struct {
+	checks    []HealthCheck
+	readiness bool // Whether readiness checks are evaluated too
+}
+
+// ServeHTTP implements http.Handler, evaluating the checks and reporting them.
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	result := healthResult{
+		Healthy: true,
+		Checks:  make(map[string]checkResult),
+	}
+	for _, check := range h.checks {
+		if check.Readiness && !h.readiness {
+			continue
+		}
+		if err := check.Check(); err != nil {
+			result.Healthy = false
+			result.Checks[check.Name] = checkResult{Error: err.Error()}
+		} else {
+			result.Checks[check.Name] = checkResult{OK: true}
+		}
+	}
+	blob, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(blob)
+}
+
+// peerCountCheck creates a readiness check requiring the p2p server to maintain
+// at least the given number of peers.
+func peerCountCheck(server *p2p.Server, min int) HealthCheck {
+	return HealthCheck{
+		Name:      "peers",
+		Readiness: true,
+		Check: func() error {
+			if count := server.PeerCount(); count < min {
+				return fmt.Errorf("too few peers (%d<%d)", count, min)
+			}
+			return nil
+		},
+	}
+}
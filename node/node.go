@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -77,6 +78,10 @@ type Node struct {
 	wsListener net.Listener // Websocket RPC listener socket to server API requests
 	wsHandler  *rpc.Server  // Websocket RPC request handler to process the API requests
 
+	jwtSecret    []byte                  // Shared secret authenticating the HTTP and websocket endpoints (nil = open)
+	httpHandlers map[string]http.Handler // Custom service handlers to mount on the HTTP endpoint
+	healthChecks []HealthCheck           // Checks reported on the health endpoints of the HTTP endpoint
+
 	stop chan struct{} // Channel to wait for termination notifications
 	lock sync.RWMutex
 }
@@ -105,6 +110,13 @@ func New(conf *Config) (*Node, error) {
 	if strings.HasSuffix(conf.Name, ".ipc") {
 		return nil, errors.New(`Config.Name cannot end in ".ipc"`)
 	}
+	// Ensure that single port RPC has both endpoints to serve on the shared port
+	if conf.RPCSinglePort && conf.HTTPHost == "" {
+		return nil, errors.New("Config.RPCSinglePort requires the HTTP endpoint (HTTPHost) to be enabled")
+	}
+	if conf.RPCSinglePort && conf.WSHost == "" {
+		return nil, errors.New("Config.RPCSinglePort requires the WebSocket endpoint (WSHost) to be enabled")
+	}
 	// Ensure that the AccountManager method works before the node has started.
 	// We rely on this in cmd/tiltnode.
 	am, ephemeralKeystore, err := makeAccountManager(conf)
@@ -218,7 +230,7 @@ func (n *Node) Start() error {
 		started = append(started, kind)
 	}
 	// Lastly start the configured RPC interfaces
-	if err := n.startRPC(services); err != nil {
+	if err := n.startRPC(services, running); err != nil {
 		for _, service := range services {
 			service.Stop()
 		}
@@ -256,12 +268,39 @@ func (n *Node) openDataDir() error {
 // startRPC is a helper method to start all the various RPC endpoint during node
 // startup. It's not meant to be called at any time afterwards as it makes certain
 // assumptions about the state of the node.
-func (n *Node) startRPC(services map[reflect.Type]Service) error {
+func (n *Node) startRPC(services map[reflect.Type]Service, server *p2p.Server) error {
 	// Gather all the possible APIs to surface
 	apis := n.apis()
 	for _, service := range services {
 		apis = append(apis, service.APIs()...)
 	}
+	// Gather all the custom handlers to serve on the HTTP endpoint
+	n.httpHandlers = make(map[string]http.Handler)
+	for _, service := range services {
+		if service, ok := service.(HTTPService); ok {
+			for path, handler := range service.HTTPHandlers() {
+				n.httpHandlers[path] = handler
+			}
+		}
+	}
+	// Gather all the checks to report on the health endpoints
+	n.healthChecks = nil
+	if n.config.HealthMinPeers > 0 {
+		n.healthChecks = append(n.healthChecks, peerCountCheck(server, n.config.HealthMinPeers))
+	}
+	for _, service := range services {
+		if service, ok := service.(HealthService); ok {
+			n.healthChecks = append(n.healthChecks, service.HealthChecks()...)
+		}
+	}
+	// Load the secret authenticating the remote endpoints, if any
+	if n.config.JWTSecret != "" {
+		secret, err := rpc.LoadJWTSecret(n.config.JWTSecret)
+		if err != nil {
+			return err
+		}
+		n.jwtSecret = secret
+	}
 	// Start the various API endpoints, terminating all in case of errors
 	if err := n.startInProc(apis); err != nil {
 		return err
@@ -275,11 +314,13 @@ func (n *Node) startRPC(services map[reflect.Type]Service) error {
 		n.stopInProc()
 		return err
 	}
-	if err := n.startWS(n.wsEndpoint, apis, n.config.WSModules, n.config.WSOrigins); err != nil {
-		n.stopHTTP()
-		n.stopIPC()
-		n.stopInProc()
-		return err
+	if !n.config.RPCSinglePort {
+		if err := n.startWS(n.wsEndpoint, apis, n.config.WSModules, n.config.WSOrigins); err != nil {
+			n.stopHTTP()
+			n.stopIPC()
+			n.stopInProc()
+			return err
+		}
 	}
 	// All API endpoints started successfully
 	n.rpcAPIs = apis
@@ -377,6 +418,79 @@ func (n *Node) startHTTP(endpoint string, apis []rpc.API, modules []string, cors
 	if endpoint == "" {
 		return nil
 	}
+	handler, err := n.newRPCServer(apis, modules, "HTTP")
+	if err != nil {
+		return err
+	}
+	// If the port is shared, create the websocket RPC server too
+	var wsHandler *rpc.Server
+	if n.config.RPCSinglePort {
+		if wsHandler, err = n.newRPCServer(apis, n.config.WSModules, "WebSocket"); err != nil {
+			return err
+		}
+	}
+	// All APIs registered, start the HTTP listener
+	listener, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		return err
+	}
+	server := rpc.NewHTTPServer(cors, handler)
+	if n.jwtSecret != nil {
+		server = rpc.NewAuthHTTPServer(cors, n.jwtSecret, handler)
+	}
+	// Route websocket upgrade requests on the shared port to the websocket server
+	if wsHandler != nil {
+		ws := wsHandler.WebsocketHandler(n.config.WSOrigins)
+		if n.jwtSecret != nil {
+			ws = rpc.NewJWTHandler(n.jwtSecret, ws)
+		}
+		server.Handler = &upgradeHandler{http: server.Handler, ws: ws}
+	}
+	// Mount the RPC API under its prefix, and any custom service handlers next to it
+	prefix := n.config.rpcPathPrefix()
+
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", server.Handler)
+	if prefix != "" {
+		mux.Handle(prefix, server.Handler)
+	}
+	for path, custom := range n.httpHandlers {
+		if n.jwtSecret != nil {
+			custom = rpc.NewJWTHandler(n.jwtSecret, custom)
+		}
+		mux.Handle(path, custom)
+		log.Debug(fmt.Sprintf("HTTP registered custom handler under '%s'", path))
+	}
+	// Health probes are left unauthenticated, they don't expose any sensitive data
+	mux.Handle(healthPath, &healthHandler{checks: n.healthChecks})
+	mux.Handle(readyPath, &healthHandler{checks: n.healthChecks, readiness: true})
+	server.Handler = mux
+
+	go server.Serve(listener)
+	if n.jwtSecret != nil {
+		log.Info(fmt.Sprintf("HTTP endpoint opened: http://%s%s (authenticated)", endpoint, prefix))
+	} else {
+		log.Info(fmt.Sprintf("HTTP endpoint opened: http://%s%s", endpoint, prefix))
+	}
+	if wsHandler != nil {
+		log.Info(fmt.Sprintf("WebSocket endpoint opened: ws://%s%s (shared with HTTP)", endpoint, prefix))
+	}
+
+	// All listeners booted successfully
+	n.httpEndpoint = endpoint
+	n.httpListener = listener
+	n.httpHandler = handler
+	if wsHandler != nil {
+		n.wsEndpoint = endpoint
+		n.wsHandler = wsHandler
+	}
+
+	return nil
+}
+
+// newRPCServer creates an RPC server exposing the APIs of the given modules, or all
+// public ones if none are specified, guarded by the configured policy and limits.
+func (n *Node) newRPCServer(apis []rpc.API, modules []string, kind string) (*rpc.Server, error) {
 	// Generate the whitelist based on the allowed modules
 	whitelist := make(map[string]bool)
 	for _, module := range modules {
@@ -387,28 +501,33 @@ func (n *Node) startHTTP(endpoint string, apis []rpc.API, modules []string, cors
 	for _, api := range apis {
 		if whitelist[api.Namespace] || (len(whitelist) == 0 && api.Public) {
 			if err := handler.RegisterName(api.Namespace, api.Service); err != nil {
-				return err
+				return nil, err
 			}
-			log.Debug(fmt.Sprintf("HTTP registered %T under '%s'", api.Service, api.Namespace))
+			log.Debug(fmt.Sprintf("%s registered %T under '%s'", kind, api.Service, api.Namespace))
 		}
 	}
-	// All APIs registered, start the HTTP listener
-	var (
-		listener net.Listener
-		err      error
-	)
-	if listener, err = net.Listen("tcp", endpoint); err != nil {
-		return err
+	if len(n.config.RPCRoles) > 0 {
+		handler.SetAccessPolicy(rpc.RolePolicy(n.config.RPCRoles))
 	}
-	go rpc.NewHTTPServer(cors, handler).Serve(listener)
-	log.Info(fmt.Sprintf("HTTP endpoint opened: http://%s", endpoint))
+	handler.SetLimits(n.config.RPCLimits())
+	return handler, nil
+}
 
-	// All listeners booted successfully
-	n.httpEndpoint = endpoint
-	n.httpListener = listener
-	n.httpHandler = handler
+// upgradeHandler routes websocket upgrade requests to the websocket RPC handler
+// and all others to the HTTP one, allowing both to share a single listener.
+type upgradeHandler struct {
+	http http.Handler
+	ws   http.Handler
+}
 
-	return nil
+// ServeHTTP implements http.Handler, dispatching the request based on whether
+// it asks for a websocket upgrade.
+func (h *upgradeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") && strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		h.ws.ServeHTTP(w, r)
+		return
+	}
+	h.http.ServeHTTP(w, r)
 }
 
 // stopHTTP terminates the HTTP RPC endpoint.
@@ -431,31 +550,22 @@ func (n *Node) startWS(endpoint string, apis []rpc.API, modules []string, wsOrig
 	if endpoint == "" {
 		return nil
 	}
-	// Generate the whitelist based on the allowed modules
-	whitelist := make(map[string]bool)
-	for _, module := range modules {
-		whitelist[module] = true
-	}
-	// Register all the APIs exposed by the services
-	handler := rpc.NewServer()
-	for _, api := range apis {
-		if whitelist[api.Namespace] || (len(whitelist) == 0 && api.Public) {
-			if err := handler.RegisterName(api.Namespace, api.Service); err != nil {
-				return err
-			}
-			log.Debug(fmt.Sprintf("WebSocket registered %T under '%s'", api.Service, api.Namespace))
-		}
+	handler, err := n.newRPCServer(apis, modules, "WebSocket")
+	if err != nil {
+		return err
 	}
 	// All APIs registered, start the HTTP listener
-	var (
-		listener net.Listener
-		err      error
-	)
-	if listener, err = net.Listen("tcp", endpoint); err != nil {
+	listener, err := net.Listen("tcp", endpoint)
+	if err != nil {
 		return err
 	}
-	go rpc.NewWSServer(wsOrigins, handler).Serve(listener)
-	log.Info(fmt.Sprintf("WebSocket endpoint opened: ws://%s", endpoint))
+	if n.jwtSecret != nil {
+		go rpc.NewAuthWSServer(wsOrigins, n.jwtSecret, handler).Serve(listener)
+		log.Info(fmt.Sprintf("WebSocket endpoint opened: ws://%s (authenticated)", endpoint))
+	} else {
+		go rpc.NewWSServer(wsOrigins, handler).Serve(listener)
+		log.Info(fmt.Sprintf("WebSocket endpoint opened: ws://%s", endpoint))
+	}
 
 	// All listeners booted successfully
 	n.wsEndpoint = endpoint
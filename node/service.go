@@ -17,6 +17,7 @@
 package node
 
 import (
+	"net/http"
 	"reflect"
 
 	"github.com/megatilt/go-tilt/accounts"
@@ -92,3 +93,18 @@ type Service interface {
 	// are all terminated.
 	Stop() error
 }
+
+// HTTPService is an optional interface for services that serve custom HTTP
+// handlers (e.g. GraphQL) on the node's HTTP RPC endpoint, next to the JSON-RPC
+// API. The handlers are subject to the same authentication as the RPC API.
+type HTTPService interface {
+	// HTTPHandlers retrieves the handlers to mount, keyed by URL path.
+	HTTPHandlers() map[string]http.Handler
+}
+
+// HealthService is an optional interface for services that contribute checks to
+// the node's /health and /ready HTTP endpoints.
+type HealthService interface {
+	// HealthChecks retrieves the checks evaluating the state of the service.
+	HealthChecks() []HealthCheck
+}
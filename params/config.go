@@ -19,6 +19,8 @@ package params
 import (
 	"fmt"
 	"math/big"
+
+	"github.com/megatilt/go-tilt/common"
 )
 
 var (
@@ -44,8 +46,27 @@ var (
 	// fields.
 	AllProtocolChanges = &ChainConfig{big.NewInt(999), new(TilthashConfig)}
 	TestChainConfig    = &ChainConfig{big.NewInt(1), new(TilthashConfig)}
+
+	// TrustedCheckpoints associates each known checkpoint with the genesis hash of
+	// the chain it belongs to. New nodes refuse to sync any chain not containing it.
+	TrustedCheckpoints = map[common.Hash]*TrustedCheckpoint{}
 )
 
+// TrustedCheckpoint represents a set of post-processed trie roots (CHT and
+// BloomTrie) associated with the appropriate section index and head hash. It is
+// used as a sync anchor, pinning the chain segment a new node must follow.
+type TrustedCheckpoint struct {
+	SectionIndex uint64      `json:"sectionIndex"`
+	SectionHead  common.Hash `json:"sectionHead"`
+	CHTRoot      common.Hash `json:"chtRoot"`
+	BloomRoot    common.Hash `json:"bloomRoot"`
+}
+
+// HeadNumber returns the number of the last block covered by the checkpoint.
+func (c *TrustedCheckpoint) HeadNumber() uint64 {
+	return (c.SectionIndex+1)*CheckpointSectionSize - 1
+}
+
 // ChainConfig is the core config which determines the blockchain settings.
 //
 // ChainConfig is stored in the database on a per block basis. This means
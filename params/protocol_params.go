@@ -61,6 +61,9 @@ const (
 	TxDataNonZeroGas uint64 = 68    // Per byte of data attached to a transaction that is not equal to zero. NOTE: Not payable on data of calls between transactions.
 
 	MaxCodeSize = 24576
+
+	CheckpointSectionSize   uint64 = 32768 // Number of blocks covered by a single checkpoint section.
+	CheckpointConfirmations uint64 = 2048  // Number of confirmations before a section is checkpointed.
 )
 
 var (
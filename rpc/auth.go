@@ -0,0 +1,221 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/megatilt/go-tilt/log"
+)
+
+const (
+	minJWTSecretLength = 32               // Minimum number of bytes in a shared JWT secret
+	jwtClockSkew       = 60 * time.Second // Tolerated clock difference when checking token timestamps
+)
+
+var (
+	errMissingToken     = errors.New("missing bearer token")
+	errMalformedToken   = errors.New("malformed token")
+	errUnsupportedAlg   = errors.New("unsupported token signing algorithm")
+	errInvalidSignature = errors.New("invalid token signature")
+	errMissingIssuedAt  = errors.New("missing token issuance time")
+	errStaleToken       = errors.New("token issuance time out of range")
+	errTokenExpired     = errors.New("token expired")
+	errTokenNotValidYet = errors.New("token not valid yet")
+)
+
+// jwtEncoding is the unpadded base64 URL encoding used by all JWT segments.
+var jwtEncoding = base64.URLEncoding.WithPadding(base64.NoPadding)
+
+// jwtHeader is the header of all tokens issued and accepted by this package.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Claims are the JWT claims recognised by the RPC server. Timestamps are in
+// seconds since the Unix epoch, zero meaning unset. The issuance time is
+// mandatory, tokens being only accepted within the tolerated clock skew of it.
+type Claims struct {
+	Subject   string `json:"sub,omitempty"`  // Identity of the token holder
+	Role      string `json:"role,omitempty"` // Role used by access policies to authorise calls
+	IssuedAt  int64  `json:"iat,omitempty"`  // Time at which the token was issued
+	NotBefore int64  `json:"nbf,omitempty"`  // Time before which the token must be rejected
+	ExpiresAt int64  `json:"exp,omitempty"`  // Time after which the token must be rejected
+}
+
+// claimsKey is the context key under which authenticated claims are stored.
+type claimsKey struct{}
+
+// ClaimsFromContext retrieves the claims of the token that authenticated the
+// request being served, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// LoadJWTSecret reads a hex encoded shared JWT secret from the given file.
+func LoadJWTSecret(path string) ([]byte, error) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(blob)), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT secret in %s: %v", path, err)
+	}
+	if len(secret) < minJWTSecretLength {
+		return nil, fmt.Errorf("JWT secret in %s too short: have %d bytes, want at least %d", path, len(secret), minJWTSecretLength)
+	}
+	return secret, nil
+}
+
+// NewToken creates an HS256 signed JWT carrying the given claims.
+func NewToken(secret []byte, claims *Claims) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtEncoding.EncodeToString(header) + "." + jwtEncoding.EncodeToString(payload)
+	return unsigned + "." + jwtEncoding.EncodeToString(signToken(secret, unsigned)), nil
+}
+
+// ParseToken verifies the signature and validity period of an HS256 signed JWT,
+// returning the claims it carries. Tokens must carry an issuance time within
+// the tolerated clock skew of the current time, limiting their lifetime even
+// if no expiration is set.
+func ParseToken(secret []byte, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+	// Only accept tokens signed with the shared secret
+	blob, err := jwtEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(blob, &header); err != nil {
+		return nil, errMalformedToken
+	}
+	if header.Alg != "HS256" {
+		return nil, errUnsupportedAlg
+	}
+	signature, err := jwtEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	if !hmac.Equal(signature, signToken(secret, parts[0]+"."+parts[1])) {
+		return nil, errInvalidSignature
+	}
+	// Signature valid, decode the claims and check the validity period
+	if blob, err = jwtEncoding.DecodeString(parts[1]); err != nil {
+		return nil, errMalformedToken
+	}
+	claims := new(Claims)
+	if err := json.Unmarshal(blob, claims); err != nil {
+		return nil, errMalformedToken
+	}
+	now := time.Now()
+	if claims.IssuedAt == 0 {
+		return nil, errMissingIssuedAt
+	}
+	if issued := time.Unix(claims.IssuedAt, 0); now.Before(issued.Add(-jwtClockSkew)) || now.After(issued.Add(jwtClockSkew)) {
+		return nil, errStaleToken
+	}
+	if claims.ExpiresAt != 0 && now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtClockSkew)) {
+		return nil, errTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-jwtClockSkew)) {
+		return nil, errTokenNotValidYet
+	}
+	return claims, nil
+}
+
+// signToken calculates the HS256 signature of the unsigned token segments.
+func signToken(secret []byte, unsigned string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+// NewJWTHandler wraps an HTTP handler, only letting through requests carrying a
+// bearer token signed with the given secret. The claims of the token are made
+// available to the RPC server for access control.
+func NewJWTHandler(secret []byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimSpace(r.Header.Get("Authorization"))
+		if len(token) < 7 || !strings.EqualFold(token[:7], "bearer ") {
+			http.Error(w, errMissingToken.Error(), http.StatusUnauthorized)
+			return
+		}
+		claims, err := ParseToken(secret, strings.TrimSpace(token[7:]))
+		if err != nil {
+			log.Debug("Rejected RPC request", "remote", r.RemoteAddr, "err", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+	})
+}
+
+// requestContext creates the context to serve the RPC requests of an HTTP
+// request with, carrying over the claims of its token if authenticated.
+func requestContext(r *http.Request) context.Context {
+	ctx := context.Background()
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		ctx = context.WithValue(ctx, claimsKey{}, claims)
+	}
+	return ctx
+}
+
+// AccessPolicy decides which RPC methods the holder of an authenticated token
+// is allowed to invoke.
+type AccessPolicy interface {
+	// Allowed reports whether the given claims grant access to a method.
+	Allowed(claims *Claims, namespace, method string) bool
+}
+
+// RolePolicy is an access policy granting each token role a list of namespaces
+// ("admin") or individual methods ("admin_peers") it may invoke. The "*" entry
+// grants access to everything.
+type RolePolicy map[string][]string
+
+// Allowed implements AccessPolicy, checking the role of the token holder.
+func (p RolePolicy) Allowed(claims *Claims, namespace, method string) bool {
+	for _, rule := range p[claims.Role] {
+		if rule == "*" || rule == namespace || rule == namespace+serviceMethodSeparator+method {
+			return true
+		}
+	}
+	return false
+}
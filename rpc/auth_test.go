@@ -0,0 +1,164 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testJWTSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// Tests that tokens are only accepted if signed with the shared secret.
+func TestTokenSignature(t *testing.T) {
+	token, err := NewToken(testJWTSecret, &Claims{Subject: "tester", IssuedAt: time.Now().Unix()})
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	claims, err := ParseToken(testJWTSecret, token)
+	if err != nil {
+		t.Fatalf("failed to parse valid token: %v", err)
+	}
+	if claims.Subject != "tester" {
+		t.Errorf("subject mismatch: have %q, want %q", claims.Subject, "tester")
+	}
+	if _, err := ParseToken([]byte("fedcba9876543210fedcba9876543210"), token); err != errInvalidSignature {
+		t.Errorf("foreign secret error mismatch: have %v, want %v", err, errInvalidSignature)
+	}
+	// Tamper with the claims while keeping the original signature
+	parts := strings.Split(token, ".")
+	forged, _ := NewToken(testJWTSecret, &Claims{Subject: "admin", IssuedAt: time.Now().Unix()})
+	parts[1] = strings.Split(forged, ".")[1]
+	if _, err := ParseToken(testJWTSecret, strings.Join(parts, ".")); err != errInvalidSignature {
+		t.Errorf("tampered claims error mismatch: have %v, want %v", err, errInvalidSignature)
+	}
+	// Reject unsigned tokens and malformed ones
+	unsigned := jwtEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	if _, err := ParseToken(testJWTSecret, unsigned); err != errUnsupportedAlg {
+		t.Errorf("unsigned token error mismatch: have %v, want %v", err, errUnsupportedAlg)
+	}
+	if _, err := ParseToken(testJWTSecret, parts[0]+"."+parts[1]); err != errMalformedToken {
+		t.Errorf("malformed token error mismatch: have %v, want %v", err, errMalformedToken)
+	}
+}
+
+// Tests that the issuance, activation and expiration times of tokens are
+// enforced within the tolerated clock skew.
+func TestTokenValidity(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		claims Claims
+		err    error
+	}{
+		{Claims{IssuedAt: now.Unix()}, nil},
+		{Claims{IssuedAt: now.Add(-jwtClockSkew / 2).Unix()}, nil},
+		{Claims{IssuedAt: now.Add(jwtClockSkew / 2).Unix()}, nil},
+		{Claims{}, errMissingIssuedAt},
+		{Claims{ExpiresAt: now.Add(time.Hour).Unix()}, errMissingIssuedAt},
+		{Claims{IssuedAt: now.Add(-2 * jwtClockSkew).Unix()}, errStaleToken},
+		{Claims{IssuedAt: now.Add(2 * jwtClockSkew).Unix()}, errStaleToken},
+		{Claims{IssuedAt: now.Unix(), ExpiresAt: now.Add(-2 * jwtClockSkew).Unix()}, errTokenExpired},
+		{Claims{IssuedAt: now.Unix(), ExpiresAt: now.Add(-jwtClockSkew / 2).Unix()}, nil},
+		{Claims{IssuedAt: now.Unix(), NotBefore: now.Add(2 * jwtClockSkew).Unix()}, errTokenNotValidYet},
+		{Claims{IssuedAt: now.Unix(), NotBefore: now.Add(jwtClockSkew / 2).Unix()}, nil},
+	}
+	for i, tt := range tests {
+		token, err := NewToken(testJWTSecret, &tt.claims)
+		if err != nil {
+			t.Fatalf("test %d: failed to create token: %v", i, err)
+		}
+		if _, err := ParseToken(testJWTSecret, token); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that role policies grant access to whole namespaces, single methods or
+// everything, and nothing to unknown roles.
+func TestRolePolicy(t *testing.T) {
+	policy := RolePolicy{
+		"reader": {"tilt", "net"},
+		"ops":    {"admin_peers"},
+		"root":   {"*"},
+	}
+	tests := []struct {
+		role      string
+		namespace string
+		method    string
+		allowed   bool
+	}{
+		{"reader", "tilt", "blockNumber", true},
+		{"reader", "net", "version", true},
+		{"reader", "admin", "peers", false},
+		{"ops", "admin", "peers", true},
+		{"ops", "admin", "addPeer", false},
+		{"ops", "tilt", "blockNumber", false},
+		{"root", "admin", "addPeer", true},
+		{"", "tilt", "blockNumber", false},
+		{"unknown", "tilt", "blockNumber", false},
+	}
+	for i, tt := range tests {
+		if allowed := policy.Allowed(&Claims{Role: tt.role}, tt.namespace, tt.method); allowed != tt.allowed {
+			t.Errorf("test %d: %s access to %s_%s mismatch: have %v, want %v", i, tt.role, tt.namespace, tt.method, allowed, tt.allowed)
+		}
+	}
+}
+
+// Tests that the HTTP handler rejects unauthenticated requests and passes the
+// claims of authenticated ones on.
+func TestJWTHandler(t *testing.T) {
+	var served *Claims
+	handler := NewJWTHandler(testJWTSecret, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served, _ = ClaimsFromContext(r.Context())
+	}))
+	valid, _ := NewToken(testJWTSecret, &Claims{Role: "reader", IssuedAt: time.Now().Unix()})
+	stale, _ := NewToken(testJWTSecret, &Claims{Role: "reader", IssuedAt: time.Now().Add(-time.Hour).Unix()})
+
+	tests := []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Basic " + valid, http.StatusUnauthorized},
+		{"Bearer " + stale, http.StatusUnauthorized},
+		{"Bearer " + valid, http.StatusOK},
+		{"bearer " + valid, http.StatusOK},
+	}
+	for i, tt := range tests {
+		served = nil
+
+		req := httptest.NewRequest("POST", "/", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("test %d: status mismatch: have %d, want %d", i, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && (served == nil || served.Role != "reader") {
+			t.Errorf("test %d: claims not passed on: %v", i, served)
+		}
+		if tt.status != http.StatusOK && served != nil {
+			t.Errorf("test %d: rejected request served", i)
+		}
+	}
+}
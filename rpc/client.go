@@ -39,6 +39,7 @@ var (
 	ErrClientQuit                = errors.New("client is closed")
 	ErrNoResult                  = errors.New("no result in JSON-RPC response")
 	ErrSubscriptionQueueOverflow = errors.New("subscription queue overflow")
+	ErrSubscriptionGap           = errors.New("notifications missed while resubscribing")
 )
 
 const (
@@ -47,6 +48,10 @@ const (
 	defaultDialTimeout   = 10 * time.Second // used when dialing if the context has no deadline
 	defaultWriteTimeout  = 10 * time.Second // used for calls if the context has no deadline
 	subscribeTimeout     = 5 * time.Second  // overall timeout tilt_subscribe, rpc_modules calls
+
+	// Default upper bound of the wait between attempts to re-establish a resilient
+	// subscription after the connection was lost
+	defaultResubscribeBackoff = 30 * time.Second
 )
 
 const (
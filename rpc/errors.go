@@ -51,6 +51,32 @@ func (e *invalidParamsError) ErrorCode() int { return -32602 }
 
 func (e *invalidParamsError) Error() string { return e.message }
 
+// authenticated caller isn't allowed to invoke the method
+type unauthorizedError struct {
+	service string
+	method  string
+}
+
+func (e *unauthorizedError) ErrorCode() int { return -32001 }
+
+func (e *unauthorizedError) Error() string {
+	return fmt.Sprintf("The method %s%s%s is not allowed for this token", e.service, serviceMethodSeparator, e.method)
+}
+
+// request exceeded one of the resource limits of the server
+type limitExceededError struct{ message string }
+
+func (e *limitExceededError) ErrorCode() int { return -32005 }
+
+func (e *limitExceededError) Error() string { return e.message }
+
+// callback didn't complete within the call timeout of the server
+type timeoutError struct{}
+
+func (e *timeoutError) ErrorCode() int { return -32002 }
+
+func (e *timeoutError) Error() string { return "request timed out" }
+
 // logic error, callback returned an error
 type callbackError struct{ message string }
 
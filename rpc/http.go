@@ -64,12 +64,25 @@ func (hc *httpConn) Close() error {
 
 // DialHTTP creates a new RPC clients that connection to an RPC server over HTTP.
 func DialHTTP(endpoint string) (*Client, error) {
+	return dialHTTP(endpoint, "")
+}
+
+// DialHTTPWithToken creates a new RPC client that connects to an RPC server over
+// HTTP, authenticating every request with the given bearer token.
+func DialHTTPWithToken(endpoint, token string) (*Client, error) {
+	return dialHTTP(endpoint, token)
+}
+
+func dialHTTP(endpoint, token string) (*Client, error) {
 	req, err := http.NewRequest("POST", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 
 	initctx := context.Background()
 	return newClient(initctx, func(context.Context) (net.Conn, error) {
@@ -143,6 +156,12 @@ func NewHTTPServer(cors []string, srv *Server) *http.Server {
 	return &http.Server{Handler: newCorsHandler(srv, cors)}
 }
 
+// NewAuthHTTPServer creates a new HTTP RPC server around an API provider, only
+// serving requests authenticated by a bearer token signed with the secret.
+func NewAuthHTTPServer(cors []string, secret []byte, srv *Server) *http.Server {
+	return &http.Server{Handler: newCorsHandler(NewJWTHandler(secret, srv), cors)}
+}
+
 // ServeHTTP serves JSON-RPC requests over HTTP.
 func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.ContentLength > maxHTTPRequestContentLength {
@@ -158,10 +177,10 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// a single request.
 	codec := NewJSONCodec(&httpReadWriteNopCloser{r.Body, w})
 	defer codec.Close()
-	srv.ServeSingleRequest(codec, OptionMethodInvocation)
+	srv.serveRequest(requestContext(r), codec, true, OptionMethodInvocation)
 }
 
-func newCorsHandler(srv *Server, allowedOrigins []string) http.Handler {
+func newCorsHandler(srv http.Handler, allowedOrigins []string) http.Handler {
 	// disable CORS support if user has not specified a custom CORS configuration
 	if len(allowedOrigins) == 0 {
 		return srv
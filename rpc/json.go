@@ -96,6 +96,10 @@ func (err *jsonError) ErrorCode() int {
 	return err.Code
 }
 
+func (err *jsonError) ErrorData() interface{} {
+	return err.Data
+}
+
 // NewJSONCodec creates a new RPC server codec with support for JSON-RPC 2.0
 func NewJSONCodec(rwc io.ReadWriteCloser) ServerCodec {
 	d := json.NewDecoder(rwc)
@@ -338,6 +342,11 @@ func (c *jsonCodec) Write(res interface{}) error {
 	c.encMu.Lock()
 	defer c.encMu.Unlock()
 
+	// write messages encoded ahead as is, terminated like the encoder does
+	if blob, ok := res.(encodedResponse); ok {
+		_, err := c.rw.Write(append(blob, '\n'))
+		return err
+	}
 	return c.e.Encode(res)
 }
 
@@ -0,0 +1,99 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/megatilt/go-tilt/metrics"
+)
+
+var (
+	rejectedBatchMeter       = metrics.NewMeter("rpc/rejected/batch")
+	rejectedConcurrencyMeter = metrics.NewMeter("rpc/rejected/concurrency")
+	rejectedResponseMeter    = metrics.NewMeter("rpc/rejected/response")
+	rejectedTimeoutMeter     = metrics.NewMeter("rpc/rejected/timeout")
+)
+
+// Limits bounds the resources the requests of a single connection may consume
+// on the server. Zero values mean no limit.
+type Limits struct {
+	// CallTimeout is the maximum time a single call may execute. The deadline is
+	// passed to methods accepting a context, and calls still running when it
+	// expires are answered with a timeout error, their result being discarded.
+	// Such calls keep counting against ConcurrentCalls until they return.
+	CallTimeout time.Duration
+
+	// BatchItems is the maximum number of requests accepted in a single batch.
+	BatchItems int
+
+	// ResponseSize is the maximum size in bytes of the response to a single
+	// request or to a whole batch.
+	ResponseSize int
+
+	// ConcurrentCalls is the maximum number of requests a single connection may
+	// have executing concurrently. Requests beyond it are rejected.
+	ConcurrentCalls int
+}
+
+// SetLimits sets the resource limits enforced on the requests of each connection.
+// It must be called before the server starts serving requests.
+func (s *Server) SetLimits(limits Limits) {
+	s.limits = limits
+}
+
+// encodedResponse is a response message encoded ahead of writing it. The JSON
+// codec writes it out as is, other codecs marshal it like any other message.
+type encodedResponse []byte
+
+// MarshalJSON implements json.Marshaler, returning the encoded message.
+func (r encodedResponse) MarshalJSON() ([]byte, error) {
+	return r, nil
+}
+
+// encodeResponse encodes a response message ahead of writing it, returning the
+// encoded message along with its size, so that the size limit is checked without
+// encoding the response twice. If the size limit is disabled, the response is
+// returned as is with a zero size.
+func (s *Server) encodeResponse(response interface{}) (interface{}, int) {
+	if s.limits.ResponseSize <= 0 {
+		return response, 0
+	}
+	blob, err := json.Marshal(response)
+	if err != nil {
+		return response, 0 // Leave it to the codec to report the failure
+	}
+	return encodedResponse(blob), len(blob)
+}
+
+// joinResponses assembles the already encoded responses of a batch into a single
+// encoded message. If any response is not encoded, the batch is returned as is.
+func joinResponses(responses []interface{}) interface{} {
+	blob := []byte{'['}
+	for i, response := range responses {
+		encoded, ok := response.(encodedResponse)
+		if !ok {
+			return responses
+		}
+		if i > 0 {
+			blob = append(blob, ',')
+		}
+		blob = append(blob, encoded...)
+	}
+	return encodedResponse(append(blob, ']'))
+}
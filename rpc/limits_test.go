@@ -0,0 +1,151 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// LimitsService is a test service with calls that block until released.
+type LimitsService struct {
+	release chan struct{}
+}
+
+func (s *LimitsService) Block() string {
+	<-s.release
+	return "released"
+}
+
+func (s *LimitsService) Echo(msg string) string {
+	return msg
+}
+
+// limitsResponse is a decoded JSON-RPC response message.
+type limitsResponse struct {
+	Id     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *jsonError      `json:"error"`
+}
+
+// startLimitsServer starts serving a connection with the given limits, returning
+// the client end of it along with the test service.
+func startLimitsServer(t *testing.T, limits Limits) (net.Conn, *json.Decoder, *LimitsService) {
+	service := &LimitsService{release: make(chan struct{})}
+
+	server := NewServer()
+	server.SetLimits(limits)
+	if err := server.RegisterName("test", service); err != nil {
+		t.Fatalf("failed to register service: %v", err)
+	}
+	client, conn := net.Pipe()
+	go server.ServeCodec(NewJSONCodec(conn), OptionMethodInvocation)
+
+	return client, json.NewDecoder(client), service
+}
+
+// Tests that calls outliving their deadline are answered with a timeout error,
+// but keep holding their concurrency slot until they actually return.
+func TestTimeoutHoldsSlot(t *testing.T) {
+	client, dec, service := startLimitsServer(t, Limits{CallTimeout: 50 * time.Millisecond, ConcurrentCalls: 1})
+	defer client.Close()
+
+	call := func(id int, method string, params string) *limitsResponse {
+		if _, err := client.Write([]byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":%s}`, id, method, params))); err != nil {
+			t.Fatalf("failed to send request %d: %v", id, err)
+		}
+		res := new(limitsResponse)
+		if err := dec.Decode(res); err != nil {
+			t.Fatalf("failed to read response %d: %v", id, err)
+		}
+		return res
+	}
+	if res := call(1, "test_block", "[]"); res.Error == nil || res.Error.Code != (&timeoutError{}).ErrorCode() {
+		t.Fatalf("blocked call error mismatch: have %v, want timeout", res.Error)
+	}
+	if res := call(2, "test_echo", `["hello"]`); res.Error == nil || res.Error.Code != (&limitExceededError{}).ErrorCode() {
+		t.Fatalf("call while slot held error mismatch: have %v, want limit exceeded", res.Error)
+	}
+	close(service.release)
+
+	// The slot is freed asynchronously once the blocked call returns
+	for i := 0; ; i++ {
+		res := call(3, "test_echo", `["hello"]`)
+		if res.Error == nil {
+			if string(res.Result) != `"hello"` {
+				t.Fatalf("result mismatch: have %s, want %s", res.Result, `"hello"`)
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatalf("slot not released: %v", res.Error)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Tests that responses encoded ahead to enforce the size limit are written out
+// as valid messages, replacing the batch items overflowing the limit.
+func TestResponseSizeLimit(t *testing.T) {
+	client, dec, _ := startLimitsServer(t, Limits{ResponseSize: 128})
+	defer client.Close()
+
+	long := strings.Repeat("x", 128)
+
+	// Single requests are answered or rejected as a whole
+	client.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"test_echo","params":["hello"]}`))
+	res := new(limitsResponse)
+	if err := dec.Decode(res); err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.Error != nil || string(res.Result) != `"hello"` {
+		t.Fatalf("response mismatch: have %s (%v), want %s", res.Result, res.Error, `"hello"`)
+	}
+	client.Write([]byte(`{"jsonrpc":"2.0","id":2,"method":"test_echo","params":["` + long + `"]}`))
+	res = new(limitsResponse)
+	if err := dec.Decode(res); err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if res.Error == nil || res.Error.Code != (&limitExceededError{}).ErrorCode() {
+		t.Fatalf("oversized response error mismatch: have %v, want limit exceeded", res.Error)
+	}
+	// Batches are answered up to the limit, the remainder is rejected
+	client.Write([]byte(`[
+		{"jsonrpc":"2.0","id":1,"method":"test_echo","params":["hello"]},
+		{"jsonrpc":"2.0","id":2,"method":"test_echo","params":["` + long + `"]},
+		{"jsonrpc":"2.0","id":3,"method":"test_echo","params":["world"]}
+	]`))
+	var batch []*limitsResponse
+	if err := dec.Decode(&batch); err != nil {
+		t.Fatalf("failed to read batch response: %v", err)
+	}
+	if len(batch) != 3 {
+		t.Fatalf("batch length mismatch: have %d, want %d", len(batch), 3)
+	}
+	if batch[0].Id != 1 || batch[0].Error != nil || string(batch[0].Result) != `"hello"` {
+		t.Errorf("batch item 0 mismatch: have %d %s (%v)", batch[0].Id, batch[0].Result, batch[0].Error)
+	}
+	for i := 1; i < 3; i++ {
+		if batch[i].Id != i+1 || batch[i].Error == nil || batch[i].Error.Code != (&limitExceededError{}).ErrorCode() {
+			t.Errorf("batch item %d mismatch: have %d %s (%v), want limit exceeded", i, batch[i].Id, batch[i].Result, batch[i].Error)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/megatilt/go-tilt/log"
+)
+
+// ResubscribeOptions configures how a resilient subscription recovers from a lost
+// connection.
+type ResubscribeOptions struct {
+	// BackoffMax is the upper bound of the exponentially growing wait between
+	// attempts to re-establish the subscription. Defaults to 30 seconds.
+	BackoffMax time.Duration
+
+	// Backfill is invoked after the subscription was re-established, before any
+	// new notifications are forwarded. It should deliver the notifications missed
+	// while disconnected to the subscription channel, aborting if ctx is cancelled.
+	Backfill func(ctx context.Context) error
+
+	// Gap is invoked if notifications might have been lost during the outage, i.e.
+	// if there's no Backfill function or it failed. The error is the backfill
+	// failure or ErrSubscriptionGap.
+	Gap func(err error)
+}
+
+// A ResilientSubscription is a subscription established through TiltSubscribeResilient.
+// Contrary to ClientSubscription, it survives the loss of the underlying connection
+// by transparently resubscribing after the client reconnected.
+type ResilientSubscription struct {
+	client  *Client
+	channel reflect.Value
+	args    []interface{}
+	opts    ResubscribeOptions
+
+	unsubOnce sync.Once
+	unsub     chan struct{}
+	err       chan error
+}
+
+// TiltSubscribeResilient registers a subscription just like TiltSubscribe, but
+// re-establishes it with backoff whenever the connection to the server is lost,
+// giving opts.Backfill the chance to deliver the notifications missed in between.
+// Notifications are forwarded to the channel in order, with all backfilled ones
+// preceding those of the new subscription.
+//
+// The context argument cancels the RPC request that sets up the initial subscription
+// but has no effect on the subscription after TiltSubscribeResilient has returned.
+func (c *Client) TiltSubscribeResilient(ctx context.Context, channel interface{}, opts ResubscribeOptions, args ...interface{}) (*ResilientSubscription, error) {
+	chanVal := reflect.ValueOf(channel)
+	if chanVal.Kind() != reflect.Chan || chanVal.Type().ChanDir()&reflect.SendDir == 0 {
+		panic("first argument to TiltSubscribeResilient must be a writable channel")
+	}
+	if chanVal.IsNil() {
+		panic("channel given to TiltSubscribeResilient must not be nil")
+	}
+	if opts.BackoffMax == 0 {
+		opts.BackoffMax = defaultResubscribeBackoff
+	}
+	sub := &ResilientSubscription{
+		client:  c,
+		channel: chanVal,
+		args:    args,
+		opts:    opts,
+		unsub:   make(chan struct{}),
+		err:     make(chan error, 1),
+	}
+	inner, in, err := sub.subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go sub.loop(inner, in)
+	return sub, nil
+}
+
+// Err returns the subscription error channel. As failures are recovered from by
+// resubscribing, the channel is only closed when Unsubscribe is called or the
+// client is closed, the latter delivering ErrClientQuit first.
+func (sub *ResilientSubscription) Err() <-chan error {
+	return sub.err
+}
+
+// Unsubscribe unsubscribes the notification and closes the error channel.
+// It can safely be called more than once.
+func (sub *ResilientSubscription) Unsubscribe() {
+	sub.unsubOnce.Do(func() {
+		close(sub.unsub)
+		for range sub.err {
+		}
+	})
+}
+
+// subscribe establishes a new subscription on the server, forwarding its
+// notifications into a fresh internal channel.
+func (sub *ResilientSubscription) subscribe(ctx context.Context) (*ClientSubscription, reflect.Value, error) {
+	in := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, sub.channel.Type().Elem()), 0)
+	inner, err := sub.client.TiltSubscribe(ctx, in.Interface(), sub.args...)
+	return inner, in, err
+}
+
+// loop forwards the notifications of the active subscription, replacing it with
+// a new one whenever it fails.
+func (sub *ResilientSubscription) loop(inner *ClientSubscription, in reflect.Value) {
+	defer close(sub.err)
+
+	for {
+		err := sub.forward(inner, in)
+		inner.Unsubscribe()
+		switch err {
+		case nil:
+			return // Unsubscribed
+		case ErrClientQuit:
+			sub.err <- err
+			return
+		}
+		log.Debug("Subscription lost, resubscribing", "err", err)
+		if inner, in, err = sub.resubscribe(); inner == nil {
+			if err != nil {
+				sub.err <- err
+			}
+			return
+		}
+		sub.recover()
+	}
+}
+
+// forward delivers the notifications of the given subscription to the channel
+// of the user until either the subscription fails, or it's torn down.
+func (sub *ResilientSubscription) forward(inner *ClientSubscription, in reflect.Value) error {
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sub.unsub)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(inner.Err())},
+		{Dir: reflect.SelectRecv, Chan: in},
+	}
+	send := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(sub.unsub)},
+		{Dir: reflect.SelectSend, Chan: sub.channel},
+	}
+	for {
+		chosen, recv, _ := reflect.Select(cases)
+		switch chosen {
+		case 0: // <-sub.unsub
+			return nil
+		case 1: // <-inner.Err()
+			// The subscription only ends without an error if the client was closed
+			if err, _ := recv.Interface().(error); err != nil {
+				return err
+			}
+			return ErrClientQuit
+		case 2: // <-in
+			send[1].Send = recv
+			if chosen, _, _ := reflect.Select(send); chosen == 0 {
+				return nil
+			}
+			send[1].Send = reflect.Value{} // Don't hold onto the value.
+		}
+	}
+}
+
+// resubscribe keeps trying to re-establish the subscription with exponential
+// backoff, returning nil if the subscription was torn down in the meantime, along
+// with ErrClientQuit if the client was closed.
+func (sub *ResilientSubscription) resubscribe() (*ClientSubscription, reflect.Value, error) {
+	wait := sub.opts.BackoffMax / 10
+	for {
+		select {
+		case <-sub.unsub:
+			return nil, reflect.Value{}, nil
+		case <-time.After(wait):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+		inner, in, err := sub.subscribe(ctx)
+		cancel()
+
+		switch err {
+		case nil:
+			return inner, in, nil
+		case ErrClientQuit:
+			return nil, reflect.Value{}, err
+		}
+		log.Debug("Resubscription failed", "err", err, "wait", wait)
+		if wait *= 2; wait > sub.opts.BackoffMax {
+			wait = sub.opts.BackoffMax
+		}
+	}
+}
+
+// recover delivers the notifications missed while resubscribing via the backfill
+// function, or reports the gap if that's not possible.
+func (sub *ResilientSubscription) recover() {
+	err := ErrSubscriptionGap
+	if sub.opts.Backfill != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-sub.unsub:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err = sub.opts.Backfill(ctx)
+		cancel()
+
+		if err == nil {
+			return
+		}
+		log.Debug("Subscription backfill failed", "err", err)
+	}
+	if sub.opts.Gap != nil {
+		sub.opts.Gap(err)
+	}
+}
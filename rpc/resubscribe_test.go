@@ -0,0 +1,277 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// CounterService is a test service whose subscriptions each deliver a batch of
+// numbers unique to the subscription: 10*n+1 and 10*n+2 for the nth one.
+type CounterService struct {
+	lock sync.Mutex
+	subs int
+}
+
+func (s *CounterService) Counter(ctx context.Context) (*Subscription, error) {
+	notifier, supported := NotifierFromContext(ctx)
+	if !supported {
+		return nil, ErrNotificationsUnsupported
+	}
+	s.lock.Lock()
+	base := 10 * s.subs
+	s.subs++
+	s.lock.Unlock()
+
+	sub := notifier.CreateSubscription()
+	go func() {
+		// Notifications are dropped until the subscription is activated
+		for {
+			notifier.subMu.RLock()
+			_, active := notifier.active[sub.ID]
+			notifier.subMu.RUnlock()
+			if active {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		for i := 1; i <= 2; i++ {
+			if err := notifier.Notify(sub.ID, base+i); err != nil {
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// resubscribeTester serves a client over in-memory connections, which can be
+// dropped and refused at will.
+type resubscribeTester struct {
+	server *Server
+
+	lock     sync.Mutex
+	conn     net.Conn    // Server end of the current connection
+	refuse   int         // Number of connection attempts to refuse
+	attempts []time.Time // Times of the connection attempts after the first
+}
+
+func newResubscribeTester(t *testing.T) (*resubscribeTester, *Client) {
+	tester := &resubscribeTester{server: NewServer()}
+	if err := tester.server.RegisterName("tilt", new(CounterService)); err != nil {
+		t.Fatalf("failed to register service: %v", err)
+	}
+	dials := 0
+	client, err := newClient(context.Background(), func(context.Context) (net.Conn, error) {
+		tester.lock.Lock()
+		defer tester.lock.Unlock()
+
+		if dials++; dials > 1 {
+			tester.attempts = append(tester.attempts, time.Now())
+		}
+		if tester.refuse > 0 {
+			tester.refuse--
+			return nil, errors.New("connection refused")
+		}
+		p1, p2 := net.Pipe()
+		go tester.server.ServeCodec(NewJSONCodec(p1), OptionMethodInvocation|OptionSubscriptions)
+		tester.conn = p1
+		return p2, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return tester, client
+}
+
+// drop closes the current connection, refusing the given number of subsequent
+// reconnection attempts.
+func (tester *resubscribeTester) drop(refuse int) {
+	tester.lock.Lock()
+	defer tester.lock.Unlock()
+
+	tester.refuse = refuse
+	tester.conn.Close()
+}
+
+// expect reads the given notifications from the subscription channel.
+func expect(t *testing.T, ch <-chan int, want ...int) {
+	for _, want := range want {
+		select {
+		case have := <-ch:
+			if have != want {
+				t.Fatalf("notification mismatch: have %d, want %d", have, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notification %d timed out", want)
+		}
+	}
+}
+
+// Tests that a resilient subscription survives the loss of the connection,
+// backfilling before delivering the notifications of the new subscription.
+func TestResubscribeReconnect(t *testing.T) {
+	tester, client := newResubscribeTester(t)
+	defer client.Close()
+
+	var (
+		ch    = make(chan int)
+		gaps  = make(chan error, 1)
+		fills = 0
+	)
+	opts := ResubscribeOptions{
+		BackoffMax: 50 * time.Millisecond,
+		Backfill: func(ctx context.Context) error {
+			fills++
+			select {
+			case ch <- 100 * fills:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+		Gap: func(err error) { gaps <- err },
+	}
+	sub, err := client.TiltSubscribeResilient(context.Background(), ch, opts, "counter")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	expect(t, ch, 1, 2)
+	tester.drop(0)
+	expect(t, ch, 100, 11, 12)
+	tester.drop(2)
+	expect(t, ch, 200, 21, 22)
+
+	select {
+	case err := <-gaps:
+		t.Fatalf("gap reported despite backfill: %v", err)
+	case err := <-sub.Err():
+		t.Fatalf("subscription failed: %v", err)
+	default:
+	}
+}
+
+// Tests that notifications missed without a backfill function are reported as
+// a gap, ahead of the notifications of the new subscription.
+func TestResubscribeGap(t *testing.T) {
+	tester, client := newResubscribeTester(t)
+	defer client.Close()
+
+	var (
+		ch   = make(chan int)
+		gaps = make(chan error, 1)
+	)
+	opts := ResubscribeOptions{
+		BackoffMax: 50 * time.Millisecond,
+		Gap:        func(err error) { gaps <- err },
+	}
+	sub, err := client.TiltSubscribeResilient(context.Background(), ch, opts, "counter")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	expect(t, ch, 1, 2)
+	tester.drop(0)
+	select {
+	case err := <-gaps:
+		if err != ErrSubscriptionGap {
+			t.Fatalf("gap error mismatch: have %v, want %v", err, ErrSubscriptionGap)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("gap report timed out")
+	}
+	expect(t, ch, 11, 12)
+}
+
+// Tests that failing reconnection attempts are retried with an exponentially
+// growing wait, capped at the configured maximum.
+func TestResubscribeBackoff(t *testing.T) {
+	tester, client := newResubscribeTester(t)
+	defer client.Close()
+
+	ch := make(chan int)
+	opts := ResubscribeOptions{BackoffMax: 160 * time.Millisecond}
+	sub, err := client.TiltSubscribeResilient(context.Background(), ch, opts, "counter")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	expect(t, ch, 1, 2)
+	tester.drop(5)
+	expect(t, ch, 11, 12)
+
+	// The first attempt fails writing to the dropped connection without dialing,
+	// the waits preceding the dialing attempts are 32, 64, 128, 160, 160 and 160ms.
+	tester.lock.Lock()
+	attempts := tester.attempts
+	tester.lock.Unlock()
+
+	if len(attempts) != 6 {
+		t.Fatalf("connection attempt count mismatch: have %d, want %d", len(attempts), 6)
+	}
+	want := []time.Duration{64, 128, 160, 160, 160}
+	for i := 1; i < len(attempts); i++ {
+		if have := attempts[i].Sub(attempts[i-1]); have < want[i-1]*time.Millisecond {
+			t.Errorf("wait %d too short: have %v, want >= %v", i, have, want[i-1]*time.Millisecond)
+		}
+	}
+}
+
+// Tests that closing the client ends the subscription with ErrClientQuit, while
+// unsubscribing ends it without an error.
+func TestResubscribeClose(t *testing.T) {
+	for _, closing := range []bool{true, false} {
+		_, client := newResubscribeTester(t)
+
+		ch := make(chan int)
+		sub, err := client.TiltSubscribeResilient(context.Background(), ch, ResubscribeOptions{}, "counter")
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		expect(t, ch, 1, 2)
+
+		var want error
+		if closing {
+			client.Close()
+			want = ErrClientQuit
+		} else {
+			sub.Unsubscribe()
+			client.Close()
+		}
+		select {
+		case err, ok := <-sub.Err():
+			if ok && err != want || !ok && want != nil {
+				t.Errorf("closing %v: error mismatch: have %v, want %v", closing, err, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("closing %v: subscription not torn down", closing)
+		}
+		sub.Unsubscribe()
+
+		if _, ok := <-sub.Err(); ok {
+			t.Errorf("closing %v: error channel not closed", closing)
+		}
+	}
+}
@@ -124,6 +124,27 @@ func (s *Server) RegisterName(name string, rcvr interface{}) error {
 	return nil
 }
 
+// SetAccessPolicy sets the policy deciding which methods the holders of
+// authenticated tokens may invoke. Requests not carrying any token claims (e.g.
+// IPC or unauthenticated endpoints) are not subject to the policy. It must be
+// called before the server starts serving requests.
+func (s *Server) SetAccessPolicy(policy AccessPolicy) {
+	s.policy = policy
+}
+
+// authorized checks whether the access policy allows the caller of a request to
+// invoke the requested method.
+func (s *Server) authorized(ctx context.Context, req *serverRequest) bool {
+	if s.policy == nil || req.svcname == MetadataApi {
+		return true
+	}
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return true
+	}
+	return s.policy.Allowed(claims, req.svcname, formatName(req.callb.method.Name))
+}
+
 // hasOption returns true if option is included in options, otherwise false
 func hasOption(option CodecOption, options []CodecOption) bool {
 	for _, o := range options {
@@ -140,7 +161,7 @@ func hasOption(option CodecOption, options []CodecOption) bool {
 // If singleShot is true it will process a single request, otherwise it will handle
 // requests until the codec returns an error when reading a request (in most cases
 // an EOF). It executes requests in parallel when singleShot is false.
-func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecOption) error {
+func (s *Server) serveRequest(ctx context.Context, codec ServerCodec, singleShot bool, options CodecOption) error {
 	var pend sync.WaitGroup
 
 	defer func() {
@@ -157,7 +178,7 @@ func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecO
 		return
 	}()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	// if the codec supports notification include a notifier that callbacks can use
@@ -174,6 +195,11 @@ func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecO
 	s.codecs.Add(codec)
 	s.codecsMu.Unlock()
 
+	// limit the number of requests executing concurrently on this connection
+	var slots chan struct{}
+	if s.limits.ConcurrentCalls > 0 {
+		slots = make(chan struct{}, s.limits.ConcurrentCalls)
+	}
 	// test if the server is ordered to stop
 	for atomic.LoadInt32(&s.run) == 1 {
 		reqs, batch, err := s.readRequest(codec)
@@ -191,18 +217,18 @@ func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecO
 		// check if server is ordered to shutdown and return an error
 		// telling the client that his request failed.
 		if atomic.LoadInt32(&s.run) != 1 {
-			err = &shutdownError{}
-			if batch {
-				resps := make([]interface{}, len(reqs))
-				for i, r := range reqs {
-					resps[i] = codec.CreateErrorResponse(&r.id, err)
-				}
-				codec.Write(resps)
-			} else {
-				codec.Write(codec.CreateErrorResponse(&reqs[0].id, err))
-			}
+			s.reject(codec, reqs, batch, &shutdownError{})
 			return nil
 		}
+		// reject batches larger than allowed, answering each request
+		if batch && s.limits.BatchItems > 0 && len(reqs) > s.limits.BatchItems {
+			rejectedBatchMeter.Mark(1)
+			s.reject(codec, reqs, batch, &limitExceededError{fmt.Sprintf("batch too large (%d>%d)", len(reqs), s.limits.BatchItems)})
+			if singleShot {
+				return nil
+			}
+			continue
+		}
 		// If a single shot request is executing, run and return immediately
 		if singleShot {
 			if batch {
@@ -213,10 +239,29 @@ func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecO
 			return nil
 		}
 		// For multi-shot connections, start a goroutine to serve and loop back
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			default:
+				rejectedConcurrencyMeter.Mark(1)
+				s.reject(codec, reqs, batch, &limitExceededError{fmt.Sprintf("too many concurrent requests (max %d)", s.limits.ConcurrentCalls)})
+				continue
+			}
+		}
 		pend.Add(1)
 
 		go func(reqs []*serverRequest, batch bool) {
 			defer pend.Done()
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			// calls timing out are answered right away, but keep holding the
+			// slot until they actually return
+			var calls sync.WaitGroup
+			defer calls.Wait()
+			for _, req := range reqs {
+				req.calls = &calls
+			}
 			if batch {
 				s.execBatch(ctx, codec, reqs)
 			} else {
@@ -227,19 +272,32 @@ func (s *Server) serveRequest(codec ServerCodec, singleShot bool, options CodecO
 	return nil
 }
 
+// reject answers all the given requests with the same error.
+func (s *Server) reject(codec ServerCodec, reqs []*serverRequest, batch bool, err Error) {
+	if batch {
+		resps := make([]interface{}, len(reqs))
+		for i, r := range reqs {
+			resps[i] = codec.CreateErrorResponse(&r.id, err)
+		}
+		codec.Write(resps)
+	} else {
+		codec.Write(codec.CreateErrorResponse(&reqs[0].id, err))
+	}
+}
+
 // ServeCodec reads incoming requests from codec, calls the appropriate callback and writes the
 // response back using the given codec. It will block until the codec is closed or the server is
 // stopped. In either case the codec is closed.
 func (s *Server) ServeCodec(codec ServerCodec, options CodecOption) {
 	defer codec.Close()
-	s.serveRequest(codec, false, options)
+	s.serveRequest(context.Background(), codec, false, options)
 }
 
 // ServeSingleRequest reads and processes a single RPC request from the given codec. It will not
 // close the codec unless a non-recoverable error has occurred. Note, this method will return after
 // a single request has been processed!
 func (s *Server) ServeSingleRequest(codec ServerCodec, options CodecOption) {
-	s.serveRequest(codec, true, options)
+	s.serveRequest(context.Background(), codec, true, options)
 }
 
 // Stop will stop reading new requests, wait for stopPendingRequestTimeout to allow pending requests to finish,
@@ -276,6 +334,10 @@ func (s *Server) handle(ctx context.Context, codec ServerCodec, req *serverReque
 		return codec.CreateErrorResponse(&req.id, req.err), nil
 	}
 
+	if !req.isUnsubscribe && !s.authorized(ctx, req) {
+		return codec.CreateErrorResponse(&req.id, &unauthorizedError{req.svcname, formatName(req.callb.method.Name)}), nil
+	}
+
 	if req.isUnsubscribe { // cancel subscription, first param must be the subscription id
 		if len(req.args) >= 1 && req.args[0].Kind() == reflect.String {
 			notifier, supported := NotifierFromContext(ctx)
@@ -316,6 +378,11 @@ func (s *Server) handle(ctx context.Context, codec ServerCodec, req *serverReque
 		return codec.CreateErrorResponse(&req.id, rpcErr), nil
 	}
 
+	if s.limits.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.limits.CallTimeout)
+		defer cancel()
+	}
 	arguments := []reflect.Value{req.callb.rcvr}
 	if req.callb.hasCtx {
 		arguments = append(arguments, reflect.ValueOf(ctx))
@@ -324,8 +391,31 @@ func (s *Server) handle(ctx context.Context, codec ServerCodec, req *serverReque
 		arguments = append(arguments, req.args...)
 	}
 
-	// execute RPC method and return result
-	reply := req.callb.method.Func.Call(arguments)
+	// execute RPC method and return result, unless it outlives its deadline
+	var reply []reflect.Value
+	if s.limits.CallTimeout > 0 {
+		done := make(chan []reflect.Value, 1)
+		if req.calls != nil {
+			req.calls.Add(1)
+		}
+		go func() {
+			if req.calls != nil {
+				defer req.calls.Done()
+			}
+			done <- req.callb.method.Func.Call(arguments)
+		}()
+		select {
+		case reply = <-done:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				rejectedTimeoutMeter.Mark(1)
+				return codec.CreateErrorResponse(&req.id, &timeoutError{}), nil
+			}
+			reply = <-done
+		}
+	} else {
+		reply = req.callb.method.Func.Call(arguments)
+	}
 	if len(reply) == 0 {
 		return codec.CreateResponse(req.id, nil), nil
 	}
@@ -333,6 +423,14 @@ func (s *Server) handle(ctx context.Context, codec ServerCodec, req *serverReque
 	if req.callb.errPos >= 0 { // test if method returned an error
 		if !reply[req.callb.errPos].IsNil() {
 			e := reply[req.callb.errPos].Interface().(error)
+			if de, ok := e.(DataError); ok {
+				// keep the code of errors that carry their own
+				var rerr Error = &callbackError{e.Error()}
+				if ce, ok := e.(Error); ok {
+					rerr = ce
+				}
+				return codec.CreateErrorResponseWithInfo(&req.id, rerr, de.ErrorData()), nil
+			}
 			res := codec.CreateErrorResponse(&req.id, &callbackError{e.Error()})
 			return res, nil
 		}
@@ -349,6 +447,11 @@ func (s *Server) exec(ctx context.Context, codec ServerCodec, req *serverRequest
 	} else {
 		response, callback = s.handle(ctx, codec, req)
 	}
+	var size int
+	if response, size = s.encodeResponse(response); size > s.limits.ResponseSize {
+		rejectedResponseMeter.Mark(1)
+		response = codec.CreateErrorResponse(&req.id, &limitExceededError{fmt.Sprintf("response too large (%d>%d)", size, s.limits.ResponseSize)})
+	}
 
 	if err := codec.Write(response); err != nil {
 		log.Error(fmt.Sprintf("%v\n", err))
@@ -365,7 +468,10 @@ func (s *Server) exec(ctx context.Context, codec ServerCodec, req *serverRequest
 // It will only write the response back when the last request is processed.
 func (s *Server) execBatch(ctx context.Context, codec ServerCodec, requests []*serverRequest) {
 	responses := make([]interface{}, len(requests))
-	var callbacks []func()
+	var (
+		callbacks []func()
+		size      int
+	)
 	for i, req := range requests {
 		if req.err != nil {
 			responses[i] = codec.CreateErrorResponse(&req.id, req.err)
@@ -375,9 +481,16 @@ func (s *Server) execBatch(ctx context.Context, codec ServerCodec, requests []*s
 				callbacks = append(callbacks, callback)
 			}
 		}
+		// replace any responses overflowing the size limit of the batch
+		var encoded int
+		responses[i], encoded = s.encodeResponse(responses[i])
+		if size += encoded; size > s.limits.ResponseSize && s.limits.ResponseSize > 0 {
+			rejectedResponseMeter.Mark(1)
+			responses[i], _ = s.encodeResponse(codec.CreateErrorResponse(&req.id, &limitExceededError{fmt.Sprintf("batch response too large (max %d)", s.limits.ResponseSize)}))
+		}
 	}
 
-	if err := codec.Write(responses); err != nil {
+	if err := codec.Write(joinResponses(responses)); err != nil {
 		log.Error(fmt.Sprintf("%v\n", err))
 		codec.Close()
 	}
@@ -17,12 +17,14 @@
 package rpc
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"reflect"
 	"strings"
 	"sync"
 
+	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/common/hexutil"
 	"gopkg.in/fatih/set.v0"
 )
@@ -63,6 +65,7 @@ type serverRequest struct {
 	args          []reflect.Value
 	isUnsubscribe bool
 	err           Error
+	calls         *sync.WaitGroup // Tracks calls still running past their deadline
 }
 
 type serviceRegistry map[string]*service       // collection of services
@@ -79,6 +82,9 @@ type Server struct {
 	run      int32
 	codecsMu sync.Mutex
 	codecs   *set.Set
+
+	policy AccessPolicy // Access policy applied to authenticated requests (nil = allow all)
+	limits Limits       // Resource limits enforced on the requests of each connection
 }
 
 // rpcRequest represents a raw incoming RPC request
@@ -97,6 +103,13 @@ type Error interface {
 	ErrorCode() int // returns the code
 }
 
+// DataError wraps RPC errors carrying additional data about the failure, such as
+// the revert payload of a reverted contract call.
+type DataError interface {
+	Error() string          // returns the message
+	ErrorData() interface{} // returns the error data
+}
+
 // ServerCodec implements reading, parsing and writing RPC messages for the server side of
 // a RPC session. Implementations must be go-routine safe since the codec can be called in
 // multiple go-routines concurrently.
@@ -124,13 +137,14 @@ type ServerCodec interface {
 type BlockNumber int64
 
 const (
+	SafeBlockNumber     = BlockNumber(-3)
 	PendingBlockNumber  = BlockNumber(-2)
 	LatestBlockNumber   = BlockNumber(-1)
 	EarliestBlockNumber = BlockNumber(0)
 )
 
 // UnmarshalJSON parses the given JSON fragment into a BlockNumber. It supports:
-// - "latest", "earliest" or "pending" as string arguments
+// - "latest", "earliest", "pending" or "safe" as string arguments
 // - the block number
 // Returned errors:
 // - an invalid block number error when the given argument isn't a known strings
@@ -151,6 +165,9 @@ func (bn *BlockNumber) UnmarshalJSON(data []byte) error {
 	case "pending":
 		*bn = PendingBlockNumber
 		return nil
+	case "safe":
+		*bn = SafeBlockNumber
+		return nil
 	}
 
 	blckNum, err := hexutil.DecodeUint64(input)
@@ -168,3 +185,115 @@ func (bn *BlockNumber) UnmarshalJSON(data []byte) error {
 func (bn BlockNumber) Int64() int64 {
 	return (int64)(bn)
 }
+
+// BlockNumberOrHash selects a block either by number (or one of the named block
+// tags), or by hash. Selecting by hash pins the request to a specific block, so
+// that a reorg between consecutive calls cannot silently change their results.
+type BlockNumberOrHash struct {
+	BlockNumber      *BlockNumber `json:"blockNumber,omitempty"`
+	BlockHash        *common.Hash `json:"blockHash,omitempty"`
+	RequireCanonical bool         `json:"requireCanonical,omitempty"`
+}
+
+// UnmarshalJSON parses the given JSON fragment into a BlockNumberOrHash. It supports:
+// - everything accepted by BlockNumber
+// - a 32 byte hex encoded block hash
+// - an object with either a "blockNumber" or a "blockHash" and an optional
+//   "requireCanonical" field
+func (bnh *BlockNumberOrHash) UnmarshalJSON(data []byte) error {
+	input := strings.TrimSpace(string(data))
+	if len(input) > 0 && input[0] == '{' {
+		var obj struct {
+			BlockNumber      *BlockNumber `json:"blockNumber"`
+			BlockHash        *common.Hash `json:"blockHash"`
+			RequireCanonical bool         `json:"requireCanonical"`
+		}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		if (obj.BlockNumber == nil) == (obj.BlockHash == nil) {
+			return fmt.Errorf("exactly one of blockNumber or blockHash must be specified")
+		}
+		*bnh = BlockNumberOrHash{
+			BlockNumber:      obj.BlockNumber,
+			BlockHash:        obj.BlockHash,
+			RequireCanonical: obj.RequireCanonical,
+		}
+		return nil
+	}
+	if len(input) == 2+2+2*common.HashLength && input[0] == '"' {
+		var hash common.Hash
+		if err := json.Unmarshal(data, &hash); err != nil {
+			return err
+		}
+		*bnh = BlockNumberOrHash{BlockHash: &hash}
+		return nil
+	}
+	var number BlockNumber
+	if err := number.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*bnh = BlockNumberOrHash{BlockNumber: &number}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding block numbers in the same format
+// accepted by BlockNumber and block hashes as an object carrying the canonical flag.
+func (bnh BlockNumberOrHash) MarshalJSON() ([]byte, error) {
+	if bnh.BlockHash != nil {
+		return json.Marshal(map[string]interface{}{
+			"blockHash":        bnh.BlockHash,
+			"requireCanonical": bnh.RequireCanonical,
+		})
+	}
+	if bnh.BlockNumber == nil {
+		return nil, fmt.Errorf("neither block number nor hash specified")
+	}
+	switch *bnh.BlockNumber {
+	case SafeBlockNumber:
+		return json.Marshal("safe")
+	case PendingBlockNumber:
+		return json.Marshal("pending")
+	case LatestBlockNumber:
+		return json.Marshal("latest")
+	}
+	return json.Marshal(hexutil.Uint64(*bnh.BlockNumber))
+}
+
+// Number returns the selected block number, if the block was selected by number.
+func (bnh *BlockNumberOrHash) Number() (BlockNumber, bool) {
+	if bnh.BlockNumber != nil {
+		return *bnh.BlockNumber, true
+	}
+	return BlockNumber(0), false
+}
+
+// Hash returns the selected block hash, if the block was selected by hash.
+func (bnh *BlockNumberOrHash) Hash() (common.Hash, bool) {
+	if bnh.BlockHash != nil {
+		return *bnh.BlockHash, true
+	}
+	return common.Hash{}, false
+}
+
+// String implements fmt.Stringer, formatting the selector for log and error messages.
+func (bnh *BlockNumberOrHash) String() string {
+	if bnh.BlockHash != nil {
+		return bnh.BlockHash.Hex()
+	}
+	if bnh.BlockNumber != nil {
+		return fmt.Sprintf("#%d", *bnh.BlockNumber)
+	}
+	return "nil"
+}
+
+// BlockNumberOrHashWithNumber creates a block selector referencing the given number.
+func BlockNumberOrHashWithNumber(blockNr BlockNumber) BlockNumberOrHash {
+	return BlockNumberOrHash{BlockNumber: &blockNr}
+}
+
+// BlockNumberOrHashWithHash creates a block selector referencing the given hash,
+// optionally requiring the block to be part of the canonical chain.
+func BlockNumberOrHashWithHash(hash common.Hash, canonical bool) BlockNumberOrHash {
+	return BlockNumberOrHash{BlockHash: &hash, RequireCanonical: canonical}
+}
@@ -40,7 +40,9 @@ func (srv *Server) WebsocketHandler(allowedOrigins []string) http.Handler {
 	return websocket.Server{
 		Handshake: wsHandshakeValidator(allowedOrigins),
 		Handler: func(conn *websocket.Conn) {
-			srv.ServeCodec(NewJSONCodec(conn), OptionMethodInvocation|OptionSubscriptions)
+			codec := NewJSONCodec(conn)
+			defer codec.Close()
+			srv.serveRequest(requestContext(conn.Request()), codec, false, OptionMethodInvocation|OptionSubscriptions)
 		},
 	}
 }
@@ -52,6 +54,12 @@ func NewWSServer(allowedOrigins []string, srv *Server) *http.Server {
 	return &http.Server{Handler: srv.WebsocketHandler(allowedOrigins)}
 }
 
+// NewAuthWSServer creates a new websocket RPC server around an API provider, only
+// accepting connections authenticated by a bearer token signed with the secret.
+func NewAuthWSServer(allowedOrigins []string, secret []byte, srv *Server) *http.Server {
+	return &http.Server{Handler: NewJWTHandler(secret, srv.WebsocketHandler(allowedOrigins))}
+}
+
 // wsHandshakeValidator returns a handler that verifies the origin during the
 // websocket upgrade process. When a '*' is specified as an allowed origins all
 // connections are accepted.
@@ -96,6 +104,17 @@ func wsHandshakeValidator(allowedOrigins []string) func(*websocket.Config, *http
 // The context is used for the initial connection establishment. It does not
 // affect subsequent interactions with the client.
 func DialWebsocket(ctx context.Context, endpoint, origin string) (*Client, error) {
+	return dialWebsocket(ctx, endpoint, origin, "")
+}
+
+// DialWebsocketWithToken creates a new RPC client that communicates with a JSON-RPC
+// server listening on the given endpoint, authenticating the connection with the
+// given bearer token.
+func DialWebsocketWithToken(ctx context.Context, endpoint, origin, token string) (*Client, error) {
+	return dialWebsocket(ctx, endpoint, origin, token)
+}
+
+func dialWebsocket(ctx context.Context, endpoint, origin, token string) (*Client, error) {
 	if origin == "" {
 		var err error
 		if origin, err = os.Hostname(); err != nil {
@@ -111,6 +130,9 @@ func DialWebsocket(ctx context.Context, endpoint, origin string) (*Client, error
 	if err != nil {
 		return nil, err
 	}
+	if token != "" {
+		config.Header.Set("Authorization", "Bearer "+token)
+	}
 
 	return newClient(ctx, func(ctx context.Context) (net.Conn, error) {
 		return wsDialContext(ctx, config)
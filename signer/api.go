@@ -0,0 +1,293 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package signer implements an external signing daemon, holding the account
+// keys outside of the node and requiring every request to be approved by the
+// user, either interactively or through a rule set.
+//
+// The daemon exposes the "account" JSON-RPC namespace consumed by the node side
+// accounts/external backend.
+package signer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/external"
+	"github.com/megatilt/go-tilt/accounts/typeddata"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/rlp"
+)
+
+// ExternalAPIVersion is the version of the signer protocol, reported to the
+// nodes connecting to the signer.
+const ExternalAPIVersion = "1.0.0"
+
+// ErrRequestDenied is returned if the user rejects a signing request.
+var ErrRequestDenied = errors.New("request denied")
+
+// errChainIDRequired is returned if a transaction is requested to be signed
+// without replay protection.
+var errChainIDRequired = errors.New("chain id required for replay protection")
+
+// ExternalAPI defines the methods the signer exposes to the connecting nodes.
+type ExternalAPI interface {
+	// List returns the accounts the user allows the caller to see.
+	List(ctx context.Context) ([]common.Address, error)
+
+	// SignTransaction requests the approval and signature of a transaction.
+	SignTransaction(ctx context.Context, args external.SendTxArgs, chainID *hexutil.Big) (*external.SignTxResult, error)
+
+	// SignHash requests the approval and signature of an arbitrary hash.
+	SignHash(ctx context.Context, addr common.Address, hash hexutil.Bytes) (hexutil.Bytes, error)
+
+	// SignTypedData requests the approval and signature of typed structured data.
+	SignTypedData(ctx context.Context, addr common.Address, data typeddata.TypedData) (hexutil.Bytes, error)
+
+	// Version returns the version of the signer protocol.
+	Version(ctx context.Context) (string, error)
+}
+
+// UI defines the methods an approval frontend of the signer needs to implement.
+type UI interface {
+	// ApproveTx prompts the user for the confirmation of a transaction signing.
+	ApproveTx(request *SignTxRequest) (SignTxResponse, error)
+
+	// ApproveSignHash prompts the user for the confirmation of a hash signing.
+	ApproveSignHash(request *SignHashRequest) (SignHashResponse, error)
+
+	// ApproveSignTypedData prompts the user for the confirmation of a typed data
+	// signing, displaying the decoded fields.
+	ApproveSignTypedData(request *SignTypedDataRequest) (SignHashResponse, error)
+
+	// ApproveListing prompts the user for the accounts to reveal to the caller.
+	ApproveListing(request *ListRequest) (ListResponse, error)
+
+	// ShowInfo displays an informational message to the user.
+	ShowInfo(message string)
+
+	// ShowError displays an error message to the user.
+	ShowError(message string)
+}
+
+// SignTxRequest contains the transaction to be approved and signed.
+type SignTxRequest struct {
+	Transaction external.SendTxArgs `json:"transaction"`
+	ChainID     *hexutil.Big        `json:"chainId"`
+}
+
+// SignTxResponse is the decision of the user on a transaction signing request.
+// The password is used to decrypt the signing key if it is not unlocked.
+type SignTxResponse struct {
+	Approved bool   `json:"approved"`
+	Password string `json:"-"`
+}
+
+// SignHashRequest contains the hash to be approved and signed.
+type SignHashRequest struct {
+	Address common.Address `json:"address"`
+	Hash    hexutil.Bytes  `json:"hash"`
+}
+
+// SignHashResponse is the decision of the user on a hash signing request. The
+// password is used to decrypt the signing key if it is not unlocked.
+type SignHashResponse struct {
+	Approved bool   `json:"approved"`
+	Password string `json:"-"`
+}
+
+// SignTypedDataRequest contains the typed data to be approved and signed, along
+// with its decoded fields and the hash that will be signed.
+type SignTypedDataRequest struct {
+	Address common.Address      `json:"address"`
+	Data    typeddata.TypedData `json:"data"`
+	Fields  []*typeddata.Field  `json:"fields"`
+	Hash    common.Hash         `json:"hash"`
+}
+
+// ListRequest contains all the accounts the signer holds.
+type ListRequest struct {
+	Accounts []common.Address `json:"accounts"`
+}
+
+// ListResponse contains the accounts the user allows to be revealed.
+type ListResponse struct {
+	Accounts []common.Address `json:"accounts"`
+}
+
+// SignerAPI implements ExternalAPI on top of an account manager, requesting
+// the user's approval for every operation through a UI.
+type SignerAPI struct {
+	am *accounts.Manager
+	ui UI
+}
+
+// NewSignerAPI creates a new signer API signing with the accounts of the given
+// manager, after having the requests approved through the given UI.
+func NewSignerAPI(am *accounts.Manager, ui UI) *SignerAPI {
+	return &SignerAPI{am: am, ui: ui}
+}
+
+// List implements ExternalAPI, returning the accounts approved by the user.
+func (api *SignerAPI) List(ctx context.Context) ([]common.Address, error) {
+	var addresses []common.Address
+	for _, wallet := range api.am.Wallets() {
+		for _, account := range wallet.Accounts() {
+			addresses = append(addresses, account.Address)
+		}
+	}
+	result, err := api.ui.ApproveListing(&ListRequest{Accounts: addresses})
+	if err != nil {
+		return nil, err
+	}
+	// The UI may only filter the account list, never extend it
+	known := make(map[common.Address]bool)
+	for _, addr := range addresses {
+		known[addr] = true
+	}
+	allowed := make([]common.Address, 0, len(result.Accounts))
+	for _, addr := range result.Accounts {
+		if known[addr] {
+			allowed = append(allowed, addr)
+		}
+	}
+	return allowed, nil
+}
+
+// SignTransaction implements ExternalAPI, signing the requested transaction if
+// approved by the user. A chain ID is required, as transactions signed without
+// one could be replayed on any other chain.
+func (api *SignerAPI) SignTransaction(ctx context.Context, args external.SendTxArgs, chainID *hexutil.Big) (*external.SignTxResult, error) {
+	if chainID == nil || chainID.ToInt().Sign() <= 0 {
+		return nil, errChainIDRequired
+	}
+	result, err := api.ui.ApproveTx(&SignTxRequest{Transaction: args, ChainID: chainID})
+	if err != nil {
+		return nil, err
+	}
+	if !result.Approved {
+		return nil, ErrRequestDenied
+	}
+	// Look up the wallet containing the requested signer
+	account := accounts.Account{Address: args.From}
+
+	wallet, err := api.am.Find(account)
+	if err != nil {
+		api.ui.ShowError(err.Error())
+		return nil, err
+	}
+	// Sign the transaction, decrypting the key if a password was provided
+	var signed *types.Transaction
+	if result.Password != "" {
+		signed, err = wallet.SignTxWithPassphrase(account, result.Password, args.ToTransaction(), chainID.ToInt())
+	} else {
+		signed, err = wallet.SignTx(account, args.ToTransaction(), chainID.ToInt())
+	}
+	if err != nil {
+		api.ui.ShowError(err.Error())
+		return nil, err
+	}
+	raw, err := rlp.EncodeToBytes(signed)
+	if err != nil {
+		return nil, err
+	}
+	api.ui.ShowInfo("Transaction signed: " + signed.Hash().Hex())
+	return &external.SignTxResult{Raw: raw, Tx: signed}, nil
+}
+
+// SignHash implements ExternalAPI, signing the requested hash if approved by
+// the user.
+func (api *SignerAPI) SignHash(ctx context.Context, addr common.Address, hash hexutil.Bytes) (hexutil.Bytes, error) {
+	if len(hash) != common.HashLength {
+		return nil, errors.New("hash must be 32 bytes long")
+	}
+	result, err := api.ui.ApproveSignHash(&SignHashRequest{Address: addr, Hash: hash})
+	if err != nil {
+		return nil, err
+	}
+	if !result.Approved {
+		return nil, ErrRequestDenied
+	}
+	// Look up the wallet containing the requested signer
+	account := accounts.Account{Address: addr}
+
+	wallet, err := api.am.Find(account)
+	if err != nil {
+		api.ui.ShowError(err.Error())
+		return nil, err
+	}
+	// Sign the hash, decrypting the key if a password was provided
+	var signature []byte
+	if result.Password != "" {
+		signature, err = wallet.SignHashWithPassphrase(account, result.Password, hash)
+	} else {
+		signature, err = wallet.SignHash(account, hash)
+	}
+	if err != nil {
+		api.ui.ShowError(err.Error())
+		return nil, err
+	}
+	return signature, nil
+}
+
+// SignTypedData implements ExternalAPI, signing the requested typed data if
+// approved by the user.
+func (api *SignerAPI) SignTypedData(ctx context.Context, addr common.Address, data typeddata.TypedData) (hexutil.Bytes, error) {
+	// Decode the fields to display, rejecting any malformed data upfront
+	fields, err := data.Format()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := data.Hash()
+	if err != nil {
+		return nil, err
+	}
+	result, err := api.ui.ApproveSignTypedData(&SignTypedDataRequest{Address: addr, Data: data, Fields: fields, Hash: hash})
+	if err != nil {
+		return nil, err
+	}
+	if !result.Approved {
+		return nil, ErrRequestDenied
+	}
+	// Look up the wallet containing the requested signer
+	account := accounts.Account{Address: addr}
+
+	wallet, err := api.am.Find(account)
+	if err != nil {
+		api.ui.ShowError(err.Error())
+		return nil, err
+	}
+	// Sign the typed data, decrypting the key if a password was provided
+	var signature []byte
+	if result.Password != "" {
+		signature, err = accounts.SignTypedDataWithPassphrase(wallet, account, result.Password, &data)
+	} else {
+		signature, err = accounts.SignTypedData(wallet, account, &data)
+	}
+	if err != nil {
+		api.ui.ShowError(err.Error())
+		return nil, err
+	}
+	return signature, nil
+}
+
+// Version implements ExternalAPI, returning the version of the signer protocol.
+func (api *SignerAPI) Version(ctx context.Context) (string, error) {
+	return ExternalAPIVersion, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package signer
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/megatilt/go-tilt/accounts"
+	"github.com/megatilt/go-tilt/accounts/external"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+)
+
+// denyingUI is a test UI denying every request, counting the prompts.
+type denyingUI struct {
+	prompts int
+}
+
+func (ui *denyingUI) ApproveTx(request *SignTxRequest) (SignTxResponse, error) {
+	ui.prompts++
+	return SignTxResponse{}, nil
+}
+
+func (ui *denyingUI) ApproveSignHash(request *SignHashRequest) (SignHashResponse, error) {
+	ui.prompts++
+	return SignHashResponse{}, nil
+}
+
+func (ui *denyingUI) ApproveSignTypedData(request *SignTypedDataRequest) (SignHashResponse, error) {
+	ui.prompts++
+	return SignHashResponse{}, nil
+}
+
+func (ui *denyingUI) ApproveListing(request *ListRequest) (ListResponse, error) {
+	ui.prompts++
+	return ListResponse{}, nil
+}
+
+func (ui *denyingUI) ShowInfo(message string)  {}
+func (ui *denyingUI) ShowError(message string) {}
+
+// Tests that transactions without a chain ID are rejected before prompting the
+// user, as their signatures could be replayed on any chain.
+func TestSignTransactionChainID(t *testing.T) {
+	tests := []struct {
+		chainID *hexutil.Big
+		err     error
+	}{
+		{nil, errChainIDRequired},
+		{(*hexutil.Big)(big.NewInt(0)), errChainIDRequired},
+		{(*hexutil.Big)(big.NewInt(-1)), errChainIDRequired},
+		{(*hexutil.Big)(big.NewInt(1)), ErrRequestDenied},
+	}
+	for i, tt := range tests {
+		ui := new(denyingUI)
+		api := NewSignerAPI(accounts.NewManager(), ui)
+
+		args := external.SendTxArgs{From: common.Address{1}, To: &common.Address{2}}
+		if _, err := api.SignTransaction(context.Background(), args, tt.chainID); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		if prompted := ui.prompts > 0; prompted != (tt.err == ErrRequestDenied) {
+			t.Errorf("test %d: prompt mismatch: prompted %v", i, prompted)
+		}
+	}
+}
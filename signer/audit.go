@@ -0,0 +1,104 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package signer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/megatilt/go-tilt/accounts/external"
+	"github.com/megatilt/go-tilt/accounts/typeddata"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/log"
+)
+
+// AuditLogger is an ExternalAPI wrapper recording every request and its outcome
+// into an append only log file.
+type AuditLogger struct {
+	log log.Logger
+	api ExternalAPI
+}
+
+// NewAuditLogger creates an audit log at the given path, wrapping the given API.
+func NewAuditLogger(path string, api ExternalAPI) (*AuditLogger, error) {
+	handler, err := log.FileHandler(path, log.LogfmtFormat())
+	if err != nil {
+		return nil, err
+	}
+	logger := log.New("api", "signer")
+	logger.SetHandler(handler)
+	logger.Info("Audit log started", "path", path)
+
+	return &AuditLogger{log: logger, api: api}, nil
+}
+
+// List implements ExternalAPI.
+func (l *AuditLogger) List(ctx context.Context) ([]common.Address, error) {
+	l.log.Info("List", "type", "request")
+	res, err := l.api.List(ctx)
+	l.log.Info("List", "type", "response", "addresses", fmt.Sprint(res), "err", err)
+
+	return res, err
+}
+
+// SignTransaction implements ExternalAPI.
+func (l *AuditLogger) SignTransaction(ctx context.Context, args external.SendTxArgs, chainID *hexutil.Big) (*external.SignTxResult, error) {
+	to := "<contract creation>"
+	if args.To != nil {
+		to = args.To.Hex()
+	}
+	l.log.Info("SignTransaction", "type", "request", "from", args.From.Hex(), "to", to,
+		"value", args.Value, "gas", args.Gas, "gasPrice", args.GasPrice, "nonce", uint64(args.Nonce),
+		"data", args.Data, "chainId", chainID)
+
+	res, err := l.api.SignTransaction(ctx, args, chainID)
+	if res != nil {
+		l.log.Info("SignTransaction", "type", "response", "hash", res.Tx.Hash().Hex(), "err", err)
+	} else {
+		l.log.Info("SignTransaction", "type", "response", "err", err)
+	}
+	return res, err
+}
+
+// SignHash implements ExternalAPI.
+func (l *AuditLogger) SignHash(ctx context.Context, addr common.Address, hash hexutil.Bytes) (hexutil.Bytes, error) {
+	l.log.Info("SignHash", "type", "request", "address", addr.Hex(), "hash", hash)
+	res, err := l.api.SignHash(ctx, addr, hash)
+	l.log.Info("SignHash", "type", "response", "signature", res, "err", err)
+
+	return res, err
+}
+
+// SignTypedData implements ExternalAPI.
+func (l *AuditLogger) SignTypedData(ctx context.Context, addr common.Address, data typeddata.TypedData) (hexutil.Bytes, error) {
+	hash, _ := data.Hash()
+	l.log.Info("SignTypedData", "type", "request", "address", addr.Hex(), "primaryType", data.PrimaryType, "hash", hash.Hex())
+	res, err := l.api.SignTypedData(ctx, addr, data)
+	l.log.Info("SignTypedData", "type", "response", "signature", res, "err", err)
+
+	return res, err
+}
+
+// Version implements ExternalAPI.
+func (l *AuditLogger) Version(ctx context.Context) (string, error) {
+	l.log.Info("Version", "type", "request")
+	res, err := l.api.Version(ctx)
+	l.log.Info("Version", "type", "response", "version", res, "err", err)
+
+	return res, err
+}
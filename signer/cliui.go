@@ -0,0 +1,154 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package signer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/megatilt/go-tilt/accounts/typeddata"
+	"github.com/megatilt/go-tilt/console"
+)
+
+// CommandlineUI is a UI prompting the user on the terminal for the approval of
+// every request. Requests are shown one at a time.
+type CommandlineUI struct {
+	prompter console.UserPrompter
+	lock     sync.Mutex
+}
+
+// NewCommandlineUI creates a terminal based approval UI.
+func NewCommandlineUI() *CommandlineUI {
+	return &CommandlineUI{prompter: console.Stdin}
+}
+
+// confirm asks the user for a yes/no decision, treating any failure as a no.
+func (ui *CommandlineUI) confirm() bool {
+	approved, err := ui.prompter.PromptConfirm("Approve?")
+	if err != nil {
+		fmt.Printf("Failed to read confirmation: %v\n", err)
+		return false
+	}
+	return approved
+}
+
+// password asks the user for the password of the signing account. An empty one
+// signals that the account is unlocked.
+func (ui *CommandlineUI) password() string {
+	password, err := ui.prompter.PromptPassword("Account password (empty if unlocked): ")
+	if err != nil {
+		fmt.Printf("Failed to read password: %v\n", err)
+		return ""
+	}
+	return password
+}
+
+// ApproveTx implements UI, displaying the transaction and asking for approval.
+func (ui *CommandlineUI) ApproveTx(request *SignTxRequest) (SignTxResponse, error) {
+	ui.lock.Lock()
+	defer ui.lock.Unlock()
+
+	tx := request.Transaction
+
+	fmt.Println("--------- Transaction request -------------")
+	if tx.To == nil {
+		fmt.Println("to:       <contract creation>")
+	} else {
+		fmt.Printf("to:       %s\n", tx.To.Hex())
+	}
+	fmt.Printf("from:     %s\n", tx.From.Hex())
+	fmt.Printf("value:    %v wei\n", tx.Value.ToInt())
+	fmt.Printf("gas:      %v\n", tx.Gas.ToInt())
+	fmt.Printf("gasprice: %v wei\n", tx.GasPrice.ToInt())
+	fmt.Printf("nonce:    %d\n", uint64(tx.Nonce))
+	if len(tx.Data) > 0 {
+		fmt.Printf("data:     %v\n", tx.Data)
+	}
+	if request.ChainID != nil {
+		fmt.Printf("chainid:  %v\n", request.ChainID.ToInt())
+	}
+	fmt.Println("-------------------------------------------")
+
+	if !ui.confirm() {
+		return SignTxResponse{Approved: false}, nil
+	}
+	return SignTxResponse{Approved: true, Password: ui.password()}, nil
+}
+
+// ApproveSignHash implements UI, displaying the hash and asking for approval.
+func (ui *CommandlineUI) ApproveSignHash(request *SignHashRequest) (SignHashResponse, error) {
+	ui.lock.Lock()
+	defer ui.lock.Unlock()
+
+	fmt.Println("--------- Sign hash request -------------")
+	fmt.Printf("account:  %s\n", request.Address.Hex())
+	fmt.Printf("hash:     %v\n", request.Hash)
+	fmt.Println("-------------------------------------------")
+
+	if !ui.confirm() {
+		return SignHashResponse{Approved: false}, nil
+	}
+	return SignHashResponse{Approved: true, Password: ui.password()}, nil
+}
+
+// ApproveSignTypedData implements UI, displaying the typed data fields and
+// asking for approval.
+func (ui *CommandlineUI) ApproveSignTypedData(request *SignTypedDataRequest) (SignHashResponse, error) {
+	ui.lock.Lock()
+	defer ui.lock.Unlock()
+
+	fmt.Println("--------- Sign typed data request -------------")
+	fmt.Printf("account:  %s\n", request.Address.Hex())
+	fmt.Printf("type:     %s\n", request.Data.PrimaryType)
+	fmt.Print(typeddata.Pprint(request.Fields))
+	fmt.Printf("hash:     %s\n", request.Hash.Hex())
+	fmt.Println("-------------------------------------------")
+
+	if !ui.confirm() {
+		return SignHashResponse{Approved: false}, nil
+	}
+	return SignHashResponse{Approved: true, Password: ui.password()}, nil
+}
+
+// ApproveListing implements UI, displaying the accounts and asking whether to
+// reveal them.
+func (ui *CommandlineUI) ApproveListing(request *ListRequest) (ListResponse, error) {
+	ui.lock.Lock()
+	defer ui.lock.Unlock()
+
+	fmt.Println("--------- List accounts request -------------")
+	fmt.Println("A request has been made to list all accounts:")
+	for _, addr := range request.Accounts {
+		fmt.Printf("  %s\n", addr.Hex())
+	}
+	fmt.Println("-------------------------------------------")
+
+	if !ui.confirm() {
+		return ListResponse{}, nil
+	}
+	return ListResponse{Accounts: request.Accounts}, nil
+}
+
+// ShowInfo implements UI, printing an informational message.
+func (ui *CommandlineUI) ShowInfo(message string) {
+	fmt.Printf("Info: %s\n", message)
+}
+
+// ShowError implements UI, printing an error message.
+func (ui *CommandlineUI) ShowError(message string) {
+	fmt.Printf("Error: %s\n", message)
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/megatilt/go-tilt/log"
+	"github.com/robertkrimen/otto"
+)
+
+// RulesetUI is a UI deciding on requests through a JavaScript rule set, and
+// deferring to a fallback UI for all requests the rules don't decide on.
+//
+// The rule set may define any of the functions below, each receiving the JSON
+// representation of the request and returning "Approve" or "Reject". Any other
+// return value, a missing function or a failing rule leaves the decision to the
+// fallback UI:
+//
+//   function ApproveTx(request) {}
+//   function ApproveSignHash(request) {}
+//   function ApproveSignTypedData(request) {}
+//   function ApproveListing(request) {}
+//
+// Automatically approved requests can only be signed with unlocked accounts.
+type RulesetUI struct {
+	next UI
+	vm   *otto.Otto
+	lock sync.Mutex // The JavaScript VM is not thread safe
+}
+
+// NewRulesetUI creates a rule based UI from the source of a rule set, deferring
+// to the given UI for the undecided requests.
+func NewRulesetUI(next UI, rules string) (*RulesetUI, error) {
+	vm := otto.New()
+	if _, err := vm.Run(rules); err != nil {
+		return nil, fmt.Errorf("failed to load rules: %v", err)
+	}
+	return &RulesetUI{next: next, vm: vm}, nil
+}
+
+// decide evaluates the named rule on a request, returning whether the rule set
+// made a decision and what it was.
+func (r *RulesetUI) decide(rule string, request interface{}) (decided bool, approved bool) {
+	blob, err := json.Marshal(request)
+	if err != nil {
+		log.Warn("Failed to encode request for rules", "rule", rule, "err", err)
+		return false, false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	fn, err := r.vm.Get(rule)
+	if err != nil || !fn.IsFunction() {
+		return false, false
+	}
+	arg, err := r.vm.Call("JSON.parse", nil, string(blob))
+	if err != nil {
+		log.Warn("Failed to decode request for rules", "rule", rule, "err", err)
+		return false, false
+	}
+	res, err := fn.Call(otto.NullValue(), arg)
+	if err != nil {
+		log.Warn("Rule evaluation failed", "rule", rule, "err", err)
+		return false, false
+	}
+	switch decision, _ := res.ToString(); decision {
+	case "Approve":
+		log.Info("Request approved by rules", "rule", rule)
+		return true, true
+	case "Reject":
+		log.Info("Request rejected by rules", "rule", rule)
+		return true, false
+	}
+	return false, false
+}
+
+// ApproveTx implements UI, evaluating the ApproveTx rule.
+func (r *RulesetUI) ApproveTx(request *SignTxRequest) (SignTxResponse, error) {
+	if decided, approved := r.decide("ApproveTx", request); decided {
+		return SignTxResponse{Approved: approved}, nil
+	}
+	return r.next.ApproveTx(request)
+}
+
+// ApproveSignHash implements UI, evaluating the ApproveSignHash rule.
+func (r *RulesetUI) ApproveSignHash(request *SignHashRequest) (SignHashResponse, error) {
+	if decided, approved := r.decide("ApproveSignHash", request); decided {
+		return SignHashResponse{Approved: approved}, nil
+	}
+	return r.next.ApproveSignHash(request)
+}
+
+// ApproveSignTypedData implements UI, evaluating the ApproveSignTypedData rule.
+func (r *RulesetUI) ApproveSignTypedData(request *SignTypedDataRequest) (SignHashResponse, error) {
+	if decided, approved := r.decide("ApproveSignTypedData", request); decided {
+		return SignHashResponse{Approved: approved}, nil
+	}
+	return r.next.ApproveSignTypedData(request)
+}
+
+// ApproveListing implements UI, evaluating the ApproveListing rule. Approving
+// reveals all accounts, rejecting reveals none.
+func (r *RulesetUI) ApproveListing(request *ListRequest) (ListResponse, error) {
+	if decided, approved := r.decide("ApproveListing", request); decided {
+		if approved {
+			return ListResponse{Accounts: request.Accounts}, nil
+		}
+		return ListResponse{}, nil
+	}
+	return r.next.ApproveListing(request)
+}
+
+// ShowInfo implements UI, forwarding the message to the fallback UI.
+func (r *RulesetUI) ShowInfo(message string) {
+	r.next.ShowInfo(message)
+}
+
+// ShowError implements UI, forwarding the message to the fallback UI.
+func (r *RulesetUI) ShowError(message string) {
+	r.next.ShowError(message)
+}
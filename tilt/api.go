@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -71,6 +72,16 @@ func (api *PublicTiltnetAPI) Hashrate() hexutil.Uint64 {
 	return hexutil.Uint64(api.e.Miner().HashRate())
 }
 
+// LatestCheckpoint returns the trusted checkpoint of the most recent section
+// of the local canonical chain, as computed by the node itself.
+func (api *PublicTiltnetAPI) LatestCheckpoint() (*params.TrustedCheckpoint, error) {
+	checkpoint := api.e.checkpoints.Latest()
+	if checkpoint == nil {
+		return nil, errors.New("no checkpoint available yet")
+	}
+	return checkpoint, nil
+}
+
 // PublicMinerAPI provides an API to control the miner.
 // It offers only methods that operate on data that pose no security risk when it is publicly accessible.
 type PublicMinerAPI struct {
@@ -309,11 +320,9 @@ func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error
 		_, stateDb := api.tilt.miner.Pending()
 		return stateDb.RawDump(), nil
 	}
-	var block *types.Block
-	if blockNr == rpc.LatestBlockNumber {
-		block = api.tilt.blockchain.CurrentBlock()
-	} else {
-		block = api.tilt.blockchain.GetBlockByNumber(uint64(blockNr))
+	block, err := api.tilt.ApiBackend.BlockByNumber(context.Background(), blockNr)
+	if err != nil {
+		return state.Dump{}, err
 	}
 	if block == nil {
 		return state.Dump{}, fmt.Errorf("block #%d not found", blockNr)
@@ -383,17 +392,10 @@ func (api *PrivateDebugAPI) TraceBlockFromFile(file string, config *vm.LogConfig
 // TraceBlockByNumber processes the block by canonical block number.
 func (api *PrivateDebugAPI) TraceBlockByNumber(blockNr rpc.BlockNumber, config *vm.LogConfig) BlockTraceResult {
 	// Fetch the block that we aim to reprocess
-	var block *types.Block
-	switch blockNr {
-	case rpc.PendingBlockNumber:
-		// Pending block is only known by the miner
-		block = api.tilt.miner.PendingBlock()
-	case rpc.LatestBlockNumber:
-		block = api.tilt.blockchain.CurrentBlock()
-	default:
-		block = api.tilt.blockchain.GetBlockByNumber(uint64(blockNr))
+	block, err := api.tilt.ApiBackend.BlockByNumber(context.Background(), blockNr)
+	if err != nil {
+		return BlockTraceResult{Error: formatError(err)}
 	}
-
 	if block == nil {
 		return BlockTraceResult{Error: fmt.Sprintf("block #%d not found", blockNr)}
 	}
@@ -533,7 +535,7 @@ func (api *PrivateDebugAPI) TraceTransaction(ctx context.Context, txHash common.
 
 	// Run the transaction with tracing enabled.
 	vmenv := vm.NewTiltVM(context, statedb, api.config, vm.Config{Debug: true, Tracer: tracer})
-	ret, gas, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(tx.Gas()))
+	ret, gas, _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(tx.Gas()))
 	if err != nil {
 		return nil, fmt.Errorf("tracing failed: %v", err)
 	}
@@ -580,7 +582,7 @@ func (api *PrivateDebugAPI) computeTxEnv(blockHash common.Hash, txIndex int) (co
 
 		vmenv := vm.NewTiltVM(context, statedb, api.config, vm.Config{})
 		gp := new(core.GasPool).AddGas(tx.Gas())
-		_, _, err := core.ApplyMessage(vmenv, msg, gp)
+		_, _, _, err := core.ApplyMessage(vmenv, msg, gp)
 		if err != nil {
 			return nil, vm.Context{}, nil, fmt.Errorf("tx %x failed: %v", tx.Hash(), err)
 		}
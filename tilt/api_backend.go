@@ -18,6 +18,7 @@ package tilt
 
 import (
 	"context"
+	"errors"
 	"math/big"
 
 	"github.com/megatilt/go-tilt/accounts"
@@ -38,8 +39,9 @@ import (
 
 // TiltApiBackend implements tiltapi.Backend for full nodes
 type TiltApiBackend struct {
-	tilt *Tiltnet
-	gpo  *gasprice.Oracle
+	tilt      *Tiltnet
+	gpo       *gasprice.Oracle
+	safeDepth uint64 // Number of confirmations after which a block is deemed safe
 }
 
 func (b *TiltApiBackend) ChainConfig() *params.ChainConfig {
@@ -65,9 +67,44 @@ func (b *TiltApiBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNu
 	if blockNr == rpc.LatestBlockNumber {
 		return b.tilt.blockchain.CurrentBlock().Header(), nil
 	}
+	if blockNr == rpc.SafeBlockNumber {
+		return b.tilt.blockchain.GetHeaderByNumber(b.safeNumber()), nil
+	}
 	return b.tilt.blockchain.GetHeaderByNumber(uint64(blockNr)), nil
 }
 
+// HeaderByNumberOrHash retrieves the header selected either by number or by hash,
+// optionally ensuring that a block selected by hash is part of the canonical chain.
+func (b *TiltApiBackend) HeaderByNumberOrHash(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*types.Header, error) {
+	if blockNr, ok := blockNrOrHash.Number(); ok {
+		return b.HeaderByNumber(ctx, blockNr)
+	}
+	hash, ok := blockNrOrHash.Hash()
+	if !ok {
+		return nil, errors.New("invalid arguments; neither block nor hash specified")
+	}
+	header := b.tilt.blockchain.GetHeaderByHash(hash)
+	if header == nil {
+		return nil, errors.New("header for hash not found")
+	}
+	if blockNrOrHash.RequireCanonical {
+		if canon := b.tilt.blockchain.GetHeaderByNumber(header.Number.Uint64()); canon == nil || canon.Hash() != hash {
+			return nil, errors.New("hash is not currently canonical")
+		}
+	}
+	return header, nil
+}
+
+// safeNumber returns the number of the latest block buried under the configured
+// number of confirmations, deemed safe from reorgs.
+func (b *TiltApiBackend) safeNumber() uint64 {
+	head := b.tilt.blockchain.CurrentBlock().NumberU64()
+	if head < b.safeDepth {
+		return 0
+	}
+	return head - b.safeDepth
+}
+
 func (b *TiltApiBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Block, error) {
 	// Pending block is only known by the miner
 	if blockNr == rpc.PendingBlockNumber {
@@ -78,6 +115,9 @@ func (b *TiltApiBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNum
 	if blockNr == rpc.LatestBlockNumber {
 		return b.tilt.blockchain.CurrentBlock(), nil
 	}
+	if blockNr == rpc.SafeBlockNumber {
+		return b.tilt.blockchain.GetBlockByNumber(b.safeNumber()), nil
+	}
 	return b.tilt.blockchain.GetBlockByNumber(uint64(blockNr)), nil
 }
 
@@ -96,6 +136,18 @@ func (b *TiltApiBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc
 	return TiltApiState{stateDb}, header, err
 }
 
+func (b *TiltApiBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (tiltapi.State, *types.Header, error) {
+	if blockNr, ok := blockNrOrHash.Number(); ok {
+		return b.StateAndHeaderByNumber(ctx, blockNr)
+	}
+	header, err := b.HeaderByNumberOrHash(ctx, blockNrOrHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	stateDb, err := b.tilt.BlockChain().StateAt(header.Root)
+	return TiltApiState{stateDb}, header, err
+}
+
 func (b *TiltApiBackend) GetBlock(ctx context.Context, blockHash common.Hash) (*types.Block, error) {
 	return b.tilt.blockchain.GetBlockByHash(blockHash), nil
 }
@@ -220,3 +272,19 @@ func (s TiltApiState) GetState(ctx context.Context, a common.Address, b common.H
 func (s TiltApiState) GetNonce(ctx context.Context, addr common.Address) (uint64, error) {
 	return s.state.GetNonce(addr), nil
 }
+
+func (s TiltApiState) SetBalance(addr common.Address, amount *big.Int) {
+	s.state.SetBalance(addr, amount)
+}
+
+func (s TiltApiState) SetNonce(addr common.Address, nonce uint64) {
+	s.state.SetNonce(addr, nonce)
+}
+
+func (s TiltApiState) SetCode(addr common.Address, code []byte) {
+	s.state.SetCode(addr, code)
+}
+
+func (s TiltApiState) SetState(addr common.Address, key, value common.Hash) {
+	s.state.SetState(addr, key, value)
+}
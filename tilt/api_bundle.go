@@ -0,0 +1,214 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package tilt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/common/hexutil"
+	"github.com/megatilt/go-tilt/core"
+	"github.com/megatilt/go-tilt/core/state"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/core/vm"
+	"github.com/megatilt/go-tilt/log"
+	"github.com/megatilt/go-tilt/rlp"
+	"github.com/megatilt/go-tilt/rpc"
+)
+
+// maxBundleTxs is the maximum number of transactions in a simulated bundle.
+const maxBundleTxs = 64
+
+// PublicBundleAPI provides an API to simulate the inclusion of transaction
+// bundles without submitting them to the network.
+type PublicBundleAPI struct {
+	tilt *Tiltnet
+}
+
+// NewPublicBundleAPI creates a new bundle simulation API.
+func NewPublicBundleAPI(tilt *Tiltnet) *PublicBundleAPI {
+	return &PublicBundleAPI{tilt: tilt}
+}
+
+// BundleTxResult is the outcome of a single transaction of a simulated bundle.
+type BundleTxResult struct {
+	TxHash       common.Hash    `json:"txHash"`
+	From         common.Address `json:"from"`
+	GasUsed      *hexutil.Big   `json:"gasUsed"`
+	Logs         []*types.Log   `json:"logs"`
+	Receipt      *types.Receipt `json:"receipt"`
+	CoinbaseDiff *hexutil.Big   `json:"coinbaseDiff"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// BundleResult is the outcome of a simulated bundle.
+type BundleResult struct {
+	StateBlockNumber hexutil.Uint64   `json:"stateBlockNumber"`
+	BlockNumber      hexutil.Uint64   `json:"blockNumber"`
+	Coinbase         common.Address   `json:"coinbase"`
+	GasUsed          *hexutil.Big     `json:"gasUsed"`
+	CoinbaseDiff     *hexutil.Big     `json:"coinbaseDiff"`
+	Results          []BundleTxResult `json:"results"`
+}
+
+// SimulateBundle applies the given signed transactions in order on top of the
+// state of the given block, as they would be included in the block following
+// it (or in the pending block itself). Transactions failing to apply are
+// reported in their result and leave the state untouched, the others see the
+// changes made by the ones preceding them. Nothing is persisted or broadcast.
+// If a timestamp is given, the block is simulated as if sealed at that time.
+//
+// The coinbase balance deltas only cover the transaction fees and transfers,
+// the block reward is not accounted for.
+func (api *PublicBundleAPI) SimulateBundle(ctx context.Context, rawTxs []hexutil.Bytes, blockNr rpc.BlockNumber, timestamp *hexutil.Uint64) (*BundleResult, error) {
+	defer func(start time.Time) {
+		log.Debug("Simulating transaction bundle finished", "txs", len(rawTxs), "runtime", time.Since(start))
+	}(time.Now())
+
+	if len(rawTxs) == 0 {
+		return nil, errors.New("empty bundle")
+	}
+	if len(rawTxs) > maxBundleTxs {
+		return nil, fmt.Errorf("bundle too large (%d>%d)", len(rawTxs), maxBundleTxs)
+	}
+	txs := make([]*types.Transaction, len(rawTxs))
+	for i, raw := range rawTxs {
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(raw, tx); err != nil {
+			return nil, fmt.Errorf("invalid transaction #%d: %v", i, err)
+		}
+		txs[i] = tx
+	}
+	env, err := api.simulationEnv(ctx, blockNr, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		header  = env.header
+		statedb = env.state
+		txIndex = env.txCount
+		config  = api.tilt.chainConfig
+		signer  = types.MakeSigner(config, header.Number)
+		gp      = new(core.GasPool).AddGas(new(big.Int).Sub(header.GasLimit, header.GasUsed))
+		start   = statedb.GetBalance(header.Coinbase)
+		usedGas = new(big.Int)
+		results = make([]BundleTxResult, len(txs))
+	)
+	for i, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		from, _ := types.Sender(signer, tx)
+		before := statedb.GetBalance(header.Coinbase)
+
+		results[i] = BundleTxResult{TxHash: tx.Hash(), From: from, Logs: []*types.Log{}}
+
+		snap := statedb.Snapshot()
+		statedb.StartRecord(tx.Hash(), common.Hash{}, txIndex)
+
+		receipt, gas, err := core.ApplyTransaction(config, api.tilt.blockchain, &header.Coinbase, gp, statedb, header, tx, header.GasUsed, vm.Config{})
+		if err != nil {
+			statedb.RevertToSnapshot(snap)
+			results[i].Error = err.Error()
+			results[i].CoinbaseDiff = (*hexutil.Big)(new(big.Int))
+			continue
+		}
+		usedGas.Add(usedGas, gas)
+		txIndex++
+
+		results[i].GasUsed = (*hexutil.Big)(gas)
+		results[i].Receipt = receipt
+		if receipt.Logs != nil {
+			results[i].Logs = receipt.Logs
+		}
+		results[i].CoinbaseDiff = (*hexutil.Big)(new(big.Int).Sub(statedb.GetBalance(header.Coinbase), before))
+	}
+	return &BundleResult{
+		StateBlockNumber: hexutil.Uint64(env.stateNumber),
+		BlockNumber:      hexutil.Uint64(header.Number.Uint64()),
+		Coinbase:         header.Coinbase,
+		GasUsed:          (*hexutil.Big)(usedGas),
+		CoinbaseDiff:     (*hexutil.Big)(new(big.Int).Sub(statedb.GetBalance(header.Coinbase), start)),
+		Results:          results,
+	}, nil
+}
+
+// bundleEnv is the environment a bundle is simulated in.
+type bundleEnv struct {
+	header      *types.Header  // Header of the block the bundle is included in
+	state       *state.StateDB // Private copy of the state the bundle is applied on
+	stateNumber uint64         // Number of the block the state belongs to
+	txCount     int            // Number of transactions already in the block
+}
+
+// simulationEnv assembles the environment to simulate a bundle in, either the
+// pending block or a child of the requested one, optionally sealed at the given
+// timestamp instead of the current time.
+func (api *PublicBundleAPI) simulationEnv(ctx context.Context, blockNr rpc.BlockNumber, timestamp *hexutil.Uint64) (*bundleEnv, error) {
+	// The pending block already has a header, extend it with the bundle
+	if blockNr == rpc.PendingBlockNumber {
+		block, statedb := api.tilt.miner.Pending()
+		header := types.CopyHeader(block.Header())
+		if timestamp != nil {
+			header.Time = new(big.Int).SetUint64(uint64(*timestamp))
+			if err := api.tilt.engine.Prepare(api.tilt.blockchain, header); err != nil {
+				return nil, err
+			}
+		}
+		return &bundleEnv{header, statedb, header.Number.Uint64(), len(block.Transactions())}, nil
+	}
+	// Otherwise assemble a child of the requested block
+	parent, err := api.tilt.ApiBackend.HeaderByNumber(ctx, blockNr)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, fmt.Errorf("block #%d not found", blockNr)
+	}
+	statedb, err := api.tilt.blockchain.StateAt(parent.Root)
+	if err != nil {
+		return nil, err
+	}
+	tstamp := time.Now().Unix()
+	if parent.Time.Cmp(big.NewInt(tstamp)) >= 0 {
+		tstamp = parent.Time.Int64() + 1
+	}
+	coinbase, err := api.tilt.Tiltbase()
+	if err != nil {
+		return nil, fmt.Errorf("coinbase unavailable: %v", err)
+	}
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		GasLimit:   core.CalcGasLimit(types.NewBlockWithHeader(parent)),
+		GasUsed:    new(big.Int),
+		Coinbase:   coinbase,
+		Time:       big.NewInt(tstamp),
+	}
+	// The difficulty depends on the timestamp, so it must be final before preparing
+	if timestamp != nil {
+		header.Time = new(big.Int).SetUint64(uint64(*timestamp))
+	}
+	if err := api.tilt.engine.Prepare(api.tilt.blockchain, header); err != nil {
+		return nil, err
+	}
+	return &bundleEnv{header, statedb, parent.Number.Uint64(), 0}, nil
+}
@@ -22,6 +22,7 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/megatilt/go-tilt/accounts"
 	"github.com/megatilt/go-tilt/common"
@@ -62,6 +63,7 @@ type Tiltnet struct {
 	txPool          *core.TxPool
 	txMu            sync.Mutex
 	blockchain      *core.BlockChain
+	checkpoints     *core.CheckpointIndexer
 	protocolManager *ProtocolManager
 	lesServer       LesServer
 	// DB interfaces
@@ -80,6 +82,8 @@ type Tiltnet struct {
 
 	networkId     uint64
 	netRPCService *tiltapi.PublicNetAPI
+
+	maxHeadAge time.Duration // Maximum age of the head block to report the node ready (0 = unchecked)
 }
 
 func (s *Tiltnet) AddLesServer(ls LesServer) {
@@ -116,6 +120,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Tiltnet, error) {
 		networkId:      config.NetworkId,
 		tiltbase:      config.Tiltbase,
 		MinerThreads:   config.MinerThreads,
+		maxHeadAge:     config.HealthMaxHeadAge,
 	}
 
 	if err := addMipmapBloomBins(chainDb); err != nil {
@@ -148,7 +153,13 @@ func New(ctx *node.ServiceContext, config *Config) (*Tiltnet, error) {
 
 	maxPeers := config.MaxPeers
 
-	if tilt.protocolManager, err = NewProtocolManager(tilt.chainConfig, config.SyncMode, config.NetworkId, maxPeers, tilt.eventMux, tilt.txPool, tilt.engine, tilt.blockchain, chainDb); err != nil {
+	tilt.checkpoints = core.NewCheckpointIndexer(tilt.blockchain, chainDb, tilt.eventMux)
+
+	checkpoint := config.Checkpoint
+	if checkpoint == nil {
+		checkpoint = params.TrustedCheckpoints[genesisHash]
+	}
+	if tilt.protocolManager, err = NewProtocolManager(tilt.chainConfig, checkpoint, config.SyncMode, config.NetworkId, maxPeers, tilt.eventMux, tilt.txPool, tilt.engine, tilt.blockchain, chainDb); err != nil {
 		return nil, err
 	}
 
@@ -156,7 +167,7 @@ func New(ctx *node.ServiceContext, config *Config) (*Tiltnet, error) {
 	tilt.miner.SetGasPrice(config.GasPrice)
 	tilt.miner.SetExtra(makeExtraData(config.ExtraData))
 
-	tilt.ApiBackend = &TiltApiBackend{tilt, nil}
+	tilt.ApiBackend = &TiltApiBackend{tilt, nil, config.SafeBlockDepth}
 	gpoParams := config.GPO
 	if gpoParams.Default == nil {
 		gpoParams.Default = config.GasPrice
@@ -232,6 +243,11 @@ func (s *Tiltnet) APIs() []rpc.API {
 			Version:   "1.0",
 			Service:   NewPublicMinerAPI(s),
 			Public:    true,
+		}, {
+			Namespace: "tilt",
+			Version:   "1.0",
+			Service:   NewPublicBundleAPI(s),
+			Public:    true,
 		}, {
 			Namespace: "tilt",
 			Version:   "1.0",
@@ -351,6 +367,7 @@ func (s *Tiltnet) Stop() error {
 	if s.stopDbUpgrade != nil {
 		s.stopDbUpgrade()
 	}
+	s.checkpoints.Stop()
 	s.blockchain.Stop()
 	s.protocolManager.Stop()
 	if s.lesServer != nil {
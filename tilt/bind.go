@@ -24,9 +24,11 @@ import (
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/common/hexutil"
 	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/event"
 	"github.com/megatilt/go-tilt/internal/tiltapi"
 	"github.com/megatilt/go-tilt/rlp"
 	"github.com/megatilt/go-tilt/rpc"
+	"github.com/megatilt/go-tilt/tilt/filters"
 )
 
 // ContractBackend implements bind.ContractBackend with direct calls to Tiltnet
@@ -40,27 +42,40 @@ type ContractBackend struct {
 	eapi  *tiltapi.PublicTiltnetAPI         // Wrapper around the Tiltnet object to access metadata
 	bcapi *tiltapi.PublicBlockChainAPI      // Wrapper around the blockchain to access chain data
 	txapi *tiltapi.PublicTransactionPoolAPI // Wrapper around the transaction pool to access transaction data
+
+	backend tiltapi.Backend      // Backend to run one-off log filter queries against
+	events  *filters.EventSystem // Event system to stream live log events from
 }
 
 // NewContractBackend creates a new native contract backend using an existing
-// Tiltnet object.
+// Tiltnet object. The backend streams log events through its own event system,
+// which needs to be stopped with Close once the backend is no longer used.
 func NewContractBackend(apiBackend tiltapi.Backend) *ContractBackend {
 	return &ContractBackend{
 		eapi:  tiltapi.NewPublicTiltnetAPI(apiBackend),
 		bcapi: tiltapi.NewPublicBlockChainAPI(apiBackend),
 		txapi: tiltapi.NewPublicTransactionPoolAPI(apiBackend),
+
+		backend: apiBackend,
+		events:  filters.NewEventSystem(apiBackend.EventMux(), apiBackend, false),
 	}
 }
 
+// Close stops the event system of the backend, ending all the log subscriptions
+// still active.
+func (b *ContractBackend) Close() {
+	b.events.Stop()
+}
+
 // CodeAt retrieves any code associated with the contract from the local API.
 func (b *ContractBackend) CodeAt(ctx context.Context, contract common.Address, blockNum *big.Int) ([]byte, error) {
-	out, err := b.bcapi.GetCode(ctx, contract, toBlockNumber(blockNum))
+	out, err := b.bcapi.GetCode(ctx, contract, toBlockNumberOrHash(blockNum))
 	return common.FromHex(out), err
 }
 
 // CodeAt retrieves any code associated with the contract from the local API.
 func (b *ContractBackend) PendingCodeAt(ctx context.Context, contract common.Address) ([]byte, error) {
-	out, err := b.bcapi.GetCode(ctx, contract, rpc.PendingBlockNumber)
+	out, err := b.bcapi.GetCode(ctx, contract, rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber))
 	return common.FromHex(out), err
 }
 
@@ -68,7 +83,7 @@ func (b *ContractBackend) PendingCodeAt(ctx context.Context, contract common.Add
 // call with the specified data as the input. The pending flag requests execution
 // against the pending block, not the stable head of the chain.
 func (b *ContractBackend) CallContract(ctx context.Context, msg tiltnet.CallMsg, blockNum *big.Int) ([]byte, error) {
-	out, err := b.bcapi.Call(ctx, toCallArgs(msg), toBlockNumber(blockNum))
+	out, err := b.bcapi.Call(ctx, toCallArgs(msg), toBlockNumberOrHash(blockNum))
 	return out, err
 }
 
@@ -76,7 +91,7 @@ func (b *ContractBackend) CallContract(ctx context.Context, msg tiltnet.CallMsg,
 // call with the specified data as the input. The pending flag requests execution
 // against the pending block, not the stable head of the chain.
 func (b *ContractBackend) PendingCallContract(ctx context.Context, msg tiltnet.CallMsg) ([]byte, error) {
-	out, err := b.bcapi.Call(ctx, toCallArgs(msg), rpc.PendingBlockNumber)
+	out, err := b.bcapi.Call(ctx, toCallArgs(msg), rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber))
 	return out, err
 }
 
@@ -98,17 +113,17 @@ func toCallArgs(msg tiltnet.CallMsg) tiltapi.CallArgs {
 	return args
 }
 
-func toBlockNumber(num *big.Int) rpc.BlockNumber {
+func toBlockNumberOrHash(num *big.Int) rpc.BlockNumberOrHash {
 	if num == nil {
-		return rpc.LatestBlockNumber
+		return rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
 	}
-	return rpc.BlockNumber(num.Int64())
+	return rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(num.Int64()))
 }
 
 // PendingAccountNonce implements bind.ContractTransactor retrieving the current
 // pending nonce associated with an account.
 func (b *ContractBackend) PendingNonceAt(ctx context.Context, account common.Address) (nonce uint64, err error) {
-	out, err := b.txapi.GetTransactionCount(ctx, account, rpc.PendingBlockNumber)
+	out, err := b.txapi.GetTransactionCount(ctx, account, rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber))
 	if out != nil {
 		nonce = uint64(*out)
 	}
@@ -127,7 +142,7 @@ func (b *ContractBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error)
 // requirement as other transactions may be added or removed by miners, but it
 // should provide a basis for setting a reasonable default.
 func (b *ContractBackend) EstimateGas(ctx context.Context, msg tiltnet.CallMsg) (*big.Int, error) {
-	out, err := b.bcapi.EstimateGas(ctx, toCallArgs(msg))
+	out, err := b.bcapi.EstimateGas(ctx, toCallArgs(msg), nil)
 	return out.ToInt(), err
 }
 
@@ -138,3 +153,66 @@ func (b *ContractBackend) SendTransaction(ctx context.Context, tx *types.Transac
 	_, err := b.txapi.SendRawTransaction(ctx, raw)
 	return err
 }
+
+// FilterLogs implements bind.ContractFilterer executing a log filter operation,
+// blocking during execution and returning all the results in one batch.
+func (b *ContractBackend) FilterLogs(ctx context.Context, query tiltnet.FilterQuery) ([]types.Log, error) {
+	// Unset boundaries run the filter from genesis to the chain head
+	from, to := int64(0), rpc.LatestBlockNumber.Int64()
+	if query.FromBlock != nil {
+		from = query.FromBlock.Int64()
+	}
+	if query.ToBlock != nil {
+		to = query.ToBlock.Int64()
+	}
+	filter := filters.New(b.backend, true)
+	filter.SetBeginBlock(from)
+	filter.SetEndBlock(to)
+	filter.SetAddresses(query.Addresses)
+	filter.SetTopics(query.Topics)
+
+	logs, err := filter.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]types.Log, len(logs))
+	for i, log := range logs {
+		res[i] = *log
+	}
+	return res, nil
+}
+
+// SubscribeFilterLogs implements bind.ContractFilterer creating a background log
+// filtering operation, streaming the found events into the given channel.
+func (b *ContractBackend) SubscribeFilterLogs(ctx context.Context, query tiltnet.FilterQuery, ch chan<- types.Log) (tiltnet.Subscription, error) {
+	sink := make(chan []*types.Log)
+	sub, err := b.events.SubscribeLogs(filters.FilterCriteria{
+		FromBlock: query.FromBlock,
+		ToBlock:   query.ToBlock,
+		Addresses: query.Addresses,
+		Topics:    query.Topics,
+	}, sink)
+	if err != nil {
+		return nil, err
+	}
+	// Logs arrive in batches, flatten them into a plain stream
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case logs := <-sink:
+				for _, log := range logs {
+					select {
+					case ch <- *log:
+					case <-quit:
+						return nil
+					}
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
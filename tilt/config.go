@@ -22,6 +22,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/common/hexutil"
@@ -40,6 +41,7 @@ var DefaultConfig = Config{
 	TilthashDatasetsInMem:  1,
 	TilthashDatasetsOnDisk: 2,
 	NetworkId:            1,
+	SafeBlockDepth:       12,
 	DatabaseCache:        128,
 	GasPrice:             big.NewInt(20 * params.Blom),
 
@@ -74,8 +76,16 @@ type Config struct {
 	NetworkId uint64 // Network ID to use for selecting peers to connect to
 	SyncMode  downloader.SyncMode
 
+	// Trusted checkpoint the synced chain must contain. If nil, the built-in
+	// checkpoint of the genesis block is used, if any.
+	Checkpoint *params.TrustedCheckpoint `toml:",omitempty"`
+
 	MaxPeers int `toml:"-"` // Maximum number of global peers
 
+	// Number of confirmations after which the "safe" RPC block selector deems a
+	// block safe from reorgs.
+	SafeBlockDepth uint64
+
 	// Database options
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
@@ -101,6 +111,9 @@ type Config struct {
 	// Enables tracking of SHA3 preimages in the VM
 	EnablePreimageRecording bool
 
+	// Maximum age of the head block for the node to be reported ready (0 = unchecked)
+	HealthMaxHeadAge time.Duration `toml:",omitempty"`
+
 	// Miscellaneous options
 	DocRoot   string `toml:"-"`
 	PowFake   bool   `toml:"-"`
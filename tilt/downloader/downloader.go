@@ -33,6 +33,7 @@ import (
 	"github.com/megatilt/go-tilt/event"
 	"github.com/megatilt/go-tilt/log"
 	"github.com/megatilt/go-tilt/params"
+	"github.com/megatilt/go-tilt/tilt/snap"
 	"github.com/megatilt/go-tilt/trie"
 	"github.com/rcrowley/go-metrics"
 )
@@ -91,16 +92,23 @@ var (
 	errCancelHeaderProcessing  = errors.New("header processing canceled (requested)")
 	errCancelContentProcessing = errors.New("content processing canceled (requested)")
 	errNoSyncActive            = errors.New("no sync active")
+	errUnrequestedDelivery     = errors.New("delivery not matching any pending request")
 	errTooOld                  = errors.New("peer doesn't speak recent enough protocol version (need version >= 62)")
+	errCheckpointUnreached     = errors.New("peer chain doesn't reach the trusted checkpoint")
+	errCheckpointMismatch      = errors.New("peer chain doesn't contain the trusted checkpoint")
 )
 
 type Downloader struct {
 	mode SyncMode       // Synchronisation mode defining the strategy used (per sync cycle)
 	mux  *event.TypeMux // Event multiplexer to announce sync operation events
 
+	checkpoint *params.TrustedCheckpoint // Trusted checkpoint the synced chain must contain (nil = trust the heaviest)
+
 	queue *queue   // Scheduler for selecting the hashes to download
 	peers *peerSet // Set of active peers from which download can proceed
 
+	SnapSyncer *snap.Syncer // Range based state retriever used in snap sync mode
+
 	fsPivotLock  *types.Header // Pivot header on critical section entry (cannot change between retries)
 	fsPivotFails uint32        // Number of subsequent fast sync failures in the critical section
 
@@ -144,6 +152,7 @@ type Downloader struct {
 	receiptWakeCh chan bool            // [tilt/63] Channel to signal the receipt fetcher of new tasks
 	stateWakeCh   chan bool            // [tilt/63] Channel to signal the state fetcher of new tasks
 	headerProcCh  chan []*types.Header // [tilt/62] Channel to feed the header processor new tasks
+	snapSyncCh    chan *snapSync       // [snap/1] Channel to hand the pivot state sync to the content processor
 
 	// Cancellation and termination
 	cancelPeer string        // Identifier of the peer currently being used as the master (cancel on drop)
@@ -161,7 +170,7 @@ type Downloader struct {
 }
 
 // New creates a new downloader to fetch hashes and blocks from remote peers.
-func New(mode SyncMode, stateDb tiltdb.Database, mux *event.TypeMux, hasHeader headerCheckFn, hasBlockAndState blockAndStateCheckFn,
+func New(mode SyncMode, checkpoint *params.TrustedCheckpoint, stateDb tiltdb.Database, mux *event.TypeMux, hasHeader headerCheckFn, hasBlockAndState blockAndStateCheckFn,
 	getHeader headerRetrievalFn, getBlock blockRetrievalFn, headHeader headHeaderRetrievalFn, headBlock headBlockRetrievalFn,
 	headFastBlock headFastBlockRetrievalFn, commitHeadBlock headBlockCommitterFn, getTd tdRetrievalFn, insertHeaders headerChainInsertFn,
 	insertBlocks blockChainInsertFn, insertReceipts receiptChainInsertFn, rollback chainRollbackFn, dropPeer peerDropFn) *Downloader {
@@ -169,8 +178,10 @@ func New(mode SyncMode, stateDb tiltdb.Database, mux *event.TypeMux, hasHeader h
 	dl := &Downloader{
 		mode:             mode,
 		mux:              mux,
+		checkpoint:       checkpoint,
 		queue:            newQueue(stateDb),
 		peers:            newPeerSet(),
+		SnapSyncer:       snap.NewSyncer(stateDb),
 		rttEstimate:      uint64(rttMaxEstimate),
 		rttConfidence:    uint64(1000000),
 		hasHeader:        hasHeader,
@@ -196,6 +207,7 @@ func New(mode SyncMode, stateDb tiltdb.Database, mux *event.TypeMux, hasHeader h
 		receiptWakeCh:    make(chan bool, 1),
 		stateWakeCh:      make(chan bool, 1),
 		headerProcCh:     make(chan []*types.Header, 1),
+		snapSyncCh:       make(chan *snapSync, 1),
 		quitCh:           make(chan struct{}),
 	}
 	go dl.qosTuner()
@@ -212,6 +224,7 @@ func New(mode SyncMode, stateDb tiltdb.Database, mux *event.TypeMux, hasHeader h
 func (d *Downloader) Progress() tiltnet.SyncProgress {
 	// Fetch the pending state count outside of the lock to prevent unforeseen deadlocks
 	pendingStates := uint64(d.queue.PendingNodeData())
+	snapStates, snapPending := d.SnapSyncer.Progress()
 
 	// Lock the current stats and return the progress
 	d.syncStatsLock.RLock()
@@ -221,13 +234,15 @@ func (d *Downloader) Progress() tiltnet.SyncProgress {
 	switch d.mode {
 	case FullSync:
 		current = d.headBlock().NumberU64()
+	case SnapSync:
+		current = d.headFastBlock().NumberU64()
 	}
 	return tiltnet.SyncProgress{
 		StartingBlock: d.syncStatsChainOrigin,
 		CurrentBlock:  current,
 		HighestBlock:  d.syncStatsChainHeight,
-		PulledStates:  d.syncStatsStateDone,
-		KnownStates:   d.syncStatsStateDone + pendingStates,
+		PulledStates:  d.syncStatsStateDone + snapStates,
+		KnownStates:   d.syncStatsStateDone + snapStates + pendingStates + snapPending,
 	}
 }
 
@@ -287,7 +302,7 @@ func (d *Downloader) Synchronise(id string, head common.Hash, td *big.Int, mode
 
 	case errTimeout, errBadPeer, errStallingPeer,
 		errEmptyHeaderSet, errPeersUnavailable, errTooOld,
-		errInvalidAncestor, errInvalidChain:
+		errInvalidAncestor, errInvalidChain, errCheckpointMismatch:
 		log.Warn("Synchronisation failed, dropping peer", "peer", id, "err", err)
 		d.dropPeer(id)
 
@@ -341,6 +356,13 @@ func (d *Downloader) synchronise(id string, hash common.Hash, td *big.Int, mode
 			empty = true
 		}
 	}
+	for empty := false; !empty; {
+		select {
+		case <-d.snapSyncCh:
+		default:
+			empty = true
+		}
+	}
 	// Create cancel channel for aborting mid-flight and mark the master peer
 	d.cancelLock.Lock()
 	d.cancelCh = make(chan struct{})
@@ -387,6 +409,12 @@ func (d *Downloader) syncWithPeer(p *peer, hash common.Hash, td *big.Int) (err e
 	}
 	height := latest.Number.Uint64()
 
+	// Until the trusted checkpoint is imported, only accept chains containing it
+	if d.checkpoint != nil && d.headHeader().Number.Uint64() < d.checkpoint.HeadNumber() {
+		if err := d.verifyCheckpoint(p, height); err != nil {
+			return err
+		}
+	}
 	origin, err := d.findAncestor(p, height)
 	if err != nil {
 		return err
@@ -398,8 +426,22 @@ func (d *Downloader) syncWithPeer(p *peer, hash common.Hash, td *big.Int) (err e
 	d.syncStatsChainHeight = height
 	d.syncStatsLock.Unlock()
 
-	// Initiate the sync using a concurrent header and content retrieval algorithm
+	// Pick the pivot block whose state to download in snap sync mode, leaving the
+	// most recent blocks to be imported fully
 	pivot := uint64(0)
+	if d.mode == SnapSync {
+		if height > uint64(fsMinFullBlocks) {
+			pivot = height - uint64(fsMinFullBlocks)
+		}
+		if d.fsPivotLock != nil {
+			pivot = d.fsPivotLock.Number.Uint64()
+		}
+		// The pivot header itself is needed to start the state sync
+		if pivot > 0 && pivot <= origin {
+			origin = pivot - 1
+		}
+	}
+	// Initiate the sync using a concurrent header and content retrieval algorithm
 	d.queue.Prepare(origin+1, d.mode, pivot, latest)
 	if d.syncInitHook != nil {
 		d.syncInitHook(origin, height)
@@ -483,7 +525,7 @@ func (d *Downloader) fetchHeight(p *peer) (*types.Header, error) {
 
 	// Request the advertised remote head block and wait for the response
 	head, _ := p.currentHead()
-	go p.getRelHeaders(head, 1, 0, false)
+	reqId := p.RequestRelHeaders(head, 1, 0, false)
 
 	ttl := d.requestTTL()
 	timeout := time.After(ttl)
@@ -493,11 +535,15 @@ func (d *Downloader) fetchHeight(p *peer) (*types.Header, error) {
 			return nil, errCancelBlockFetch
 
 		case packet := <-d.headerCh:
-			// Discard anything not from the origin peer
+			// Discard anything not from the origin peer or not answering our request
 			if packet.PeerId() != p.id {
 				log.Debug("Received headers from incorrect peer", "peer", packet.PeerId())
 				break
 			}
+			if !p.Matches(packet, reqId) {
+				p.log.Debug("Received headers for stale request", "request", packet.RequestId())
+				break
+			}
 			// Make sure the peer actually gave something valid
 			headers := packet.(*headerPack).headers
 			if len(headers) != 1 {
@@ -520,6 +566,61 @@ func (d *Downloader) fetchHeight(p *peer) (*types.Header, error) {
 	}
 }
 
+// verifyCheckpoint retrieves the header of the trusted checkpoint from the remote
+// peer, ensuring that its chain is anchored to it.
+func (d *Downloader) verifyCheckpoint(p *peer, height uint64) error {
+	number := d.checkpoint.HeadNumber()
+	if height < number {
+		p.log.Debug("Remote chain below trusted checkpoint", "height", height, "checkpoint", number)
+		return errCheckpointUnreached
+	}
+	p.log.Debug("Retrieving remote checkpoint header", "number", number)
+
+	// Request the checkpoint header and wait for the response
+	reqId := p.RequestAbsHeaders(number, 1, 0, false)
+
+	ttl := d.requestTTL()
+	timeout := time.After(ttl)
+	for {
+		select {
+		case <-d.cancelCh:
+			return errCancelBlockFetch
+
+		case packet := <-d.headerCh:
+			// Discard anything not from the origin peer or not answering our request
+			if packet.PeerId() != p.id {
+				log.Debug("Received headers from incorrect peer", "peer", packet.PeerId())
+				break
+			}
+			if !p.Matches(packet, reqId) {
+				p.log.Debug("Received headers for stale request", "request", packet.RequestId())
+				break
+			}
+			// Make sure the peer gave us the checkpoint header
+			headers := packet.(*headerPack).headers
+			if len(headers) != 1 || headers[0].Number.Uint64() != number {
+				p.log.Debug("Invalid checkpoint header reply", "headers", len(headers))
+				return errBadPeer
+			}
+			if hash := headers[0].Hash(); hash != d.checkpoint.SectionHead {
+				p.log.Warn("Remote chain conflicts with trusted checkpoint", "number", number, "have", hash, "want", d.checkpoint.SectionHead)
+				return errCheckpointMismatch
+			}
+			p.log.Debug("Remote checkpoint header verified", "number", number, "hash", d.checkpoint.SectionHead)
+			return nil
+
+		case <-timeout:
+			p.log.Debug("Waiting for checkpoint header timed out", "elapsed", ttl)
+			return errTimeout
+
+		case <-d.bodyCh:
+		case <-d.stateCh:
+		case <-d.receiptCh:
+			// Out of bounds delivery, ignore
+		}
+	}
+}
+
 // findAncestor tries to locate the common ancestor link of the local chain and
 // a remote peers blockchain. In the general case when our node was in sync and
 // on the correct chain, checking the top N links should already get us a match.
@@ -551,7 +652,7 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 	if count > limit {
 		count = limit
 	}
-	go p.getAbsHeaders(uint64(from), count, 15, false)
+	reqId := p.RequestAbsHeaders(uint64(from), count, 15, false)
 
 	// Wait for the remote response to the head fetch
 	number, hash := uint64(0), common.Hash{}
@@ -565,11 +666,15 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 			return 0, errCancelHeaderFetch
 
 		case packet := <-d.headerCh:
-			// Discard anything not from the origin peer
+			// Discard anything not from the origin peer or not answering our request
 			if packet.PeerId() != p.id {
 				log.Debug("Received headers from incorrect peer", "peer", packet.PeerId())
 				break
 			}
+			if !p.Matches(packet, reqId) {
+				p.log.Debug("Received headers for stale request", "request", packet.RequestId())
+				break
+			}
 			// Make sure the peer actually gave something valid
 			headers := packet.(*headerPack).headers
 			if len(headers) == 0 {
@@ -634,7 +739,7 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 		ttl := d.requestTTL()
 		timeout := time.After(ttl)
 
-		go p.getAbsHeaders(uint64(check), 1, 0, false)
+		reqId := p.RequestAbsHeaders(uint64(check), 1, 0, false)
 
 		// Wait until a reply arrives to this request
 		for arrived := false; !arrived; {
@@ -643,11 +748,15 @@ func (d *Downloader) findAncestor(p *peer, height uint64) (uint64, error) {
 				return 0, errCancelHeaderFetch
 
 			case packer := <-d.headerCh:
-				// Discard anything not from the origin peer
+				// Discard anything not from the origin peer or not answering our request
 				if packer.PeerId() != p.id {
 					log.Debug("Received headers from incorrect peer", "peer", packer.PeerId())
 					break
 				}
+				if !p.Matches(packer, reqId) {
+					p.log.Debug("Received headers for stale request", "request", packer.RequestId())
+					break
+				}
 				// Make sure the peer actually gave something valid
 				headers := packer.(*headerPack).headers
 				if len(headers) != 1 {
@@ -707,7 +816,10 @@ func (d *Downloader) fetchHeaders(p *peer, from uint64) error {
 	<-timeout.C                 // timeout channel should be initially empty
 	defer timeout.Stop()
 
-	var ttl time.Duration
+	var (
+		ttl   time.Duration
+		reqId uint64 // identifier of the last skeleton fetch request
+	)
 	getHeaders := func(from uint64) {
 		request = time.Now()
 
@@ -716,10 +828,10 @@ func (d *Downloader) fetchHeaders(p *peer, from uint64) error {
 
 		if skeleton {
 			p.log.Trace("Fetching skeleton headers", "count", MaxHeaderFetch, "from", from)
-			go p.getAbsHeaders(from+uint64(MaxHeaderFetch)-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
+			reqId = p.RequestAbsHeaders(from+uint64(MaxHeaderFetch)-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
 		} else {
 			p.log.Trace("Fetching full headers", "count", MaxHeaderFetch, "from", from)
-			go p.getAbsHeaders(from, MaxHeaderFetch, 0, false)
+			reqId = p.RequestAbsHeaders(from, MaxHeaderFetch, 0, false)
 		}
 	}
 	// Start pulling the header chain skeleton until all is done
@@ -736,6 +848,10 @@ func (d *Downloader) fetchHeaders(p *peer, from uint64) error {
 				log.Debug("Received skeleton from incorrect peer", "peer", packet.PeerId())
 				break
 			}
+			if !p.Matches(packet, reqId) {
+				p.log.Debug("Received skeleton for stale request", "request", packet.RequestId())
+				break
+			}
 			headerReqTimer.UpdateSince(request)
 			timeout.Stop()
 
@@ -1230,8 +1346,45 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 				}
 				chunk := headers[:limit]
 
-				// Unless we're doing light chains, schedule the headers for associated content retrieval
-				if d.mode == FullSync {
+				// Reject the chain if it deviates from the trusted checkpoint
+				if d.checkpoint != nil {
+					if number := d.checkpoint.HeadNumber(); number >= origin && number < origin+uint64(limit) {
+						if hash := chunk[number-origin].Hash(); hash != d.checkpoint.SectionHead {
+							log.Warn("Header chain conflicts with trusted checkpoint", "number", number, "have", hash, "want", d.checkpoint.SectionHead)
+							return errCheckpointMismatch
+						}
+					}
+				}
+				// In snap sync mode, insert the headers into the chain ahead of their contents
+				if d.mode == SnapSync {
+					// Collect the yet unknown headers to mark them as uncertain
+					unknown := make([]*types.Header, 0, len(chunk))
+					for _, header := range chunk {
+						if !d.hasHeader(header.Hash()) {
+							unknown = append(unknown, header)
+						}
+					}
+					// Verify the headers near the pivot fully, the rest only probabilistically
+					frequency := fsHeaderCheckFrequency
+					if chunk[len(chunk)-1].Number.Uint64()+uint64(fsHeaderForceVerify) > pivot {
+						frequency = 1
+					}
+					if n, err := d.insertHeaders(chunk, frequency); err != nil {
+						// If some headers were inserted, add them too to the rollback list
+						if n > 0 {
+							rollback = append(rollback, chunk[:n]...)
+						}
+						log.Debug("Invalid header encountered", "number", chunk[n].Number, "hash", chunk[n].Hash(), "err", err)
+						return errInvalidChain
+					}
+					// All verifications passed, store newly found uncertain headers
+					rollback = append(rollback, unknown...)
+					if len(rollback) > fsHeaderSafetyNet {
+						rollback = append(rollback[:0], rollback[len(rollback)-fsHeaderSafetyNet:]...)
+					}
+				}
+				// Schedule the headers for associated content retrieval
+				if d.mode == FullSync || d.mode == SnapSync {
 					// If we've reached the allowed number of pending headers, stall a bit
 					for d.queue.PendingBlocks() >= maxQueuedHeaders || d.queue.PendingReceipts() >= maxQueuedHeaders {
 						select {
@@ -1247,6 +1400,16 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 						return errBadPeer
 					}
 				}
+				// If the pivot header arrived, start retrieving its state in the background
+				if d.mode == SnapSync && pivot >= origin && pivot < origin+uint64(limit) {
+					header := chunk[pivot-origin]
+					log.Debug("Starting pivot state sync", "number", pivot, "hash", header.Hash(), "root", header.Root)
+					select {
+					case d.snapSyncCh <- d.startSnapSync(header.Root):
+					case <-d.cancelCh:
+						return errCancelHeaderProcessing
+					}
+				}
 				headers = headers[limit:]
 				origin += uint64(limit)
 			}
@@ -1292,10 +1455,18 @@ func (d *Downloader) processContent() error {
 				receipts = make([]types.Receipts, 0, maxResultsProcess)
 			)
 			items := int(math.Min(float64(len(results)), float64(maxResultsProcess)))
+			if first := results[0].Header.Number.Uint64(); d.mode == SnapSync && first <= pivot && first+uint64(items) > pivot+1 {
+				items = int(pivot - first + 1) // Don't mix receipt and full imports in a batch
+			}
 			for _, result := range results[:items] {
 				switch {
 				case d.mode == FullSync:
 					blocks = append(blocks, types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles))
+				case d.mode == SnapSync && result.Header.Number.Uint64() <= pivot:
+					blocks = append(blocks, types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles))
+					receipts = append(receipts, result.Receipts)
+				case d.mode == SnapSync:
+					blocks = append(blocks, types.NewBlockWithHeader(result.Header).WithBody(result.Transactions, result.Uncles))
 				}
 			}
 			// Try to process the results, aborting if there's an error
@@ -1307,6 +1478,10 @@ func (d *Downloader) processContent() error {
 			case len(receipts) > 0:
 				index, err = d.insertReceipts(blocks, receipts)
 				if err == nil && blocks[len(blocks)-1].NumberU64() == pivot {
+					// The pivot can only become the head once its state is complete
+					if err := d.waitSnapSync(); err != nil {
+						return err
+					}
 					log.Debug("Committing block as new head", "number", blocks[len(blocks)-1].Number(), "hash", blocks[len(blocks)-1].Hash())
 					index, err = len(blocks)-1, d.commitHeadBlock(blocks[len(blocks)-1].Hash())
 				}
@@ -1323,29 +1498,76 @@ func (d *Downloader) processContent() error {
 	}
 }
 
+// snapSync is a background retrieval of the pivot block's state in snap sync mode.
+type snapSync struct {
+	root common.Hash   // State root being retrieved
+	done chan struct{} // Closed when the retrieval terminates
+	err  error         // Failure of the retrieval, if any
+}
+
+// startSnapSync starts retrieving the state with the given root via the snap
+// protocol, aborting if the current sync cycle is cancelled.
+func (d *Downloader) startSnapSync(root common.Hash) *snapSync {
+	d.cancelLock.RLock()
+	cancel := d.cancelCh
+	d.cancelLock.RUnlock()
+
+	task := &snapSync{root: root, done: make(chan struct{})}
+	go func() {
+		task.err = d.SnapSyncer.Sync(root, cancel)
+		close(task.done)
+	}()
+	return task
+}
+
+// waitSnapSync blocks until the state of the pivot block has been retrieved.
+func (d *Downloader) waitSnapSync() error {
+	d.cancelLock.RLock()
+	cancel := d.cancelCh
+	d.cancelLock.RUnlock()
+
+	var task *snapSync
+	select {
+	case task = <-d.snapSyncCh:
+	case <-cancel:
+		return errCancelStateFetch
+	}
+	select {
+	case <-task.done:
+		if task.err != nil {
+			log.Debug("Pivot state sync failed", "root", task.root, "err", task.err)
+		}
+		return task.err
+	case <-cancel:
+		return errCancelStateFetch
+	}
+}
+
 // DeliverHeaders injects a new batch of block headers received from a remote
-// node into the download schedule.
-func (d *Downloader) DeliverHeaders(id string, headers []*types.Header) (err error) {
-	return d.deliver(id, d.headerCh, &headerPack{id, headers}, headerInMeter, headerDropMeter)
+// node into the download schedule. The request identifier is only meaningful
+// for tilt/65 peers and above, being ignored otherwise.
+func (d *Downloader) DeliverHeaders(id string, reqId uint64, headers []*types.Header) (err error) {
+	return d.deliver(id, headerRequest, d.headerCh, &headerPack{id, reqId, headers}, headerInMeter, headerDropMeter)
 }
 
 // DeliverBodies injects a new batch of block bodies received from a remote node.
-func (d *Downloader) DeliverBodies(id string, transactions [][]*types.Transaction, uncles [][]*types.Header) (err error) {
-	return d.deliver(id, d.bodyCh, &bodyPack{id, transactions, uncles}, bodyInMeter, bodyDropMeter)
+func (d *Downloader) DeliverBodies(id string, reqId uint64, transactions [][]*types.Transaction, uncles [][]*types.Header) (err error) {
+	return d.deliver(id, bodyRequest, d.bodyCh, &bodyPack{id, reqId, transactions, uncles}, bodyInMeter, bodyDropMeter)
 }
 
 // DeliverReceipts injects a new batch of receipts received from a remote node.
-func (d *Downloader) DeliverReceipts(id string, receipts [][]*types.Receipt) (err error) {
-	return d.deliver(id, d.receiptCh, &receiptPack{id, receipts}, receiptInMeter, receiptDropMeter)
+func (d *Downloader) DeliverReceipts(id string, reqId uint64, receipts [][]*types.Receipt) (err error) {
+	return d.deliver(id, receiptRequest, d.receiptCh, &receiptPack{id, reqId, receipts}, receiptInMeter, receiptDropMeter)
 }
 
 // DeliverNodeData injects a new batch of node state data received from a remote node.
-func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
-	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
+func (d *Downloader) DeliverNodeData(id string, reqId uint64, data [][]byte) (err error) {
+	return d.deliver(id, stateRequest, d.stateCh, &statePack{id, reqId, data}, stateInMeter, stateDropMeter)
 }
 
-// deliver injects a new batch of data received from a remote node.
-func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
+// deliver injects a new batch of data received from a remote node, ensuring that
+// tagged replies correspond to a request still pending.
+func (d *Downloader) deliver(id string, kind requestKind, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
 	inMeter.Mark(int64(packet.Items()))
 	defer func() {
@@ -1353,6 +1575,10 @@ func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, i
 			dropMeter.Mark(int64(packet.Items()))
 		}
 	}()
+	// Reject any stale or unsolicited replies from peers tagging their requests
+	if p := d.peers.Peer(id); p != nil && !p.Fulfil(packet.RequestId(), kind) {
+		return errUnrequestedDelivery
+	}
 	// Deliver or abort if the sync is canceled while queuing
 	d.cancelLock.RLock()
 	cancel := d.cancelCh
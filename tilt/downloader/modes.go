@@ -23,10 +23,11 @@ type SyncMode int
 
 const (
 	FullSync SyncMode = iota // Synchronise the entire blockchain history from full blocks
+	SnapSync                 // Download the state of a recent block in ranges via the snap protocol
 )
 
 func (mode SyncMode) IsValid() bool {
-	return mode >= FullSync
+	return mode >= FullSync && mode <= SnapSync
 }
 
 // String implements the stringer interface.
@@ -34,6 +35,8 @@ func (mode SyncMode) String() string {
 	switch mode {
 	case FullSync:
 		return "full"
+	case SnapSync:
+		return "snap"
 	default:
 		return "unknown"
 	}
@@ -43,6 +46,8 @@ func (mode SyncMode) MarshalText() ([]byte, error) {
 	switch mode {
 	case FullSync:
 		return []byte("full"), nil
+	case SnapSync:
+		return []byte("snap"), nil
 	default:
 		return nil, fmt.Errorf("unknown sync mode %d", mode)
 	}
@@ -52,8 +57,10 @@ func (mode *SyncMode) UnmarshalText(text []byte) error {
 	switch string(text) {
 	case "full":
 		*mode = FullSync
+	case "snap":
+		*mode = SnapSync
 	default:
-		return fmt.Errorf(`unknown sync mode %q, want "full"`, text)
+		return fmt.Errorf(`unknown sync mode %q, want "full" or "snap"`, text)
 	}
 	return nil
 }
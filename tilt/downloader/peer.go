@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"math/rand"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -35,18 +36,34 @@ import (
 
 const (
 	maxLackingHashes  = 4096 // Maximum number of entries allowed on the list or lacking items
+	maxPendingIds     = 1024 // Maximum number of request identifiers tracked per peer
 	measurementImpact = 0.1  // The impact a single measurement has on a peer's final throughput value.
+
+	// requestIdVersion is the first protocol version tagging each request and
+	// response with an identifier, allowing exact matching of replies.
+	requestIdVersion = 65
 )
 
 // Head hash and total difficulty retriever for
 type currentHeadRetrievalFn func() (common.Hash, *big.Int)
 
-// Block header and body fetchers belonging to tilt/62 and above
-type relativeHeaderFetcherFn func(common.Hash, int, int, bool) error
-type absoluteHeaderFetcherFn func(uint64, int, int, bool) error
-type blockBodyFetcherFn func([]common.Hash) error
-type receiptFetcherFn func([]common.Hash) error
-type stateFetcherFn func([]common.Hash) error
+// Block header and body fetchers belonging to tilt/62 and above. The first
+// parameter is the request identifier to tag the query with (tilt/65 and above).
+type relativeHeaderFetcherFn func(uint64, common.Hash, int, int, bool) error
+type absoluteHeaderFetcherFn func(uint64, uint64, int, int, bool) error
+type blockBodyFetcherFn func(uint64, []common.Hash) error
+type receiptFetcherFn func(uint64, []common.Hash) error
+type stateFetcherFn func(uint64, []common.Hash) error
+
+// requestKind is the type of data a tracked retrieval request is waiting for.
+type requestKind int
+
+const (
+	headerRequest requestKind = iota
+	bodyRequest
+	receiptRequest
+	stateRequest
+)
 
 var (
 	errAlreadyFetching   = errors.New("already fetching blocks from peer")
@@ -76,6 +93,7 @@ type peer struct {
 	stateStarted   time.Time // Time instance when the last node data fetch was started
 
 	lacking map[common.Hash]struct{} // Set of hashes not to request (didn't have previously)
+	pending map[uint64]requestKind   // Request identifiers awaiting a reply (tilt/65 and above)
 
 	currentHead currentHeadRetrievalFn // Method to fetch the currently known head of the peer
 
@@ -100,6 +118,7 @@ func newPeer(id string, version int, currentHead currentHeadRetrievalFn,
 	return &peer{
 		id:      id,
 		lacking: make(map[common.Hash]struct{}),
+		pending: make(map[uint64]requestKind),
 
 		currentHead:    currentHead,
 		getRelHeaders:  getRelHeaders,
@@ -130,6 +149,7 @@ func (p *peer) Reset() {
 	p.stateThroughput = 0
 
 	p.lacking = make(map[common.Hash]struct{})
+	p.pending = make(map[uint64]requestKind)
 }
 
 // FetchHeaders sends a header retrieval request to the remote peer.
@@ -145,7 +165,7 @@ func (p *peer) FetchHeaders(from uint64, count int) error {
 	p.headerStarted = time.Now()
 
 	// Issue the header retrieval request (absolut upwards without gaps)
-	go p.getAbsHeaders(from, count, 0, false)
+	p.RequestAbsHeaders(from, count, 0, false)
 
 	return nil
 }
@@ -167,7 +187,7 @@ func (p *peer) FetchBodies(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.getBlockBodies(hashes)
+	go p.getBlockBodies(p.track(bodyRequest), hashes)
 
 	return nil
 }
@@ -189,7 +209,7 @@ func (p *peer) FetchReceipts(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.getReceipts(hashes)
+	go p.getReceipts(p.track(receiptRequest), hashes)
 
 	return nil
 }
@@ -211,56 +231,142 @@ func (p *peer) FetchNodeData(request *fetchRequest) error {
 	for hash := range request.Hashes {
 		hashes = append(hashes, hash)
 	}
-	go p.getNodeData(hashes)
+	go p.getNodeData(p.track(stateRequest), hashes)
 
 	return nil
 }
 
+// RequestRelHeaders issues a header retrieval request based on the hash of an
+// origin block, returning the identifier the reply will be tagged with.
+func (p *peer) RequestRelHeaders(origin common.Hash, amount int, skip int, reverse bool) uint64 {
+	id := p.track(headerRequest)
+	go p.getRelHeaders(id, origin, amount, skip, reverse)
+	return id
+}
+
+// RequestAbsHeaders issues a header retrieval request based on the number of an
+// origin block, returning the identifier the reply will be tagged with.
+func (p *peer) RequestAbsHeaders(origin uint64, amount int, skip int, reverse bool) uint64 {
+	id := p.track(headerRequest)
+	go p.getAbsHeaders(id, origin, amount, skip, reverse)
+	return id
+}
+
+// track generates a new request identifier and, if the peer supports tagged
+// requests, registers it as awaiting a reply of the given kind.
+func (p *peer) track(kind requestKind) uint64 {
+	id := newRequestId()
+	if p.version < requestIdVersion {
+		return id
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for len(p.pending) >= maxPendingIds {
+		for drop := range p.pending {
+			delete(p.pending, drop)
+			break
+		}
+	}
+	p.pending[id] = kind
+	return id
+}
+
+// Fulfil checks whether a reply of the given kind was indeed requested with the
+// specified identifier, and if so, stops tracking the request. Peers running a
+// protocol version without request identifiers are always accepted.
+func (p *peer) Fulfil(id uint64, kind requestKind) bool {
+	if p.version < requestIdVersion {
+		return true
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if pending, ok := p.pending[id]; !ok || pending != kind {
+		return false
+	}
+	delete(p.pending, id)
+	return true
+}
+
+// Matches checks whether a delivered data packet is the reply to the request
+// with the given identifier. Peers running a protocol version without request
+// identifiers always match.
+func (p *peer) Matches(packet dataPack, id uint64) bool {
+	return p.version < requestIdVersion || packet.RequestId() == id
+}
+
+// forget stops tracking all the pending requests of a given kind, rejecting any
+// replies arriving late for them.
+func (p *peer) forget(kind requestKind) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for id, pending := range p.pending {
+		if pending == kind {
+			delete(p.pending, id)
+		}
+	}
+}
+
+// newRequestId generates a random, non-zero request identifier.
+func newRequestId() uint64 {
+	for {
+		if id := uint64(rand.Int63()); id != 0 {
+			return id
+		}
+	}
+}
+
 // SetHeadersIdle sets the peer to idle, allowing it to execute new header retrieval
 // requests. Its estimated header retrieval throughput is updated with that measured
 // just now.
 func (p *peer) SetHeadersIdle(delivered int) {
-	p.setIdle(p.headerStarted, delivered, &p.headerThroughput, &p.headerIdle)
+	p.setIdle(p.headerStarted, delivered, headerRequest, &p.headerThroughput, &p.headerIdle)
 }
 
 // SetBlocksIdle sets the peer to idle, allowing it to execute new block retrieval
 // requests. Its estimated block retrieval throughput is updated with that measured
 // just now.
 func (p *peer) SetBlocksIdle(delivered int) {
-	p.setIdle(p.blockStarted, delivered, &p.blockThroughput, &p.blockIdle)
+	p.setIdle(p.blockStarted, delivered, bodyRequest, &p.blockThroughput, &p.blockIdle)
 }
 
 // SetBodiesIdle sets the peer to idle, allowing it to execute block body retrieval
 // requests. Its estimated body retrieval throughput is updated with that measured
 // just now.
 func (p *peer) SetBodiesIdle(delivered int) {
-	p.setIdle(p.blockStarted, delivered, &p.blockThroughput, &p.blockIdle)
+	p.setIdle(p.blockStarted, delivered, bodyRequest, &p.blockThroughput, &p.blockIdle)
 }
 
 // SetReceiptsIdle sets the peer to idle, allowing it to execute new receipt
 // retrieval requests. Its estimated receipt retrieval throughput is updated
 // with that measured just now.
 func (p *peer) SetReceiptsIdle(delivered int) {
-	p.setIdle(p.receiptStarted, delivered, &p.receiptThroughput, &p.receiptIdle)
+	p.setIdle(p.receiptStarted, delivered, receiptRequest, &p.receiptThroughput, &p.receiptIdle)
 }
 
 // SetNodeDataIdle sets the peer to idle, allowing it to execute new state trie
 // data retrieval requests. Its estimated state retrieval throughput is updated
 // with that measured just now.
 func (p *peer) SetNodeDataIdle(delivered int) {
-	p.setIdle(p.stateStarted, delivered, &p.stateThroughput, &p.stateIdle)
+	p.setIdle(p.stateStarted, delivered, stateRequest, &p.stateThroughput, &p.stateIdle)
 }
 
 // setIdle sets the peer to idle, allowing it to execute new retrieval requests.
 // Its estimated retrieval throughput is updated with that measured just now.
-func (p *peer) setIdle(started time.Time, delivered int, throughput *float64, idle *int32) {
+func (p *peer) setIdle(started time.Time, delivered int, kind requestKind, throughput *float64, idle *int32) {
 	// Irrelevant of the scaling, make sure the peer ends up idle
 	defer atomic.StoreInt32(idle, 0)
 
+	// If nothing was delivered (hard timeout / unavailable data), reject any late
+	// replies and reduce throughput to minimum
+	if delivered == 0 {
+		p.forget(kind)
+	}
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	// If nothing was delivered (hard timeout / unavailable data), reduce throughput to minimum
 	if delivered == 0 {
 		*throughput = 0
 		return
@@ -454,7 +560,7 @@ func (ps *peerSet) HeaderIdlePeers() ([]*peer, int) {
 		defer p.lock.RUnlock()
 		return p.headerThroughput
 	}
-	return ps.idlePeers(62, 64, idle, throughput)
+	return ps.idlePeers(62, 65, idle, throughput)
 }
 
 // BodyIdlePeers retrieves a flat list of all the currently body-idle peers within
@@ -468,7 +574,7 @@ func (ps *peerSet) BodyIdlePeers() ([]*peer, int) {
 		defer p.lock.RUnlock()
 		return p.blockThroughput
 	}
-	return ps.idlePeers(62, 64, idle, throughput)
+	return ps.idlePeers(62, 65, idle, throughput)
 }
 
 // ReceiptIdlePeers retrieves a flat list of all the currently receipt-idle peers
@@ -482,7 +588,7 @@ func (ps *peerSet) ReceiptIdlePeers() ([]*peer, int) {
 		defer p.lock.RUnlock()
 		return p.receiptThroughput
 	}
-	return ps.idlePeers(63, 64, idle, throughput)
+	return ps.idlePeers(63, 65, idle, throughput)
 }
 
 // NodeDataIdlePeers retrieves a flat list of all the currently node-data-idle
@@ -496,7 +602,7 @@ func (ps *peerSet) NodeDataIdlePeers() ([]*peer, int) {
 		defer p.lock.RUnlock()
 		return p.stateThroughput
 	}
-	return ps.idlePeers(63, 64, idle, throughput)
+	return ps.idlePeers(63, 65, idle, throughput)
 }
 
 // idlePeers retrieves a flat list of all currently idle peers satisfying the
@@ -384,6 +384,12 @@ func (q *queue) Schedule(headers []*types.Header, from uint64) []*types.Header {
 		q.blockTaskPool[hash] = header
 		q.blockTaskQueue.Push(header, -float32(header.Number.Uint64()))
 
+		if q.mode == SnapSync && header.Number.Uint64() <= q.fastSyncPivot {
+			// Snap sync needs the receipts of the blocks up to the pivot too
+			q.receiptTaskPool[hash] = header
+			q.receiptTaskQueue.Push(header, -float32(header.Number.Uint64()))
+		}
+
 		inserts = append(inserts, header)
 		q.headerHead = hash
 		from++
@@ -613,6 +619,9 @@ func (q *queue) reserveHeaders(p *peer, count int, taskPool map[common.Hash]*typ
 		}
 		if q.resultCache[index] == nil {
 			components := 1
+			if q.mode == SnapSync && header.Number.Uint64() <= q.fastSyncPivot {
+				components = 2
+			}
 			q.resultCache[index] = &fetchResult{
 				Pending: components,
 				Header:  header,
@@ -14,7 +14,8 @@
 // You should have received a copy of the GNU Lesser General Public License
 // along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
 
-// Package fetcher contains the block announcement based synchronisation.
+// Package fetcher contains the block and transaction announcement based
+// synchronisation.
 package fetcher
 
 import (
@@ -46,11 +47,13 @@ var (
 // blockRetrievalFn is a callback type for retrieving a block from the local chain.
 type blockRetrievalFn func(common.Hash) *types.Block
 
-// headerRequesterFn is a callback type for sending a header retrieval request.
-type headerRequesterFn func(common.Hash) error
+// headerRequesterFn is a callback type for sending a header retrieval request,
+// tagged with a request identifier (tilt/65 and above).
+type headerRequesterFn func(uint64, common.Hash) error
 
-// bodyRequesterFn is a callback type for sending a body retrieval request.
-type bodyRequesterFn func([]common.Hash) error
+// bodyRequesterFn is a callback type for sending a body retrieval request,
+// tagged with a request identifier (tilt/65 and above).
+type bodyRequesterFn func(uint64, []common.Hash) error
 
 // headerVerifierFn is a callback type to verify a block's header for fast propagation.
 type headerVerifierFn func(header *types.Header) error
@@ -75,7 +78,8 @@ type announce struct {
 	header *types.Header // Header of the block partially reassembled (new protocol)
 	time   time.Time     // Timestamp of the announcement
 
-	origin string // Identifier of the peer originating the notification
+	origin  string // Identifier of the peer originating the notification
+	request uint64 // Identifier of the header or body retrieval in flight
 
 	fetchHeader headerRequesterFn // Fetcher function to retrieve the header of an announced block
 	fetchBodies bodyRequesterFn   // Fetcher function to retrieve the body of an announced block
@@ -83,6 +87,9 @@ type announce struct {
 
 // headerFilterTask represents a batch of headers needing fetcher filtering.
 type headerFilterTask struct {
+	peer    string          // Identifier of the peer delivering the headers
+	request uint64          // Identifier of the request being answered (0 = untagged)
+	owned   bool            // Whether the fetcher issued the tagged request
 	headers []*types.Header // Collection of headers to filter
 	time    time.Time       // Arrival time of the headers
 }
@@ -90,6 +97,9 @@ type headerFilterTask struct {
 // headerFilterTask represents a batch of block bodies (transactions and uncles)
 // needing fetcher filtering.
 type bodyFilterTask struct {
+	peer         string                 // Identifier of the peer delivering the bodies
+	request      uint64                 // Identifier of the request being answered (0 = untagged)
+	owned        bool                   // Whether the fetcher issued the tagged request
 	transactions [][]*types.Transaction // Collection of transactions per block bodies
 	uncles       [][]*types.Header      // Collection of uncles per block bodies
 	time         time.Time              // Arrival time of the blocks' contents
@@ -218,9 +228,30 @@ func (f *Fetcher) Enqueue(peer string, block *types.Block) error {
 
 // FilterHeaders extracts all the headers that were explicitly requested by the fetcher,
 // returning those that should be handled differently.
-func (f *Fetcher) FilterHeaders(headers []*types.Header, time time.Time) []*types.Header {
-	log.Trace("Filtering headers", "headers", len(headers))
+func (f *Fetcher) FilterHeaders(peer string, headers []*types.Header, time time.Time) []*types.Header {
+	log.Trace("Filtering headers", "peer", peer, "headers", len(headers))
 
+	task := f.filterHeaders(&headerFilterTask{peer: peer, headers: headers, time: time})
+	if task == nil {
+		return nil
+	}
+	return task.headers
+}
+
+// DeliverHeaders injects a batch of headers tagged with a request identifier
+// (tilt/65 and above) into the fetcher, returning whether it was the fetcher
+// that issued the request. If so, the entire batch is consumed; otherwise it
+// should be handled by other parts of the system.
+func (f *Fetcher) DeliverHeaders(peer string, request uint64, headers []*types.Header, time time.Time) bool {
+	log.Trace("Delivering headers", "peer", peer, "request", request, "headers", len(headers))
+
+	task := f.filterHeaders(&headerFilterTask{peer: peer, request: request, headers: headers, time: time})
+	return task != nil && task.owned
+}
+
+// filterHeaders sends a header filtering task to the fetcher and waits for the
+// remainder to be returned.
+func (f *Fetcher) filterHeaders(task *headerFilterTask) *headerFilterTask {
 	// Send the filter channel to the fetcher
 	filter := make(chan *headerFilterTask)
 
@@ -231,14 +262,14 @@ func (f *Fetcher) FilterHeaders(headers []*types.Header, time time.Time) []*type
 	}
 	// Request the filtering of the header list
 	select {
-	case filter <- &headerFilterTask{headers: headers, time: time}:
+	case filter <- task:
 	case <-f.quit:
 		return nil
 	}
 	// Retrieve the headers remaining after filtering
 	select {
 	case task := <-filter:
-		return task.headers
+		return task
 	case <-f.quit:
 		return nil
 	}
@@ -246,29 +277,50 @@ func (f *Fetcher) FilterHeaders(headers []*types.Header, time time.Time) []*type
 
 // FilterBodies extracts all the block bodies that were explicitly requested by
 // the fetcher, returning those that should be handled differently.
-func (f *Fetcher) FilterBodies(transactions [][]*types.Transaction, uncles [][]*types.Header, time time.Time) ([][]*types.Transaction, [][]*types.Header) {
-	log.Trace("Filtering bodies", "txs", len(transactions), "uncles", len(uncles))
+func (f *Fetcher) FilterBodies(peer string, transactions [][]*types.Transaction, uncles [][]*types.Header, time time.Time) ([][]*types.Transaction, [][]*types.Header) {
+	log.Trace("Filtering bodies", "peer", peer, "txs", len(transactions), "uncles", len(uncles))
 
+	task := f.filterBodies(&bodyFilterTask{peer: peer, transactions: transactions, uncles: uncles, time: time})
+	if task == nil {
+		return nil, nil
+	}
+	return task.transactions, task.uncles
+}
+
+// DeliverBodies injects a batch of block bodies tagged with a request identifier
+// (tilt/65 and above) into the fetcher, returning whether it was the fetcher
+// that issued the request. If so, the entire batch is consumed; otherwise it
+// should be handled by other parts of the system.
+func (f *Fetcher) DeliverBodies(peer string, request uint64, transactions [][]*types.Transaction, uncles [][]*types.Header, time time.Time) bool {
+	log.Trace("Delivering bodies", "peer", peer, "request", request, "txs", len(transactions), "uncles", len(uncles))
+
+	task := f.filterBodies(&bodyFilterTask{peer: peer, request: request, transactions: transactions, uncles: uncles, time: time})
+	return task != nil && task.owned
+}
+
+// filterBodies sends a body filtering task to the fetcher and waits for the
+// remainder to be returned.
+func (f *Fetcher) filterBodies(task *bodyFilterTask) *bodyFilterTask {
 	// Send the filter channel to the fetcher
 	filter := make(chan *bodyFilterTask)
 
 	select {
 	case f.bodyFilter <- filter:
 	case <-f.quit:
-		return nil, nil
+		return nil
 	}
 	// Request the filtering of the body list
 	select {
-	case filter <- &bodyFilterTask{transactions: transactions, uncles: uncles, time: time}:
+	case filter <- task:
 	case <-f.quit:
-		return nil, nil
+		return nil
 	}
 	// Retrieve the bodies remaining after filtering
 	select {
 	case task := <-filter:
-		return task.transactions, task.uncles
+		return task
 	case <-f.quit:
-		return nil, nil
+		return nil
 	}
 }
 
@@ -372,6 +424,7 @@ func (f *Fetcher) loop() {
 
 					// If the block still didn't arrive, queue for fetching
 					if f.getBlock(hash) == nil {
+						announce.request = newRequestId()
 						request[announce.origin] = append(request[announce.origin], hash)
 						f.fetching[hash] = announce
 					}
@@ -383,13 +436,17 @@ func (f *Fetcher) loop() {
 
 				// Create a closure of the fetch and schedule in on a new thread
 				fetchHeader, hashes := f.fetching[hashes[0]].fetchHeader, hashes
+				requests := make([]uint64, len(hashes))
+				for i, hash := range hashes {
+					requests[i] = f.fetching[hash].request
+				}
 				go func() {
 					if f.fetchingHook != nil {
 						f.fetchingHook(hashes)
 					}
-					for _, hash := range hashes {
+					for i, hash := range hashes {
 						headerFetchMeter.Mark(1)
-						fetchHeader(hash) // Suboptimal, but protocol doesn't allow batch header retrievals
+						fetchHeader(requests[i], hash) // Suboptimal, but protocol doesn't allow batch header retrievals
 					}
 				}()
 			}
@@ -415,12 +472,17 @@ func (f *Fetcher) loop() {
 			for peer, hashes := range request {
 				log.Trace("Fetching scheduled bodies", "peer", peer, "list", hashes)
 
+				// Tag the batch with a shared request identifier to match the reply
+				id := newRequestId()
+				for _, hash := range hashes {
+					f.completing[hash].request = id
+				}
 				// Create a closure of the fetch and schedule in on a new thread
 				if f.completingHook != nil {
 					f.completingHook(hashes)
 				}
 				bodyFetchMeter.Mark(int64(len(hashes)))
-				go f.completing[hashes[0]].fetchBodies(hashes)
+				go f.completing[hashes[0]].fetchBodies(id, hashes)
 			}
 			// Schedule the next fetch if blocks are still pending
 			f.rescheduleComplete(completeTimer)
@@ -437,6 +499,36 @@ func (f *Fetcher) loop() {
 			}
 			headerFilterInMeter.Mark(int64(len(task.headers)))
 
+			// If the reply is tagged, only handle it if we requested it, and then
+			// make sure it contains exactly the header asked for
+			if task.request != 0 {
+				announce := f.pendingHeader(task.peer, task.request)
+				if announce == nil {
+					headerFilterOutMeter.Mark(int64(len(task.headers)))
+					select {
+					case filter <- task:
+					case <-f.quit:
+						return
+					}
+					break
+				}
+				task.owned = true
+
+				if len(task.headers) != 1 || task.headers[0].Hash() != announce.hash {
+					if len(task.headers) > 0 {
+						log.Trace("Invalid header delivered", "peer", announce.origin, "hash", announce.hash, "headers", len(task.headers))
+						f.dropPeer(announce.origin)
+					}
+					f.forgetHash(announce.hash)
+
+					select {
+					case filter <- &headerFilterTask{peer: task.peer, request: task.request, owned: true, time: task.time}:
+					case <-f.quit:
+						return
+					}
+					break
+				}
+			}
 			// Split the batch of headers into unknown ones (to return to the caller),
 			// known incomplete ones (requiring body retrievals) and completed blocks.
 			unknown, incomplete, complete := []*types.Header{}, []*announce{}, []*types.Block{}
@@ -444,7 +536,7 @@ func (f *Fetcher) loop() {
 				hash := header.Hash()
 
 				// Filter fetcher-requested headers from other synchronisation algorithms
-				if announce := f.fetching[hash]; announce != nil && f.fetched[hash] == nil && f.completing[hash] == nil && f.queued[hash] == nil {
+				if announce := f.fetching[hash]; announce != nil && (task.request == 0 || announce.request == task.request) && f.fetched[hash] == nil && f.completing[hash] == nil && f.queued[hash] == nil {
 					// If the delivered header does not match the promised number, drop the announcer
 					if header.Number.Uint64() != announce.number {
 						log.Trace("Invalid block number fetched", "peer", announce.origin, "hash", header.Hash(), "announced", announce.number, "provided", header.Number)
@@ -479,9 +571,12 @@ func (f *Fetcher) loop() {
 					unknown = append(unknown, header)
 				}
 			}
+			if task.owned {
+				unknown = nil
+			}
 			headerFilterOutMeter.Mark(int64(len(unknown)))
 			select {
-			case filter <- &headerFilterTask{headers: unknown, time: task.time}:
+			case filter <- &headerFilterTask{peer: task.peer, request: task.request, owned: task.owned, headers: unknown, time: task.time}:
 			case <-f.quit:
 				return
 			}
@@ -513,12 +608,29 @@ func (f *Fetcher) loop() {
 			}
 			bodyFilterInMeter.Mark(int64(len(task.transactions)))
 
+			// If the reply is tagged, only handle it if we requested it
+			var requested map[common.Hash]*announce
+			if task.request != 0 {
+				if requested = f.pendingBodies(task.peer, task.request); len(requested) == 0 {
+					bodyFilterOutMeter.Mark(int64(len(task.transactions)))
+					select {
+					case filter <- task:
+					case <-f.quit:
+						return
+					}
+					break
+				}
+				task.owned = true
+			}
 			blocks := []*types.Block{}
 			for i := 0; i < len(task.transactions) && i < len(task.uncles); i++ {
 				// Match up a body to any possible completion request
 				matched := false
 
 				for hash, announce := range f.completing {
+					if task.request != 0 && announce.request != task.request {
+						continue
+					}
 					if f.queued[hash] == nil {
 						txnHash := types.DeriveSha(types.Transactions(task.transactions[i]))
 						uncleHash := types.CalcUncleHash(task.uncles[i])
@@ -546,6 +658,19 @@ func (f *Fetcher) loop() {
 				}
 			}
 
+			// Tagged replies are consumed entirely, retry anything the peer didn't deliver
+			if task.owned {
+				delivered := make(map[common.Hash]struct{})
+				for _, block := range blocks {
+					delivered[block.Hash()] = struct{}{}
+				}
+				for hash := range requested {
+					if _, ok := delivered[hash]; !ok && f.queued[hash] == nil {
+						f.forgetHash(hash)
+					}
+				}
+				task.transactions, task.uncles = nil, nil
+			}
 			bodyFilterOutMeter.Mark(int64(len(task.transactions)))
 			select {
 			case filter <- task:
@@ -562,6 +687,38 @@ func (f *Fetcher) loop() {
 	}
 }
 
+// pendingHeader retrieves the announcement whose header retrieval was tagged
+// with the given request identifier, if any.
+func (f *Fetcher) pendingHeader(peer string, request uint64) *announce {
+	for _, announce := range f.fetching {
+		if announce.origin == peer && announce.request == request {
+			return announce
+		}
+	}
+	return nil
+}
+
+// pendingBodies retrieves the announcements whose body retrievals were tagged
+// with the given request identifier.
+func (f *Fetcher) pendingBodies(peer string, request uint64) map[common.Hash]*announce {
+	pending := make(map[common.Hash]*announce)
+	for hash, announce := range f.completing {
+		if announce.origin == peer && announce.request == request {
+			pending[hash] = announce
+		}
+	}
+	return pending
+}
+
+// newRequestId generates a random, non-zero request identifier.
+func newRequestId() uint64 {
+	for {
+		if id := uint64(rand.Int63()); id != 0 {
+			return id
+		}
+	}
+}
+
 // rescheduleFetch resets the specified fetch timer to the next announce timeout.
 func (f *Fetcher) rescheduleFetch(fetch *time.Timer) {
 	// Short circuit if no blocks are announced
@@ -40,4 +40,12 @@ var (
 	headerFilterOutMeter = metrics.NewMeter("tilt/fetcher/filter/headers/out")
 	bodyFilterInMeter    = metrics.NewMeter("tilt/fetcher/filter/bodies/in")
 	bodyFilterOutMeter   = metrics.NewMeter("tilt/fetcher/filter/bodies/out")
+
+	txAnnounceInMeter    = metrics.NewMeter("tilt/fetcher/prop/txhashes/in")
+	txAnnounceKnownMeter = metrics.NewMeter("tilt/fetcher/prop/txhashes/known")
+	txAnnounceDOSMeter   = metrics.NewMeter("tilt/fetcher/prop/txhashes/dos")
+	txDeliveryInMeter    = metrics.NewMeter("tilt/fetcher/prop/txs/in")
+
+	txFetchMeter        = metrics.NewMeter("tilt/fetcher/fetch/txs")
+	txFetchTimeoutMeter = metrics.NewMeter("tilt/fetcher/fetch/txs/timeout")
 )
@@ -0,0 +1,388 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/log"
+)
+
+const (
+	txArriveTimeout = 500 * time.Millisecond // Time allowance before an announced transaction is explicitly requested
+	txGatherSlack   = 100 * time.Millisecond // Interval used to collate almost-expired announces with fetches
+	txFetchTimeout  = 5 * time.Second        // Maximum allotted time to return an explicitly requested transaction
+	txHashLimit     = 4096                   // Maximum number of unique transactions a peer may have announced
+
+	// MaxTxFetch is the maximum number of transactions requested from, or served
+	// to, a single peer in one retrieval round.
+	MaxTxFetch = 256
+)
+
+// txRequesterFn is a callback type for sending a transaction retrieval request.
+type txRequesterFn func([]common.Hash) error
+
+// txPoolHasFn is a callback type for checking whether the local pool already
+// contains a transaction.
+type txPoolHasFn func(common.Hash) bool
+
+// txPoolAddFn is a callback type for injecting a batch of transactions into
+// the local pool.
+type txPoolAddFn func([]*types.Transaction) error
+
+// txAnnounce is the hash notification of the availability of a transaction in
+// the network.
+type txAnnounce struct {
+	hash common.Hash // Hash of the transaction being announced
+	time time.Time   // Timestamp of the announcement (or retrieval request once fetching)
+
+	origin string // Identifier of the peer originating the notification
+
+	fetchTxs txRequesterFn // Fetcher function to retrieve the announced transactions
+}
+
+// txNotification is a batch of transaction announcements from a single peer.
+type txNotification struct {
+	origin   string        // Identifier of the peer originating the notification
+	hashes   []common.Hash // Batch of transaction hashes being announced
+	time     time.Time     // Timestamp of the announcement
+	fetchTxs txRequesterFn // Fetcher function to retrieve the announced transactions
+}
+
+// txDelivery is a batch of transaction hashes that arrived in full from a peer,
+// either explicitly requested or directly broadcast.
+type txDelivery struct {
+	origin string        // Identifier of the peer delivering the transactions
+	hashes []common.Hash // Hashes of the transactions delivered
+}
+
+// TxFetcher is responsible for accumulating transaction announcements from
+// various peers and scheduling them for retrieval, making sure that a single
+// transaction is only ever requested from one peer at a time.
+type TxFetcher struct {
+	// Various event channels
+	notify  chan *txNotification
+	cleanup chan *txDelivery
+	drop    chan string
+	quit    chan struct{}
+
+	// Announce states
+	announces map[string]int                // Per peer announce counts to prevent memory exhaustion
+	announced map[common.Hash][]*txAnnounce // Announced transactions, scheduled for fetching
+	fetching  map[common.Hash]*txAnnounce   // Announced transactions, currently fetching
+
+	// Callbacks
+	hasTx  txPoolHasFn // Checks whether a transaction is already in the local pool
+	addTxs txPoolAddFn // Injects a batch of transactions into the local pool
+
+	// Testing hooks
+	announceChangeHook func(common.Hash, bool) // Method to call upon adding or deleting a hash from the announce list
+	fetchingHook       func([]common.Hash)     // Method to call upon starting a transaction fetch
+}
+
+// NewTxFetcher creates a transaction fetcher to retrieve transactions based on
+// hash announcements.
+func NewTxFetcher(hasTx txPoolHasFn, addTxs txPoolAddFn) *TxFetcher {
+	return &TxFetcher{
+		notify:    make(chan *txNotification),
+		cleanup:   make(chan *txDelivery),
+		drop:      make(chan string),
+		quit:      make(chan struct{}),
+		announces: make(map[string]int),
+		announced: make(map[common.Hash][]*txAnnounce),
+		fetching:  make(map[common.Hash]*txAnnounce),
+		hasTx:     hasTx,
+		addTxs:    addTxs,
+	}
+}
+
+// Start boots up the announcement based transaction retriever, accepting and
+// processing hash notifications and transaction deliveries until termination
+// requested.
+func (f *TxFetcher) Start() {
+	go f.loop()
+}
+
+// Stop terminates the announcement based transaction retriever, canceling all
+// pending operations.
+func (f *TxFetcher) Stop() {
+	close(f.quit)
+}
+
+// Notify announces the fetcher of the potential availability of a batch of new
+// transactions in the network.
+func (f *TxFetcher) Notify(peer string, hashes []common.Hash, time time.Time, fetcher txRequesterFn) error {
+	notification := &txNotification{
+		origin:   peer,
+		hashes:   hashes,
+		time:     time,
+		fetchTxs: fetcher,
+	}
+	select {
+	case f.notify <- notification:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Enqueue imports a batch of transactions into the local pool and clears any
+// pending announcements or retrievals of them, irrelevant whether they were
+// explicitly requested or arrived through a direct broadcast.
+func (f *TxFetcher) Enqueue(peer string, txs []*types.Transaction) error {
+	txDeliveryInMeter.Mark(int64(len(txs)))
+
+	if err := f.addTxs(txs); err != nil {
+		log.Debug("Failed to import delivered transactions", "peer", peer, "err", err)
+	}
+	hashes := make([]common.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+	select {
+	case f.cleanup <- &txDelivery{origin: peer, hashes: hashes}:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Drop notifies the fetcher that a peer disconnected, releasing all of its
+// announcements and rescheduling its in-flight retrievals to alternate peers.
+func (f *TxFetcher) Drop(peer string) error {
+	select {
+	case f.drop <- peer:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Loop is the main transaction fetcher loop, checking and processing various
+// notification events.
+func (f *TxFetcher) loop() {
+	fetchTimer := time.NewTimer(0)
+
+	for {
+		// Clean up any expired transaction fetches, alternates are picked up by
+		// the next retrieval round
+		for hash, announce := range f.fetching {
+			if time.Since(announce.time) > txFetchTimeout {
+				log.Trace("Transaction retrieval timed out", "peer", announce.origin, "hash", hash)
+				txFetchTimeoutMeter.Mark(1)
+
+				f.release(announce)
+				delete(f.fetching, hash)
+			}
+		}
+		// Wait for an outside event to occur
+		select {
+		case <-f.quit:
+			// Fetcher terminating, abort all operations
+			return
+
+		case notification := <-f.notify:
+			// A batch of transactions was announced, make sure the peer isn't DOSing us
+			txAnnounceInMeter.Mark(int64(len(notification.hashes)))
+
+			scheduled := len(f.announced)
+			for _, hash := range notification.hashes {
+				count := f.announces[notification.origin] + 1
+				if count > txHashLimit {
+					log.Debug("Peer exceeded outstanding transaction announces", "peer", notification.origin, "limit", txHashLimit)
+					txAnnounceDOSMeter.Mark(1)
+					break
+				}
+				// Skip anything already known locally or already announced by the same peer
+				if f.hasTx(hash) {
+					txAnnounceKnownMeter.Mark(1)
+					continue
+				}
+				if f.announcedBy(hash, notification.origin) {
+					continue
+				}
+				f.announces[notification.origin] = count
+				f.announced[hash] = append(f.announced[hash], &txAnnounce{
+					hash:     hash,
+					time:     notification.time,
+					origin:   notification.origin,
+					fetchTxs: notification.fetchTxs,
+				})
+				if f.announceChangeHook != nil && len(f.announced[hash]) == 1 {
+					f.announceChangeHook(hash, true)
+				}
+			}
+			if scheduled == 0 && len(f.announced) > 0 {
+				f.rescheduleFetch(fetchTimer)
+			}
+
+		case delivery := <-f.cleanup:
+			// Transactions arrived, remove all traces of their announcements
+			for _, hash := range delivery.hashes {
+				f.forgetHash(hash)
+			}
+
+		case peer := <-f.drop:
+			// A peer disconnected, release its announces and reassign its retrievals
+			for hash, announce := range f.fetching {
+				if announce.origin == peer {
+					f.release(announce)
+					delete(f.fetching, hash)
+				}
+			}
+			for hash, announces := range f.announced {
+				for i, announce := range announces {
+					if announce.origin == peer {
+						f.release(announce)
+						announces = append(announces[:i], announces[i+1:]...)
+						break
+					}
+				}
+				if len(announces) == 0 {
+					delete(f.announced, hash)
+					if f.announceChangeHook != nil {
+						f.announceChangeHook(hash, false)
+					}
+				} else {
+					f.announced[hash] = announces
+				}
+			}
+			f.rescheduleFetch(fetchTimer)
+
+		case <-fetchTimer.C:
+			// At least one transaction's timer ran out, check for needing retrieval
+			request := make(map[string][]common.Hash)
+
+			for hash, announces := range f.announced {
+				// Skip transactions already being retrieved from some other peer
+				if _, ok := f.fetching[hash]; ok {
+					continue
+				}
+				if time.Since(announces[0].time) <= txArriveTimeout-txGatherSlack {
+					continue
+				}
+				// If the transaction arrived in the mean time, drop all announces
+				if f.hasTx(hash) {
+					f.forgetHash(hash)
+					continue
+				}
+				// Pick a random peer with spare request capacity to retrieve from
+				idx := rand.Intn(len(announces))
+				announce := announces[idx]
+				if len(request[announce.origin]) >= MaxTxFetch {
+					continue
+				}
+				f.announced[hash] = append(announces[:idx], announces[idx+1:]...)
+				if len(f.announced[hash]) == 0 {
+					delete(f.announced, hash)
+				}
+				announce.time = time.Now()
+				f.fetching[hash] = announce
+				request[announce.origin] = append(request[announce.origin], hash)
+			}
+			// Send out all transaction requests
+			for peer, hashes := range request {
+				log.Trace("Fetching scheduled transactions", "peer", peer, "count", len(hashes))
+
+				// Create a closure of the fetch and schedule in on a new thread
+				fetchTxs, hashes := f.fetching[hashes[0]].fetchTxs, hashes
+				go func() {
+					if f.fetchingHook != nil {
+						f.fetchingHook(hashes)
+					}
+					txFetchMeter.Mark(int64(len(hashes)))
+					fetchTxs(hashes)
+				}()
+			}
+			// Schedule the next fetch if transactions are still pending
+			f.rescheduleFetch(fetchTimer)
+		}
+	}
+}
+
+// rescheduleFetch resets the specified fetch timer to the next announce or
+// retrieval timeout.
+func (f *TxFetcher) rescheduleFetch(fetch *time.Timer) {
+	// Short circuit if nothing is announced or in flight
+	if len(f.announced) == 0 && len(f.fetching) == 0 {
+		return
+	}
+	// Otherwise find the earliest expiring announcement or retrieval
+	var (
+		now      = time.Now()
+		earliest = now.Add(txFetchTimeout)
+	)
+	for hash, announces := range f.announced {
+		if _, ok := f.fetching[hash]; ok {
+			continue
+		}
+		if deadline := announces[0].time.Add(txArriveTimeout); deadline.Before(earliest) {
+			earliest = deadline
+		}
+	}
+	for _, announce := range f.fetching {
+		if deadline := announce.time.Add(txFetchTimeout + txGatherSlack); deadline.Before(earliest) {
+			earliest = deadline
+		}
+	}
+	fetch.Reset(earliest.Sub(now))
+}
+
+// announcedBy checks whether a transaction hash was already announced by the
+// given peer, either pending or currently being retrieved.
+func (f *TxFetcher) announcedBy(hash common.Hash, peer string) bool {
+	if announce := f.fetching[hash]; announce != nil && announce.origin == peer {
+		return true
+	}
+	for _, announce := range f.announced[hash] {
+		if announce.origin == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// release decrements the DOS counter of the peer originating an announcement.
+func (f *TxFetcher) release(announce *txAnnounce) {
+	f.announces[announce.origin]--
+	if f.announces[announce.origin] <= 0 {
+		delete(f.announces, announce.origin)
+	}
+}
+
+// forgetHash removes all traces of a transaction announcement from the
+// fetcher's internal state.
+func (f *TxFetcher) forgetHash(hash common.Hash) {
+	// Remove all pending announces and decrement DOS counters
+	if announces, ok := f.announced[hash]; ok {
+		for _, announce := range announces {
+			f.release(announce)
+		}
+		delete(f.announced, hash)
+		if f.announceChangeHook != nil {
+			f.announceChangeHook(hash, false)
+		}
+	}
+	// Remove any pending fetches and decrement the DOS counters
+	if announce := f.fetching[hash]; announce != nil {
+		f.release(announce)
+		delete(f.fetching, hash)
+	}
+}
@@ -17,6 +17,7 @@
 package filters
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -28,6 +29,7 @@ import (
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/common/hexutil"
 	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/internal/tiltapi"
 	"github.com/megatilt/go-tilt/tiltdb"
 	"github.com/megatilt/go-tilt/event"
 	"github.com/megatilt/go-tilt/rpc"
@@ -37,6 +39,10 @@ var (
 	deadline = 5 * time.Minute // consider a filter inactive if it has not been polled for within deadline
 )
 
+// pendingTxBuffer is the number of pending transactions buffered for a subscriber
+// before any further ones are dropped.
+const pendingTxBuffer = 4096
+
 // filter is a helper struct that holds meta information over the filter type
 // and associated subscription in the event system.
 type filter struct {
@@ -137,12 +143,20 @@ func (api *PublicFilterAPI) NewPendingTransactionFilter() rpc.ID {
 
 // NewPendingTransactions creates a subscription that is triggered each time a transaction
 // enters the transaction pool and was signed from one of the transactions this nodes manages.
-func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+//
+// If fullTx is true, the full transactions are delivered instead of their hashes. If
+// criteria are given, only the transactions matching them are delivered. In both of
+// these modes notifications are buffered per subscription, and subscribers unable to
+// keep up with the transaction pool miss the transactions arriving while their buffer
+// is full.
+func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context, fullTx *bool, crit *PendingTxCriteria) (*rpc.Subscription, error) {
 	notifier, supported := rpc.NotifierFromContext(ctx)
 	if !supported {
 		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
 	}
-
+	if fullTx != nil || crit != nil {
+		return api.newPendingTxBodies(notifier, fullTx != nil && *fullTx, crit), nil
+	}
 	rpcSub := notifier.CreateSubscription()
 
 	go func() {
@@ -166,6 +180,39 @@ func (api *PublicFilterAPI) NewPendingTransactions(ctx context.Context) (*rpc.Su
 	return rpcSub, nil
 }
 
+// newPendingTxBodies creates a subscription delivering the pending transactions
+// matching the criteria, either in full or only their hashes.
+func (api *PublicFilterAPI) newPendingTxBodies(notifier *rpc.Notifier, fullTx bool, crit *PendingTxCriteria) *rpc.Subscription {
+	if crit == nil {
+		crit = new(PendingTxCriteria)
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		txs := make(chan *types.Transaction, pendingTxBuffer)
+		pendingTxSub := api.events.SubscribePendingTxs(*crit, txs)
+
+		for {
+			select {
+			case tx := <-txs:
+				if fullTx {
+					notifier.Notify(rpcSub.ID, tiltapi.NewRPCPendingTransaction(tx))
+				} else {
+					notifier.Notify(rpcSub.ID, tx.Hash())
+				}
+			case <-rpcSub.Err():
+				pendingTxSub.Unsubscribe()
+				return
+			case <-notifier.Closed():
+				pendingTxSub.Unsubscribe()
+				return
+			}
+		}
+	}()
+
+	return rpcSub
+}
+
 // NewBlockFilter creates a filter that fetches blocks that are imported into the chain.
 // It is part of the filter package since polling goes with tilt_getFilterChanges.
 //
@@ -277,6 +324,44 @@ type FilterCriteria struct {
 	Topics    [][]common.Hash
 }
 
+// PendingTxCriteria restricts the pending transactions delivered by a subscription.
+// Transactions must match all the given fields, an empty field matches any.
+type PendingTxCriteria struct {
+	From      []common.Address `json:"from"`      // Senders of the transaction
+	To        []common.Address `json:"to"`        // Recipients of the transaction
+	Selectors []hexutil.Bytes  `json:"selectors"` // 4 byte method selectors prefixing the input
+}
+
+// matches checks whether the transaction satisfies the criteria.
+func (crit *PendingTxCriteria) matches(tx *types.Transaction) bool {
+	if len(crit.To) > 0 && (tx.To() == nil || !includes(crit.To, *tx.To())) {
+		return false
+	}
+	if len(crit.Selectors) > 0 {
+		var included bool
+		for _, selector := range crit.Selectors {
+			if len(selector) > 0 && bytes.HasPrefix(tx.Data(), selector) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	if len(crit.From) > 0 {
+		var signer types.Signer = types.HoldemSigner{}
+		if tx.Protected() {
+			signer = types.NewTiltSigner(tx.ChainId())
+		}
+		from, err := types.Sender(signer, tx)
+		if err != nil || !includes(crit.From, from) {
+			return false
+		}
+	}
+	return true
+}
+
 // NewFilter creates a new filter and returns the filter id. It can be
 // used to retrieve logs when the state changes. This method cannot be
 // used to fetch logs that are already stored in the state.
@@ -18,6 +18,7 @@ package filters
 
 import (
 	"context"
+	"errors"
 	"math"
 	"math/big"
 	"time"
@@ -96,13 +97,13 @@ func (f *Filter) FindOnce(ctx context.Context) ([]*types.Log, error) {
 	}
 	headBlockNumber := head.Number.Uint64()
 
-	var beginBlockNo uint64 = uint64(f.begin)
-	if f.begin == -1 {
-		beginBlockNo = headBlockNumber
+	beginBlockNo, err := f.resolveNumber(ctx, f.begin, headBlockNumber)
+	if err != nil {
+		return nil, err
 	}
-	var endBlockNo uint64 = uint64(f.end)
-	if f.end == -1 {
-		endBlockNo = headBlockNumber
+	endBlockNo, err := f.resolveNumber(ctx, f.end, headBlockNumber)
+	if err != nil {
+		return nil, err
 	}
 
 	// if no addresses are present we can't make use of fast search which
@@ -119,6 +120,25 @@ func (f *Filter) FindOnce(ctx context.Context) ([]*types.Log, error) {
 	return logs, nil
 }
 
+// resolveNumber converts a boundary of the filter range into a block number,
+// resolving the latest and safe block selectors against the chain.
+func (f *Filter) resolveNumber(ctx context.Context, number int64, head uint64) (uint64, error) {
+	switch rpc.BlockNumber(number) {
+	case rpc.LatestBlockNumber:
+		return head, nil
+	case rpc.SafeBlockNumber:
+		header, err := f.backend.HeaderByNumber(ctx, rpc.SafeBlockNumber)
+		if err != nil {
+			return 0, err
+		}
+		if header == nil {
+			return 0, errors.New("safe block not found")
+		}
+		return header.Number.Uint64(), nil
+	}
+	return uint64(number), nil
+}
+
 // Run filters logs with the current parameters set
 func (f *Filter) Find(ctx context.Context) (logs []*types.Log, err error) {
 	for {
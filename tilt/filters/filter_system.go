@@ -29,6 +29,8 @@ import (
 	"github.com/megatilt/go-tilt/core"
 	"github.com/megatilt/go-tilt/core/types"
 	"github.com/megatilt/go-tilt/event"
+	"github.com/megatilt/go-tilt/log"
+	"github.com/megatilt/go-tilt/metrics"
 	"github.com/megatilt/go-tilt/rpc"
 )
 
@@ -50,12 +52,19 @@ const (
 	PendingTransactionsSubscription
 	// BlocksSubscription queries hashes for blocks that are imported
 	BlocksSubscription
+	// PendingTxBodiesSubscription queries full pending transactions matching
+	// some criteria as they enter the pending state
+	PendingTxBodiesSubscription
 	// LastSubscription keeps track of the last index
 	LastIndexSubscription
 )
 
 var (
 	ErrInvalidSubscriptionID = errors.New("invalid id")
+
+	// droppedPendingTxMeter counts the pending transactions dropped because their
+	// subscriber couldn't keep up with the transaction pool.
+	droppedPendingTxMeter = metrics.NewMeter("tilt/filters/pendingtx/dropped")
 )
 
 type subscription struct {
@@ -66,8 +75,10 @@ type subscription struct {
 	logs      chan []*types.Log
 	hashes    chan common.Hash
 	headers   chan *types.Header
-	installed chan struct{} // closed when the filter is installed
-	err       chan error    // closed when the filter is uninstalled
+	txCrit    PendingTxCriteria
+	txs       chan *types.Transaction // buffered, overflowing transactions are dropped
+	installed chan struct{}           // closed when the filter is installed
+	err       chan error              // closed when the filter is uninstalled
 }
 
 // EventSystem creates subscriptions, processes events and broadcasts them to the
@@ -80,6 +91,7 @@ type EventSystem struct {
 	lastHead  *types.Header
 	install   chan *subscription // install filter for event notification
 	uninstall chan *subscription // remove filter for event notification
+	quit      chan struct{}      // closed when the event loop terminates
 }
 
 // NewEventSystem creates a new manager that listens for event on the given mux,
@@ -95,13 +107,21 @@ func NewEventSystem(mux *event.TypeMux, backend Backend, lightMode bool) *EventS
 		lightMode: lightMode,
 		install:   make(chan *subscription),
 		uninstall: make(chan *subscription),
+		quit:      make(chan struct{}),
 	}
+	m.sub = mux.Subscribe(core.PendingLogsEvent{}, core.RemovedLogsEvent{}, []*types.Log{}, core.TxPreEvent{}, core.ChainEvent{})
 
 	go m.eventLoop()
 
 	return m
 }
 
+// Stop terminates the event loop, ending all the subscriptions still installed.
+func (es *EventSystem) Stop() {
+	es.sub.Unsubscribe()
+	<-es.quit
+}
+
 // Subscription is created when the client registers itself for a particular event.
 type Subscription struct {
 	ID        rpc.ID
@@ -127,9 +147,12 @@ func (sub *Subscription) Unsubscribe() {
 			select {
 			case sub.es.uninstall <- sub.f:
 				break uninstallLoop
+			case <-sub.es.quit:
+				break uninstallLoop
 			case <-sub.f.logs:
 			case <-sub.f.hashes:
 			case <-sub.f.headers:
+			case <-sub.f.txs:
 			}
 		}
 
@@ -141,9 +164,14 @@ func (sub *Subscription) Unsubscribe() {
 }
 
 // subscribe installs the subscription in the event broadcast loop.
+// If the event system is already stopped, the subscription is ended right away.
 func (es *EventSystem) subscribe(sub *subscription) *Subscription {
-	es.install <- sub
-	<-sub.installed
+	select {
+	case es.install <- sub:
+		<-sub.installed
+	case <-es.quit:
+		close(sub.err)
+	}
 	return &Subscription{ID: sub.id, f: sub, es: es}
 }
 
@@ -162,6 +190,10 @@ func (es *EventSystem) SubscribeLogs(crit FilterCriteria, logs chan []*types.Log
 	} else {
 		to = rpc.BlockNumber(crit.ToBlock.Int64())
 	}
+	// the safe block lags behind the head, so there are no new logs to stream
+	if from == rpc.SafeBlockNumber || to == rpc.SafeBlockNumber {
+		return nil, fmt.Errorf("safe block selector not supported for log subscriptions")
+	}
 
 	// only interested in pending logs
 	if from == rpc.PendingBlockNumber && to == rpc.PendingBlockNumber {
@@ -274,6 +306,27 @@ func (es *EventSystem) SubscribePendingTxEvents(hashes chan common.Hash) *Subscr
 	return es.subscribe(sub)
 }
 
+// SubscribePendingTxs creates a subscription that writes the transactions entering
+// the transaction pool that match the given criteria to the given channel. As the
+// transactions are delivered without blocking the event loop, the channel should be
+// buffered: transactions arriving while it is full are dropped.
+func (es *EventSystem) SubscribePendingTxs(crit PendingTxCriteria, txs chan *types.Transaction) *Subscription {
+	sub := &subscription{
+		id:        rpc.NewID(),
+		typ:       PendingTxBodiesSubscription,
+		created:   time.Now(),
+		logs:      make(chan []*types.Log),
+		hashes:    make(chan common.Hash),
+		headers:   make(chan *types.Header),
+		txCrit:    crit,
+		txs:       txs,
+		installed: make(chan struct{}),
+		err:       make(chan error),
+	}
+
+	return es.subscribe(sub)
+}
+
 type filterIndex map[Type]map[rpc.ID]*subscription
 
 // broadcast event to filters that match criteria.
@@ -315,6 +368,16 @@ func (es *EventSystem) broadcast(filters filterIndex, ev *event.TypeMuxEvent) {
 				f.hashes <- e.Tx.Hash()
 			}
 		}
+		for _, f := range filters[PendingTxBodiesSubscription] {
+			if ev.Time.After(f.created) && f.txCrit.matches(e.Tx) {
+				select {
+				case f.txs <- e.Tx:
+				default:
+					droppedPendingTxMeter.Mark(1)
+					log.Debug("Dropping pending transaction for slow subscriber", "id", f.id, "hash", e.Tx.Hash())
+				}
+			}
+		}
 	case core.ChainEvent:
 		for _, f := range filters[BlocksSubscription] {
 			if ev.Time.After(f.created) {
@@ -394,10 +457,20 @@ func (es *EventSystem) lightFilterLogs(header *types.Header, addresses []common.
 
 // eventLoop (un)installs filters and processes mux events.
 func (es *EventSystem) eventLoop() {
-	var (
-		index = make(filterIndex)
-		sub   = es.mux.Subscribe(core.PendingLogsEvent{}, core.RemovedLogsEvent{}, []*types.Log{}, core.TxPreEvent{}, core.ChainEvent{})
-	)
+	index := make(filterIndex)
+	defer func() {
+		// End all the remaining subscriptions, some are indexed more than once
+		ended := make(map[rpc.ID]bool)
+		for _, subs := range index {
+			for id, f := range subs {
+				if !ended[id] {
+					ended[id] = true
+					close(f.err)
+				}
+			}
+		}
+		close(es.quit)
+	}()
 
 	for i := UnknownSubscription; i < LastIndexSubscription; i++ {
 		index[i] = make(map[rpc.ID]*subscription)
@@ -405,7 +478,7 @@ func (es *EventSystem) eventLoop() {
 
 	for {
 		select {
-		case ev, active := <-sub.Chan():
+		case ev, active := <-es.sub.Chan():
 			if !active { // system stopped
 				return
 			}
@@ -4,10 +4,12 @@ package tilt
 
 import (
 	"math/big"
+	"time"
 
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/common/hexutil"
 	"github.com/megatilt/go-tilt/core"
+	"github.com/megatilt/go-tilt/params"
 	"github.com/megatilt/go-tilt/tilt/downloader"
 	"github.com/megatilt/go-tilt/tilt/gasprice"
 )
@@ -17,7 +19,9 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		Genesis                 *core.Genesis `toml:",omitempty"`
 		NetworkId               uint64
 		SyncMode                downloader.SyncMode
+		Checkpoint              *params.TrustedCheckpoint `toml:",omitempty"`
 		MaxPeers                int  `toml:"-"`
+		SafeBlockDepth          uint64
 		SkipBcVersionCheck      bool `toml:"-"`
 		DatabaseHandles         int  `toml:"-"`
 		DatabaseCache           int
@@ -33,6 +37,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		TilthashDatasetsOnDisk    int
 		GPO                     gasprice.Config
 		EnablePreimageRecording bool
+		HealthMaxHeadAge        time.Duration `toml:",omitempty"`
 		DocRoot                 string `toml:"-"`
 		PowFake                 bool   `toml:"-"`
 		PowTest                 bool   `toml:"-"`
@@ -42,7 +47,9 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.Genesis = c.Genesis
 	enc.NetworkId = c.NetworkId
 	enc.SyncMode = c.SyncMode
+	enc.Checkpoint = c.Checkpoint
 	enc.MaxPeers = c.MaxPeers
+	enc.SafeBlockDepth = c.SafeBlockDepth
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
@@ -58,6 +65,7 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.TilthashDatasetsOnDisk = c.TilthashDatasetsOnDisk
 	enc.GPO = c.GPO
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
+	enc.HealthMaxHeadAge = c.HealthMaxHeadAge
 	enc.DocRoot = c.DocRoot
 	enc.PowFake = c.PowFake
 	enc.PowTest = c.PowTest
@@ -70,7 +78,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		Genesis                 *core.Genesis `toml:",omitempty"`
 		NetworkId               *uint64
 		SyncMode                *downloader.SyncMode
+		Checkpoint              *params.TrustedCheckpoint `toml:",omitempty"`
 		MaxPeers                *int  `toml:"-"`
+		SafeBlockDepth          *uint64
 		SkipBcVersionCheck      *bool `toml:"-"`
 		DatabaseHandles         *int  `toml:"-"`
 		DatabaseCache           *int
@@ -86,6 +96,7 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		TilthashDatasetsOnDisk    *int
 		GPO                     *gasprice.Config
 		EnablePreimageRecording *bool
+		HealthMaxHeadAge        *time.Duration `toml:",omitempty"`
 		DocRoot                 *string `toml:"-"`
 		PowFake                 *bool   `toml:"-"`
 		PowTest                 *bool   `toml:"-"`
@@ -104,9 +115,15 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.SyncMode != nil {
 		c.SyncMode = *dec.SyncMode
 	}
+	if dec.Checkpoint != nil {
+		c.Checkpoint = dec.Checkpoint
+	}
 	if dec.MaxPeers != nil {
 		c.MaxPeers = *dec.MaxPeers
 	}
+	if dec.SafeBlockDepth != nil {
+		c.SafeBlockDepth = *dec.SafeBlockDepth
+	}
 	if dec.SkipBcVersionCheck != nil {
 		c.SkipBcVersionCheck = *dec.SkipBcVersionCheck
 	}
@@ -152,6 +169,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.EnablePreimageRecording != nil {
 		c.EnablePreimageRecording = *dec.EnablePreimageRecording
 	}
+	if dec.HealthMaxHeadAge != nil {
+		c.HealthMaxHeadAge = *dec.HealthMaxHeadAge
+	}
 	if dec.DocRoot != nil {
 		c.DocRoot = *dec.DocRoot
 	}
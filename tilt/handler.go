@@ -29,6 +29,7 @@ import (
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/consensus"
 	"github.com/megatilt/go-tilt/core"
+	"github.com/megatilt/go-tilt/core/forkid"
 	"github.com/megatilt/go-tilt/core/types"
 	"github.com/megatilt/go-tilt/event"
 	"github.com/megatilt/go-tilt/log"
@@ -38,6 +39,7 @@ import (
 	"github.com/megatilt/go-tilt/rlp"
 	"github.com/megatilt/go-tilt/tilt/downloader"
 	"github.com/megatilt/go-tilt/tilt/fetcher"
+	"github.com/megatilt/go-tilt/tilt/snap"
 	"github.com/megatilt/go-tilt/tiltdb"
 )
 
@@ -61,16 +63,20 @@ func errResp(code errCode, format string, v ...interface{}) error {
 type ProtocolManager struct {
 	networkId uint64
 
+	snapSync  uint32 // Flag whether snap sync is enabled (gets disabled if we already have blocks)
 	acceptTxs uint32 // Flag whether we're considered synchronised (enables transaction processing)
+	seenPeer  uint32 // Flag whether any peer completed the handshake (sync progress is meaningless before)
 
 	txpool      txPool
 	blockchain  *core.BlockChain
 	chaindb     tiltdb.Database
 	chainconfig *params.ChainConfig
+	forkFilter  forkid.Filter // Fork ID filter, constant across the lifetime of the node
 	maxPeers    int
 
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
+	txFetcher  *fetcher.TxFetcher
 	peers      *peerSet
 
 	SubProtocols []p2p.Protocol
@@ -94,7 +100,7 @@ type ProtocolManager struct {
 
 // NewProtocolManager returns a new tiltnet sub protocol manager. The Tiltnet sub protocol manages peers capable
 // with the tiltnet network.
-func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, networkId uint64, maxPeers int, mux *event.TypeMux, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb tiltdb.Database) (*ProtocolManager, error) {
+func NewProtocolManager(config *params.ChainConfig, checkpoint *params.TrustedCheckpoint, mode downloader.SyncMode, networkId uint64, maxPeers int, mux *event.TypeMux, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb tiltdb.Database) (*ProtocolManager, error) {
 	// Create the protocol manager with the base fields
 	manager := &ProtocolManager{
 		networkId:   networkId,
@@ -103,6 +109,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		blockchain:  blockchain,
 		chaindb:     chaindb,
 		chainconfig: config,
+		forkFilter:  forkid.NewFilter(blockchain),
 		maxPeers:    maxPeers,
 		peers:       newPeerSet(),
 		newPeerCh:   make(chan *peer),
@@ -110,7 +117,15 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		txsyncCh:    make(chan *txsync),
 		quitSync:    make(chan struct{}),
 	}
-	mode = downloader.FullSync
+	// Figure out whether to allow snap sync or not
+	if mode == downloader.SnapSync {
+		if blockchain.CurrentBlock().NumberU64() > 0 {
+			log.Warn("Blockchain not empty, snap sync disabled")
+			mode = downloader.FullSync
+		} else {
+			manager.snapSync = uint32(1)
+		}
+	}
 	// Initiate a sub-protocol for every implemented version we can handle
 	manager.SubProtocols = make([]p2p.Protocol, 0, len(ProtocolVersions))
 	for i, version := range ProtocolVersions {
@@ -146,11 +161,14 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		return nil, errIncompatibleConfig
 	}
 	// Construct the different synchronisation mechanisms
-	manager.downloader = downloader.New(mode, chaindb, manager.eventMux, blockchain.HasHeader, blockchain.HasBlockAndState, blockchain.GetHeaderByHash,
+	manager.downloader = downloader.New(mode, checkpoint, chaindb, manager.eventMux, blockchain.HasHeader, blockchain.HasBlockAndState, blockchain.GetHeaderByHash,
 		blockchain.GetBlockByHash, blockchain.CurrentHeader, blockchain.CurrentBlock, blockchain.CurrentFastBlock, blockchain.FastSyncCommitHead,
 		blockchain.GetTdByHash, blockchain.InsertHeaderChain, manager.blockchain.InsertChain, blockchain.InsertReceiptChain, blockchain.Rollback,
 		manager.removePeer)
 
+	// Serve the local state to snap syncing peers and feed their replies to our own syncer
+	manager.SubProtocols = append(manager.SubProtocols, snap.MakeProtocols(chaindb, manager.downloader.SnapSyncer)...)
+
 	validator := func(header *types.Header) error {
 		return engine.VerifyHeader(blockchain, header, true)
 	}
@@ -163,6 +181,11 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 	}
 	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.removePeer)
 
+	hasTx := func(hash common.Hash) bool {
+		return manager.txpool.Get(hash) != nil
+	}
+	manager.txFetcher = fetcher.NewTxFetcher(hasTx, manager.txpool.AddBatch)
+
 	return manager, nil
 }
 
@@ -176,6 +199,7 @@ func (pm *ProtocolManager) removePeer(id string) {
 
 	// Unregister the peer from the downloader and Tiltnet peer set
 	pm.downloader.UnregisterPeer(id)
+	pm.txFetcher.Drop(id)
 	if err := pm.peers.Unregister(id); err != nil {
 		log.Error("Peer removal failed", "peer", id, "err", err)
 	}
@@ -237,7 +261,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 
 	// Execute the Tiltnet handshake
 	td, head, genesis := pm.blockchain.Status()
-	if err := p.Handshake(pm.networkId, td, head, genesis); err != nil {
+	if err := p.Handshake(pm.networkId, td, head, genesis, forkid.NewID(pm.blockchain), pm.forkFilter); err != nil {
 		p.Log().Debug("Tiltnet handshake failed", "err", err)
 		return err
 	}
@@ -250,6 +274,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		return err
 	}
 	defer pm.removePeer(p.id)
+	atomic.StoreUint32(&pm.seenPeer, 1)
 
 	// Register the peer in the downloader. If the downloader considers it banned, we disconnect
 	if err := pm.downloader.RegisterPeer(p.id, p.version, p.Head, p.RequestHeadersByHash, p.RequestHeadersByNumber, p.RequestBodies, p.RequestReceipts, p.RequestNodeData); err != nil {
@@ -290,8 +315,17 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	// Block header query, collect the requested headers and reply
 	case msg.Code == GetBlockHeadersMsg:
 		// Decode the complex header query
-		var query getBlockHeadersData
-		if err := msg.Decode(&query); err != nil {
+		var (
+			query getBlockHeadersData
+			reqId uint64
+		)
+		if p.version >= eth65 {
+			packet := getBlockHeadersPacket{Query: &query}
+			if err := msg.Decode(&packet); err != nil {
+				return errResp(ErrDecode, "%v: %v", msg, err)
+			}
+			reqId = packet.RequestId
+		} else if err := msg.Decode(&query); err != nil {
 			return errResp(ErrDecode, "%v: %v", msg, err)
 		}
 		hashMode := query.Origin.Hash != (common.Hash{})
@@ -364,7 +398,20 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				query.Origin.Number += (query.Skip + 1)
 			}
 		}
-		return p.SendBlockHeaders(headers)
+		return p.SendBlockHeaders(reqId, headers)
+
+	case p.version >= eth65 && msg.Code == BlockHeadersMsg:
+		// A batch of headers arrived to one of our previous tagged requests
+		var packet blockHeadersPacket
+		if err := msg.Decode(&packet); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Route the headers to whichever of the fetcher or downloader requested them
+		if !pm.fetcher.DeliverHeaders(p.id, packet.RequestId, packet.Headers, time.Now()) {
+			if err := pm.downloader.DeliverHeaders(p.id, packet.RequestId, packet.Headers); err != nil {
+				log.Debug("Failed to deliver headers", "err", err)
+			}
+		}
 
 	case msg.Code == BlockHeadersMsg:
 		// A batch of headers arrived to one of our previous requests
@@ -377,10 +424,10 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		filter := len(headers) == 1
 		if filter {
 			// Irrelevant of the fork checks, send the header to the fetcher just in case
-			headers = pm.fetcher.FilterHeaders(headers, time.Now())
+			headers = pm.fetcher.FilterHeaders(p.id, headers, time.Now())
 		}
 		if len(headers) > 0 || !filter {
-			err := pm.downloader.DeliverHeaders(p.id, headers)
+			err := pm.downloader.DeliverHeaders(p.id, 0, headers)
 			if err != nil {
 				log.Debug("Failed to deliver headers", "err", err)
 			}
@@ -388,8 +435,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	case msg.Code == GetBlockBodiesMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		msgStream, reqId, err := openHashRequest(p, msg)
+		if err != nil {
 			return err
 		}
 		// Gather blocks until the fetch or network limits is reached
@@ -411,12 +458,21 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(data)
 			}
 		}
-		return p.SendBlockBodiesRLP(bodies)
+		return p.SendBlockBodiesRLP(reqId, bodies)
 
 	case msg.Code == BlockBodiesMsg:
 		// A batch of block bodies arrived to one of our previous requests
-		var request blockBodiesData
-		if err := msg.Decode(&request); err != nil {
+		var (
+			request blockBodiesData
+			reqId   uint64
+		)
+		if p.version >= eth65 {
+			var packet blockBodiesPacket
+			if err := msg.Decode(&packet); err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			request, reqId = packet.Bodies, packet.RequestId
+		} else if err := msg.Decode(&request); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		// Deliver them all to the downloader for queuing
@@ -427,13 +483,22 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			trasactions[i] = body.Transactions
 			uncles[i] = body.Uncles
 		}
+		// Tagged replies go wholesale to whichever of the fetcher or downloader requested them
+		if reqId != 0 {
+			if !pm.fetcher.DeliverBodies(p.id, reqId, trasactions, uncles, time.Now()) {
+				if err := pm.downloader.DeliverBodies(p.id, reqId, trasactions, uncles); err != nil {
+					log.Debug("Failed to deliver bodies", "err", err)
+				}
+			}
+			break
+		}
 		// Filter out any explicitly requested bodies, deliver the rest to the downloader
 		filter := len(trasactions) > 0 || len(uncles) > 0
 		if filter {
-			trasactions, uncles = pm.fetcher.FilterBodies(trasactions, uncles, time.Now())
+			trasactions, uncles = pm.fetcher.FilterBodies(p.id, trasactions, uncles, time.Now())
 		}
 		if len(trasactions) > 0 || len(uncles) > 0 || !filter {
-			err := pm.downloader.DeliverBodies(p.id, trasactions, uncles)
+			err := pm.downloader.DeliverBodies(p.id, 0, trasactions, uncles)
 			if err != nil {
 				log.Debug("Failed to deliver bodies", "err", err)
 			}
@@ -441,8 +506,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	case p.version >= eth63 && msg.Code == GetNodeDataMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		msgStream, reqId, err := openHashRequest(p, msg)
+		if err != nil {
 			return err
 		}
 		// Gather state data until the fetch or network limits is reached
@@ -464,23 +529,32 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(entry)
 			}
 		}
-		return p.SendNodeData(data)
+		return p.SendNodeData(reqId, data)
 
 	case p.version >= eth63 && msg.Code == NodeDataMsg:
 		// A batch of node state data arrived to one of our previous requests
-		var data [][]byte
-		if err := msg.Decode(&data); err != nil {
+		var (
+			data  [][]byte
+			reqId uint64
+		)
+		if p.version >= eth65 {
+			var packet nodeDataPacket
+			if err := msg.Decode(&packet); err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			data, reqId = packet.Data, packet.RequestId
+		} else if err := msg.Decode(&data); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverNodeData(p.id, data); err != nil {
+		if err := pm.downloader.DeliverNodeData(p.id, reqId, data); err != nil {
 			log.Debug("Failed to deliver node state data", "err", err)
 		}
 
 	case p.version >= eth63 && msg.Code == GetReceiptsMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		msgStream, reqId, err := openHashRequest(p, msg)
+		if err != nil {
 			return err
 		}
 		// Gather state data until the fetch or network limits is reached
@@ -511,16 +585,25 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		return p.SendReceiptsRLP(receipts)
+		return p.SendReceiptsRLP(reqId, receipts)
 
 	case p.version >= eth63 && msg.Code == ReceiptsMsg:
 		// A batch of receipts arrived to one of our previous requests
-		var receipts [][]*types.Receipt
-		if err := msg.Decode(&receipts); err != nil {
+		var (
+			receipts [][]*types.Receipt
+			reqId    uint64
+		)
+		if p.version >= eth65 {
+			var packet receiptsPacket
+			if err := msg.Decode(&packet); err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			receipts, reqId = packet.Receipts, packet.RequestId
+		} else if err := msg.Decode(&receipts); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverReceipts(p.id, receipts); err != nil {
+		if err := pm.downloader.DeliverReceipts(p.id, reqId, receipts); err != nil {
 			log.Debug("Failed to deliver receipts", "err", err)
 		}
 
@@ -593,7 +676,82 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 			p.MarkTransaction(tx.Hash())
 		}
-		pm.txpool.AddBatch(txs)
+		pm.txFetcher.Enqueue(p.id, txs)
+
+	case p.version >= eth64 && msg.Code == NewPooledTransactionHashesMsg:
+		// New transaction announcements arrived, make sure we have a valid and fresh chain to handle them
+		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
+			break
+		}
+		var hashes []common.Hash
+		if err := msg.Decode(&hashes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Mark the hashes as present at the remote node and schedule the unknown ones
+		for _, hash := range hashes {
+			p.MarkTransaction(hash)
+		}
+		pm.txFetcher.Notify(p.id, hashes, time.Now(), p.RequestTxs)
+
+	case p.version >= eth64 && msg.Code == GetPooledTransactionsMsg:
+		// Decode the retrieval message
+		msgStream, reqId, err := openHashRequest(p, msg)
+		if err != nil {
+			return err
+		}
+		// Gather transactions until the fetch or network limits is reached
+		var (
+			hash   common.Hash
+			bytes  int
+			hashes []common.Hash
+			txs    []rlp.RawValue
+		)
+		for bytes < softResponseLimit && len(txs) < fetcher.MaxTxFetch {
+			// Retrieve the hash of the next transaction
+			if err := msgStream.Decode(&hash); err == rlp.EOL {
+				break
+			} else if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// Retrieve the requested transaction, skipping if unknown to us
+			tx := pm.txpool.Get(hash)
+			if tx == nil {
+				continue
+			}
+			// If known, encode and queue for response packet
+			if encoded, err := rlp.EncodeToBytes(tx); err != nil {
+				log.Error("Failed to encode transaction", "err", err)
+			} else {
+				hashes = append(hashes, hash)
+				txs = append(txs, encoded)
+				bytes += len(encoded)
+			}
+		}
+		return p.SendPooledTransactionsRLP(reqId, hashes, txs)
+
+	case p.version >= eth64 && msg.Code == PooledTransactionsMsg:
+		// Requested transactions arrived, make sure we have a valid and fresh chain to handle them
+		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
+			break
+		}
+		var txs []*types.Transaction
+		if p.version >= eth65 {
+			var packet pooledTransactionsPacket
+			if err := msg.Decode(&packet); err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			txs = packet.Transactions
+		} else if err := msg.Decode(&txs); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		for i, tx := range txs {
+			// Validate and mark the remote transaction
+			if tx == nil {
+				return errResp(ErrDecode, "transaction %d is nil", i)
+			}
+			p.MarkTransaction(tx.Hash())
+		}
+		pm.txFetcher.Enqueue(p.id, txs)
 
 	default:
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
@@ -601,6 +759,26 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	return nil
 }
 
+// openHashRequest opens the stream of a hash based retrieval request, returning
+// the request identifier it was tagged with (zero for peers prior to tilt/65).
+func openHashRequest(p *peer, msg p2p.Msg) (*rlp.Stream, uint64, error) {
+	stream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+	if _, err := stream.List(); err != nil {
+		return nil, 0, err
+	}
+	if p.version < eth65 {
+		return stream, 0, nil
+	}
+	id, err := stream.Uint()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := stream.List(); err != nil {
+		return nil, 0, err
+	}
+	return stream, id, nil
+}
+
 // BroadcastBlock will either propagate a block to a subset of it's peers, or
 // will only announce it's availability (depending what's requested).
 func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
@@ -634,15 +812,27 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 }
 
 // BroadcastTx will propagate a transaction to all peers which are not known to
-// already have the given transaction.
+// already have the given transaction. The full transaction is only sent to a
+// subset of the peers (and every legacy one not supporting announcements), the
+// rest are only notified of its hash and may retrieve it on demand.
 func (pm *ProtocolManager) BroadcastTx(hash common.Hash, tx *types.Transaction) {
 	// Broadcast transaction to a batch of peers not knowing about it
-	peers := pm.peers.PeersWithoutTx(hash)
-	//FIXME include this again: peers = peers[:int(math.Sqrt(float64(len(peers))))]
+	var (
+		peers    = pm.peers.PeersWithoutTx(hash)
+		transfer = int(math.Sqrt(float64(len(peers))))
+		direct   int
+		announce int
+	)
 	for _, peer := range peers {
-		peer.SendTransactions(types.Transactions{tx})
+		if peer.version < eth64 || direct < transfer {
+			peer.SendTransactions(types.Transactions{tx})
+			direct++
+			continue
+		}
+		peer.SendPooledTransactionHashes([]common.Hash{hash})
+		announce++
 	}
-	log.Trace("Broadcast transaction", "hash", hash, "recipients", len(peers))
+	log.Trace("Broadcast transaction", "hash", hash, "recipients", direct, "announced", announce)
 }
 
 // Mined broadcast loop
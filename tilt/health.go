@@ -0,0 +1,74 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package tilt
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core"
+	"github.com/megatilt/go-tilt/node"
+)
+
+// HealthChecks implements node.HealthService, returning the checks reporting the
+// state of the chain database, the synchronisation and the chain head.
+func (s *Tiltnet) HealthChecks() []node.HealthCheck {
+	checks := []node.HealthCheck{
+		{Name: "db", Check: s.checkDatabase},
+		{Name: "sync", Readiness: true, Check: s.checkSync},
+	}
+	if s.maxHeadAge > 0 {
+		checks = append(checks, node.HealthCheck{Name: "head", Readiness: true, Check: s.checkHeadAge})
+	}
+	return checks
+}
+
+// checkDatabase verifies that the chain database is reachable by looking up the
+// hash of the current head block.
+func (s *Tiltnet) checkDatabase() error {
+	if core.GetHeadBlockHash(s.chainDb) == (common.Hash{}) {
+		return errors.New("head block not found in database")
+	}
+	return nil
+}
+
+// checkSync verifies that the node isn't lagging behind the chain of its peers.
+// Until a peer is seen the height of the chain is unknown, so the node is not
+// considered in sync.
+func (s *Tiltnet) checkSync() error {
+	if atomic.LoadUint32(&s.protocolManager.seenPeer) == 0 {
+		return errors.New("no peers seen yet")
+	}
+	progress := s.Downloader().Progress()
+	if progress.CurrentBlock < progress.HighestBlock {
+		return fmt.Errorf("syncing (%d/%d)", progress.CurrentBlock, progress.HighestBlock)
+	}
+	return nil
+}
+
+// checkHeadAge verifies that the current head block is recent enough.
+func (s *Tiltnet) checkHeadAge() error {
+	head := s.blockchain.CurrentBlock()
+	age := time.Since(time.Unix(head.Time().Int64(), 0))
+	if age > s.maxHeadAge {
+		return fmt.Errorf("head #%d too old (%v>%v)", head.NumberU64(), common.PrettyDuration(age), s.maxHeadAge)
+	}
+	return nil
+}
@@ -26,6 +26,10 @@ var (
 	propTxnInTrafficMeter     = metrics.NewMeter("tilt/prop/txns/in/traffic")
 	propTxnOutPacketsMeter    = metrics.NewMeter("tilt/prop/txns/out/packets")
 	propTxnOutTrafficMeter    = metrics.NewMeter("tilt/prop/txns/out/traffic")
+	propTxnAnnInPacketsMeter  = metrics.NewMeter("tilt/prop/txhashes/in/packets")
+	propTxnAnnInTrafficMeter  = metrics.NewMeter("tilt/prop/txhashes/in/traffic")
+	propTxnAnnOutPacketsMeter = metrics.NewMeter("tilt/prop/txhashes/out/packets")
+	propTxnAnnOutTrafficMeter = metrics.NewMeter("tilt/prop/txhashes/out/traffic")
 	propHashInPacketsMeter    = metrics.NewMeter("tilt/prop/hashes/in/packets")
 	propHashInTrafficMeter    = metrics.NewMeter("tilt/prop/hashes/in/traffic")
 	propHashOutPacketsMeter   = metrics.NewMeter("tilt/prop/hashes/out/packets")
@@ -50,6 +54,10 @@ var (
 	reqReceiptInTrafficMeter  = metrics.NewMeter("tilt/req/receipts/in/traffic")
 	reqReceiptOutPacketsMeter = metrics.NewMeter("tilt/req/receipts/out/packets")
 	reqReceiptOutTrafficMeter = metrics.NewMeter("tilt/req/receipts/out/traffic")
+	reqTxnInPacketsMeter      = metrics.NewMeter("tilt/req/txns/in/packets")
+	reqTxnInTrafficMeter      = metrics.NewMeter("tilt/req/txns/in/traffic")
+	reqTxnOutPacketsMeter     = metrics.NewMeter("tilt/req/txns/out/packets")
+	reqTxnOutTrafficMeter     = metrics.NewMeter("tilt/req/txns/out/traffic")
 	miscInPacketsMeter        = metrics.NewMeter("tilt/misc/in/packets")
 	miscInTrafficMeter        = metrics.NewMeter("tilt/misc/in/traffic")
 	miscOutPacketsMeter       = metrics.NewMeter("tilt/misc/out/packets")
@@ -96,6 +104,8 @@ func (rw *meteredMsgReadWriter) ReadMsg() (p2p.Msg, error) {
 		packets, traffic = reqStateInPacketsMeter, reqStateInTrafficMeter
 	case rw.version >= eth63 && msg.Code == ReceiptsMsg:
 		packets, traffic = reqReceiptInPacketsMeter, reqReceiptInTrafficMeter
+	case rw.version >= eth64 && msg.Code == PooledTransactionsMsg:
+		packets, traffic = reqTxnInPacketsMeter, reqTxnInTrafficMeter
 
 	case msg.Code == NewBlockHashesMsg:
 		packets, traffic = propHashInPacketsMeter, propHashInTrafficMeter
@@ -103,6 +113,8 @@ func (rw *meteredMsgReadWriter) ReadMsg() (p2p.Msg, error) {
 		packets, traffic = propBlockInPacketsMeter, propBlockInTrafficMeter
 	case msg.Code == TxMsg:
 		packets, traffic = propTxnInPacketsMeter, propTxnInTrafficMeter
+	case rw.version >= eth64 && msg.Code == NewPooledTransactionHashesMsg:
+		packets, traffic = propTxnAnnInPacketsMeter, propTxnAnnInTrafficMeter
 	}
 	packets.Mark(1)
 	traffic.Mark(int64(msg.Size))
@@ -123,6 +135,8 @@ func (rw *meteredMsgReadWriter) WriteMsg(msg p2p.Msg) error {
 		packets, traffic = reqStateOutPacketsMeter, reqStateOutTrafficMeter
 	case rw.version >= eth63 && msg.Code == ReceiptsMsg:
 		packets, traffic = reqReceiptOutPacketsMeter, reqReceiptOutTrafficMeter
+	case rw.version >= eth64 && msg.Code == PooledTransactionsMsg:
+		packets, traffic = reqTxnOutPacketsMeter, reqTxnOutTrafficMeter
 
 	case msg.Code == NewBlockHashesMsg:
 		packets, traffic = propHashOutPacketsMeter, propHashOutTrafficMeter
@@ -130,6 +144,8 @@ func (rw *meteredMsgReadWriter) WriteMsg(msg p2p.Msg) error {
 		packets, traffic = propBlockOutPacketsMeter, propBlockOutTrafficMeter
 	case msg.Code == TxMsg:
 		packets, traffic = propTxnOutPacketsMeter, propTxnOutTrafficMeter
+	case rw.version >= eth64 && msg.Code == NewPooledTransactionHashesMsg:
+		packets, traffic = propTxnAnnOutPacketsMeter, propTxnAnnOutTrafficMeter
 	}
 	packets.Mark(1)
 	traffic.Mark(int64(msg.Size))
@@ -20,10 +20,12 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core/forkid"
 	"github.com/megatilt/go-tilt/core/types"
 	"github.com/megatilt/go-tilt/p2p"
 	"github.com/megatilt/go-tilt/rlp"
@@ -139,6 +141,25 @@ func (p *peer) SendTransactions(txs types.Transactions) error {
 	return p2p.Send(p.rw, TxMsg, txs)
 }
 
+// SendPooledTransactionHashes announces the availability of a batch of
+// transactions through a hash notification, and includes the hashes in the
+// peer's transaction hash set for future reference.
+func (p *peer) SendPooledTransactionHashes(hashes []common.Hash) error {
+	for _, hash := range hashes {
+		p.knownTxs.Add(hash)
+	}
+	return p2p.Send(p.rw, NewPooledTransactionHashesMsg, hashes)
+}
+
+// SendPooledTransactionsRLP sends a batch of explicitly requested transactions
+// to the peer from an already RLP encoded format.
+func (p *peer) SendPooledTransactionsRLP(id uint64, hashes []common.Hash, txs []rlp.RawValue) error {
+	for _, hash := range hashes {
+		p.knownTxs.Add(hash)
+	}
+	return p.sendTagged(PooledTransactionsMsg, id, txs)
+}
+
 // SendNewBlockHashes announces the availability of a number of blocks through
 // a hash notification.
 func (p *peer) SendNewBlockHashes(hashes []common.Hash, numbers []uint64) error {
@@ -160,82 +181,112 @@ func (p *peer) SendNewBlock(block *types.Block, td *big.Int) error {
 }
 
 // SendBlockHeaders sends a batch of block headers to the remote peer.
-func (p *peer) SendBlockHeaders(headers []*types.Header) error {
-	return p2p.Send(p.rw, BlockHeadersMsg, headers)
+func (p *peer) SendBlockHeaders(id uint64, headers []*types.Header) error {
+	return p.sendTagged(BlockHeadersMsg, id, headers)
 }
 
 // SendBlockBodies sends a batch of block contents to the remote peer.
-func (p *peer) SendBlockBodies(bodies []*blockBody) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, blockBodiesData(bodies))
+func (p *peer) SendBlockBodies(id uint64, bodies []*blockBody) error {
+	return p.sendTagged(BlockBodiesMsg, id, blockBodiesData(bodies))
 }
 
 // SendBlockBodiesRLP sends a batch of block contents to the remote peer from
 // an already RLP encoded format.
-func (p *peer) SendBlockBodiesRLP(bodies []rlp.RawValue) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, bodies)
+func (p *peer) SendBlockBodiesRLP(id uint64, bodies []rlp.RawValue) error {
+	return p.sendTagged(BlockBodiesMsg, id, bodies)
 }
 
 // SendNodeDataRLP sends a batch of arbitrary internal data, corresponding to the
 // hashes requested.
-func (p *peer) SendNodeData(data [][]byte) error {
-	return p2p.Send(p.rw, NodeDataMsg, data)
+func (p *peer) SendNodeData(id uint64, data [][]byte) error {
+	return p.sendTagged(NodeDataMsg, id, data)
 }
 
 // SendReceiptsRLP sends a batch of transaction receipts, corresponding to the
 // ones requested from an already RLP encoded format.
-func (p *peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
-	return p2p.Send(p.rw, ReceiptsMsg, receipts)
+func (p *peer) SendReceiptsRLP(id uint64, receipts []rlp.RawValue) error {
+	return p.sendTagged(ReceiptsMsg, id, receipts)
 }
 
 // RequestOneHeader is a wrapper around the header query functions to fetch a
 // single header. It is used solely by the fetcher.
-func (p *peer) RequestOneHeader(hash common.Hash) error {
+func (p *peer) RequestOneHeader(id uint64, hash common.Hash) error {
 	p.Log().Debug("Fetching single header", "hash", hash)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+	return p.sendTagged(GetBlockHeadersMsg, id, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
 }
 
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the hash of an origin block.
-func (p *peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+func (p *peer) RequestHeadersByHash(id uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromhash", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.sendTagged(GetBlockHeadersMsg, id, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the number of an origin block.
-func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+func (p *peer) RequestHeadersByNumber(id uint64, origin uint64, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromnum", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.sendTagged(GetBlockHeadersMsg, id, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestBodies fetches a batch of blocks' bodies corresponding to the hashes
 // specified.
-func (p *peer) RequestBodies(hashes []common.Hash) error {
+func (p *peer) RequestBodies(id uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of block bodies", "count", len(hashes))
-	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
+	return p.sendTagged(GetBlockBodiesMsg, id, hashes)
 }
 
 // RequestNodeData fetches a batch of arbitrary data from a node's known state
 // data, corresponding to the specified hashes.
-func (p *peer) RequestNodeData(hashes []common.Hash) error {
+func (p *peer) RequestNodeData(id uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of state data", "count", len(hashes))
-	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
+	return p.sendTagged(GetNodeDataMsg, id, hashes)
 }
 
 // RequestReceipts fetches a batch of transaction receipts from a remote node.
-func (p *peer) RequestReceipts(hashes []common.Hash) error {
+func (p *peer) RequestReceipts(id uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of receipts", "count", len(hashes))
-	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
+	return p.sendTagged(GetReceiptsMsg, id, hashes)
+}
+
+// RequestTxs fetches a batch of transactions from a remote node's pool,
+// corresponding to the previously announced hashes. Deliveries are matched up
+// by the transaction fetcher based on their hashes, so the request identifier
+// is only used to satisfy the tilt/65 wire format.
+func (p *peer) RequestTxs(hashes []common.Hash) error {
+	p.Log().Debug("Fetching batch of transactions", "count", len(hashes))
+	return p.sendTagged(GetPooledTransactionsMsg, uint64(rand.Int63()), hashes)
+}
+
+// sendTagged sends a request or reply packet to the remote peer, prefixing it
+// with the request identifier if the negotiated protocol supports it (tilt/65).
+func (p *peer) sendTagged(msgcode uint64, id uint64, data interface{}) error {
+	if p.version >= eth65 {
+		return p2p.Send(p.rw, msgcode, []interface{}{id, data})
+	}
+	return p2p.Send(p.rw, msgcode, data)
 }
 
 // Handshake executes the tilt protocol handshake, negotiating version number,
-// network IDs, difficulties, head and genesis blocks.
-func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash) error {
+// network IDs, difficulties, head and genesis blocks. Since tilt/66 the fork
+// identifiers are also exchanged, rejecting peers on an incompatible chain.
+func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash, forkID forkid.ID, forkFilter forkid.Filter) error {
 	// Send out own handshake in a new thread
 	errc := make(chan error, 2)
-	var status statusData // safe to read after two values have been received from errc
+	var status statusData66 // safe to read after two values have been received from errc
 
 	go func() {
+		if p.version >= eth66 {
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData66{
+				ProtocolVersion: uint32(p.version),
+				NetworkId:       network,
+				TD:              td,
+				CurrentBlock:    head,
+				GenesisBlock:    genesis,
+				ForkID:          forkID,
+			})
+			return
+		}
 		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
 			ProtocolVersion: uint32(p.version),
 			NetworkId:       network,
@@ -245,7 +296,7 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 		})
 	}()
 	go func() {
-		errc <- p.readStatus(network, &status, genesis)
+		errc <- p.readStatus(network, &status, genesis, forkFilter)
 	}()
 	timeout := time.NewTimer(handshakeTimeout)
 	defer timeout.Stop()
@@ -263,7 +314,7 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 	return nil
 }
 
-func (p *peer) readStatus(network uint64, status *statusData, genesis common.Hash) (err error) {
+func (p *peer) readStatus(network uint64, status *statusData66, genesis common.Hash, forkFilter forkid.Filter) (err error) {
 	msg, err := p.rw.ReadMsg()
 	if err != nil {
 		return err
@@ -275,8 +326,17 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
 	}
 	// Decode the handshake and make sure everything matches
-	if err := msg.Decode(&status); err != nil {
-		return errResp(ErrDecode, "msg %v: %v", msg, err)
+	if p.version >= eth66 {
+		if err := msg.Decode(status); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+	} else {
+		var legacy statusData
+		if err := msg.Decode(&legacy); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		status.ProtocolVersion, status.NetworkId, status.TD = legacy.ProtocolVersion, legacy.NetworkId, legacy.TD
+		status.CurrentBlock, status.GenesisBlock = legacy.CurrentBlock, legacy.GenesisBlock
 	}
 	if status.GenesisBlock != genesis {
 		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", status.GenesisBlock[:8], genesis[:8])
@@ -287,6 +347,11 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 	if int(status.ProtocolVersion) != p.version {
 		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status.ProtocolVersion, p.version)
 	}
+	if p.version >= eth66 {
+		if err := forkFilter(status.ForkID); err != nil {
+			return errResp(ErrForkIDRejected, "%v", err)
+		}
+	}
 	return nil
 }
 
@@ -22,6 +22,7 @@ import (
 	"math/big"
 
 	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core/forkid"
 	"github.com/megatilt/go-tilt/core/types"
 	"github.com/megatilt/go-tilt/rlp"
 )
@@ -30,16 +31,19 @@ import (
 const (
 	eth62 = 62
 	eth63 = 63
+	eth64 = 64
+	eth65 = 65
+	eth66 = 66
 )
 
 // Official short name of the protocol used during capability negotiation.
 var ProtocolName = "tilt"
 
 // Supported versions of the tilt protocol (first is primary).
-var ProtocolVersions = []uint{eth63, eth62}
+var ProtocolVersions = []uint{eth66, eth65, eth64, eth63, eth62}
 
 // Number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = []uint64{17, 8}
+var ProtocolLengths = []uint64{17, 17, 17, 17, 8}
 
 const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
@@ -60,6 +64,11 @@ const (
 	NodeDataMsg    = 0x0e
 	GetReceiptsMsg = 0x0f
 	ReceiptsMsg    = 0x10
+
+	// Protocol messages belonging to tilt/64
+	NewPooledTransactionHashesMsg = 0x08
+	GetPooledTransactionsMsg      = 0x09
+	PooledTransactionsMsg         = 0x0a
 )
 
 type errCode int
@@ -74,6 +83,7 @@ const (
 	ErrNoStatusMsg
 	ErrExtraStatusMsg
 	ErrSuspendedPeer
+	ErrForkIDRejected
 )
 
 func (e errCode) String() string {
@@ -91,6 +101,7 @@ var errorToString = map[int]string{
 	ErrNoStatusMsg:             "No status message",
 	ErrExtraStatusMsg:          "Extra status message",
 	ErrSuspendedPeer:           "Suspended peer",
+	ErrForkIDRejected:          "Fork ID rejected",
 }
 
 type txPool interface {
@@ -100,6 +111,10 @@ type txPool interface {
 	// Pending should return pending transactions.
 	// The slice should be modifiable by the caller.
 	Pending() (map[common.Address]types.Transactions, error)
+
+	// Get should return a transaction if it is contained in the pool, or nil
+	// otherwise.
+	Get(hash common.Hash) *types.Transaction
 }
 
 // statusData is the network packet for the status message.
@@ -111,6 +126,17 @@ type statusData struct {
 	GenesisBlock    common.Hash
 }
 
+// statusData66 is the network packet for the status message since tilt/66,
+// extending the original with the fork identifier of the sender.
+type statusData66 struct {
+	ProtocolVersion uint32
+	NetworkId       uint64
+	TD              *big.Int
+	CurrentBlock    common.Hash
+	GenesisBlock    common.Hash
+	ForkID          forkid.ID
+}
+
 // newBlockHashesData is the network packet for the block announcements.
 type newBlockHashesData []struct {
 	Hash   common.Hash // Hash of one particular block being announced
@@ -125,6 +151,19 @@ type getBlockHeadersData struct {
 	Reverse bool         // Query direction (false = rising towards latest, true = falling towards genesis)
 }
 
+// getBlockHeadersPacket is the tilt/65 network packet for a header query, tagged
+// with the identifier of the request.
+type getBlockHeadersPacket struct {
+	RequestId uint64
+	Query     *getBlockHeadersData
+}
+
+// blockHeadersPacket is the tilt/65 network packet for a header reply.
+type blockHeadersPacket struct {
+	RequestId uint64
+	Headers   []*types.Header
+}
+
 // hashOrNumber is a combined field for specifying an origin block.
 type hashOrNumber struct {
 	Hash   common.Hash // Block hash from which to retrieve headers (excludes Number)
@@ -175,3 +214,28 @@ type blockBody struct {
 
 // blockBodiesData is the network packet for block content distribution.
 type blockBodiesData []*blockBody
+
+// blockBodiesPacket is the tilt/65 network packet for a block content reply.
+type blockBodiesPacket struct {
+	RequestId uint64
+	Bodies    blockBodiesData
+}
+
+// nodeDataPacket is the tilt/65 network packet for a state data reply.
+type nodeDataPacket struct {
+	RequestId uint64
+	Data      [][]byte
+}
+
+// receiptsPacket is the tilt/65 network packet for a receipt reply.
+type receiptsPacket struct {
+	RequestId uint64
+	Receipts  [][]*types.Receipt
+}
+
+// pooledTransactionsPacket is the tilt/65 network packet for a pooled
+// transaction reply.
+type pooledTransactionsPacket struct {
+	RequestId    uint64
+	Transactions []*types.Transaction
+}
@@ -0,0 +1,345 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core/state"
+	"github.com/megatilt/go-tilt/p2p"
+	"github.com/megatilt/go-tilt/rlp"
+	"github.com/megatilt/go-tilt/tiltdb"
+	"github.com/megatilt/go-tilt/trie"
+)
+
+const (
+	softResponseLimit  = 2 * 1024 * 1024 // Target maximum size of returned ranges, codes or trie nodes
+	maxCodeLookups     = 1024            // Maximum number of bytecodes to serve in one request
+	maxTrieNodeLookups = 1024            // Maximum number of trie nodes to serve in one request
+)
+
+// MakeProtocols constructs the P2P protocol definitions for `snap`, serving the
+// state contained in the given database and feeding any replies to our own
+// requests into the syncer.
+func MakeProtocols(chaindb tiltdb.Database, syncer *Syncer) []p2p.Protocol {
+	protocols := make([]p2p.Protocol, len(ProtocolVersions))
+	for i, version := range ProtocolVersions {
+		version := version // Closure for the run
+
+		protocols[i] = p2p.Protocol{
+			Name:    ProtocolName,
+			Version: version,
+			Length:  ProtocolLengths[i],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				return handle(chaindb, syncer, NewPeer(int(version), p, rw))
+			},
+		}
+	}
+	return protocols
+}
+
+// handle is the callback invoked to manage the life cycle of a `snap` peer.
+// When this function terminates, the peer is disconnected.
+func handle(chaindb tiltdb.Database, syncer *Syncer, peer *Peer) error {
+	if err := syncer.Register(peer); err != nil {
+		peer.Log().Error("Snapshot peer registration failed", "err", err)
+		return err
+	}
+	defer syncer.Unregister(peer.id)
+
+	for {
+		if err := handleMsg(chaindb, syncer, peer); err != nil {
+			peer.Log().Debug("Snapshot message handling failed", "err", err)
+			return err
+		}
+	}
+}
+
+// handleMsg is invoked whenever an inbound message is received from a remote
+// peer. The remote connection is torn down upon returning any error.
+func handleMsg(chaindb tiltdb.Database, syncer *Syncer, peer *Peer) error {
+	// Read the next message from the remote peer, and ensure it's fully consumed
+	msg, err := peer.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return fmt.Errorf("%v: %v > %v", errMsgTooLarge, msg.Size, ProtocolMaxMsgSize)
+	}
+	defer msg.Discard()
+
+	// Handle the message depending on its contents
+	switch msg.Code {
+	case GetAccountRangeMsg:
+		// Decode the account retrieval request and serve it from the local state
+		var req getAccountRangeData
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, AccountRangeMsg, serviceAccountRange(chaindb, &req))
+
+	case AccountRangeMsg:
+		// A range of accounts arrived to one of our previous requests
+		var res accountRangeData
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		hashes := make([]common.Hash, len(res.Accounts))
+		bodies := make([][]byte, len(res.Accounts))
+		for i, account := range res.Accounts {
+			hashes[i], bodies[i] = account.Hash, account.Body
+		}
+		return syncer.OnAccounts(peer, res.ID, hashes, bodies, res.Proof)
+
+	case GetStorageRangesMsg:
+		// Decode the storage retrieval request and serve it from the local state
+		var req getStorageRangesData
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, StorageRangesMsg, serviceStorageRanges(chaindb, &req))
+
+	case StorageRangesMsg:
+		// A batch of storage slot ranges arrived to one of our previous requests
+		var res storageRangesData
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		hashes := make([][]common.Hash, len(res.Slots))
+		slots := make([][][]byte, len(res.Slots))
+		for i, list := range res.Slots {
+			hashes[i] = make([]common.Hash, len(list))
+			slots[i] = make([][]byte, len(list))
+			for j, slot := range list {
+				hashes[i][j], slots[i][j] = slot.Hash, slot.Body
+			}
+		}
+		return syncer.OnStorage(peer, res.ID, hashes, slots, res.Proof)
+
+	case GetByteCodesMsg:
+		// Decode the bytecode retrieval request and serve it from the database
+		var req getByteCodesData
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, ByteCodesMsg, serviceByteCodes(chaindb, &req))
+
+	case ByteCodesMsg:
+		// A batch of bytecodes arrived to one of our previous requests
+		var res byteCodesData
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return syncer.OnByteCodes(peer, res.ID, res.Codes)
+
+	case GetTrieNodesMsg:
+		// Decode the trie node retrieval request and serve it from the database
+		var req getTrieNodesData
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, TrieNodesMsg, serviceTrieNodes(chaindb, &req))
+
+	case TrieNodesMsg:
+		// A batch of trie nodes arrived to one of our previous requests
+		var res trieNodesData
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%v: message %v: %v", errDecode, msg, err)
+		}
+		return syncer.OnTrieNodes(peer, res.ID, res.Nodes)
+
+	default:
+		return fmt.Errorf("%v: %v", errInvalidMsgCode, msg.Code)
+	}
+}
+
+// serviceAccountRange assembles the response to an account range query. If the
+// requested state is not available, an empty response is returned.
+func serviceAccountRange(chaindb tiltdb.Database, req *getAccountRangeData) *accountRangeData {
+	res := &accountRangeData{ID: req.ID}
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	tr, err := trie.New(req.Root, chaindb)
+	if err != nil {
+		return res
+	}
+	// Gather accounts until the limit hash or the size cap is reached
+	var (
+		size   uint64
+		nodeIt = tr.NodeIterator(req.Origin[:])
+		it     = trie.NewIterator(nodeIt)
+	)
+	for it.Next() {
+		hash := common.BytesToHash(it.Key)
+
+		res.Accounts = append(res.Accounts, &accountData{Hash: hash, Body: common.CopyBytes(it.Value)})
+		size += uint64(common.HashLength + len(it.Value))
+
+		if bytes.Compare(hash[:], req.Limit[:]) >= 0 || size >= req.Bytes {
+			break
+		}
+	}
+	if nodeIt.Error() != nil {
+		return &accountRangeData{ID: req.ID}
+	}
+	// Prove the boundaries of the range for the requester to verify
+	res.Proof = tr.Prove(req.Origin[:])
+	if len(res.Accounts) > 0 {
+		res.Proof = append(res.Proof, tr.Prove(res.Accounts[len(res.Accounts)-1].Hash[:])...)
+	}
+	return res
+}
+
+// serviceStorageRanges assembles the response to a storage range query. Only the
+// last returned range may be incomplete, in which case it's accompanied by the
+// proofs of its boundaries.
+func serviceStorageRanges(chaindb tiltdb.Database, req *getStorageRangesData) *storageRangesData {
+	res := &storageRangesData{ID: req.ID}
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	accTrie, err := trie.New(req.Root, chaindb)
+	if err != nil {
+		return res
+	}
+	var size uint64
+	for i, account := range req.Accounts {
+		// If we've exceeded the requested data limit, abort without opening a new range
+		if size >= req.Bytes {
+			break
+		}
+		// Resolve the storage trie of the requested account
+		blob, err := accTrie.TryGet(account[:])
+		if err != nil || blob == nil {
+			break
+		}
+		var acc state.Account
+		if err := rlp.DecodeBytes(blob, &acc); err != nil {
+			break
+		}
+		stTrie, err := trie.New(acc.Root, chaindb)
+		if err != nil {
+			break
+		}
+		// The origin only applies to the first account, the limit only to the last
+		var (
+			origin = common.Hash{}
+			limit  = common.Hash{}
+		)
+		for j := range limit {
+			limit[j] = 0xff
+		}
+		if i == 0 && len(req.Origin) > 0 {
+			origin = common.BytesToHash(req.Origin)
+		}
+		if i == len(req.Accounts)-1 && len(req.Limit) > 0 {
+			limit = common.BytesToHash(req.Limit)
+		}
+		// Gather the slots, always returning at least one to make progress
+		var (
+			slots   []*storageData
+			partial bool
+			nodeIt  = stTrie.NodeIterator(origin[:])
+			it      = trie.NewIterator(nodeIt)
+		)
+		for it.Next() {
+			if size >= req.Bytes && len(slots) > 0 {
+				partial = true
+				break
+			}
+			hash := common.BytesToHash(it.Key)
+
+			slots = append(slots, &storageData{Hash: hash, Body: common.CopyBytes(it.Value)})
+			size += uint64(common.HashLength + len(it.Value))
+
+			if bytes.Compare(hash[:], limit[:]) >= 0 {
+				partial = true
+				break
+			}
+		}
+		if nodeIt.Error() != nil {
+			break
+		}
+		res.Slots = append(res.Slots, slots)
+
+		// If the range is incomplete, prove its boundaries and stop serving
+		if partial || origin != (common.Hash{}) {
+			res.Proof = stTrie.Prove(origin[:])
+			if len(slots) > 0 {
+				res.Proof = append(res.Proof, stTrie.Prove(slots[len(slots)-1].Hash[:])...)
+			}
+			break
+		}
+	}
+	return res
+}
+
+// serviceByteCodes assembles the response to a bytecode query.
+func serviceByteCodes(chaindb tiltdb.Database, req *getByteCodesData) *byteCodesData {
+	res := &byteCodesData{ID: req.ID}
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	if len(req.Hashes) > maxCodeLookups {
+		req.Hashes = req.Hashes[:maxCodeLookups]
+	}
+	var size uint64
+	for _, hash := range req.Hashes {
+		if hash == emptyCode {
+			// Peers should not request the empty code, but if they do, serve it
+			res.Codes = append(res.Codes, []byte{})
+			continue
+		}
+		if blob, err := chaindb.Get(hash[:]); err == nil && len(blob) > 0 {
+			res.Codes = append(res.Codes, blob)
+			size += uint64(len(blob))
+		}
+		if size >= req.Bytes {
+			break
+		}
+	}
+	return res
+}
+
+// serviceTrieNodes assembles the response to a trie node query. If the state
+// being healed is not available, an empty response is returned.
+func serviceTrieNodes(chaindb tiltdb.Database, req *getTrieNodesData) *trieNodesData {
+	res := &trieNodesData{ID: req.ID}
+	if req.Bytes > softResponseLimit {
+		req.Bytes = softResponseLimit
+	}
+	if blob, err := chaindb.Get(req.Root[:]); err != nil || len(blob) == 0 {
+		return res
+	}
+	if len(req.Hashes) > maxTrieNodeLookups {
+		req.Hashes = req.Hashes[:maxTrieNodeLookups]
+	}
+	var size uint64
+	for _, hash := range req.Hashes {
+		if blob, err := chaindb.Get(hash[:]); err == nil && len(blob) > 0 {
+			res.Nodes = append(res.Nodes, blob)
+			size += uint64(len(blob))
+		}
+		if size >= req.Bytes {
+			break
+		}
+	}
+	return res
+}
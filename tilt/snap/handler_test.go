@@ -0,0 +1,314 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core/state"
+	"github.com/megatilt/go-tilt/crypto"
+	"github.com/megatilt/go-tilt/p2p"
+	"github.com/megatilt/go-tilt/p2p/discover"
+	"github.com/megatilt/go-tilt/rlp"
+	"github.com/megatilt/go-tilt/tiltdb"
+	"github.com/megatilt/go-tilt/trie"
+)
+
+// makeTestState creates a state with the given number of accounts, every third
+// of them being a contract with unique code and the given number of storage
+// slots. The first contract gets the number of slots given by large instead.
+func makeTestState(accounts, slots, large int) (*tiltdb.MemDatabase, common.Hash, []common.Address) {
+	db, _ := tiltdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, db)
+
+	addrs := make([]common.Address, accounts)
+	for i := 0; i < accounts; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		statedb.AddBalance(addr, big.NewInt(int64(i+1)))
+		statedb.SetNonce(addr, uint64(i))
+
+		if i%3 == 0 {
+			statedb.SetCode(addr, []byte{0x60, byte(i >> 8), byte(i), 0x00})
+
+			n := slots
+			if i == 0 {
+				n = large
+			}
+			for j := 0; j < n; j++ {
+				statedb.SetState(addr, common.BigToHash(big.NewInt(int64(j+1))), common.BigToHash(big.NewInt(int64(i+j+1))))
+			}
+		}
+		addrs[i] = addr
+	}
+	root, _ := statedb.Commit()
+	return db, root, addrs
+}
+
+// accountOf retrieves the consensus representation of an account from the state
+// trie with the given root.
+func accountOf(t *testing.T, db tiltdb.Database, root common.Hash, addr common.Address) *state.Account {
+	tr, err := trie.NewSecure(root, db, 0)
+	if err != nil {
+		t.Fatalf("failed to open state trie: %v", err)
+	}
+	var account state.Account
+	if err := rlp.DecodeBytes(tr.Get(addr[:]), &account); err != nil {
+		t.Fatalf("failed to decode account %x: %v", addr, err)
+	}
+	return &account
+}
+
+// newTestPeer wraps one end of a message pipe into a `snap` peer.
+func newTestPeer(name string, rw p2p.MsgReadWriter) *Peer {
+	var id discover.NodeID
+	copy(id[:], name)
+
+	return NewPeer(snap1, p2p.NewPeer(id, name, nil), rw)
+}
+
+// newTestServer starts a simulated remote peer serving the state contained in
+// the given database, returning the local end of the connection.
+func newTestServer(db tiltdb.Database) *p2p.MsgPipeRW {
+	app, net := p2p.MsgPipe()
+	go handle(db, NewSyncer(db), newTestPeer("remote", net))
+	return app
+}
+
+// request sends a query to a simulated remote peer and decodes its reply.
+func request(t *testing.T, rw p2p.MsgReadWriter, code uint64, req interface{}, rescode uint64, res interface{}) {
+	if err := p2p.Send(rw, code, req); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	defer msg.Discard()
+
+	if msg.Code != rescode {
+		t.Fatalf("response code mismatch: have %d, want %d", msg.Code, rescode)
+	}
+	if err := msg.Decode(res); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+}
+
+// Tests that account ranges are served in order and with valid proofs, both in
+// full and cut short by the requested byte limit.
+func TestAccountRange(t *testing.T) {
+	db, root, addrs := makeTestState(100, 4, 4)
+	peer := newTestServer(db)
+	defer peer.Close()
+
+	limit := common.Hash{}
+	for i := range limit {
+		limit[i] = 0xff
+	}
+	tests := []struct {
+		bytes uint64 // Soft byte limit of the request
+		full  bool   // Whether the entire state fits into the response
+	}{
+		{softResponseLimit, true},
+		{1000, false},
+		{1, false},
+	}
+	for i, tt := range tests {
+		var res accountRangeData
+		request(t, peer, GetAccountRangeMsg, &getAccountRangeData{ID: uint64(i), Root: root, Limit: limit, Bytes: tt.bytes}, AccountRangeMsg, &res)
+
+		if res.ID != uint64(i) {
+			t.Errorf("test %d: request id mismatch: have %d, want %d", i, res.ID, i)
+		}
+		if tt.full && len(res.Accounts) != len(addrs) {
+			t.Errorf("test %d: account count mismatch: have %d, want %d", i, len(res.Accounts), len(addrs))
+		}
+		if !tt.full && (len(res.Accounts) == 0 || len(res.Accounts) >= len(addrs)) {
+			t.Errorf("test %d: account count out of bounds: have %d, want 1..%d", i, len(res.Accounts), len(addrs)-1)
+		}
+		keys := make([][]byte, len(res.Accounts))
+		vals := make([][]byte, len(res.Accounts))
+		for j, account := range res.Accounts {
+			keys[j], vals[j] = common.CopyBytes(account.Hash[:]), account.Body
+			if j > 0 && bytes.Compare(keys[j-1], keys[j]) >= 0 {
+				t.Errorf("test %d: account %d out of order", i, j)
+			}
+		}
+		more, err := trie.VerifyRangeProof(root, common.Hash{}.Bytes(), keys, vals, res.Proof)
+		if err != nil {
+			t.Errorf("test %d: failed to verify range: %v", i, err)
+		}
+		if more == tt.full {
+			t.Errorf("test %d: continuation mismatch: have %v, want %v", i, more, !tt.full)
+		}
+	}
+}
+
+// Tests that querying a state root the peer doesn't have results in empty replies
+// instead of a disconnect.
+func TestUnknownRoot(t *testing.T) {
+	_, root, addrs := makeTestState(10, 4, 4)
+	empty, _ := tiltdb.NewMemDatabase()
+
+	peer := newTestServer(empty)
+	defer peer.Close()
+
+	var accounts accountRangeData
+	request(t, peer, GetAccountRangeMsg, &getAccountRangeData{ID: 1, Root: root, Bytes: softResponseLimit}, AccountRangeMsg, &accounts)
+	if accounts.ID != 1 || len(accounts.Accounts) != 0 || len(accounts.Proof) != 0 {
+		t.Errorf("account range not empty: %d accounts, %d proof nodes", len(accounts.Accounts), len(accounts.Proof))
+	}
+	var slots storageRangesData
+	request(t, peer, GetStorageRangesMsg, &getStorageRangesData{ID: 2, Root: root, Accounts: []common.Hash{crypto.Keccak256Hash(addrs[0][:])}, Bytes: softResponseLimit}, StorageRangesMsg, &slots)
+	if slots.ID != 2 || len(slots.Slots) != 0 || len(slots.Proof) != 0 {
+		t.Errorf("storage ranges not empty: %d ranges, %d proof nodes", len(slots.Slots), len(slots.Proof))
+	}
+	var nodes trieNodesData
+	request(t, peer, GetTrieNodesMsg, &getTrieNodesData{ID: 3, Root: root, Hashes: []common.Hash{root}, Bytes: softResponseLimit}, TrieNodesMsg, &nodes)
+	if nodes.ID != 3 || len(nodes.Nodes) != 0 {
+		t.Errorf("trie nodes not empty: %d nodes", len(nodes.Nodes))
+	}
+}
+
+// Tests that storage ranges of multiple small contracts are served in full, and
+// that a single large contract is served in proven sections.
+func TestStorageRanges(t *testing.T) {
+	db, root, addrs := makeTestState(30, 8, 100)
+	peer := newTestServer(db)
+	defer peer.Close()
+
+	// Request all the small contracts in one go
+	var (
+		hashes []common.Hash
+		roots  []common.Hash
+	)
+	for i := 3; i < len(addrs); i += 3 {
+		hashes = append(hashes, crypto.Keccak256Hash(addrs[i][:]))
+		roots = append(roots, accountOf(t, db, root, addrs[i]).Root)
+	}
+	var res storageRangesData
+	request(t, peer, GetStorageRangesMsg, &getStorageRangesData{ID: 1, Root: root, Accounts: hashes, Bytes: softResponseLimit}, StorageRangesMsg, &res)
+
+	if len(res.Slots) != len(hashes) {
+		t.Fatalf("storage range count mismatch: have %d, want %d", len(res.Slots), len(hashes))
+	}
+	if len(res.Proof) != 0 {
+		t.Errorf("complete ranges returned %d proof nodes", len(res.Proof))
+	}
+	for i, slots := range res.Slots {
+		if len(slots) != 8 {
+			t.Errorf("range %d: slot count mismatch: have %d, want %d", i, len(slots), 8)
+		}
+		keys := make([][]byte, len(slots))
+		vals := make([][]byte, len(slots))
+		for j, slot := range slots {
+			keys[j], vals[j] = common.CopyBytes(slot.Hash[:]), slot.Body
+		}
+		if more, err := trie.VerifyRangeProof(roots[i], common.Hash{}.Bytes(), keys, vals, nil); err != nil || more {
+			t.Errorf("range %d: failed to verify: more %v, err %v", i, more, err)
+		}
+	}
+	// Request the large contract section by section
+	var (
+		large  = crypto.Keccak256Hash(addrs[0][:])
+		origin = common.Hash{}
+		keys   [][]byte
+		vals   [][]byte
+	)
+	for {
+		var res storageRangesData
+		request(t, peer, GetStorageRangesMsg, &getStorageRangesData{ID: 2, Root: root, Accounts: []common.Hash{large}, Origin: origin[:], Bytes: 512}, StorageRangesMsg, &res)
+
+		if len(res.Slots) != 1 || len(res.Slots[0]) == 0 {
+			t.Fatalf("large storage section missing from response")
+		}
+		var sectionKeys, sectionVals [][]byte
+		for _, slot := range res.Slots[0] {
+			sectionKeys = append(sectionKeys, common.CopyBytes(slot.Hash[:]))
+			sectionVals = append(sectionVals, slot.Body)
+		}
+		more, err := trie.VerifyRangeProof(accountOf(t, db, root, addrs[0]).Root, origin[:], sectionKeys, sectionVals, res.Proof)
+		if err != nil {
+			t.Fatalf("failed to verify large storage section: %v", err)
+		}
+		keys, vals = append(keys, sectionKeys...), append(vals, sectionVals...)
+		if !more {
+			break
+		}
+		next, ok := incHash(common.BytesToHash(sectionKeys[len(sectionKeys)-1]))
+		if !ok {
+			t.Fatalf("large storage section continues past the end of the key space")
+		}
+		origin = next
+	}
+	if len(keys) != 100 {
+		t.Errorf("large storage slot count mismatch: have %d, want %d", len(keys), 100)
+	}
+}
+
+// Tests that known bytecodes are served and unknown ones skipped.
+func TestByteCodes(t *testing.T) {
+	db, root, addrs := makeTestState(10, 0, 0)
+	peer := newTestServer(db)
+	defer peer.Close()
+
+	var (
+		hashes []common.Hash
+		want   [][]byte
+	)
+	for i := 0; i < len(addrs); i += 3 {
+		hash := common.BytesToHash(accountOf(t, db, root, addrs[i]).CodeHash)
+		code, _ := db.Get(hash[:])
+
+		hashes = append(hashes, hash, common.BigToHash(big.NewInt(int64(i))))
+		want = append(want, code)
+	}
+	var res byteCodesData
+	request(t, peer, GetByteCodesMsg, &getByteCodesData{ID: 1, Hashes: hashes, Bytes: softResponseLimit}, ByteCodesMsg, &res)
+
+	if len(res.Codes) != len(want) {
+		t.Fatalf("bytecode count mismatch: have %d, want %d", len(res.Codes), len(want))
+	}
+	for i, code := range res.Codes {
+		if !bytes.Equal(code, want[i]) {
+			t.Errorf("bytecode %d mismatch: have %x, want %x", i, code, want[i])
+		}
+	}
+}
+
+// Tests that trie nodes are served by hash.
+func TestTrieNodes(t *testing.T) {
+	db, root, addrs := makeTestState(10, 4, 4)
+	peer := newTestServer(db)
+	defer peer.Close()
+
+	hashes := []common.Hash{root, accountOf(t, db, root, addrs[0]).Root, common.Hash{1}}
+
+	var res trieNodesData
+	request(t, peer, GetTrieNodesMsg, &getTrieNodesData{ID: 1, Root: root, Hashes: hashes, Bytes: softResponseLimit}, TrieNodesMsg, &res)
+
+	if len(res.Nodes) != 2 {
+		t.Fatalf("trie node count mismatch: have %d, want %d", len(res.Nodes), 2)
+	}
+	for i, node := range res.Nodes {
+		if hash := crypto.Keccak256Hash(node); hash != hashes[i] {
+			t.Errorf("trie node %d hash mismatch: have %x, want %x", i, hash, hashes[i])
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package snap implements the snapshot synchronisation protocol, retrieving
+// the state trie in contiguous account and storage ranges instead of node by
+// node, healing any leftover inconsistencies at the end.
+package snap
+
+import (
+	"errors"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/rlp"
+)
+
+// Constants to match up protocol versions and messages
+const (
+	snap1 = 1
+)
+
+// Official short name of the protocol used during capability negotiation.
+var ProtocolName = "snap"
+
+// Supported versions of the snap protocol (first is primary).
+var ProtocolVersions = []uint{snap1}
+
+// Number of implemented message corresponding to different protocol versions.
+var ProtocolLengths = []uint64{8}
+
+const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
+
+// snap protocol message codes
+const (
+	GetAccountRangeMsg  = 0x00
+	AccountRangeMsg     = 0x01
+	GetStorageRangesMsg = 0x02
+	StorageRangesMsg    = 0x03
+	GetByteCodesMsg     = 0x04
+	ByteCodesMsg        = 0x05
+	GetTrieNodesMsg     = 0x06
+	TrieNodesMsg        = 0x07
+)
+
+var (
+	errMsgTooLarge    = errors.New("message too long")
+	errDecode         = errors.New("invalid message")
+	errInvalidMsgCode = errors.New("invalid message code")
+	errBadRequest     = errors.New("bad request")
+)
+
+// getAccountRangeData represents an account range query.
+type getAccountRangeData struct {
+	ID     uint64      // Request ID to match up responses with
+	Root   common.Hash // Root hash of the account trie to serve
+	Origin common.Hash // Hash of the first account to retrieve
+	Limit  common.Hash // Hash of the last account to retrieve
+	Bytes  uint64      // Soft limit at which to stop returning data
+}
+
+// accountRangeData represents an account range query response.
+type accountRangeData struct {
+	ID       uint64         // ID of the request this is a response for
+	Accounts []*accountData // List of consecutive accounts from the trie
+	Proof    []rlp.RawValue // List of trie nodes proving the account range
+}
+
+// accountData represents a single account in a query response.
+type accountData struct {
+	Hash common.Hash  // Hash of the account
+	Body rlp.RawValue // Account body in consensus RLP format
+}
+
+// getStorageRangesData represents a storage slot range query.
+type getStorageRangesData struct {
+	ID       uint64        // Request ID to match up responses with
+	Root     common.Hash   // Root hash of the account trie to serve
+	Accounts []common.Hash // Account hashes of the storage tries to serve
+	Origin   []byte        // Hash of the first storage slot to retrieve (large contract mode)
+	Limit    []byte        // Hash of the last storage slot to retrieve (large contract mode)
+	Bytes    uint64        // Soft limit at which to stop returning data
+}
+
+// storageRangesData represents a storage slot range query response.
+type storageRangesData struct {
+	ID    uint64           // ID of the request this is a response for
+	Slots [][]*storageData // Lists of consecutive storage slots for the requested accounts
+	Proof []rlp.RawValue   // Merkle proofs for the *last* slot range, if it's incomplete
+}
+
+// storageData represents a single storage slot in a query response.
+type storageData struct {
+	Hash common.Hash // Hash of the storage slot
+	Body []byte      // Data content of the slot
+}
+
+// getByteCodesData represents a contract bytecode query.
+type getByteCodesData struct {
+	ID     uint64        // Request ID to match up responses with
+	Hashes []common.Hash // Code hashes to retrieve the code for
+	Bytes  uint64        // Soft limit at which to stop returning data
+}
+
+// byteCodesData represents a contract bytecode query response.
+type byteCodesData struct {
+	ID    uint64   // ID of the request this is a response for
+	Codes [][]byte // Requested contract bytecodes
+}
+
+// getTrieNodesData represents a state trie node query, used to heal the
+// inconsistencies left over after the range retrievals.
+type getTrieNodesData struct {
+	ID     uint64        // Request ID to match up responses with
+	Root   common.Hash   // Root hash of the account trie being healed
+	Hashes []common.Hash // Hashes of the trie nodes to retrieve
+	Bytes  uint64        // Soft limit at which to stop returning data
+}
+
+// trieNodesData represents a state trie node query response.
+type trieNodesData struct {
+	ID    uint64   // ID of the request this is a response for
+	Nodes [][]byte // Requested state trie nodes
+}
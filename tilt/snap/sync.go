@@ -0,0 +1,1065 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core/state"
+	"github.com/megatilt/go-tilt/crypto"
+	"github.com/megatilt/go-tilt/log"
+	"github.com/megatilt/go-tilt/rlp"
+	"github.com/megatilt/go-tilt/tiltdb"
+	"github.com/megatilt/go-tilt/trie"
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCode is the known hash of the empty EVM bytecode.
+	emptyCode = crypto.Keccak256Hash(nil)
+)
+
+const (
+	maxRequestSize      = 512 * 1024 // Soft byte limit of the data requested from a single peer
+	maxStorageAccounts  = 128        // Maximum number of small storage tries to request at once
+	maxCodeRequestCount = 384        // Maximum number of bytecodes to request at once
+	maxTrieRequestCount = 384        // Maximum number of trie nodes to request at once
+
+	accountConcurrency = 16 // Number of account ranges the state trie is split into
+
+	requestTimeout = 10 * time.Second // Maximum time allowance before a request is considered stale
+)
+
+var (
+	errCancelled         = errors.New("sync cancelled")
+	errAlreadyRegistered = errors.New("peer is already registered")
+	errNotRegistered     = errors.New("peer is not registered")
+)
+
+// accountRequest tracks a pending account range request.
+type accountRequest struct {
+	peer string      // Peer to which this request is assigned
+	id   uint64      // Request ID of this request
+	root common.Hash // State root the request was made against
+
+	task   *accountTask // Account range task this request is filling
+	origin common.Hash  // First account requested to allow continuation checks
+
+	timeout *time.Timer // Timer to track delivery timeout
+}
+
+// storageRequest tracks a pending storage ranges request. It either contains a
+// batch of small storage tries to retrieve whole, or a single large one from an
+// origin onward.
+type storageRequest struct {
+	peer string      // Peer to which this request is assigned
+	id   uint64      // Request ID of this request
+	root common.Hash // State root the request was made against
+
+	tasks  []*storageTask // Storage tries this request is filling
+	origin []byte         // Starting slot of a large trie continuation
+
+	timeout *time.Timer // Timer to track delivery timeout
+}
+
+// codeRequest tracks a pending bytecode request.
+type codeRequest struct {
+	peer string // Peer to which this request is assigned
+	id   uint64 // Request ID of this request
+
+	hashes []common.Hash // Bytecode hashes to validate responses against
+
+	timeout *time.Timer // Timer to track delivery timeout
+}
+
+// healRequest tracks a pending trie node request.
+type healRequest struct {
+	peer string      // Peer to which this request is assigned
+	id   uint64      // Request ID of this request
+	root common.Hash // State root the request was made against
+
+	hashes []common.Hash // Trie node hashes to validate responses against
+
+	timeout *time.Timer // Timer to track delivery timeout
+}
+
+// accountTask represents one section of the account trie, retrieved in
+// consecutive ranges until exhausted.
+type accountTask struct {
+	next common.Hash // Next account to retrieve in this section
+	last common.Hash // Last account belonging to this section
+
+	req  *accountRequest // Pending request filling this task, if any
+	done bool            // Flag whether the section was fully retrieved
+}
+
+// accountChunk is a range of delivered accounts waiting for their storage tries
+// and bytecodes to be retrieved before being committed to the database.
+type accountChunk struct {
+	hashes []common.Hash // Account hashes in the delivered range
+	bodies [][]byte      // Account bodies in consensus RLP format
+
+	pending int  // Number of storage tries and bytecodes still outstanding
+	partial bool // Flag whether a dependency was retrieved piecemeal
+}
+
+// storageTask represents the retrieval of a single storage trie, shared by all
+// the accounts referencing the same root.
+type storageTask struct {
+	root    common.Hash     // Root hash of the storage trie
+	account common.Hash     // Account hash through which to request the trie
+	waiters []*accountChunk // Account chunks waiting for this trie
+
+	next  common.Hash     // Next slot to retrieve if the trie is large
+	large bool            // Flag whether the trie is being retrieved piecemeal
+	req   *storageRequest // Pending request filling this task, if any
+}
+
+// Syncer is a state synchroniser retrieving the state trie in contiguous ranges
+// of accounts and storage slots via the `snap` protocol. As the ranges may be
+// served from slightly different states, the retrieved data is finally healed
+// node by node into the exact requested state.
+//
+// The syncer maintains the invariant that any trie node written into the
+// database has its entire subtree (including storage tries and bytecodes)
+// present too, which allows the healing to skip over whole subtries.
+type Syncer struct {
+	db tiltdb.Database // Database to store the retrieved state into
+
+	root   common.Hash   // Current state root being synchronised
+	lock   sync.Mutex    // Lock protecting the fields below
+	syncMu sync.Mutex    // Lock preventing concurrent syncs
+	update chan struct{} // Notification channel to reschedule the sync
+	fail   error         // Database failure aborting the sync
+
+	peers     map[string]*Peer    // Currently active peers to download from
+	idlers    map[string]struct{} // Peers that are currently idle
+	stateless map[string]struct{} // Peers that failed to deliver the current state
+
+	accountReqs map[uint64]*accountRequest // Account requests currently running
+	storageReqs map[uint64]*storageRequest // Storage requests currently running
+	codeReqs    map[uint64]*codeRequest    // Bytecode requests currently running
+	healReqs    map[uint64]*healRequest    // Trie node requests currently running
+
+	accountTasks []*accountTask                  // Sections of the account trie to retrieve
+	storageTasks map[common.Hash]*storageTask    // Storage tries being retrieved, by root
+	storagePend  map[common.Hash]*storageTask    // Storage tries waiting to be requested
+	storageDone  map[common.Hash]struct{}        // Storage tries retrieved piecemeal
+	codeQueue    map[common.Hash]struct{}        // Bytecodes waiting to be requested
+	codeWaiters  map[common.Hash][]*accountChunk // Account chunks waiting for each bytecode
+	chunks       int                             // Number of account chunks still waiting for dependencies
+
+	healer    *state.StateSync // Trie node scheduler filling the gaps of the ranges
+	healQueue []common.Hash    // Trie nodes waiting to be requested
+
+	accountSynced uint64 // Number of accounts retrieved
+	storageSynced uint64 // Number of storage slots retrieved
+	codeSynced    uint64 // Number of bytecodes retrieved
+	nodesHealed   uint64 // Number of trie nodes healed
+}
+
+// NewSyncer creates a new snapshot syncer to download the state trie into the
+// given database.
+func NewSyncer(db tiltdb.Database) *Syncer {
+	return &Syncer{
+		db:        db,
+		update:    make(chan struct{}, 1),
+		peers:     make(map[string]*Peer),
+		idlers:    make(map[string]struct{}),
+		stateless: make(map[string]struct{}),
+	}
+}
+
+// Register injects a new data source into the syncer's peerset.
+func (s *Syncer) Register(peer *Peer) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.peers[peer.id]; ok {
+		return errAlreadyRegistered
+	}
+	s.peers[peer.id] = peer
+	s.idlers[peer.id] = struct{}{}
+
+	s.notify()
+	return nil
+}
+
+// Unregister removes a data source from the syncer's peerset, rescheduling any
+// requests it had in flight.
+func (s *Syncer) Unregister(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.peers[id]; !ok {
+		return errNotRegistered
+	}
+	delete(s.peers, id)
+	delete(s.idlers, id)
+	delete(s.stateless, id)
+
+	for reqid, req := range s.accountReqs {
+		if req.peer == id {
+			delete(s.accountReqs, reqid)
+			s.revertAccountRequest(req)
+		}
+	}
+	for reqid, req := range s.storageReqs {
+		if req.peer == id {
+			delete(s.storageReqs, reqid)
+			s.revertStorageRequest(req)
+		}
+	}
+	for reqid, req := range s.codeReqs {
+		if req.peer == id {
+			delete(s.codeReqs, reqid)
+			s.revertCodeRequest(req)
+		}
+	}
+	for reqid, req := range s.healReqs {
+		if req.peer == id {
+			delete(s.healReqs, reqid)
+			s.revertHealRequest(req)
+		}
+	}
+	s.notify()
+	return nil
+}
+
+// Progress returns the number of state entries synced so far and the number
+// of entries known to be still pending.
+func (s *Syncer) Progress() (synced uint64, pending uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	synced = s.accountSynced + s.storageSynced + s.codeSynced + s.nodesHealed
+	pending = uint64(len(s.storagePend) + len(s.codeQueue) + len(s.healQueue))
+	if s.healer != nil {
+		pending += uint64(s.healer.Pending())
+	}
+	return synced, pending
+}
+
+// Sync starts (or resumes) the retrieval of the state trie with the given root,
+// blocking until it's fully available locally or until cancelled.
+func (s *Syncer) Sync(root common.Hash, cancel chan struct{}) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	// If the state is already available (i.e. complete from a previous run), return
+	if root == emptyRoot {
+		return nil
+	}
+	if blob, err := s.db.Get(root[:]); err == nil && len(blob) > 0 {
+		return nil
+	}
+	s.lock.Lock()
+	s.reset(root)
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		s.reset(common.Hash{})
+		s.lock.Unlock()
+	}()
+	log.Debug("Starting snapshot sync cycle", "root", root)
+
+	for {
+		s.lock.Lock()
+		if s.fail != nil {
+			err := s.fail
+			s.lock.Unlock()
+			return err
+		}
+		done := s.schedule()
+		s.lock.Unlock()
+
+		if done {
+			log.Debug("Snapshot sync cycle completed", "root", root)
+			return nil
+		}
+		select {
+		case <-s.update:
+		case <-cancel:
+			return errCancelled
+		}
+	}
+}
+
+// reset cancels any requests in flight and initialises the sync tasks for the
+// given state root. The zero root tears everything down.
+func (s *Syncer) reset(root common.Hash) {
+	for _, req := range s.accountReqs {
+		req.timeout.Stop()
+		s.idlers[req.peer] = struct{}{}
+	}
+	for _, req := range s.storageReqs {
+		req.timeout.Stop()
+		s.idlers[req.peer] = struct{}{}
+	}
+	for _, req := range s.codeReqs {
+		req.timeout.Stop()
+		s.idlers[req.peer] = struct{}{}
+	}
+	for _, req := range s.healReqs {
+		req.timeout.Stop()
+		s.idlers[req.peer] = struct{}{}
+	}
+	for id := range s.idlers {
+		if _, ok := s.peers[id]; !ok {
+			delete(s.idlers, id)
+		}
+	}
+	s.root, s.fail = root, nil
+	s.stateless = make(map[string]struct{})
+
+	s.accountReqs = make(map[uint64]*accountRequest)
+	s.storageReqs = make(map[uint64]*storageRequest)
+	s.codeReqs = make(map[uint64]*codeRequest)
+	s.healReqs = make(map[uint64]*healRequest)
+
+	s.accountTasks = nil
+	s.storageTasks = make(map[common.Hash]*storageTask)
+	s.storagePend = make(map[common.Hash]*storageTask)
+	s.storageDone = make(map[common.Hash]struct{})
+	s.codeQueue = make(map[common.Hash]struct{})
+	s.codeWaiters = make(map[common.Hash][]*accountChunk)
+	s.chunks = 0
+
+	s.healer, s.healQueue = nil, nil
+
+	if root == (common.Hash{}) {
+		return
+	}
+	// Split the account trie into equal sections to retrieve concurrently
+	var (
+		next = new(big.Int)
+		step = new(big.Int).Div(new(big.Int).Lsh(common.Big1, 256), big.NewInt(accountConcurrency))
+	)
+	for i := 0; i < accountConcurrency; i++ {
+		last := new(big.Int).Sub(new(big.Int).Add(next, step), common.Big1)
+		if i == accountConcurrency-1 {
+			last = new(big.Int).Sub(new(big.Int).Lsh(common.Big1, 256), common.Big1)
+		}
+		s.accountTasks = append(s.accountTasks, &accountTask{
+			next: common.BigToHash(next),
+			last: common.BigToHash(last),
+		})
+		next = new(big.Int).Add(last, common.Big1)
+	}
+}
+
+// notify pings the sync loop to reschedule, without blocking if a reschedule is
+// already pending.
+func (s *Syncer) notify() {
+	select {
+	case s.update <- struct{}{}:
+	default:
+	}
+}
+
+// schedule assigns new tasks to all idle peers, returning whether the sync has
+// completed. The caller must hold the lock.
+func (s *Syncer) schedule() bool {
+	// Check whether the range retrievals are done and start healing if so
+	ranged := s.chunks == 0 && len(s.storageTasks) == 0 && len(s.codeWaiters) == 0
+	for _, task := range s.accountTasks {
+		if !task.done {
+			ranged = false
+			break
+		}
+	}
+	if ranged && s.healer == nil {
+		s.healer = state.NewStateSync(s.root, s.db)
+	}
+	if s.healer != nil {
+		s.healQueue = append(s.healQueue, s.healer.Missing(0)...)
+		if s.healer.Pending() == 0 && len(s.healReqs) == 0 {
+			return true
+		}
+	}
+	// Assign a request to every idle and usable peer
+	for id := range s.idlers {
+		if _, ok := s.stateless[id]; ok {
+			continue
+		}
+		peer := s.peers[id]
+		switch {
+		case s.healer != nil:
+			if !s.assignHealTask(peer) {
+				return false
+			}
+		case len(s.codeQueue) > 0:
+			s.assignCodeTask(peer)
+		case len(s.storagePend) > 0:
+			s.assignStorageTask(peer)
+		default:
+			if !s.assignAccountTask(peer) {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// newRequestId generates a request identifier not in use by any running request.
+func (s *Syncer) newRequestId() uint64 {
+	for {
+		id := uint64(rand.Int63())
+		if _, ok := s.accountReqs[id]; ok {
+			continue
+		}
+		if _, ok := s.storageReqs[id]; ok {
+			continue
+		}
+		if _, ok := s.codeReqs[id]; ok {
+			continue
+		}
+		if _, ok := s.healReqs[id]; ok {
+			continue
+		}
+		return id
+	}
+}
+
+// assignAccountTask requests the next range of an idle account section from the
+// given peer, returning false if there's nothing left to request.
+func (s *Syncer) assignAccountTask(peer *Peer) bool {
+	for _, task := range s.accountTasks {
+		if task.done || task.req != nil {
+			continue
+		}
+		id := s.newRequestId()
+		req := &accountRequest{
+			peer:   peer.id,
+			id:     id,
+			root:   s.root,
+			task:   task,
+			origin: task.next,
+		}
+		req.timeout = time.AfterFunc(requestTimeout, func() {
+			peer.Log().Debug("Account range request timed out", "reqid", id)
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
+			if s.accountReqs[id] == req {
+				delete(s.accountReqs, id)
+				s.idlers[req.peer] = struct{}{}
+				s.revertAccountRequest(req)
+				s.notify()
+			}
+		})
+		s.accountReqs[id] = req
+		task.req = req
+		delete(s.idlers, peer.id)
+
+		go func(root, origin, limit common.Hash) {
+			if err := peer.RequestAccountRange(id, root, origin, limit, maxRequestSize); err != nil {
+				peer.Log().Debug("Failed to request account range", "err", err)
+			}
+		}(req.root, req.origin, task.last)
+		return true
+	}
+	return false
+}
+
+// assignStorageTask requests either a batch of small storage tries or the next
+// section of a large one from the given peer.
+func (s *Syncer) assignStorageTask(peer *Peer) {
+	var (
+		tasks  []*storageTask
+		origin []byte
+	)
+	for root, task := range s.storagePend {
+		if task.large {
+			// Large tries are requested on their own, continuing from the last slot
+			if len(tasks) > 0 {
+				continue
+			}
+			tasks, origin = []*storageTask{task}, common.CopyBytes(task.next[:])
+			delete(s.storagePend, root)
+			break
+		}
+		tasks = append(tasks, task)
+		delete(s.storagePend, root)
+		if len(tasks) >= maxStorageAccounts {
+			break
+		}
+	}
+	id := s.newRequestId()
+	req := &storageRequest{
+		peer:   peer.id,
+		id:     id,
+		root:   s.root,
+		tasks:  tasks,
+		origin: origin,
+	}
+	req.timeout = time.AfterFunc(requestTimeout, func() {
+		peer.Log().Debug("Storage ranges request timed out", "reqid", id)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if s.storageReqs[id] == req {
+			delete(s.storageReqs, id)
+			s.idlers[req.peer] = struct{}{}
+			s.revertStorageRequest(req)
+			s.notify()
+		}
+	})
+	accounts := make([]common.Hash, len(tasks))
+	for i, task := range tasks {
+		accounts[i] = task.account
+		task.req = req
+	}
+	s.storageReqs[id] = req
+	delete(s.idlers, peer.id)
+
+	go func(root common.Hash) {
+		if err := peer.RequestStorageRanges(id, root, accounts, origin, nil, maxRequestSize); err != nil {
+			peer.Log().Debug("Failed to request storage ranges", "err", err)
+		}
+	}(req.root)
+}
+
+// assignCodeTask requests a batch of queued bytecodes from the given peer.
+func (s *Syncer) assignCodeTask(peer *Peer) {
+	hashes := make([]common.Hash, 0, maxCodeRequestCount)
+	for hash := range s.codeQueue {
+		delete(s.codeQueue, hash)
+		hashes = append(hashes, hash)
+		if len(hashes) >= maxCodeRequestCount {
+			break
+		}
+	}
+	id := s.newRequestId()
+	req := &codeRequest{
+		peer:   peer.id,
+		id:     id,
+		hashes: hashes,
+	}
+	req.timeout = time.AfterFunc(requestTimeout, func() {
+		peer.Log().Debug("Bytecode request timed out", "reqid", id)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if s.codeReqs[id] == req {
+			delete(s.codeReqs, id)
+			s.idlers[req.peer] = struct{}{}
+			s.revertCodeRequest(req)
+			s.notify()
+		}
+	})
+	s.codeReqs[id] = req
+	delete(s.idlers, peer.id)
+
+	go func() {
+		if err := peer.RequestByteCodes(id, hashes, maxRequestSize); err != nil {
+			peer.Log().Debug("Failed to request bytecodes", "err", err)
+		}
+	}()
+}
+
+// assignHealTask requests a batch of missing trie nodes from the given peer,
+// returning false if there's nothing left to request.
+func (s *Syncer) assignHealTask(peer *Peer) bool {
+	if len(s.healQueue) == 0 {
+		return false
+	}
+	n := len(s.healQueue)
+	if n > maxTrieRequestCount {
+		n = maxTrieRequestCount
+	}
+	hashes := s.healQueue[:n]
+	s.healQueue = s.healQueue[n:]
+
+	id := s.newRequestId()
+	req := &healRequest{
+		peer:   peer.id,
+		id:     id,
+		root:   s.root,
+		hashes: hashes,
+	}
+	req.timeout = time.AfterFunc(requestTimeout, func() {
+		peer.Log().Debug("Trie node request timed out", "reqid", id)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if s.healReqs[id] == req {
+			delete(s.healReqs, id)
+			s.idlers[req.peer] = struct{}{}
+			s.revertHealRequest(req)
+			s.notify()
+		}
+	})
+	s.healReqs[id] = req
+	delete(s.idlers, peer.id)
+
+	go func(root common.Hash) {
+		if err := peer.RequestTrieNodes(id, root, hashes, maxRequestSize); err != nil {
+			peer.Log().Debug("Failed to request trie nodes", "err", err)
+		}
+	}(req.root)
+	return true
+}
+
+// revertAccountRequest returns an account range to the pool of unfilled tasks.
+func (s *Syncer) revertAccountRequest(req *accountRequest) {
+	req.timeout.Stop()
+	if req.task.req == req {
+		req.task.req = nil
+	}
+}
+
+// revertStorageRequest returns a batch of storage tries to the request queue.
+func (s *Syncer) revertStorageRequest(req *storageRequest) {
+	req.timeout.Stop()
+	for _, task := range req.tasks {
+		if task.req == req && s.storageTasks[task.root] == task {
+			task.req = nil
+			s.storagePend[task.root] = task
+		}
+	}
+}
+
+// revertCodeRequest returns a batch of bytecode hashes to the request queue.
+func (s *Syncer) revertCodeRequest(req *codeRequest) {
+	req.timeout.Stop()
+	for _, hash := range req.hashes {
+		if _, ok := s.codeWaiters[hash]; ok {
+			s.codeQueue[hash] = struct{}{}
+		}
+	}
+}
+
+// revertHealRequest returns a batch of trie node hashes to the request queue.
+func (s *Syncer) revertHealRequest(req *healRequest) {
+	req.timeout.Stop()
+	if req.root == s.root {
+		s.healQueue = append(s.healQueue, req.hashes...)
+	}
+}
+
+// OnAccounts is a callback method to invoke when a range of accounts are
+// received from a remote peer.
+func (s *Syncer) OnAccounts(peer *Peer, id uint64, hashes []common.Hash, accounts [][]byte, proof []rlp.RawValue) error {
+	s.lock.Lock()
+	req, ok := s.accountReqs[id]
+	if !ok || req.peer != peer.id {
+		s.lock.Unlock()
+		peer.Log().Debug("Unexpected account range packet", "reqid", id)
+		return nil
+	}
+	delete(s.accountReqs, id)
+	req.timeout.Stop()
+	s.idlers[peer.id] = struct{}{}
+
+	// An empty response means the peer doesn't have the requested state
+	if len(hashes) == 0 && len(proof) == 0 {
+		s.stateless[peer.id] = struct{}{}
+		s.revertAccountRequest(req)
+		s.lock.Unlock()
+
+		peer.Log().Debug("Peer rejected account range request", "root", req.root)
+		s.notify()
+		return nil
+	}
+	s.lock.Unlock()
+
+	// Verify the range against the requested root outside of the lock
+	keys := make([][]byte, len(hashes))
+	for i := range hashes {
+		keys[i] = hashes[i][:]
+	}
+	more, err := trie.VerifyRangeProof(req.root, req.origin[:], keys, accounts, proof)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.notify()
+
+	if err != nil {
+		s.revertAccountRequest(req)
+		return fmt.Errorf("%v: invalid account range: %v", errBadRequest, err)
+	}
+	if req.root != s.root || req.task.req != req {
+		return nil
+	}
+	s.processAccounts(req.task, hashes, accounts, more)
+	return nil
+}
+
+// processAccounts injects a verified range of accounts into its section and
+// schedules the retrieval of their storage tries and bytecodes.
+func (s *Syncer) processAccounts(task *accountTask, hashes []common.Hash, accounts [][]byte, more bool) {
+	task.req = nil
+
+	// Drop anything beyond the section and advance the section's origin
+	for i, hash := range hashes {
+		if bytes.Compare(hash[:], task.last[:]) > 0 {
+			hashes, accounts, more = hashes[:i], accounts[:i], false
+			break
+		}
+	}
+	if !more || len(hashes) == 0 {
+		task.done = true
+	} else if next, ok := incHash(hashes[len(hashes)-1]); ok {
+		task.next = next
+	} else {
+		task.done = true
+	}
+	if len(hashes) == 0 {
+		return
+	}
+	s.accountSynced += uint64(len(hashes))
+
+	// Queue up all the storage tries and bytecodes not yet known locally
+	chunk := &accountChunk{
+		hashes: hashes,
+		bodies: accounts,
+	}
+	for i, blob := range accounts {
+		var account state.Account
+		if err := rlp.DecodeBytes(blob, &account); err != nil {
+			// The range proof passed, so the data is consensus, just unusable
+			log.Error("Failed to decode synced account", "hash", hashes[i], "err", err)
+			chunk.partial = true
+			continue
+		}
+		if account.Root != emptyRoot && !s.known(account.Root) {
+			task, ok := s.storageTasks[account.Root]
+			if !ok {
+				task = &storageTask{
+					root:    account.Root,
+					account: hashes[i],
+				}
+				s.storageTasks[account.Root] = task
+				s.storagePend[account.Root] = task
+			}
+			task.waiters = append(task.waiters, chunk)
+			if task.large {
+				chunk.partial = true
+			}
+			chunk.pending++
+		}
+		if hash := common.BytesToHash(account.CodeHash); hash != emptyCode && !s.known(hash) {
+			if _, ok := s.codeWaiters[hash]; !ok {
+				s.codeQueue[hash] = struct{}{}
+			}
+			s.codeWaiters[hash] = append(s.codeWaiters[hash], chunk)
+			chunk.pending++
+		}
+	}
+	s.chunks++
+	if chunk.pending == 0 {
+		s.commitChunk(chunk)
+	}
+}
+
+// known checks whether a storage trie or bytecode is already present locally.
+func (s *Syncer) known(hash common.Hash) bool {
+	if _, ok := s.storageDone[hash]; ok {
+		return true
+	}
+	blob, err := s.db.Get(hash[:])
+	return err == nil && len(blob) > 0
+}
+
+// commitChunk writes a completed account range into the database as a trie of
+// its own. Any subtrie fully covered by the range is identical to the one in the
+// requested state, the rest is healed later. Ranges depending on piecemeal
+// storage tries are not committed at all, leaving them to the healer.
+func (s *Syncer) commitChunk(chunk *accountChunk) {
+	s.chunks--
+	if chunk.partial {
+		return
+	}
+	s.commitTrie(chunk.hashes, chunk.bodies)
+}
+
+// commitTrie builds a fresh trie out of the given key-value pairs and writes all
+// of its nodes into the database.
+func (s *Syncer) commitTrie(keys []common.Hash, values [][]byte) {
+	tr, _ := trie.New(common.Hash{}, s.db)
+	for i, key := range keys {
+		tr.Update(key[:], values[i])
+	}
+	batch := s.db.NewBatch()
+	if _, err := tr.CommitTo(batch); err != nil {
+		s.fail = err
+		return
+	}
+	if err := batch.Write(); err != nil {
+		s.fail = err
+	}
+}
+
+// OnStorage is a callback method to invoke when ranges of storage slots are
+// received from a remote peer.
+func (s *Syncer) OnStorage(peer *Peer, id uint64, hashes [][]common.Hash, slots [][][]byte, proof []rlp.RawValue) error {
+	s.lock.Lock()
+	req, ok := s.storageReqs[id]
+	if !ok || req.peer != peer.id {
+		s.lock.Unlock()
+		peer.Log().Debug("Unexpected storage ranges packet", "reqid", id)
+		return nil
+	}
+	delete(s.storageReqs, id)
+	req.timeout.Stop()
+	s.idlers[peer.id] = struct{}{}
+
+	// An empty response means the peer doesn't have the requested state
+	if len(hashes) == 0 {
+		s.stateless[peer.id] = struct{}{}
+		s.revertStorageRequest(req)
+		s.lock.Unlock()
+
+		peer.Log().Debug("Peer rejected storage ranges request", "root", req.root)
+		s.notify()
+		return nil
+	}
+	s.lock.Unlock()
+
+	// Verify every range against its storage root outside of the lock
+	var (
+		err  error
+		more bool
+	)
+	if len(hashes) > len(req.tasks) || len(hashes) != len(slots) {
+		err = fmt.Errorf("%v: ranges: %d, slots: %d, requested: %d", errBadRequest, len(hashes), len(slots), len(req.tasks))
+	}
+	for i := 0; i < len(hashes) && err == nil; i++ {
+		keys := make([][]byte, len(hashes[i]))
+		for j := range hashes[i] {
+			keys[j] = hashes[i][j][:]
+		}
+		// Only the last range may be partial and accompanied by a proof
+		var (
+			origin []byte
+			nodes  []rlp.RawValue
+		)
+		if i == len(hashes)-1 {
+			origin, nodes = req.origin, proof
+			if origin == nil {
+				origin = common.Hash{}.Bytes()
+			}
+		}
+		more, err = trie.VerifyRangeProof(req.tasks[i].root, origin, keys, slots[i], nodes)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.notify()
+
+	if err != nil {
+		s.revertStorageRequest(req)
+		return fmt.Errorf("%v: invalid storage range: %v", errBadRequest, err)
+	}
+	if req.root != s.root {
+		return nil
+	}
+	for i, task := range req.tasks {
+		if task.req != req || s.storageTasks[task.root] != task {
+			continue
+		}
+		task.req = nil
+
+		// Reschedule any tries the peer didn't get to
+		if i >= len(hashes) {
+			s.storagePend[task.root] = task
+			continue
+		}
+		s.storageSynced += uint64(len(hashes[i]))
+
+		// Complete tries are written as is, large ones piece by piece
+		s.commitTrie(hashes[i], slots[i])
+		if i == len(hashes)-1 && more {
+			if !task.large {
+				task.large = true
+				for _, chunk := range task.waiters {
+					chunk.partial = true
+				}
+			}
+			if next, ok := incHash(hashes[i][len(hashes[i])-1]); ok {
+				task.next = next
+				s.storagePend[task.root] = task
+				continue
+			}
+		}
+		if task.large {
+			s.storageDone[task.root] = struct{}{}
+		}
+		delete(s.storageTasks, task.root)
+		for _, chunk := range task.waiters {
+			if chunk.pending--; chunk.pending == 0 {
+				s.commitChunk(chunk)
+			}
+		}
+	}
+	return nil
+}
+
+// OnByteCodes is a callback method to invoke when a batch of contract bytecodes
+// are received from a remote peer.
+func (s *Syncer) OnByteCodes(peer *Peer, id uint64, codes [][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.notify()
+
+	req, ok := s.codeReqs[id]
+	if !ok || req.peer != peer.id {
+		peer.Log().Debug("Unexpected bytecode packet", "reqid", id)
+		return nil
+	}
+	delete(s.codeReqs, id)
+	req.timeout.Stop()
+	s.idlers[peer.id] = struct{}{}
+
+	// An empty response means the peer doesn't have the requested codes
+	if len(codes) == 0 {
+		s.stateless[peer.id] = struct{}{}
+		s.revertCodeRequest(req)
+
+		peer.Log().Debug("Peer rejected bytecode request")
+		return nil
+	}
+	// Cross reference the delivered codes with the requested hashes
+	requested := make(map[common.Hash]struct{}, len(req.hashes))
+	for _, hash := range req.hashes {
+		requested[hash] = struct{}{}
+	}
+	batch := s.db.NewBatch()
+	for _, code := range codes {
+		hash := crypto.Keccak256Hash(code)
+		if _, ok := requested[hash]; !ok {
+			s.revertCodeRequest(req)
+			return fmt.Errorf("%v: unrequested bytecode %x", errBadRequest, hash)
+		}
+		delete(requested, hash)
+		if err := batch.Put(hash[:], code); err != nil {
+			s.fail = err
+			return nil
+		}
+	}
+	if err := batch.Write(); err != nil {
+		s.fail = err
+		return nil
+	}
+	s.codeSynced += uint64(len(codes))
+
+	// Release the waiting account chunks and requeue anything undelivered
+	for _, hash := range req.hashes {
+		if _, ok := requested[hash]; ok {
+			if _, ok := s.codeWaiters[hash]; ok {
+				s.codeQueue[hash] = struct{}{}
+			}
+			continue
+		}
+		for _, chunk := range s.codeWaiters[hash] {
+			if chunk.pending--; chunk.pending == 0 {
+				s.commitChunk(chunk)
+			}
+		}
+		delete(s.codeWaiters, hash)
+	}
+	return nil
+}
+
+// OnTrieNodes is a callback method to invoke when a batch of trie nodes are
+// received from a remote peer.
+func (s *Syncer) OnTrieNodes(peer *Peer, id uint64, nodes [][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.notify()
+
+	req, ok := s.healReqs[id]
+	if !ok || req.peer != peer.id {
+		peer.Log().Debug("Unexpected trie node packet", "reqid", id)
+		return nil
+	}
+	delete(s.healReqs, id)
+	req.timeout.Stop()
+	s.idlers[peer.id] = struct{}{}
+
+	// An empty response means the peer doesn't have the requested state
+	if len(nodes) == 0 {
+		s.stateless[peer.id] = struct{}{}
+		s.revertHealRequest(req)
+
+		peer.Log().Debug("Peer rejected trie node request", "root", req.root)
+		return nil
+	}
+	if req.root != s.root || s.healer == nil {
+		return nil
+	}
+	// Cross reference the delivered nodes with the requested hashes
+	requested := make(map[common.Hash]struct{}, len(req.hashes))
+	for _, hash := range req.hashes {
+		requested[hash] = struct{}{}
+	}
+	results := make([]trie.SyncResult, len(nodes))
+	for i, blob := range nodes {
+		hash := crypto.Keccak256Hash(blob)
+		if _, ok := requested[hash]; !ok {
+			s.revertHealRequest(req)
+			return fmt.Errorf("%v: unrequested trie node %x", errBadRequest, hash)
+		}
+		delete(requested, hash)
+		results[i] = trie.SyncResult{Hash: hash, Data: blob}
+	}
+	// Inject the nodes one by one, as some might have been delivered by others
+	batch := s.db.NewBatch()
+	for _, result := range results {
+		_, _, err := s.healer.Process([]trie.SyncResult{result}, batch)
+		switch err {
+		case nil:
+			s.nodesHealed++
+		case trie.ErrNotRequested:
+			// Already delivered by someone else, ignore
+		default:
+			log.Warn("Failed to heal trie node", "hash", result.Hash, "err", err)
+		}
+	}
+	if err := batch.Write(); err != nil {
+		s.fail = err
+		return nil
+	}
+	// Requeue anything the peer didn't deliver
+	for _, hash := range req.hashes {
+		if _, ok := requested[hash]; ok {
+			s.healQueue = append(s.healQueue, hash)
+		}
+	}
+	return nil
+}
+
+// incHash returns the hash following the given one, or false on overflow.
+func incHash(h common.Hash) (common.Hash, bool) {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			return h, true
+		}
+	}
+	return common.Hash{}, false
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core/state"
+	"github.com/megatilt/go-tilt/p2p"
+	"github.com/megatilt/go-tilt/tiltdb"
+)
+
+// connectPeer links the syncer to a simulated remote peer serving the state in
+// the given database. If drop is positive, the remote peer disconnects after
+// serving that many requests. The returned function tears the connection down.
+func connectPeer(syncer *Syncer, name string, db tiltdb.Database, drop int) func() {
+	app, net := p2p.MsgPipe()
+
+	go handle(syncer.db, syncer, newTestPeer(name, app))
+	go func() {
+		remote := newTestPeer(name, net)
+		for i := 0; drop <= 0 || i < drop; i++ {
+			if err := handleMsg(db, nil, remote); err != nil {
+				break
+			}
+		}
+		net.Close()
+	}()
+	return func() { app.Close() }
+}
+
+// syncState runs a sync cycle of the given state root, failing if it doesn't
+// complete in a reasonable amount of time.
+func syncState(t *testing.T, syncer *Syncer, root common.Hash) {
+	cancel := make(chan struct{})
+	timer := time.AfterFunc(30*time.Second, func() { close(cancel) })
+	defer timer.Stop()
+
+	if err := syncer.Sync(root, cancel); err != nil {
+		t.Fatalf("failed to sync state %x: %v", root, err)
+	}
+}
+
+// checkStateEqual verifies that every trie node and bytecode of the state with
+// the given root is present in the synced database, and that the accounts match.
+func checkStateEqual(t *testing.T, src, dst tiltdb.Database, root common.Hash, addrs []common.Address) {
+	srcState, err := state.New(root, src)
+	if err != nil {
+		t.Fatalf("failed to open source state: %v", err)
+	}
+	dstState, err := state.New(root, dst)
+	if err != nil {
+		t.Fatalf("failed to open synced state: %v", err)
+	}
+	nodes := 0
+	for it := state.NewNodeIterator(srcState); it.Next(); {
+		if it.Hash == (common.Hash{}) {
+			continue
+		}
+		if blob, err := dst.Get(it.Hash[:]); err != nil || len(blob) == 0 {
+			t.Errorf("state entry %x missing from synced database", it.Hash)
+		}
+		nodes++
+	}
+	it := state.NewNodeIterator(dstState)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("synced state incomplete after %d entries: %v", nodes, it.Error)
+	}
+	for _, addr := range addrs {
+		if have, want := dstState.GetBalance(addr), srcState.GetBalance(addr); have.Cmp(want) != 0 {
+			t.Errorf("account %x: balance mismatch: have %v, want %v", addr, have, want)
+		}
+		if have, want := dstState.GetNonce(addr), srcState.GetNonce(addr); have != want {
+			t.Errorf("account %x: nonce mismatch: have %v, want %v", addr, have, want)
+		}
+		if have, want := dstState.GetCodeHash(addr), srcState.GetCodeHash(addr); have != want {
+			t.Errorf("account %x: code hash mismatch: have %x, want %x", addr, have, want)
+		}
+	}
+}
+
+// Tests that a state can be synced from a single peer.
+func TestSync(t *testing.T) {
+	src, root, addrs := makeTestState(200, 10, 10)
+	dst, _ := tiltdb.NewMemDatabase()
+
+	syncer := NewSyncer(dst)
+	defer connectPeer(syncer, "remote-1", src, 0)()
+
+	syncState(t, syncer, root)
+	checkStateEqual(t, src, dst, root, addrs)
+}
+
+// Tests that a state can be synced concurrently from multiple peers.
+func TestSyncMultiplePeers(t *testing.T) {
+	src, root, addrs := makeTestState(1000, 10, 10)
+	dst, _ := tiltdb.NewMemDatabase()
+
+	syncer := NewSyncer(dst)
+	for i := 0; i < 4; i++ {
+		defer connectPeer(syncer, fmt.Sprintf("remote-%d", i), src, 0)()
+	}
+	syncState(t, syncer, root)
+	checkStateEqual(t, src, dst, root, addrs)
+}
+
+// Tests that a contract storage too large for a single response is retrieved in
+// sections and still results in a complete state.
+func TestSyncLargeStorage(t *testing.T) {
+	src, root, addrs := makeTestState(100, 10, 20000)
+	dst, _ := tiltdb.NewMemDatabase()
+
+	syncer := NewSyncer(dst)
+	defer connectPeer(syncer, "remote-1", src, 0)()
+
+	syncState(t, syncer, root)
+	checkStateEqual(t, src, dst, root, addrs)
+}
+
+// Tests that peers not having the requested state are skipped over and the sync
+// finishes from the ones that do.
+func TestSyncStatelessPeer(t *testing.T) {
+	src, root, addrs := makeTestState(200, 10, 10)
+	dst, _ := tiltdb.NewMemDatabase()
+	empty, _ := tiltdb.NewMemDatabase()
+
+	syncer := NewSyncer(dst)
+	defer connectPeer(syncer, "stateless", empty, 0)()
+	defer connectPeer(syncer, "remote-1", src, 0)()
+
+	syncState(t, syncer, root)
+	checkStateEqual(t, src, dst, root, addrs)
+}
+
+// Tests that the requests of a peer dropping mid-sync are rescheduled to the
+// remaining peers.
+func TestSyncDroppedPeer(t *testing.T) {
+	src, root, addrs := makeTestState(1000, 10, 10)
+	dst, _ := tiltdb.NewMemDatabase()
+
+	syncer := NewSyncer(dst)
+	defer connectPeer(syncer, "dropping", src, 3)()
+	defer connectPeer(syncer, "remote-1", src, 0)()
+
+	syncState(t, syncer, root)
+	checkStateEqual(t, src, dst, root, addrs)
+}
+
+// Tests that syncing a new state on top of an older one only retrieves the
+// differences and heals the result into the exact new state.
+func TestSyncUpdatedState(t *testing.T) {
+	src, root, addrs := makeTestState(200, 10, 10)
+	dst, _ := tiltdb.NewMemDatabase()
+
+	syncer := NewSyncer(dst)
+	defer connectPeer(syncer, "remote-1", src, 0)()
+
+	syncState(t, syncer, root)
+	checkStateEqual(t, src, dst, root, addrs)
+
+	// Modify a few accounts and storage slots, then sync the updated state
+	statedb, _ := state.New(root, src)
+	for i := 0; i < len(addrs); i += 7 {
+		statedb.AddBalance(addrs[i], big.NewInt(1))
+		statedb.SetState(addrs[i], common.Hash{1}, common.Hash{2})
+	}
+	updated, err := statedb.Commit()
+	if err != nil {
+		t.Fatalf("failed to commit updated state: %v", err)
+	}
+	syncState(t, syncer, updated)
+	checkStateEqual(t, src, dst, updated, addrs)
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/log"
 	"github.com/megatilt/go-tilt/tilt/downloader"
 	"github.com/megatilt/go-tilt/p2p/discover"
 )
@@ -33,6 +34,8 @@ const (
 
 	// This is the target size for the packs of transactions sent by txsyncLoop.
 	// A pack can get larger than this if a single transactions exceeds this size.
+	// Peers supporting announcements get the transaction hashes instead, in
+	// which case the pack size is accounted for by the hashes only.
 	txsyncPackSize = 100 * 1024
 )
 
@@ -77,7 +80,11 @@ func (pm *ProtocolManager) txsyncLoop() {
 		pack.txs = pack.txs[:0]
 		for i := 0; i < len(s.txs) && size < txsyncPackSize; i++ {
 			pack.txs = append(pack.txs, s.txs[i])
-			size += s.txs[i].Size()
+			if s.p.version >= eth64 {
+				size += common.HashLength
+			} else {
+				size += s.txs[i].Size()
+			}
 		}
 		// Remove the transactions that will be sent.
 		s.txs = s.txs[:copy(s.txs, s.txs[len(pack.txs):])]
@@ -87,7 +94,17 @@ func (pm *ProtocolManager) txsyncLoop() {
 		// Send the pack in the background.
 		s.p.Log().Trace("Sending batch of transactions", "count", len(pack.txs), "bytes", size)
 		sending = true
-		go func() { done <- pack.p.SendTransactions(pack.txs) }()
+		go func() {
+			if pack.p.version < eth64 {
+				done <- pack.p.SendTransactions(pack.txs)
+				return
+			}
+			hashes := make([]common.Hash, len(pack.txs))
+			for i, tx := range pack.txs {
+				hashes[i] = tx.Hash()
+			}
+			done <- pack.p.SendPooledTransactionHashes(hashes)
+		}()
 	}
 
 	// pick chooses the next pending sync.
@@ -134,6 +151,8 @@ func (pm *ProtocolManager) syncer() {
 	// Start and ensure cleanup of sync mechanisms
 	pm.fetcher.Start()
 	defer pm.fetcher.Stop()
+	pm.txFetcher.Start()
+	defer pm.txFetcher.Stop()
 	defer pm.downloader.Terminate()
 
 	// Wait for different events to fire synchronisation operations
@@ -173,9 +192,16 @@ func (pm *ProtocolManager) synchronise(peer *peer) {
 	}
 	// Otherwise try to sync with the downloader
 	mode := downloader.FullSync
+	if atomic.LoadUint32(&pm.snapSync) == 1 {
+		mode = downloader.SnapSync
+	}
 	if err := pm.downloader.Synchronise(peer.id, pHead, pTd, mode); err != nil {
 		return
 	}
+	if atomic.LoadUint32(&pm.snapSync) == 1 {
+		log.Info("Snap sync complete, auto disabling")
+		atomic.StoreUint32(&pm.snapSync, 0)
+	}
 	atomic.StoreUint32(&pm.acceptTxs, 1) // Mark initial sync done
 	if head := pm.blockchain.CurrentBlock(); head.NumberU64() > 0 {
 		// We've completed a sync cycle, notify all peers of new state. This path is
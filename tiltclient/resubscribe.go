@@ -0,0 +1,269 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package tiltclient
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/megatilt/go-tilt"
+	"github.com/megatilt/go-tilt/common"
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/event"
+	"github.com/megatilt/go-tilt/rpc"
+)
+
+const (
+	// maxBackfillBlocks is the maximum number of blocks backfilled after a resilient
+	// subscription was re-established. Longer outages are reported as gaps.
+	maxBackfillBlocks = 1024
+
+	// dedupWindow is the number of blocks for which delivered items are remembered
+	// to filter out the duplicates between backfilled and newly subscribed ones.
+	dedupWindow = 64
+)
+
+// resubscribeBackoff is the maximum wait between the attempts to re-establish a
+// resilient subscription.
+var resubscribeBackoff = 30 * time.Second
+
+// SubscribeNewHeadResilient subscribes to notifications about the current blockchain
+// head like SubscribeNewHead, but survives the loss of the connection to the server
+// by resubscribing after reconnecting. Heads missed during an outage are backfilled
+// by number, so ch sees a gap-free stream. If that's not possible, the error is sent
+// on gaps (if non-nil) in stream order, ahead of the heads following the outage.
+func (ec *Client) SubscribeNewHeadResilient(ctx context.Context, ch chan<- *types.Header, gaps chan<- error) (tiltnet.Subscription, error) {
+	head, err := ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		in      = make(chan *types.Header)
+		gapCh   = make(chan error)
+		done    = make(chan struct{})
+		lock    sync.Mutex
+		last    = head.Number.Uint64()
+		handled = map[uint64]common.Hash{last: head.Hash()}
+	)
+	opts := rpc.ResubscribeOptions{
+		BackoffMax: resubscribeBackoff,
+		Backfill: func(ctx context.Context) error {
+			lock.Lock()
+			from := last + 1
+			lock.Unlock()
+
+			head, err := ec.HeaderByNumber(ctx, nil)
+			if err != nil {
+				return err
+			}
+			to := head.Number.Uint64()
+			if to >= from+maxBackfillBlocks {
+				return fmt.Errorf("too many blocks missed (#%d-#%d)", from, to)
+			}
+			for number := from; number <= to; number++ {
+				header, err := ec.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+				if err != nil {
+					return err
+				}
+				select {
+				case in <- header:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		},
+		Gap: func(err error) {
+			select {
+			case gapCh <- err:
+			case <-done:
+			}
+		},
+	}
+	sub, err := ec.c.TiltSubscribeResilient(ctx, in, opts, "newHeads", map[string]struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		defer close(done) // Unblock any gap report before tearing down
+
+		for {
+			select {
+			case header := <-in:
+				// Skip heads already delivered, track the latest one otherwise
+				number := header.Number.Uint64()
+
+				lock.Lock()
+				if handled[number] == header.Hash() {
+					lock.Unlock()
+					continue
+				}
+				handled[number] = header.Hash()
+				delete(handled, number-dedupWindow)
+				last = number
+				lock.Unlock()
+
+				select {
+				case ch <- header:
+				case <-quit:
+					return nil
+				}
+			case err := <-gapCh:
+				if gaps != nil {
+					select {
+					case gaps <- err:
+					case <-quit:
+						return nil
+					}
+				}
+			case err := <-sub.Err():
+				return err // Client closed
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+// logKey uniquely identifies a log within the chain.
+type logKey struct {
+	block common.Hash
+	index uint
+}
+
+// SubscribeFilterLogsResilient subscribes to the results of a streaming filter query
+// like SubscribeFilterLogs, but survives the loss of the connection to the server by
+// resubscribing after reconnecting. Logs missed during an outage are backfilled by
+// running the filter query on the block range of the outage, so ch sees a gap-free
+// stream. If that's not possible, the error is sent on gaps (if non-nil) in stream
+// order, ahead of the logs following the outage.
+//
+// As the progress of the chain is only observed through matching logs, an outage
+// following a long stretch of blocks without any may be reported as a gap.
+func (ec *Client) SubscribeFilterLogsResilient(ctx context.Context, q tiltnet.FilterQuery, ch chan<- types.Log, gaps chan<- error) (tiltnet.Subscription, error) {
+	head, err := ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		in      = make(chan types.Log)
+		gapCh   = make(chan error)
+		done    = make(chan struct{})
+		lock    sync.Mutex
+		last    = head.Number.Uint64()
+		handled = make(map[logKey]uint64)
+	)
+	opts := rpc.ResubscribeOptions{
+		BackoffMax: resubscribeBackoff,
+		Backfill: func(ctx context.Context) error {
+			// Refetch the last block with logs too, it might have been cut short
+			lock.Lock()
+			from := last
+			lock.Unlock()
+
+			head, err := ec.HeaderByNumber(ctx, nil)
+			if err != nil {
+				return err
+			}
+			to := head.Number.Uint64()
+			if to >= from+maxBackfillBlocks {
+				return fmt.Errorf("too many blocks missed (#%d-#%d)", from, to)
+			}
+			query := q
+			query.FromBlock, query.ToBlock = new(big.Int).SetUint64(from), head.Number
+			logs, err := ec.FilterLogs(ctx, query)
+			if err != nil {
+				return err
+			}
+			for _, log := range logs {
+				select {
+				case in <- log:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			// Everything up to the head was covered, even if it had no matching logs
+			lock.Lock()
+			if to > last {
+				last = to
+			}
+			lock.Unlock()
+			return nil
+		},
+		Gap: func(err error) {
+			select {
+			case gapCh <- err:
+			case <-done:
+			}
+		},
+	}
+	sub, err := ec.c.TiltSubscribeResilient(ctx, in, opts, "logs", toFilterArg(q))
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		defer close(done) // Unblock any gap report before tearing down
+
+		for {
+			select {
+			case log := <-in:
+				// Skip logs already delivered (reorg removals are always passed on),
+				// track the block of the latest one otherwise
+				key := logKey{log.BlockHash, log.Index}
+
+				lock.Lock()
+				if _, ok := handled[key]; ok && !log.Removed {
+					lock.Unlock()
+					continue
+				}
+				handled[key] = log.BlockNumber
+				if log.BlockNumber > last {
+					last = log.BlockNumber
+					for key, number := range handled {
+						if number+dedupWindow < last {
+							delete(handled, key)
+						}
+					}
+				}
+				lock.Unlock()
+
+				select {
+				case ch <- log:
+				case <-quit:
+					return nil
+				}
+			case err := <-gapCh:
+				if gaps != nil {
+					select {
+					case gaps <- err:
+					case <-quit:
+						return nil
+					}
+				}
+			case err := <-sub.Err():
+				return err // Client closed
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2017 The go-tiltnet Authors
+// This file is part of the go-tiltnet library.
+//
+// The go-tiltnet library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-tiltnet library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-tiltnet library. If not, see <http://www.gnu.org/licenses/>.
+
+package tiltclient
+
+import (
+	"context"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megatilt/go-tilt/core/types"
+	"github.com/megatilt/go-tilt/rpc"
+)
+
+// TestChainService is a minimal tilt API serving a growing chain of headers.
+type TestChainService struct {
+	lock  sync.Mutex
+	chain []*types.Header
+}
+
+// extend appends the given number of headers to the chain.
+func (s *TestChainService) extend(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := 0; i < n; i++ {
+		header := &types.Header{
+			Number:     big.NewInt(int64(len(s.chain))),
+			Difficulty: big.NewInt(1),
+			GasLimit:   big.NewInt(0),
+			GasUsed:    big.NewInt(0),
+			Time:       big.NewInt(0),
+			Extra:      []byte{},
+		}
+		if len(s.chain) > 0 {
+			header.ParentHash = s.chain[len(s.chain)-1].Hash()
+		}
+		s.chain = append(s.chain, header)
+	}
+}
+
+func (s *TestChainService) GetBlockByNumber(number rpc.BlockNumber, full bool) (*types.Header, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if number == rpc.LatestBlockNumber {
+		return s.chain[len(s.chain)-1], nil
+	}
+	if number < 0 || int(number) >= len(s.chain) {
+		return nil, nil
+	}
+	return s.chain[number], nil
+}
+
+// NewHeads streams every new head, starting with the current one.
+func (s *TestChainService) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+
+	s.lock.Lock()
+	next := len(s.chain) - 1
+	s.lock.Unlock()
+
+	go func() {
+		for {
+			// Notifications are dropped until the subscription is activated, so the
+			// current head is repeated until a new one arrives
+			s.lock.Lock()
+			heads := s.chain[next:]
+			s.lock.Unlock()
+
+			for _, head := range heads {
+				if err := notifier.Notify(sub.ID, head); err != nil {
+					return
+				}
+			}
+			next += len(heads) - 1
+
+			select {
+			case <-time.After(5 * time.Millisecond):
+			case <-sub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// chainServer serves the test chain over IPC, tracking the connections so they
+// can be dropped.
+type chainServer struct {
+	service  *TestChainService
+	listener net.Listener
+
+	lock  sync.Mutex
+	conns []net.Conn
+}
+
+func newChainServer(t *testing.T, endpoint string) *chainServer {
+	service := new(TestChainService)
+	service.extend(3)
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("tilt", service); err != nil {
+		t.Fatalf("failed to register service: %v", err)
+	}
+	listener, err := net.Listen("unix", endpoint)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	cs := &chainServer{service: service, listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			cs.lock.Lock()
+			cs.conns = append(cs.conns, conn)
+			cs.lock.Unlock()
+
+			go server.ServeCodec(rpc.NewJSONCodec(conn), rpc.OptionMethodInvocation|rpc.OptionSubscriptions)
+		}
+	}()
+	return cs
+}
+
+// drop closes all the connections currently served.
+func (cs *chainServer) drop() {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	for _, conn := range cs.conns {
+		conn.Close()
+	}
+	cs.conns = nil
+}
+
+// Tests that resilient head subscriptions backfill the heads missed while
+// reconnecting, delivering every head exactly once, and fail with the client
+// quit error once the client is closed.
+func TestSubscribeNewHeadResilient(t *testing.T) {
+	defer func(backoff time.Duration) { resubscribeBackoff = backoff }(resubscribeBackoff)
+	resubscribeBackoff = 50 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "tiltclient-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	endpoint := filepath.Join(dir, "test.ipc")
+	server := newChainServer(t, endpoint)
+	defer server.listener.Close()
+
+	rpcClient, err := rpc.DialIPC(context.Background(), endpoint)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	client := NewClient(rpcClient)
+
+	var (
+		heads = make(chan *types.Header)
+		gaps  = make(chan error, 1)
+	)
+	sub, err := client.SubscribeNewHeadResilient(context.Background(), heads, gaps)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	expect := func(from, to int) {
+		for number := from; number <= to; number++ {
+			select {
+			case head := <-heads:
+				if head.Number.Int64() != int64(number) {
+					t.Fatalf("head mismatch: have #%d, want #%d", head.Number, number)
+				}
+				server.service.lock.Lock()
+				want := server.service.chain[number].Hash()
+				server.service.lock.Unlock()
+				if head.Hash() != want {
+					t.Fatalf("head #%d hash mismatch: have %x, want %x", number, head.Hash(), want)
+				}
+			case err := <-gaps:
+				t.Fatalf("gap reported: %v", err)
+			case err := <-sub.Err():
+				t.Fatalf("subscription failed: %v", err)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("head #%d timed out", number)
+			}
+		}
+	}
+	// The current head #2 was seen when subscribing, it's not delivered again
+	server.service.extend(1)
+	expect(3, 3)
+
+	// Drop the connection and extend the chain while disconnected
+	server.drop()
+	server.service.extend(2)
+	expect(4, 5)
+
+	server.service.extend(1)
+	expect(6, 6)
+
+	// Make sure no duplicates are delivered from the new subscription
+	select {
+	case head := <-heads:
+		t.Fatalf("unexpected head #%d", head.Number)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Closing the client tears the subscription down with an error
+	rpcClient.Close()
+	select {
+	case err := <-sub.Err():
+		if err != rpc.ErrClientQuit {
+			t.Fatalf("subscription error mismatch: have %v, want %v", err, rpc.ErrClientQuit)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("subscription not torn down")
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"math/big"
 
 	"github.com/megatilt/go-tilt"
+	"github.com/megatilt/go-tilt/accounts/abi"
 	"github.com/megatilt/go-tilt/common"
 	"github.com/megatilt/go-tilt/common/hexutil"
 	"github.com/megatilt/go-tilt/core/types"
@@ -290,6 +291,38 @@ func (ec *Client) NonceAt(ctx context.Context, account common.Address, blockNumb
 	return uint64(result), err
 }
 
+// BalanceAtBlock returns the dwan balance of the given account at the block selected
+// either by number or by hash.
+func (ec *Client) BalanceAtBlock(ctx context.Context, account common.Address, block rpc.BlockNumberOrHash) (*big.Int, error) {
+	var result hexutil.Big
+	err := ec.c.CallContext(ctx, &result, "tilt_getBalance", account, block)
+	return (*big.Int)(&result), err
+}
+
+// StorageAtBlock returns the value of key in the contract storage of the given account
+// at the block selected either by number or by hash.
+func (ec *Client) StorageAtBlock(ctx context.Context, account common.Address, key common.Hash, block rpc.BlockNumberOrHash) ([]byte, error) {
+	var result hexutil.Bytes
+	err := ec.c.CallContext(ctx, &result, "tilt_getStorageAt", account, key, block)
+	return result, err
+}
+
+// CodeAtBlock returns the contract code of the given account at the block selected
+// either by number or by hash.
+func (ec *Client) CodeAtBlock(ctx context.Context, account common.Address, block rpc.BlockNumberOrHash) ([]byte, error) {
+	var result hexutil.Bytes
+	err := ec.c.CallContext(ctx, &result, "tilt_getCode", account, block)
+	return result, err
+}
+
+// NonceAtBlock returns the account nonce of the given account at the block selected
+// either by number or by hash.
+func (ec *Client) NonceAtBlock(ctx context.Context, account common.Address, block rpc.BlockNumberOrHash) (uint64, error) {
+	var result hexutil.Uint64
+	err := ec.c.CallContext(ctx, &result, "tilt_getTransactionCount", account, block)
+	return uint64(result), err
+}
+
 // Filters
 
 // FilterLogs executes a filter query.
@@ -365,11 +398,27 @@ func (ec *Client) PendingTransactionCount(ctx context.Context) (uint, error) {
 // blockNumber selects the block height at which the call runs. It can be nil, in which
 // case the code is taken from the latest known block. Note that state from very old
 // blocks might not be available.
+//
+// Calls the node reports as reverted along with their revert payload fail with an
+// *abi.RevertError, holding the decoded reason of Error(string) reverts.
 func (ec *Client) CallContract(ctx context.Context, msg tiltnet.CallMsg, blockNumber *big.Int) ([]byte, error) {
 	var hex hexutil.Bytes
 	err := ec.c.CallContext(ctx, &hex, "tilt_call", toCallArg(msg), toBlockNumArg(blockNumber))
 	if err != nil {
-		return nil, err
+		return nil, revertError(err)
+	}
+	return hex, nil
+}
+
+// CallContractAtBlock executes a message call transaction, which is directly executed
+// in the VM of the node, but never mined into the blockchain. The call runs on the state
+// of the block selected either by number or by hash, so consecutive calls pinned to the
+// same hash are unaffected by reorgs.
+func (ec *Client) CallContractAtBlock(ctx context.Context, msg tiltnet.CallMsg, block rpc.BlockNumberOrHash) ([]byte, error) {
+	var hex hexutil.Bytes
+	err := ec.c.CallContext(ctx, &hex, "tilt_call", toCallArg(msg), block)
+	if err != nil {
+		return nil, revertError(err)
 	}
 	return hex, nil
 }
@@ -380,7 +429,7 @@ func (ec *Client) PendingCallContract(ctx context.Context, msg tiltnet.CallMsg)
 	var hex hexutil.Bytes
 	err := ec.c.CallContext(ctx, &hex, "tilt_call", toCallArg(msg), "pending")
 	if err != nil {
-		return nil, err
+		return nil, revertError(err)
 	}
 	return hex, nil
 }
@@ -420,6 +469,29 @@ func (ec *Client) SendTransaction(ctx context.Context, tx *types.Transaction) er
 	return ec.c.CallContext(ctx, nil, "tilt_sendRawTransaction", common.ToHex(data))
 }
 
+// revertError converts the error of a call the node reported as reverted into an
+// *abi.RevertError if it carries the revert payload, leaving others untouched.
+// Custom errors can't be resolved without the contract's ABI, so only their raw
+// payload is retained.
+func revertError(err error) error {
+	rpcErr, ok := err.(rpc.DataError)
+	if !ok {
+		return err
+	}
+	hex, ok := rpcErr.ErrorData().(string)
+	if !ok {
+		return err
+	}
+	data, derr := hexutil.Decode(hex)
+	if derr != nil || len(data) == 0 {
+		return err
+	}
+	if reason, uerr := abi.UnpackRevert(data); uerr == nil {
+		return &abi.RevertError{Name: "Error", Reason: reason, Data: data}
+	}
+	return &abi.RevertError{Data: data}
+}
+
 func toCallArg(msg tiltnet.CallMsg) interface{} {
 	arg := map[string]interface{}{
 		"from": msg.From,
@@ -146,3 +146,341 @@ func get(tn node, key []byte) ([]byte, node) {
 	}
 	return nil, tn.(valueNode)
 }
+
+// proofSet is an in-memory key-value store of merkle proof nodes, keyed by
+// their hashes, used to resolve the nodes along proven paths.
+type proofSet map[string][]byte
+
+func (s proofSet) Get(key []byte) ([]byte, error) {
+	if blob, ok := s[string(key)]; ok {
+		return blob, nil
+	}
+	return nil, errors.New("missing proof node")
+}
+
+func (s proofSet) Put(key, value []byte) error {
+	s[string(key)] = common.CopyBytes(value)
+	return nil
+}
+
+// VerifyRangeProof checks whether the given sorted key-value pairs make up the
+// entire contents of the trie with the given root hash in the range beginning
+// at origin and ending at the last key. The proof must contain the merkle proofs
+// of both origin and the last key (possibly merged together). All keys are
+// expected to be of equal length, as is the case for the hashed state tries.
+//
+// Special cases: if no proof is given, the key-value pairs must make up the
+// entire trie; if no key-value pairs are given, the proof of origin must show
+// that no keys exist in the trie from origin onward.
+//
+// The returned flag reports whether there are further keys in the trie beyond
+// the last delivered one.
+func VerifyRangeProof(rootHash common.Hash, origin []byte, keys [][]byte, values [][]byte, proof []rlp.RawValue) (bool, error) {
+	if len(keys) != len(values) {
+		return false, fmt.Errorf("inconsistent range data, keys: %d, values: %d", len(keys), len(values))
+	}
+	for i, key := range keys {
+		if i > 0 && bytes.Compare(keys[i-1], key) >= 0 {
+			return false, errors.New("range is not monotonically increasing")
+		}
+		if len(values[i]) == 0 {
+			return false, errors.New("range contains empty value")
+		}
+	}
+	if len(keys) > 0 && bytes.Compare(origin, keys[0]) > 0 {
+		return false, errors.New("range starts before origin")
+	}
+	// If there's no proof, the key-value pairs must be the entire trie
+	if len(proof) == 0 {
+		tr := new(Trie)
+		for i, key := range keys {
+			tr.Update(key, values[i])
+		}
+		if hash := tr.Hash(); hash != rootHash {
+			return false, fmt.Errorf("range root mismatch: have %x, want %x", hash, rootHash)
+		}
+		return false, nil
+	}
+	// Otherwise assemble a partial trie resolving its nodes from the proof
+	set, sha := make(proofSet), sha3.NewKeccak256()
+	for i, blob := range proof {
+		sha.Reset()
+		sha.Write(blob)
+		hash := sha.Sum(nil)
+
+		if _, err := decodeNode(hash, blob, 0); err != nil {
+			return false, fmt.Errorf("bad proof node %d: %v", i, err)
+		}
+		set.Put(hash, blob)
+	}
+	tr := &Trie{db: set, originalRoot: rootHash}
+	root, err := tr.resolveHash(rootHash[:], nil, nil)
+	if err != nil {
+		return false, err
+	}
+	// Remove everything within the range from the trie and reinsert the delivered
+	// data. If the range is complete, we must arrive back at the original root.
+	left := keybytesToHex(origin)
+	if len(keys) == 0 {
+		if tr.root, err = tr.unsetFrom(root, left); err != nil {
+			return false, err
+		}
+		if hash := tr.Hash(); hash != rootHash {
+			return false, errors.New("more entries available")
+		}
+		return false, nil
+	}
+	right := keybytesToHex(keys[len(keys)-1])
+
+	more, err := tr.hasRightElement(root, right)
+	if err != nil {
+		return false, err
+	}
+	if tr.root, err = tr.unsetRange(root, left, right); err != nil {
+		return false, err
+	}
+	for i, key := range keys {
+		if err := tr.TryUpdate(key, values[i]); err != nil {
+			return false, err
+		}
+	}
+	if hash := tr.Hash(); hash != rootHash {
+		return false, fmt.Errorf("range root mismatch: have %x, want %x", hash, rootHash)
+	}
+	return more, nil
+}
+
+// compareKey compares a node key against the corresponding section of a path.
+func compareKey(key, path []byte) int {
+	if len(path) > len(key) {
+		path = path[:len(key)]
+	}
+	return bytes.Compare(key, path)
+}
+
+// unsetRange removes all the values within [left, right] from the subtrie
+// rooted at n, resolving the nodes along both boundary paths as needed.
+func (t *Trie) unsetRange(n node, left, right []byte) (node, error) {
+	switch rn := n.(type) {
+	case *fullNode:
+		if len(left) == 0 || len(right) == 0 {
+			return nil, errors.New("invalid proof path")
+		}
+		var err error
+		nn := rn.copy()
+		nn.flags = t.newFlag()
+
+		if left[0] == right[0] {
+			if nn.Children[left[0]], err = t.unsetRange(rn.Children[left[0]], left[1:], right[1:]); err != nil {
+				return nil, err
+			}
+			return collapseEmpty(nn), nil
+		}
+		for i := left[0] + 1; i < right[0]; i++ {
+			nn.Children[i] = nil
+		}
+		if nn.Children[left[0]], err = t.unsetFrom(rn.Children[left[0]], left[1:]); err != nil {
+			return nil, err
+		}
+		if nn.Children[right[0]], err = t.unsetUntil(rn.Children[right[0]], right[1:]); err != nil {
+			return nil, err
+		}
+		return collapseEmpty(nn), nil
+
+	case *shortNode:
+		lcmp, rcmp := compareKey(rn.Key, left), compareKey(rn.Key, right)
+		if lcmp < 0 || rcmp > 0 {
+			return rn, nil // Subtrie fully outside of the range
+		}
+		if lcmp > 0 && rcmp < 0 {
+			return nil, nil // Subtrie fully inside the range
+		}
+		var (
+			child node
+			err   error
+		)
+		switch {
+		case lcmp == 0 && rcmp == 0:
+			child, err = t.unsetRange(rn.Val, left[len(rn.Key):], right[len(rn.Key):])
+		case lcmp == 0:
+			child, err = t.unsetFrom(rn.Val, left[len(rn.Key):])
+		default:
+			child, err = t.unsetUntil(rn.Val, right[len(rn.Key):])
+		}
+		if child == nil || err != nil {
+			return nil, err
+		}
+		return &shortNode{rn.Key, child, t.newFlag()}, nil
+
+	case hashNode:
+		resolved, err := t.resolveHash(rn, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return t.unsetRange(resolved, left, right)
+
+	case valueNode, nil:
+		return nil, nil
+
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// unsetFrom removes all the values at or after key from the subtrie rooted at
+// n, resolving the nodes along the path as needed.
+func (t *Trie) unsetFrom(n node, key []byte) (node, error) {
+	switch rn := n.(type) {
+	case *fullNode:
+		if len(key) == 0 {
+			return nil, errors.New("invalid proof path")
+		}
+		if key[0] == 16 {
+			return nil, nil // Even the value of this node is within the range
+		}
+		var err error
+		nn := rn.copy()
+		nn.flags = t.newFlag()
+		for i := key[0] + 1; i < 16; i++ {
+			nn.Children[i] = nil
+		}
+		if nn.Children[key[0]], err = t.unsetFrom(rn.Children[key[0]], key[1:]); err != nil {
+			return nil, err
+		}
+		return collapseEmpty(nn), nil
+
+	case *shortNode:
+		switch cmp := compareKey(rn.Key, key); {
+		case cmp < 0:
+			return rn, nil
+		case cmp > 0:
+			return nil, nil
+		}
+		child, err := t.unsetFrom(rn.Val, key[len(rn.Key):])
+		if child == nil || err != nil {
+			return nil, err
+		}
+		return &shortNode{rn.Key, child, t.newFlag()}, nil
+
+	case hashNode:
+		resolved, err := t.resolveHash(rn, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return t.unsetFrom(resolved, key)
+
+	case valueNode, nil:
+		return nil, nil
+
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// unsetUntil removes all the values at or before key from the subtrie rooted
+// at n, resolving the nodes along the path as needed.
+func (t *Trie) unsetUntil(n node, key []byte) (node, error) {
+	switch rn := n.(type) {
+	case *fullNode:
+		if len(key) == 0 {
+			return nil, errors.New("invalid proof path")
+		}
+		var err error
+		nn := rn.copy()
+		nn.flags = t.newFlag()
+		nn.Children[16] = nil // The value of this node precedes all children
+
+		if key[0] == 16 {
+			return collapseEmpty(nn), nil
+		}
+		for i := byte(0); i < key[0]; i++ {
+			nn.Children[i] = nil
+		}
+		if nn.Children[key[0]], err = t.unsetUntil(rn.Children[key[0]], key[1:]); err != nil {
+			return nil, err
+		}
+		return collapseEmpty(nn), nil
+
+	case *shortNode:
+		switch cmp := compareKey(rn.Key, key); {
+		case cmp < 0:
+			return nil, nil
+		case cmp > 0:
+			return rn, nil
+		}
+		child, err := t.unsetUntil(rn.Val, key[len(rn.Key):])
+		if child == nil || err != nil {
+			return nil, err
+		}
+		return &shortNode{rn.Key, child, t.newFlag()}, nil
+
+	case hashNode:
+		resolved, err := t.resolveHash(rn, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return t.unsetUntil(resolved, key)
+
+	case valueNode, nil:
+		return nil, nil
+
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// hasRightElement reports whether the subtrie rooted at n contains any values
+// after key, resolving the nodes along the path as needed.
+func (t *Trie) hasRightElement(n node, key []byte) (bool, error) {
+	switch rn := n.(type) {
+	case *fullNode:
+		if len(key) == 0 {
+			return false, errors.New("invalid proof path")
+		}
+		start := int(key[0]) + 1
+		if key[0] == 16 {
+			start = 0
+		}
+		for i := start; i < 16; i++ {
+			if rn.Children[i] != nil {
+				return true, nil
+			}
+		}
+		if key[0] == 16 {
+			return false, nil
+		}
+		return t.hasRightElement(rn.Children[key[0]], key[1:])
+
+	case *shortNode:
+		switch cmp := compareKey(rn.Key, key); {
+		case cmp < 0:
+			return false, nil
+		case cmp > 0:
+			return true, nil
+		}
+		return t.hasRightElement(rn.Val, key[len(rn.Key):])
+
+	case hashNode:
+		resolved, err := t.resolveHash(rn, nil, nil)
+		if err != nil {
+			return false, err
+		}
+		return t.hasRightElement(resolved, key)
+
+	case valueNode, nil:
+		return false, nil
+
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// collapseEmpty returns nil if all the children of a full node were removed.
+func collapseEmpty(n *fullNode) node {
+	for _, child := range n.Children {
+		if child != nil {
+			return n
+		}
+	}
+	return nil
+}